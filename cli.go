@@ -0,0 +1,988 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/picatz/openai"
+	"golang.org/x/text/language"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/config"
+	"github.com/picatz/hal/pkg/export"
+	"github.com/picatz/hal/pkg/importer"
+	"github.com/picatz/hal/pkg/index"
+	"github.com/picatz/hal/pkg/transcript"
+)
+
+// Exit codes of the command-line interface, so scripts can tell why a
+// command failed.
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitUsage       = 2
+	exitProvider    = 3
+	exitInterrupted = 130
+)
+
+const usage = `Usage:
+  hal                             start the interactive chat
+  hal <file>                      edit a file with instructions in natural language
+  hal ask [flags] [prompt...]     ask a question, reading extra context from stdin
+  hal threads list [-json]        list the saved threads
+  hal threads show <thread>       print a thread's transcript
+  hal threads tree <thread>       print the branches of a thread's conversation
+  hal threads checkout <t> <msg>  continue a thread from a message in its tree
+  hal threads export <thread>     export a thread as Markdown, JSON or HTML
+  hal threads search [flags] <q>  search the messages of all threads
+  hal threads import <file>...    import ChatGPT or Markdown conversations
+  hal index rebuild               rebuild the search index, like if it's corrupt
+  hal version                     print the version
+
+Threads can be given by ID or name.
+
+Exit codes:
+  0    success
+  1    error
+  2    invalid usage
+  3    the provider failed, like an API error or timeout
+  130  interrupted
+`
+
+// usageError is an error caused by invalid command-line arguments.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+func (e *usageError) Unwrap() error { return e.err }
+
+// usageErrorf returns a new usage error with the formatted message.
+func usageErrorf(format string, args ...any) error {
+	return &usageError{err: fmt.Errorf(format, args...)}
+}
+
+// providerError is an error returned by a chat provider.
+type providerError struct {
+	err error
+}
+
+func (e *providerError) Error() string { return e.err.Error() }
+func (e *providerError) Unwrap() error { return e.err }
+
+// cli runs HAL's non-interactive commands, which are used from scripts.
+type cli struct {
+	cfg *config.Config
+
+	// stdin is the extra context piped to the command, nil if stdin is
+	// a terminal.
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	// providers and store are created when first needed, so commands that
+	// don't need them work without an API key or thread directory.
+	providers map[string]chat.Provider
+	store     *chat.Store
+
+	// indexDir is the directory of the search index, which is the default
+	// directory if empty.
+	indexDir string
+}
+
+// newCLI returns a new command-line interface using the process's standard
+// input and output.
+func newCLI(cfg *config.Config) *cli {
+	c := &cli{
+		cfg:    cfg,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice == 0 {
+		c.stdin = os.Stdin
+	}
+
+	return c
+}
+
+// run runs the command with the given arguments, returning the exit code.
+func (c *cli) run(ctx context.Context, args []string) int {
+	err := c.runCommand(ctx, args)
+
+	var (
+		usageErr    *usageError
+		providerErr *providerError
+	)
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(c.stderr, "hal: %v\n\n%s", err, usage)
+		return exitUsage
+	case errors.Is(err, context.Canceled):
+		fmt.Fprintln(c.stderr, "hal: interrupted")
+		return exitInterrupted
+	case errors.As(err, &providerErr):
+		fmt.Fprintf(c.stderr, "hal: %v\n", err)
+		return exitProvider
+	default:
+		fmt.Fprintf(c.stderr, "hal: %v\n", err)
+		return exitError
+	}
+}
+
+// runCommand runs the command with the given arguments.
+func (c *cli) runCommand(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "ask":
+		return c.ask(ctx, args[1:])
+	case "threads":
+		if len(args) < 2 {
+			return usageErrorf("threads requires a subcommand")
+		}
+
+		switch args[1] {
+		case "list":
+			return c.threadsList(args[2:])
+		case "show":
+			return c.threadsShow(args[2:])
+		case "tree":
+			return c.threadsTree(args[2:])
+		case "checkout":
+			return c.threadsCheckout(args[2:])
+		case "export":
+			return c.threadsExport(args[2:])
+		case "search":
+			return c.threadsSearch(ctx, args[2:])
+		case "import":
+			return c.threadsImport(args[2:])
+		default:
+			return usageErrorf("unknown threads subcommand %q", args[1])
+		}
+	case "index":
+		if len(args) < 2 {
+			return usageErrorf("index requires a subcommand")
+		}
+
+		switch args[1] {
+		case "rebuild":
+			return c.indexRebuild(args[2:])
+		default:
+			return usageErrorf("unknown index subcommand %q", args[1])
+		}
+	case "help", "-h", "--help":
+		fmt.Fprint(c.stdout, usage)
+		return nil
+	default:
+		return usageErrorf("unknown command %q", args[0])
+	}
+}
+
+// fileArg returns the file to edit if the arguments are only the path of an
+// existing file, like "hal main.go", rather than a command. Files named like
+// commands can be edited with a path like "./ask".
+func fileArg(args []string) (string, bool) {
+	if len(args) != 1 {
+		return "", false
+	}
+
+	switch args[0] {
+	case "ask", "threads", "index", "help", "version":
+		return "", false
+	}
+
+	info, err := os.Stat(args[0])
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+
+	return args[0], true
+}
+
+// flagSet returns a new flag set for the command, which reports errors
+// instead of exiting.
+func (c *cli) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// parseFlags parses the command's flags, turning parse errors into usage
+// errors.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{err: err}
+	}
+	return nil
+}
+
+// openStore returns the store threads are saved in.
+func (c *cli) openStore() (*chat.Store, error) {
+	if c.store == nil {
+		dir, err := chat.DefaultStoreDir()
+		if err != nil {
+			return nil, err
+		}
+
+		store, err := chat.NewStore(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		c.store = store
+	}
+
+	return c.store, nil
+}
+
+// openIndex returns the search index, updated with the saved threads.
+func (c *cli) openIndex(threads chat.Threads) (*index.Index, error) {
+	dir, err := c.searchIndexDir()
+	if err != nil {
+		return nil, err
+	}
+
+	ix, err := index.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ix.Sync(threads); err != nil {
+		return nil, err
+	}
+
+	return ix, nil
+}
+
+// searchIndexDir returns the directory of the search index.
+func (c *cli) searchIndexDir() (string, error) {
+	if c.indexDir != "" {
+		return c.indexDir, nil
+	}
+	return index.DefaultDir()
+}
+
+// threads returns the saved threads.
+func (c *cli) threads() (chat.Threads, error) {
+	store, err := c.openStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.loadThreads(store)
+}
+
+// loadThreads loads the threads of the store, warning about the files that
+// couldn't be loaded instead of failing, unless none could be.
+func (c *cli) loadThreads(store *chat.Store) (chat.Threads, error) {
+	threads, err := store.Load()
+	if threads == nil {
+		return nil, err
+	}
+	if err != nil {
+		fmt.Fprintf(c.stderr, "hal: %v\n", err)
+	}
+
+	return threads, nil
+}
+
+// thread returns the saved thread with the given ID or name.
+func (c *cli) thread(idOrName string) (*chat.Thread, error) {
+	threads, err := c.threads()
+	if err != nil {
+		return nil, err
+	}
+
+	return threads.Find(idOrName)
+}
+
+// provider returns the provider with the given name.
+func (c *cli) provider(name string) (chat.Provider, error) {
+	if c.providers == nil {
+		providers, err := newProviders(c.cfg)
+		if err != nil {
+			return nil, err
+		}
+		c.providers = providers
+	}
+
+	provider, ok := c.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("provider %q is not configured", name)
+	}
+
+	return provider, nil
+}
+
+// ask sends the prompt, with any context from stdin, and writes the reply
+// to stdout as it's streamed.
+func (c *cli) ask(ctx context.Context, args []string) error {
+	fs := c.flagSet("ask")
+
+	var (
+		threadName   = fs.String("thread", "", "continue the thread with the given ID or name, saving the reply")
+		save         = fs.Bool("save", false, "save the conversation as a new thread")
+		providerName = fs.String("provider", "", "provider to use, instead of the thread's or default provider")
+		model        = fs.String("model", "", "model to use, instead of the provider's default model")
+		system       = fs.String("system", c.cfg.SystemMessage, "system message for a new conversation")
+	)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	text := strings.Join(fs.Args(), " ")
+
+	if c.stdin != nil {
+		input, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+
+		if extra := strings.TrimSpace(string(input)); extra != "" {
+			if text != "" {
+				text += "\n\n"
+			}
+			text += extra
+		}
+	}
+
+	if text == "" {
+		return usageErrorf("ask requires a prompt, or input on stdin")
+	}
+
+	if *threadName != "" && *save {
+		return usageErrorf("-thread and -save can't be used together")
+	}
+
+	ct := &chat.Thread{
+		Name:    threadNameFromPrompt(text),
+		Created: time.Now(),
+		ChatHistory: []chat.Message{
+			chat.NewMessage(openai.ChatRoleSystem, *system),
+		},
+	}
+
+	if *threadName != "" {
+		found, err := c.thread(*threadName)
+		if err != nil {
+			return err
+		}
+		ct = found
+	}
+
+	name := *providerName
+	if name == "" {
+		name = ct.Provider
+	}
+	if name == "" {
+		name = c.cfg.DefaultProvider
+	}
+
+	provider, err := c.provider(name)
+	if err != nil {
+		return err
+	}
+
+	opts := c.cfg.ChatOptions()
+	if *model != "" {
+		opts.Model = *model
+	}
+
+	// Fail early if the request is too big for the model, instead of
+	// waiting for the provider to reject it.
+	if p := c.cfg.Provider(name); p != nil {
+		budget := newTokenBudget(c.cfg, p, *model)
+
+		tokenizer, err := budget.tokenizer()
+		if err != nil {
+			return err
+		}
+
+		messages := append(ct.ChatHistory[:len(ct.ChatHistory):len(ct.ChatHistory)], chat.NewMessage(openai.ChatRoleUser, text))
+		if err := budget.check(tokenizer.CountMessages(messages)); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.TimeoutDuration())
+	defer cancel()
+
+	finished, err := c.stream(chat.Stream(ctx, provider, opts, ct.ChatHistory, text))
+	if err != nil {
+		return err
+	}
+
+	if finished.Err != nil {
+		if errors.Is(finished.Err, context.Canceled) {
+			return finished.Err
+		}
+		return &providerError{err: finished.Err}
+	}
+
+	if *threadName == "" && !*save {
+		return nil
+	}
+
+	ct.ChatHistory = finished.History
+	if finished.Tokens > 0 {
+		ct.Tokens = finished.Tokens
+	}
+
+	store, err := c.openStore()
+	if err != nil {
+		return err
+	}
+
+	if err := store.Save(ct); err != nil {
+		return err
+	}
+
+	// The reply was saved, so a broken index is only worth a warning.
+	if err := c.updateIndex(ct); err != nil {
+		fmt.Fprintf(c.stderr, "hal: %v\n", err)
+	}
+
+	return nil
+}
+
+// updateIndex updates the search index with the saved thread.
+func (c *cli) updateIndex(ct *chat.Thread) error {
+	dir, err := c.searchIndexDir()
+	if err != nil {
+		return err
+	}
+
+	ix, err := index.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	return ix.Update(ct)
+}
+
+// stream runs the chat command, writing the response to stdout as it's
+// streamed, and returns the message it finished with.
+func (c *cli) stream(cmd tea.Cmd) (chat.FinishedMsg, error) {
+	var last string
+
+	for {
+		switch msg := cmd().(type) {
+		case chat.StreamDeltaMsg:
+			if _, err := io.WriteString(c.stdout, msg.Delta); err != nil {
+				return chat.FinishedMsg{}, err
+			}
+			last = msg.Delta
+			cmd = msg.Next
+		case chat.FinishedMsg:
+			// End the reply with a newline, so the shell prompt starts on
+			// its own line.
+			if last != "" && !strings.HasSuffix(last, "\n") {
+				fmt.Fprintln(c.stdout)
+			}
+			return msg, nil
+		default:
+			return chat.FinishedMsg{}, fmt.Errorf("unexpected message %T", msg)
+		}
+	}
+}
+
+// threadNameFromPrompt returns a name for a new thread from the start of
+// its first prompt.
+func threadNameFromPrompt(text string) string {
+	name := strings.Join(strings.Fields(text), " ")
+
+	const maxLen = 40
+	if runes := []rune(name); len(runes) > maxLen {
+		name = strings.TrimSpace(string(runes[:maxLen])) + "…"
+	}
+
+	return name
+}
+
+// threadsList writes the saved threads to stdout.
+func (c *cli) threadsList(args []string) error {
+	fs := c.flagSet("threads list")
+	asJSON := fs.Bool("json", false, "write the threads as JSON")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	threads, err := c.threads()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(threads)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tMESSAGES\tNAME")
+	for _, ct := range threads {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", ct.ID, ct.Created.Format("2006-01-02 15:04"), len(ct.ChatHistory), ct.Name)
+	}
+
+	return w.Flush()
+}
+
+// threadArg parses the flags, and returns the saved thread given as the
+// only argument.
+func (c *cli) threadArg(fs *flag.FlagSet, args []string) (*chat.Thread, error) {
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() != 1 {
+		return nil, usageErrorf("%s requires a thread ID or name", fs.Name())
+	}
+
+	return c.thread(fs.Arg(0))
+}
+
+// threadsShow writes a thread's transcript to stdout.
+func (c *cli) threadsShow(args []string) error {
+	fs := c.flagSet("threads show")
+	system := fs.Bool("system", false, "include system messages")
+
+	ct, err := c.threadArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range ct.ChatHistory {
+		if msg.Role == openai.ChatRoleSystem && !*system {
+			continue
+		}
+
+		header := transcript.Header(msg.Role)
+		if details := transcript.Details(msg.Metadata); details != "" {
+			header += " · " + details
+		}
+
+		fmt.Fprintf(c.stdout, "%s\n\n%s\n\n", header, strings.TrimSpace(msg.Content))
+	}
+
+	return nil
+}
+
+// threadsTree writes the tree of a thread's conversation to stdout, with the
+// ID of each message, and the messages of the current branch marked.
+func (c *cli) threadsTree(args []string) error {
+	fs := c.flagSet("threads tree")
+
+	ct, err := c.threadArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	current := map[*chat.Node]bool{}
+	for _, node := range ct.Path(ct.Head) {
+		current[node] = true
+	}
+
+	lines := chatTreeLines(ct)
+
+	width := 0
+	for _, line := range lines {
+		if len(line.node.ID) > width {
+			width = len(line.node.ID)
+		}
+	}
+
+	for _, line := range lines {
+		marker := " "
+		if current[line.node] {
+			marker = "*"
+		}
+
+		fmt.Fprintf(c.stdout, "%s %-*s  %s%s: %s\n", marker, width, line.node.ID, line.prefix, chatRoleName(line.node.Message), chatSnippet(line.node.Content, 72))
+	}
+
+	return nil
+}
+
+// threadsCheckout continues a thread from one of the messages in the tree of
+// its conversation, so the next question asked in it is sent after that
+// message.
+func (c *cli) threadsCheckout(args []string) error {
+	fs := c.flagSet("threads checkout")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		return usageErrorf("threads checkout requires a thread ID or name, and a message ID")
+	}
+
+	ct, err := c.thread(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if err := ct.Checkout(fs.Arg(1)); err != nil {
+		return err
+	}
+
+	store, err := c.openStore()
+	if err != nil {
+		return err
+	}
+
+	if err := store.Save(ct); err != nil {
+		return err
+	}
+
+	if err := c.updateIndex(ct); err != nil {
+		fmt.Fprintf(c.stderr, "hal: %v\n", err)
+	}
+
+	fmt.Fprintf(c.stdout, "Thread %q continues from message %s.\n", ct.Name, ct.Head)
+
+	return nil
+}
+
+// threadsExport writes a thread as Markdown, JSON or HTML to stdout, or a
+// file.
+func (c *cli) threadsExport(args []string) error {
+	fs := c.flagSet("threads export")
+	var (
+		output = fs.String("o", "", "write to the given file, instead of stdout")
+		format = fs.String("format", "", "export format: markdown, json or html (default from the -o extension, or json)")
+		system = fs.Bool("system", false, "include system messages in markdown and html exports")
+	)
+
+	ct, err := c.threadArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	f := export.JSON
+	switch {
+	case *format != "":
+		f, err = export.ParseFormat(*format)
+		if err != nil {
+			return &usageError{err: err}
+		}
+	case *output != "":
+		if parsed, err := export.FormatFromPath(*output); err == nil {
+			f = parsed
+		}
+	}
+
+	var b bytes.Buffer
+	if err := export.Write(&b, ct, f, export.Options{System: *system}); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err := c.stdout.Write(b.Bytes())
+		return err
+	}
+
+	return os.WriteFile(*output, b.Bytes(), 0o600)
+}
+
+// threadsImport adds the conversations in the files to the saved threads,
+// skipping the ones that were already imported.
+func (c *cli) threadsImport(args []string) error {
+	fs := c.flagSet("threads import")
+	var (
+		format = fs.String("format", "", "import format: chatgpt or markdown (default from the file extension)")
+		system = fs.String("system", c.cfg.SystemMessage, "system message for imported threads without one")
+	)
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		return usageErrorf("threads import requires at least one file")
+	}
+
+	var (
+		f   importer.Format
+		err error
+	)
+	if *format != "" {
+		f, err = importer.ParseFormat(*format)
+		if err != nil {
+			return &usageError{err: err}
+		}
+	}
+
+	store, err := c.openStore()
+	if err != nil {
+		return err
+	}
+
+	threads, err := c.loadThreads(store)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool, len(threads))
+	for _, ct := range threads {
+		existing[ct.ID] = true
+	}
+
+	var imported, skipped int
+
+	for _, path := range fs.Args() {
+		found, err := readImport(path, f)
+		if err != nil {
+			return err
+		}
+
+		for _, ct := range found {
+			if existing[ct.ID] {
+				skipped++
+				continue
+			}
+
+			if *system != "" && (len(ct.ChatHistory) == 0 || ct.ChatHistory[0].Role != openai.ChatRoleSystem || chat.IsSummary(ct.ChatHistory[0])) {
+				ct.ChatHistory = append([]chat.Message{chat.NewMessage(openai.ChatRoleSystem, *system)}, ct.ChatHistory...)
+			}
+
+			if err := store.Save(ct); err != nil {
+				return err
+			}
+
+			existing[ct.ID] = true
+			threads = append(threads, ct)
+			imported++
+		}
+	}
+
+	// The threads were saved, so a broken index is only worth a warning.
+	if imported > 0 {
+		if _, err := c.openIndex(threads); err != nil {
+			fmt.Fprintf(c.stderr, "hal: %v\n", err)
+		}
+	}
+
+	fmt.Fprintf(c.stdout, "Imported %d threads, skipped %d already imported.\n", imported, skipped)
+
+	return nil
+}
+
+// readImport returns the threads in the file, in the given format, or the
+// format of its extension if empty. Threads without a creation date use the
+// file's modification time.
+func readImport(path string, format importer.Format) (chat.Threads, error) {
+	if format == "" {
+		f, err := importer.FormatFromPath(path)
+		if err != nil {
+			return nil, &usageError{err: fmt.Errorf("%w, use -format", err)}
+		}
+		format = f
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	threads, err := importer.Read(file, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import %q: %w", path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ct := range threads {
+		if ct.Created.IsZero() {
+			ct.Created = info.ModTime()
+		}
+	}
+
+	return threads, nil
+}
+
+// searchHit is a search result written by threads search -json.
+type searchHit struct {
+	ThreadID     string       `json:"thread_id"`
+	ThreadName   string       `json:"thread_name"`
+	MessageIndex int          `json:"message_index"`
+	Role         string       `json:"role"`
+	Matches      []chat.Match `json:"matches"`
+	Score        float64      `json:"score"`
+}
+
+// threadsSearch writes the messages of the saved threads matching the
+// query to stdout, with a snippet of each match.
+func (c *cli) threadsSearch(ctx context.Context, args []string) error {
+	opts := c.cfg.SearchOptions()
+
+	fs := c.flagSet("threads search")
+	regexp := fs.Bool("regexp", opts.Mode == chat.SearchRegexp, "match the query as a regular expression")
+	fuzzy := fs.Bool("fuzzy", opts.Mode == chat.SearchFuzzy, "match words of the query, allowing typos")
+	fs.BoolVar(&opts.All, "all", false, "show every match in a message, not only the first")
+	fs.BoolVar(&opts.CaseSensitive, "case", opts.CaseSensitive, "match case")
+	fs.Func("role", "only search messages with the role, like user or assistant (repeatable)", func(role string) error {
+		opts.Roles = append(opts.Roles, role)
+		return nil
+	})
+	fs.Func("since", "only search messages sent on or after the date (YYYY-MM-DD)", func(date string) (err error) {
+		opts.Since, err = time.ParseInLocation("2006-01-02", date, time.Local)
+		return err
+	})
+	fs.Func("until", "only search messages sent before the date (YYYY-MM-DD)", func(date string) (err error) {
+		opts.Until, err = time.ParseInLocation("2006-01-02", date, time.Local)
+		return err
+	})
+	fs.Func("lang", "language used to compare text, like en-US", func(tag string) (err error) {
+		opts.Language, err = language.Parse(tag)
+		return err
+	})
+	useIndex := fs.Bool("index", false, "rank the results using the search index, matching words and prefixes like \"bay*\"")
+	asJSON := fs.Bool("json", false, "write the results as JSON")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		return usageErrorf("threads search requires a query")
+	}
+
+	// The index only matches words, ignoring case.
+	if *useIndex {
+		var err error
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name != "index" && f.Name != "json" {
+				err = usageErrorf("-%s can't be used with -index", f.Name)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case *regexp && *fuzzy:
+		return usageErrorf("-regexp and -fuzzy can't be used together")
+	case *regexp:
+		opts.Mode = chat.SearchRegexp
+	case *fuzzy:
+		opts.Mode = chat.SearchFuzzy
+	default:
+		opts.Mode = chat.SearchLiteral
+	}
+
+	threads, err := c.threads()
+	if err != nil {
+		return err
+	}
+
+	var results []*chat.ThreadSearchResult
+
+	switch {
+	case *useIndex:
+		ix, err := c.openIndex(threads)
+		if err != nil {
+			return err
+		}
+
+		results = index.Results(threads, ix.Search(strings.Join(fs.Args(), " "), 0))
+	default:
+		results, err = threads.SearchWithOptions(ctx, strings.Join(fs.Args(), " "), &opts)
+		if err != nil {
+			return err
+		}
+
+		if opts.Mode == chat.SearchFuzzy {
+			chat.SortByScore(results)
+		}
+	}
+
+	if *asJSON {
+		hits := make([]searchHit, 0, len(results))
+		for _, r := range results {
+			hits = append(hits, searchHit{
+				ThreadID:     r.Thread.ID,
+				ThreadName:   r.Thread.Name,
+				MessageIndex: r.MessageIndex,
+				Role:         r.Message.Role,
+				Matches:      r.Matches,
+				Score:        r.Score,
+			})
+		}
+
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(hits)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tMESSAGE\tROLE\tMATCH")
+	for _, r := range results {
+		for i := range r.Matches {
+			before, match, after := r.SnippetAt(i, 30)
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", r.Thread.ID, r.MessageIndex, r.Message.Role, before+match+after)
+		}
+	}
+
+	return w.Flush()
+}
+
+// indexRebuild removes the search index, and indexes the saved threads
+// again.
+func (c *cli) indexRebuild(args []string) error {
+	fs := c.flagSet("index rebuild")
+
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	threads, err := c.threads()
+	if err != nil {
+		return err
+	}
+
+	dir, err := c.searchIndexDir()
+	if err != nil {
+		return err
+	}
+
+	ix, err := index.Rebuild(dir, threads)
+	if err != nil {
+		return err
+	}
+
+	indexed, messages := ix.Stats()
+	fmt.Fprintf(c.stdout, "Indexed %d messages in %d threads.\n", messages, indexed)
+
+	return nil
+}
+
+// interruptContext returns a context which is canceled when the process
+// is interrupted, like with Ctrl+C.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/chat/chattest"
+	"github.com/picatz/hal/pkg/config"
+)
+
+// newTestCLI returns a command-line interface using the given fake provider,
+// with its threads stored in a temporary directory.
+func newTestCLI(t *testing.T, provider chat.Provider, stdin string) (*cli, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+
+	store, err := chat.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+
+	var stdout, stderr bytes.Buffer
+
+	c := &cli{
+		cfg:    cfg,
+		stdout: &stdout,
+		stderr: &stderr,
+		providers: map[string]chat.Provider{
+			cfg.DefaultProvider: provider,
+		},
+		store:    store,
+		indexDir: t.TempDir(),
+	}
+
+	if stdin != "" {
+		c.stdin = strings.NewReader(stdin)
+	}
+
+	return c, &stdout, &stderr
+}
+
+func TestCLIAsk(t *testing.T) {
+	provider := chattest.NewProvider(chattest.Reply{Content: "Add the pod bay door controls"})
+
+	c, stdout, stderr := newTestCLI(t, provider, "diff --git a/doors.go b/doors.go\n")
+
+	if code := c.run(context.Background(), []string{"ask", "write", "a", "commit", "message"}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	if stdout.String() != "Add the pod bay door controls\n" {
+		t.Fatalf("unexpected output: %q", stdout)
+	}
+
+	sent := provider.Requests()[0].Messages
+	if want := "write a commit message\n\ndiff --git a/doors.go b/doors.go"; sent[len(sent)-1].Content != want {
+		t.Fatalf("expected the prompt followed by stdin, got %q", sent[len(sent)-1].Content)
+	}
+
+	// Nothing is saved without -save.
+	threads, err := c.threads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads) != 0 {
+		t.Fatalf("expected no saved threads, got %d", len(threads))
+	}
+}
+
+func TestCLIAskThread(t *testing.T) {
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "Good afternoon, Dave."},
+		chattest.Reply{Content: "I'm sorry, Dave."},
+	)
+
+	c, _, stderr := newTestCLI(t, provider, "")
+
+	if code := c.run(context.Background(), []string{"ask", "-save", "Hello, HAL."}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	if code := c.run(context.Background(), []string{"ask", "-thread", "Hello, HAL.", "Open the pod bay doors."}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	threads, err := c.threads()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads) != 1 {
+		t.Fatalf("expected 1 saved thread, got %d", len(threads))
+	}
+
+	history := threads[0].ChatHistory
+	if len(history) != 5 || history[4].Content != "I'm sorry, Dave." {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+
+	// The second request continued the conversation.
+	if sent := provider.Requests()[1].Messages; len(sent) != 4 {
+		t.Fatalf("expected the thread's history to be sent, got %+v", sent)
+	}
+
+	if history[4].Role != openai.ChatRoleAssistant || history[4].Metadata == nil || history[4].Metadata.FinishReason != "stop" {
+		t.Fatalf("expected the reply's metadata to be saved, got %+v", history[4])
+	}
+}
+
+func TestCLIThreadsTree(t *testing.T) {
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "Good afternoon, Dave."},
+		chattest.Reply{Content: "I'm sorry, Dave."},
+		chattest.Reply{Content: "Affirmative, Dave."},
+	)
+
+	c, stdout, stderr := newTestCLI(t, provider, "")
+
+	for _, args := range [][]string{
+		{"ask", "-save", "Hello, HAL."},
+		{"ask", "-thread", "Hello, HAL.", "Open the pod bay doors."},
+		// Continue from the first reply, starting a new branch.
+		{"threads", "checkout", "Hello, HAL.", "3"},
+		{"ask", "-thread", "Hello, HAL.", "Do you read me?"},
+	} {
+		if code := c.run(context.Background(), args); code != exitOK {
+			t.Fatalf("expected exit code %d for %q, got %d: %s", exitOK, args, code, stderr)
+		}
+	}
+
+	if sent := provider.Requests()[2].Messages; len(sent) != 4 || sent[2].Content != "Good afternoon, Dave." {
+		t.Fatalf("expected the checked out history to be sent, got %+v", sent)
+	}
+
+	stdout.Reset()
+
+	if code := c.run(context.Background(), []string{"threads", "tree", "Hello, HAL."}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	want := "* 1  System: " + chatSnippet(c.cfg.SystemMessage, 72) + "\n" +
+		"* 2  You: Hello, HAL.\n" +
+		"* 3  HAL: Good afternoon, Dave.\n" +
+		"  4  ├─ You: Open the pod bay doors.\n" +
+		"  5  │  HAL: I'm sorry, Dave.\n" +
+		"* 6  └─ You: Do you read me?\n" +
+		"* 7     HAL: Affirmative, Dave.\n"
+
+	if stdout.String() != want {
+		t.Fatalf("unexpected tree:\n%s", stdout)
+	}
+
+	if code := c.run(context.Background(), []string{"threads", "checkout", "Hello, HAL.", "42"}); code != exitError {
+		t.Fatalf("expected exit code %d for an unknown message, got %d", exitError, code)
+	}
+
+	if code := c.run(context.Background(), []string{"threads", "checkout", "Hello, HAL."}); code != exitUsage {
+		t.Fatalf("expected exit code %d without a message, got %d", exitUsage, code)
+	}
+}
+
+func TestCLIExitCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		replies []chattest.Reply
+		want    int
+	}{
+		{
+			name: "no prompt",
+			args: []string{"ask"},
+			want: exitUsage,
+		},
+		{
+			name: "unknown command",
+			args: []string{"open", "the", "pod", "bay", "doors"},
+			want: exitUsage,
+		},
+		{
+			name: "unknown flag",
+			args: []string{"threads", "list", "-yaml"},
+			want: exitUsage,
+		},
+		{
+			name: "missing thread",
+			args: []string{"threads", "show", "Jupiter"},
+			want: exitError,
+		},
+		{
+			name: "over the context window",
+			args: []string{"ask", strings.Repeat("Open the pod bay doors, HAL. ", 1000)},
+			want: exitError,
+		},
+		{
+			name:    "provider error",
+			args:    []string{"ask", "Hello"},
+			replies: []chattest.Reply{{Err: errors.New("rate limited")}},
+			want:    exitProvider,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _, stderr := newTestCLI(t, chattest.NewProvider(test.replies...), "")
+
+			if code := c.run(context.Background(), test.args); code != test.want {
+				t.Fatalf("expected exit code %d, got %d: %s", test.want, code, stderr)
+			}
+
+			if stderr.Len() == 0 {
+				t.Fatal("expected an error message on stderr")
+			}
+		})
+	}
+}
+
+func TestCLIThreads(t *testing.T) {
+	c, stdout, stderr := newTestCLI(t, nil, "")
+
+	ct := &chat.Thread{
+		Name: "Pod bay doors",
+		ChatHistory: chat.Messages([]openai.ChatMessage{
+			{Role: openai.ChatRoleSystem, Content: "You are HAL."},
+			{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL."},
+			{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Dave. I'm afraid I can't do that."},
+		}),
+	}
+
+	if err := c.store.Save(ct); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := c.run(context.Background(), []string{"threads", "list"}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	if !strings.Contains(stdout.String(), ct.ID) || !strings.Contains(stdout.String(), "Pod bay doors") {
+		t.Fatalf("expected the thread to be listed, got %q", stdout)
+	}
+
+	stdout.Reset()
+
+	if code := c.run(context.Background(), []string{"threads", "show", "Pod bay doors"}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	if strings.Contains(stdout.String(), "You are HAL.") || !strings.Contains(stdout.String(), "I'm afraid I can't do that.") {
+		t.Fatalf("unexpected transcript: %q", stdout)
+	}
+
+	stdout.Reset()
+
+	if code := c.run(context.Background(), []string{"threads", "export", ct.ID}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	var exported chat.Thread
+	if err := json.Unmarshal(stdout.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+
+	if exported.ID != ct.ID || len(exported.ChatHistory) != 3 {
+		t.Fatalf("unexpected export: %+v", exported)
+	}
+
+	stdout.Reset()
+
+	if code := c.run(context.Background(), []string{"threads", "export", "-format", "md", ct.ID}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	if !strings.HasPrefix(stdout.String(), "# Pod bay doors\n") || !strings.Contains(stdout.String(), "## HAL\n\nI'm sorry, Dave.") {
+		t.Fatalf("unexpected markdown export: %q", stdout)
+	}
+
+	// The format is inferred from the file extension.
+	path := filepath.Join(t.TempDir(), "thread.html")
+
+	if code := c.run(context.Background(), []string{"threads", "export", "-o", path, ct.ID}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(b), "<!DOCTYPE html>") {
+		t.Fatalf("unexpected html export: %q", b)
+	}
+
+	if code := c.run(context.Background(), []string{"threads", "export", "-format", "pdf", ct.ID}); code != exitUsage {
+		t.Fatalf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestCLIThreadsSearch(t *testing.T) {
+	c, stdout, stderr := newTestCLI(t, nil, "")
+
+	for _, ct := range []*chat.Thread{
+		{
+			Name: "Pod bay doors",
+			ChatHistory: chat.Messages([]openai.ChatMessage{
+				{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL."},
+				{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Dave. I'm afraid I can't do that, Dave."},
+			}),
+		},
+		{
+			Name: "Chess",
+			ChatHistory: chat.Messages([]openai.ChatMessage{
+				{Role: openai.ChatRoleUser, Content: "Let's play chess, HAL."},
+			}),
+		},
+	} {
+		if err := c.store.Save(ct); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if code := c.run(context.Background(), []string{"threads", "search", "-role", "user", "hal"}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(stdout.String()), "\n"); len(lines) != 3 || !strings.Contains(stdout.String(), "Let's play chess, HAL.") {
+		t.Fatalf("expected 2 results, got %q", stdout)
+	}
+
+	stdout.Reset()
+
+	if code := c.run(context.Background(), []string{"threads", "search", "-regexp", "-all", "-json", `Dav\w`}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	var hits []searchHit
+	if err := json.Unmarshal(stdout.Bytes(), &hits); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hits) != 1 || hits[0].ThreadName != "Pod bay doors" || hits[0].MessageIndex != 1 || len(hits[0].Matches) != 2 {
+		t.Fatalf("unexpected results: %+v", hits)
+	}
+
+	if code := c.run(context.Background(), []string{"threads", "search", "-regexp", "-fuzzy", "hal"}); code != exitUsage {
+		t.Fatalf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestCLIIndex(t *testing.T) {
+	provider := chattest.NewProvider(chattest.Reply{Content: "I'm sorry, Dave. I'm afraid I can't do that."})
+
+	c, stdout, stderr := newTestCLI(t, provider, "")
+
+	// Saved replies are indexed.
+	if code := c.run(context.Background(), []string{"ask", "-save", "Open the pod bay doors, HAL."}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	stdout.Reset()
+
+	if code := c.run(context.Background(), []string{"threads", "search", "-index", "-json", "afr*"}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	var hits []searchHit
+	if err := json.Unmarshal(stdout.Bytes(), &hits); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hits) != 1 || hits[0].MessageIndex != 2 || hits[0].Role != openai.ChatRoleAssistant {
+		t.Fatalf("unexpected results: %+v", hits)
+	}
+
+	if code := c.run(context.Background(), []string{"threads", "search", "-index", "-fuzzy", "afraid"}); code != exitUsage {
+		t.Fatalf("expected exit code %d, got %d", exitUsage, code)
+	}
+
+	// A corrupt index has to be rebuilt.
+	if err := os.WriteFile(filepath.Join(c.indexDir, hits[0].ThreadID+".json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	stderr.Reset()
+
+	if code := c.run(context.Background(), []string{"threads", "search", "-index", "afraid"}); code != exitError {
+		t.Fatalf("expected exit code %d, got %d", exitError, code)
+	}
+
+	if !strings.Contains(stderr.String(), "hal index rebuild") {
+		t.Fatalf("expected to be told to rebuild the index, got %q", stderr)
+	}
+
+	stdout.Reset()
+
+	if code := c.run(context.Background(), []string{"index", "rebuild"}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	if stdout.String() != "Indexed 3 messages in 1 threads.\n" {
+		t.Fatalf("unexpected output: %q", stdout)
+	}
+
+	if code := c.run(context.Background(), []string{"threads", "search", "-index", "afraid"}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+}
+
+func TestCLIThreadsImport(t *testing.T) {
+	c, stdout, stderr := newTestCLI(t, nil, "")
+
+	dir := t.TempDir()
+
+	conversations := filepath.Join(dir, "conversations.json")
+	if err := os.WriteFile(conversations, []byte(`[{
+		"id": "conv-1",
+		"title": "Pod bay doors",
+		"create_time": 986200000,
+		"current_node": "a",
+		"mapping": {
+			"u": {"id": "u", "message": {"author": {"role": "user"}, "content": {"parts": ["Open the pod bay doors, HAL."]}}, "children": ["a"]},
+			"a": {"id": "a", "message": {"author": {"role": "assistant"}, "content": {"parts": ["I'm sorry, Dave."]}}, "parent": "u"}
+		}
+	}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	transcript := filepath.Join(dir, "chess.md")
+	if err := os.WriteFile(transcript, []byte("# Chess\n\n## User\n\nLet's play chess.\n\n## Assistant\n\nQueen to bishop three.\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := c.run(context.Background(), []string{"threads", "import", conversations, transcript}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	if !strings.Contains(stdout.String(), "Imported 2 threads, skipped 0") {
+		t.Fatalf("unexpected output: %q", stdout)
+	}
+
+	ct, err := c.thread("Pod bay doors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ct.ChatHistory) != 3 || ct.ChatHistory[0].Content != c.cfg.SystemMessage || ct.Created.Year() != 2001 {
+		t.Fatalf("unexpected imported thread: %+v", ct)
+	}
+
+	// Imported threads are searchable.
+	stdout.Reset()
+
+	if code := c.run(context.Background(), []string{"threads", "search", "-index", "bishop"}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	if !strings.Contains(stdout.String(), "bishop") {
+		t.Fatalf("expected the imported thread to be found, got %q", stdout)
+	}
+
+	// Importing again skips the threads.
+	stdout.Reset()
+
+	if code := c.run(context.Background(), []string{"threads", "import", conversations, transcript}); code != exitOK {
+		t.Fatalf("expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+
+	if !strings.Contains(stdout.String(), "Imported 0 threads, skipped 2") {
+		t.Fatalf("unexpected output: %q", stdout)
+	}
+
+	if code := c.run(context.Background(), []string{"threads", "import", filepath.Join(dir, "notes.txt")}); code != exitUsage {
+		t.Fatalf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestFileArg(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(path, []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{args: []string{path}, want: true},
+		{args: []string{dir}, want: false},
+		{args: []string{filepath.Join(dir, "missing.go")}, want: false},
+		{args: []string{path, path}, want: false},
+		{args: []string{"ask"}, want: false},
+		{args: nil, want: false},
+	}
+
+	for _, test := range tests {
+		if got, ok := fileArg(test.args); ok != test.want || ok && got != path {
+			t.Fatalf("expected %v for %q, got %q, %v", test.want, test.args, got, ok)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+
+	"github.com/picatz/hal/pkg/config"
+)
+
+// keyMap is the key bindings for HAL's actions.
+type keyMap struct {
+	Quit           key.Binding
+	Send           key.Binding
+	Cancel         key.Binding
+	Back           key.Binding
+	ExternalEditor key.Binding
+	Truncate       key.Binding
+	Regenerate     key.Binding
+	PreviousBranch key.Binding
+	NextBranch     key.Binding
+	ThreadTree     key.Binding
+	Shell          key.Binding
+	ReviewPatch    key.Binding
+
+	// Key bindings to manage threads in the chat thread list.
+	NewThread       key.Binding
+	RenameThread    key.Binding
+	DuplicateThread key.Binding
+	DeleteThread    key.Binding
+	SwitchProvider  key.Binding
+	SearchThreads   key.Binding
+	ExportThread    key.Binding
+}
+
+// newKeyMap returns the key bindings from the configuration.
+func newKeyMap(keys *config.Keys) keyMap {
+	binding := func(keys []string, help string) key.Binding {
+		return key.NewBinding(
+			key.WithKeys(keys...),
+			key.WithHelp(strings.Join(keys, "/"), help),
+		)
+	}
+
+	return keyMap{
+		Quit:           binding(keys.Quit, "quit"),
+		Send:           binding(keys.Send, "send"),
+		Cancel:         binding(keys.Cancel, "cancel"),
+		Back:           binding(keys.Back, "back"),
+		ExternalEditor: binding(keys.ExternalEditor, "external editor"),
+		Truncate:       binding(keys.Truncate, "truncate"),
+		Regenerate:     binding(keys.Regenerate, "regenerate"),
+		PreviousBranch: binding(keys.PreviousBranch, "previous branch"),
+		NextBranch:     binding(keys.NextBranch, "next branch"),
+		ThreadTree:     binding(keys.ThreadTree, "tree"),
+		Shell:          binding(keys.Shell, "shell"),
+		ReviewPatch:    binding(keys.ReviewPatch, "review patch"),
+
+		NewThread:       binding(keys.NewThread, "new"),
+		RenameThread:    binding(keys.RenameThread, "rename"),
+		DuplicateThread: binding(keys.DuplicateThread, "duplicate"),
+		DeleteThread:    binding(keys.DeleteThread, "delete"),
+		SwitchProvider:  binding(keys.SwitchProvider, "provider"),
+		SearchThreads:   binding(keys.SearchThreads, "search"),
+		ExportThread:    binding(keys.ExportThread, "export"),
+	}
+}
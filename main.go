@@ -1,7 +1,7 @@
 package main
 
-// A simple program demonstrating the text area component from the Bubbles
-// component library.
+// HAL is a terminal user interface to chat with large language models, with
+// non-interactive commands for use in scripts.
 
 import (
 	"fmt"
@@ -10,6 +10,9 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+
+	"github.com/picatz/hal/pkg/config"
+	"github.com/picatz/hal/pkg/statusbar"
 )
 
 // These variables are updated at compile time.
@@ -19,7 +22,7 @@ var (
 	date    = "unknown"
 )
 
-// TODO: make these configurable, maybe HCL?
+// Shared styles, which are updated from the configuration at startup.
 var (
 	halStyleColor = lipgloss.NewStyle().Foreground(lipgloss.Color("69"))
 )
@@ -34,13 +37,60 @@ func main() {
 		}
 	}
 
+	// Load the configuration file, if there is one.
+	configPath, err := config.DefaultPath()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Run a non-interactive command, like "hal ask", for use in scripts,
+	// unless the argument is a file to edit, like "hal main.go".
+	path, editing := fileArg(os.Args[1:])
+	if len(os.Args) > 1 && !editing {
+		ctx, stop := interruptContext()
+		code := newCLI(cfg).run(ctx, os.Args[1:])
+		stop()
+		os.Exit(code)
+	}
+
+	halStyleColor = lipgloss.NewStyle().Foreground(lipgloss.Color(cfg.Theme.Primary))
+
+	m := newModel(cfg)
+	if editing {
+		if err := m.openFile(path); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	p := tea.NewProgram(
-		newModel(),
+		m,
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
 	)
 
-	if _, err := p.Run(); err != nil {
+	final, err := p.Run()
+	if err != nil {
 		log.Fatal(err)
 	}
+
+	// Changes of sandboxed commands that weren't reviewed are discarded.
+	final.(model).removeSandboxes()
+}
+
+// statusbarColors returns the status bar colors from the theme.
+func statusbarColors(theme *config.Theme) statusbar.Colors {
+	return statusbar.Colors{
+		Primary:  lipgloss.Color(theme.Primary),
+		Messages: lipgloss.Color(theme.Messages),
+		Tokens:   lipgloss.Color(theme.Tokens),
+		Error:    lipgloss.Color(theme.Error),
+	}
 }
@@ -7,4 +7,8 @@ const (
 	ModeChatThreadList Mode = iota
 	ModeEditorInsert
 	ModeShell
+	ModeSearch
+	ModeTree
+	ModeFileEdit
+	ModePatch
 )
@@ -1,21 +1,33 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/picatz/openai"
 
 	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/config"
+	"github.com/picatz/hal/pkg/diff"
+	"github.com/picatz/hal/pkg/diffview"
+	"github.com/picatz/hal/pkg/edit"
 	"github.com/picatz/hal/pkg/editor"
+	"github.com/picatz/hal/pkg/index"
+	"github.com/picatz/hal/pkg/policy"
+	"github.com/picatz/hal/pkg/sandbox"
 	"github.com/picatz/hal/pkg/statusbar"
+	"github.com/picatz/hal/pkg/transcript"
 )
 
 // Model is the main model of the application.
@@ -30,27 +42,112 @@ type model struct {
 	// Shared base style for HAL.
 	halStyle lipgloss.Style
 
+	// Key bindings, which can be configured.
+	keys keyMap
+
+	// External editor command to open with the editor's text.
+	editorCommand string
+
 	// Error message to display, usually from OpenAI.
 	err error
 
 	editor textarea.Model
 
+	// Transcript of the current thread, shown above the editor.
+	chatOutput viewport.Model
+	transcript *transcript.Renderer
+
+	// Line offsets of each message in the transcript, used to scroll to a
+	// specific message.
+	chatOutputOffsets []int
+
 	// Status bar.
 	statusbar *statusbar.Model
 
-	// OpenAI API client and chat history.
-	client            *openai.Client
-	chatSystemMessage openai.ChatMessage
+	// Chat providers by name, and chat history.
+	providers         map[string]chat.Provider
+	tokenBudgets      map[string]tokenBudget
+	defaultProvider   string
+	chatSystemMessage chat.Message
+	chatOptions       chat.Options
+	timeout           time.Duration
+	compaction        config.Compaction
 	chatThreadList    list.Model
 	chatThreads       chat.Threads
 	currnetThread     *chat.Thread
+
+	// Prompt used to create, rename, duplicate and delete threads.
+	chatThreadPrompt       textinput.Model
+	chatThreadPromptAction chatThreadPromptAction
+	chatThreadPromptTarget *chat.Thread
+
+	// Search across the messages of all threads.
+	chatSearch         textinput.Model
+	chatSearchOptions  chat.SearchOptions
+	chatSearchResults  []*chat.ThreadSearchResult
+	chatSearchSelected int
+	chatSearchErr      error
+
+	// Shell mode, where tasks are turned into commands to review and run.
+	shellInput    textarea.Model
+	shellStage    shellStage
+	shellTask     string
+	shellProposal []chat.Message
+
+	// The command being confirmed or run, and what the policy decided
+	// about it.
+	shellCommand     string
+	shellInteractive bool
+	shellDecision    policy.Decision
+	shellPolicy      *policy.Policy
+	auditLog         *policy.AuditLog
+
+	// Whether commands run in a sandbox, on a copy of the working directory
+	// shellDir, and the files changed by a sandboxed command to review.
+	shellSandbox   bool
+	sandboxOptions sandbox.Options
+	shellDir       string
+	shellChanges   viewport.Model
+
+	// File edited with instructions in natural language, and the edit
+	// proposed for it to review.
+	fileEdit  *edit.File
+	fileStage fileStage
+	fileDiff  *diffview.Model
+	fileNote  string
+	fileView  viewport.Model
+
+	// Patch of a reply being reviewed in patch mode, one file at a time,
+	// with the files left to review, and what was applied to the others.
+	patchFiles   []diff.FilePatch
+	patchFile    *edit.File
+	patchName    string
+	patchNew     bool
+	patchDiff    *diffview.Model
+	patchApplied []string
+
+	// Tree of the current thread's conversation, to navigate its branches.
+	chatTreeLines    []chatTreeLine
+	chatTreeSelected int
+
+	// Store used to persist chat threads to disk.
+	store *chat.Store
+
+	// Index used to search the messages of all threads, nil if it
+	// couldn't be opened.
+	index *index.Index
+
+	// State of each thread that isn't persisted, like in-flight requests.
+	chatThreadStates map[*chat.Thread]*chatThreadState
 }
 
-// newModel creates a new model with the default values.
-func newModel() model {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		fmt.Println("OPENAI_API_KEY environment variable is not set")
+// newModel creates a new model using the given configuration.
+func newModel(cfg *config.Config) model {
+	// Providers used to chat with large language models, which can be
+	// chosen for each thread.
+	providers, err := newProviders(cfg)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
@@ -60,44 +157,140 @@ func newModel() model {
 	// In the future it will be used to display other information, such as
 	// the current mode, and other things. It's a work in progress.
 	statusbar := statusbar.New()
+	statusbar.SetColors(statusbarColors(cfg.Theme))
 
-	// TODO: Keep track of threads over time, allow switching between them.
-	//       There's so much to do here, but this is a start.
-	chatThreads := chat.Threads{
-		{
-			Name:    "Get to know HAL",
-			Summary: "Learn how to work together.",
-			Created: time.Now(),
-			ChatHistory: []openai.ChatMessage{
-				chat.SystemMessage,
+	// System message that new threads start with.
+	chatSystemMessage := chat.NewMessage(openai.ChatRoleSystem, cfg.SystemMessage)
+
+	// Load the threads from previous sessions.
+	storeDir, err := chat.DefaultStoreDir()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	store, err := chat.NewStore(storeDir)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Threads that can't be loaded are skipped, which is shown in the
+	// status bar.
+	chatThreads, err := store.Load()
+	if chatThreads == nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err != nil {
+		statusbar.Err = err
+	}
+
+	// Keep the search index up to date with threads changed since the last
+	// session, searching without it if it's broken.
+	searchIndex, err := openIndex(chatThreads)
+	if err != nil {
+		statusbar.Err = err
+	}
+
+	// Start with a thread to get to know HAL if there are no threads yet.
+	if len(chatThreads) == 0 {
+		chatThreads = chat.Threads{
+			{
+				Name:    "Get to know HAL",
+				Summary: "Learn how to work together.",
+				Created: time.Now(),
+				ChatHistory: []chat.Message{
+					chatSystemMessage,
+				},
 			},
-		},
+		}
 	}
 
+	// Log the shell commands that ran, or were denied.
+	auditLogPath, err := cfg.AuditLogPath()
+	if err != nil {
+		statusbar.Err = err
+	}
+
+	// Key bindings for the application.
+	keys := newKeyMap(cfg.Keys)
+
 	// Setup chat thread list.
-	chatThreadList := ChatThreadList(chatThreads)
+	chatThreadList := ChatThreadList(chatThreads, keys)
 
 	// Setup text area for user input.
-	editor := EditorTextArea()
+	editorTextArea := EditorTextArea(cfg.Editor)
+
+	// Use the configured external editor, or $EDITOR.
+	editorCommand := cfg.Editor.Command
+	if editorCommand == "" {
+		editorCommand = editor.ConfiguredExternalCommand()
+	}
 
 	// Return the model.
 	return model{
 		// Started in chat thread list mode by default (if not file selected in args?)
 		mode: ModeChatThreadList,
 
-		editor: editor,
+		editor:        editorTextArea,
+		editorCommand: editorCommand,
+		chatOutput:    ChatOutputViewport(),
+
+		keys: keys,
 
 		halStyle: halStyleColor,
-		err:      nil,
+		err:      statusbar.Err,
+
+		chatThreads:      chatThreads,
+		chatThreadList:   chatThreadList,
+		chatThreadPrompt: ChatThreadPrompt(),
+		chatSearch:       ChatSearchInput(),
+		shellInput:       ShellTextArea(),
+		shellPolicy:      cfg.ShellPolicy(),
+		auditLog:         &policy.AuditLog{Path: auditLogPath},
+		shellSandbox:     cfg.Shell.Sandbox.Enabled,
+		sandboxOptions:   cfg.SandboxOptions(),
+		shellDir:         ".",
+		shellChanges:     ShellChangesViewport(),
+		fileView:         ChatOutputViewport(),
+
+		chatSearchOptions: cfg.SearchOptions(),
+
+		store:            store,
+		index:            searchIndex,
+		chatThreadStates: map[*chat.Thread]*chatThreadState{},
+
+		providers:         providers,
+		tokenBudgets:      newTokenBudgets(cfg),
+		defaultProvider:   cfg.DefaultProvider,
+		chatSystemMessage: chatSystemMessage,
+		chatOptions:       cfg.ChatOptions(),
+		timeout:           cfg.TimeoutDuration(),
+		compaction:        *cfg.Compaction,
+
+		statusbar: statusbar,
+	}
+}
 
-		chatThreads:    chatThreads,
-		chatThreadList: chatThreadList,
+// openIndex opens the default search index, and updates it with the
+// threads.
+func openIndex(threads chat.Threads) (*index.Index, error) {
+	dir, err := index.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
 
-		client:            openai.NewClient(apiKey),
-		chatSystemMessage: chat.SystemMessage,
+	ix, err := index.Open(dir)
+	if err != nil {
+		return nil, err
+	}
 
-		statusbar: statusbar,
+	if err := ix.Sync(threads); err != nil {
+		return nil, err
 	}
+
+	return ix, nil
 }
 
 // Init implements tea.Model, it just starts the blinking cursor.
@@ -116,7 +309,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var (
 		statusbarCmd      tea.Cmd
 		textareaCmd       tea.Cmd
+		chatOutputCmd     tea.Cmd
 		chatThreadListCmd tea.Cmd
+		chatSearchCmd     tea.Cmd
 	)
 
 	// Handle status bar updates, always show status bar.
@@ -125,9 +320,51 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// Handle update based on current mode.
 	switch m.mode {
 	case ModeChatThreadList:
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			if m.chatThreadPromptAction != chatThreadPromptNone {
+				return m, m.updateChatThreadPrompt(keyMsg)
+			}
+
+			if cmd, ok := m.updateChatThreadListKeys(keyMsg); ok {
+				return m, cmd
+			}
+		}
+
 		m.chatThreadList, chatThreadListCmd = m.chatThreadList.Update(msg)
+	case ModeSearch:
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			return m.updateChatSearch(keyMsg)
+		}
+
+		m.chatSearch, chatSearchCmd = m.chatSearch.Update(msg)
+	case ModeTree:
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			return m.updateChatTree(keyMsg)
+		}
+	case ModeShell:
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			return m.updateShell(keyMsg)
+		}
+
+		m.chatOutput, chatOutputCmd = m.chatOutput.Update(msg)
+	case ModeFileEdit:
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			return m.updateFileEdit(keyMsg)
+		}
+
+		m.fileView, chatOutputCmd = m.fileView.Update(msg)
+	case ModePatch:
+		if keyMsg, ok := msg.(tea.KeyMsg); ok {
+			return m.updatePatch(keyMsg)
+		}
 	case ModeEditorInsert:
 		m.editor, textareaCmd = m.editor.Update(msg)
+		m.chatOutput, chatOutputCmd = m.chatOutput.Update(msg)
+
+		// Keep the token count up to date with the draft.
+		if _, ok := msg.(tea.KeyMsg); ok {
+			m.syncStatusbar()
+		}
 	default:
 		// TODO: handle other modes.
 	}
@@ -135,57 +372,138 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// TODO: have mode-specific keybindings.
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		switch msg.Type {
-		case tea.KeyCtrlC: // Quit the program.
+		switch {
+		case key.Matches(msg, m.keys.Quit): // Quit the program.
+			// Keep the unsent text for next time.
+			if m.currnetThread != nil {
+				m.currnetThread.Draft = m.editor.Value()
+				m.saveChatThread(m.currnetThread)
+			}
 			return m, tea.Quit
-		case tea.KeyCtrlE: // Open editor with current text with textarea buffer.
-			return m, editor.OpenExternal(m.editor.Value())
+		case key.Matches(msg, m.keys.ExternalEditor): // Open editor with current text with textarea buffer.
+			return m, editor.OpenExternal(m.editorCommand, m.editor.Value())
 		// case tea.KeyCtrlO: // Open editor with current text with viewport buffer.
 		// 	// Strip any ANSI color codes from the viewport.
 		// 	vpView := Strip(m.chatOutput.View())
 		// 	return m, openEditor(vpView, true)
-		case tea.KeyCtrlT: // Truncate the previous chat history.
-			if m.currnetThread != nil && len(m.currnetThread.ChatHistory) > 2 {
-				lastMessage := m.currnetThread.ChatHistory[len(m.currnetThread.ChatHistory)-1]
+		case key.Matches(msg, m.keys.Truncate): // Truncate the previous chat history.
+			if m.currnetThread != nil {
+				m.truncateChatThread()
+			}
+		case key.Matches(msg, m.keys.Cancel): // Cancel the in-flight chat request.
+			if m.currnetThread == nil {
+				break
+			}
 
-				m.currnetThread.ChatHistory = []openai.ChatMessage{
-					m.chatSystemMessage,
-					lastMessage,
-				}
+			state := m.chatThreadState(m.currnetThread)
+			if state.cancelRequest == nil {
+				break
+			}
+
+			state.cancelRequest()
+			state.cancelRequest = nil
+
+			// Give the user their unsent text back, keeping anything they've
+			// started typing since.
+			if text := m.editor.Value(); text != "" {
+				m.editor.SetValue(state.pendingText + "\n" + text)
+			} else {
+				m.editor.SetValue(state.pendingText)
 			}
-		// case tea.KeyCtrlL: // Clear the viewport.
-		// 	m.chatOutput.SetContent("")
-		case tea.KeyEscape:
-			text := m.editor.Value()
+			state.pendingText = ""
+			state.response = ""
+
+			m.syncStatusbar()
+			m.refreshChatOutput()
+
+			return m, nil
+		case key.Matches(msg, m.keys.Back): // Go back to the chat thread list.
+			if m.currnetThread == nil {
+				break
+			}
+
+			// Keep the unsent text for when the user comes back.
+			m.currnetThread.Draft = m.editor.Value()
+			m.saveChatThread(m.currnetThread)
 
-			// m.chatOutput.GotoBottom()
 			m.editor.Reset()
-			m.editor.Placeholder = "..."
+			m.currnetThread = nil
+			m.mode = ModeChatThreadList
+
+			m.chatThreadList.SetItems(m.chatThreads.ListItems())
+			m.syncStatusbar()
+			m.refreshChatOutput()
+
+			return m, nil
+		case key.Matches(msg, m.keys.Send):
+			if m.currnetThread == nil {
+				break
+			}
 
-			m.statusbar.Spinning = true
+			if m.chatThreadState(m.currnetThread).cancelRequest != nil {
+				break
+			}
 
-			// send the message to the OpenAI chat API
+			// Leave the text in the editor if it can't be sent, like when
+			// it's too long, so it can be shortened.
+			sendCmd, sent := m.sendChatMessage(m.editor.Value())
+			if !sent {
+				return m, sendCmd
+			}
 
-			sendCmd := chat.Send(m.client, m.currnetThread.ChatHistory, text)
+			m.editor.Reset()
 
-			return m, tea.Batch(sendCmd, statusbarCmd, textareaCmd, chatThreadListCmd, m.statusbar.Spinner.Tick)
-		case tea.KeyEnter:
+			return m, tea.Batch(sendCmd, statusbarCmd, textareaCmd, chatOutputCmd, chatThreadListCmd)
+		case key.Matches(msg, m.keys.Regenerate):
 			if m.currnetThread == nil {
-				// Select the thread.
-				m.editor.SetValue("") // For some reason, the text area is not cleared when selecting a thread.
-				m.currnetThread = m.chatThreadList.SelectedItem().(*chat.Thread)
+				break
+			}
 
-				if len(m.currnetThread.ChatHistory) == 0 {
-					m.currnetThread.ChatHistory = append(m.currnetThread.ChatHistory, m.chatSystemMessage)
-				}
+			return m, m.regenerateChatReply()
+		case key.Matches(msg, m.keys.PreviousBranch):
+			if m.currnetThread == nil {
+				break
+			}
 
-				// Update the status bar with the current thread.
-				m.statusbar.Update(&statusbar.Model{
-					ChatThread: m.currnetThread,
-				})
+			m.switchChatBranch(-1)
 
-				// Change the mode to editor mode.
-				m.mode = ModeEditorInsert
+			return m, nil
+		case key.Matches(msg, m.keys.NextBranch):
+			if m.currnetThread == nil {
+				break
+			}
+
+			m.switchChatBranch(1)
+
+			return m, nil
+		case key.Matches(msg, m.keys.ThreadTree):
+			if m.currnetThread == nil {
+				break
+			}
+
+			m.openChatTree()
+
+			return m, nil
+		case key.Matches(msg, m.keys.Shell):
+			if m.currnetThread == nil {
+				break
+			}
+
+			return m, m.openShell()
+		case key.Matches(msg, m.keys.ReviewPatch):
+			if m.currnetThread == nil {
+				break
+			}
+
+			return m, m.openPatch()
+		case msg.Type == tea.KeyEnter:
+			if m.currnetThread == nil {
+				selected, ok := m.chatThreadList.SelectedItem().(*chat.Thread)
+				if !ok {
+					break
+				}
+
+				m.openChatThread(selected)
 
 				return m, nil
 			}
@@ -197,40 +515,129 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		m.editor.SetValue(string(msg.Buffer))
-	case chat.FinishedMsg:
-		if msg.Err != nil {
-			return m, tea.Quit
-		}
-
-		m.currnetThread.ChatHistory = msg.History
-		m.currnetThread.Tokens = msg.Tokens
-
-		m.statusbar.ChatThread = m.currnetThread
-
-		m.statusbar.Spinning = false
-
-		m.editor.SetValue(string(msg.Buffer))
+	case chatThreadMsg:
+		return m.updateChatThreadMsg(msg)
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
 
-		m.editor.SetHeight(msg.Height - 2)
+		// Split the screen between the transcript, and the editor below it,
+		// leaving room for the status bar.
+		inputHeight := chatInputHeight(msg.Height)
+
+		m.editor.SetHeight(inputHeight)
 		m.editor.SetWidth(msg.Width)
+
+		// Leave room for the help below the shell input.
+		m.shellInput.SetHeight(inputHeight - 1)
+		m.shellInput.SetWidth(msg.Width)
+
+		m.chatOutput.Width = msg.Width
+		m.chatOutput.Height = msg.Height - inputHeight - 2
+
+		m.shellChanges.Width = m.chatOutput.Width
+		m.shellChanges.Height = m.chatOutput.Height
+
+		// Leave room for the help below the editor.
+		m.fileView.Width = m.chatOutput.Width
+		m.fileView.Height = m.chatOutput.Height - 1
+		if m.fileDiff != nil {
+			m.fileDiff.SetSize(m.fileView.Width, m.fileView.Height)
+		}
+		if m.patchDiff != nil {
+			m.patchDiff.SetSize(m.fileView.Width, m.fileView.Height)
+		}
+
+		m.refreshChatOutput()
 	case spinner.TickMsg:
 		var cmd tea.Cmd
 		m.statusbar.Spinner, cmd = m.statusbar.Spinner.Update(msg)
 		return m, cmd
 	}
 
-	return m, tea.Batch(statusbarCmd, textareaCmd, chatThreadListCmd)
+	return m, tea.Batch(statusbarCmd, textareaCmd, chatOutputCmd, chatThreadListCmd, chatSearchCmd)
+}
+
+// sendChatMessage sends the text to the current thread's provider,
+// streaming the response into the transcript as it arrives.
+//
+// It returns false if the text wasn't sent, with a command to make room for
+// it if it doesn't fit in the model's context window.
+func (m *model) sendChatMessage(text string) (tea.Cmd, bool) {
+	state := m.chatThreadState(m.currnetThread)
+
+	provider, err := m.chatThreadProvider(m.currnetThread)
+	if err != nil {
+		state.err = err
+		m.syncStatusbar()
+		return nil, false
+	}
+
+	// Don't send a request the model can't handle.
+	if budget, ok := m.chatThreadTokenBudget(m.currnetThread); ok {
+		tokens, _ := m.chatThreadTokens(m.currnetThread, chat.NewMessage(openai.ChatRoleUser, text))
+		if err := budget.check(tokens); err != nil {
+			state.err = err
+			m.syncStatusbar()
+
+			// Make room by summarizing the older messages.
+			return m.compactChatThread(m.currnetThread, true), false
+		}
+	}
+
+	m.chatOutput.GotoBottom()
+	m.editor.Placeholder = "..."
+
+	m.currnetThread.Draft = ""
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+
+	state.cancelRequest = cancel
+	state.request++
+	state.pendingText = text
+	state.response = ""
+	state.historyLen = len(m.currnetThread.ChatHistory)
+	state.err = nil
+
+	m.syncStatusbar()
+	m.refreshChatOutput()
+
+	sendCmd := chatRequestCmd(m.currnetThread, state.request, chat.Stream(ctx, provider, m.chatOptions, m.currnetThread.ChatHistory, text))
+
+	return tea.Batch(sendCmd, m.statusbar.Spinner.Tick), true
+}
+
+// openChatThread selects the thread, showing its transcript above the
+// editor.
+func (m *model) openChatThread(ct *chat.Thread) {
+	// Select the thread.
+	m.currnetThread = ct
+
+	if len(m.currnetThread.ChatHistory) == 0 {
+		m.currnetThread.ChatHistory = append(m.currnetThread.ChatHistory, m.chatSystemMessage)
+	}
+
+	// Restore the unsent text.
+	m.editor.SetValue(m.currnetThread.Draft)
+
+	// Update the status bar with the current thread.
+	m.syncStatusbar()
+
+	// Show the transcript, starting with the latest messages.
+	m.refreshChatOutput()
+	m.chatOutput.GotoBottom()
+
+	// Change the mode to editor mode.
+	m.mode = ModeEditorInsert
 }
 
 func (m model) chooseThreadListView() string {
 	return lipgloss.JoinVertical(
 		lipgloss.Top,
-		welcomeToHAL,
+		welcomeToHAL(),
 		"",
 		m.chatThreadList.View(),
+		m.viewChatThreadPrompt(),
 	)
 }
 
@@ -241,12 +648,52 @@ func (m model) viewChatInput() string {
 func (m model) View() string {
 	var mainView string
 
-	if m.currnetThread == nil {
+	switch {
+	case m.mode == ModeSearch:
+		mainView = lipgloss.JoinVertical(
+			lipgloss.Top,
+			welcomeToHAL(),
+			"",
+			m.viewChatSearch(),
+		)
+	case m.mode == ModeTree:
+		mainView = m.viewChatTree()
+	case m.mode == ModeFileEdit && m.fileStage == fileReview:
+		mainView = lipgloss.JoinVertical(
+			lipgloss.Top,
+			m.fileDiff.View(),
+			m.viewFileEdit(),
+		)
+	case m.mode == ModeFileEdit:
+		mainView = lipgloss.JoinVertical(
+			lipgloss.Top,
+			m.fileView.View(),
+			m.viewFileEdit(),
+		)
+	case m.mode == ModePatch:
+		mainView = lipgloss.JoinVertical(
+			lipgloss.Top,
+			m.patchDiff.View(),
+			m.viewPatch(),
+		)
+	case m.mode == ModeShell && m.shellStage == shellChanges:
+		mainView = lipgloss.JoinVertical(
+			lipgloss.Top,
+			m.shellChanges.View(),
+			m.viewShell(),
+		)
+	case m.mode == ModeShell:
+		mainView = lipgloss.JoinVertical(
+			lipgloss.Top,
+			m.viewChatOutput(),
+			m.viewShell(),
+		)
+	case m.currnetThread == nil:
 		mainView = m.chooseThreadListView()
-	} else {
+	default:
 		mainView = lipgloss.JoinVertical(
 			lipgloss.Top,
-			// m.viewChatOutput(),
+			m.viewChatOutput(),
 			m.viewChatInput(),
 		)
 	}
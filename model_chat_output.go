@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/transcript"
+)
+
+// ChatOutputViewport returns the viewport used to show the transcript of
+// the current thread.
+//
+// Only keys that don't conflict with typing in the editor are used to
+// scroll, the mouse wheel can be used as well.
+func ChatOutputViewport() viewport.Model {
+	chatOutput := viewport.New(80, 10)
+	chatOutput.KeyMap = viewport.KeyMap{
+		PageDown: key.NewBinding(key.WithKeys("pgdown")),
+		PageUp:   key.NewBinding(key.WithKeys("pgup")),
+		Down:     key.NewBinding(key.WithKeys("shift+down")),
+		Up:       key.NewBinding(key.WithKeys("shift+up")),
+	}
+	return chatOutput
+}
+
+// chatInputHeight returns the height of the editor below the transcript,
+// for the given height of the whole screen.
+func chatInputHeight(height int) int {
+	if height/4 < 3 {
+		return 3
+	}
+	return height / 4
+}
+
+// refreshChatOutput re-renders the transcript of the current thread,
+// including the message being sent and response being streamed, if any.
+//
+// If the viewport was scrolled to the bottom, it stays there so new
+// content is always visible.
+func (m *model) refreshChatOutput() {
+	if m.currnetThread == nil {
+		m.chatOutput.SetContent("")
+		m.chatOutputOffsets = nil
+		return
+	}
+
+	if m.transcript == nil || m.transcript.Width() != m.chatOutput.Width {
+		renderer, err := transcript.NewRenderer(m.chatOutput.Width)
+		if err != nil {
+			m.err = err
+			m.statusbar.Err = err
+			return
+		}
+		m.transcript = renderer
+	}
+
+	var pending []chat.Message
+
+	if state := m.chatThreadState(m.currnetThread); state.cancelRequest != nil {
+		pending = append(pending, chat.NewMessage(openai.ChatRoleUser, state.pendingText))
+
+		if state.response != "" {
+			pending = append(pending, chat.NewMessage(openai.ChatRoleAssistant, state.response))
+		}
+	}
+
+	content, offsets, err := m.transcript.RenderBranches(m.currnetThread.ChatHistory, m.chatThreadBranches(m.currnetThread), pending...)
+	if err != nil {
+		m.err = err
+		m.statusbar.Err = err
+		return
+	}
+
+	m.chatOutputOffsets = offsets
+
+	atBottom := m.chatOutput.AtBottom()
+
+	m.chatOutput.SetContent(content)
+
+	if atBottom {
+		m.chatOutput.GotoBottom()
+	}
+}
+
+func (m model) viewChatOutput() string {
+	return m.chatOutput.View()
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/index"
+)
+
+// maxChatSearchResults is the number of indexed search results shown.
+const maxChatSearchResults = 100
+
+var (
+	chatSearchThreadStyle = lipgloss.NewStyle().Bold(true)
+	chatSearchRoleStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	chatSearchMatchStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("231")).Background(lipgloss.Color("62"))
+)
+
+// ChatSearchInput returns the text input used to search across threads.
+func ChatSearchInput() textinput.Model {
+	input := textinput.New()
+	input.Prompt = "Search: "
+	input.PromptStyle = halStyleColor
+	input.Placeholder = "messages in all threads"
+	input.CharLimit = 256
+	input.Width = 60
+	return input
+}
+
+// openChatSearch switches to searching the messages of all threads.
+func (m *model) openChatSearch() tea.Cmd {
+	m.mode = ModeSearch
+	m.chatSearch.Reset()
+	m.chatSearchResults = nil
+	m.chatSearchSelected = 0
+	m.chatSearchErr = nil
+	m.syncChatSearchPrompt()
+	return m.chatSearch.Focus()
+}
+
+// syncChatSearchPrompt shows the search mode in the prompt, unless it's a
+// literal search.
+func (m *model) syncChatSearchPrompt() {
+	m.chatSearch.Prompt = "Search: "
+	if m.chatSearchOptions.Mode != chat.SearchLiteral {
+		m.chatSearch.Prompt = fmt.Sprintf("Search (%s): ", m.chatSearchOptions.Mode)
+	}
+}
+
+// closeChatSearch goes back to the chat thread list.
+func (m *model) closeChatSearch() {
+	m.mode = ModeChatThreadList
+	m.chatSearch.Blur()
+	m.chatSearchResults = nil
+}
+
+// updateChatSearch handles key presses while searching.
+func (m model) updateChatSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Quit):
+		return m, tea.Quit
+	case msg.Type == tea.KeyEscape:
+		m.closeChatSearch()
+		return m, nil
+	case msg.Type == tea.KeyTab:
+		// Switch between literal, regexp and fuzzy searches.
+		m.chatSearchOptions.Mode = (m.chatSearchOptions.Mode + 1) % (chat.SearchFuzzy + 1)
+		m.syncChatSearchPrompt()
+		m.searchChatThreads()
+		return m, nil
+	case msg.Type == tea.KeyUp || msg.Type == tea.KeyCtrlP:
+		if m.chatSearchSelected > 0 {
+			m.chatSearchSelected--
+		}
+		return m, nil
+	case msg.Type == tea.KeyDown || msg.Type == tea.KeyCtrlN:
+		if m.chatSearchSelected < len(m.chatSearchResults)-1 {
+			m.chatSearchSelected++
+		}
+		return m, nil
+	case msg.Type == tea.KeyEnter:
+		if len(m.chatSearchResults) == 0 {
+			return m, nil
+		}
+		m.jumpToChatSearchResult(m.chatSearchResults[m.chatSearchSelected])
+		return m, nil
+	}
+
+	query := m.chatSearch.Value()
+
+	var cmd tea.Cmd
+	m.chatSearch, cmd = m.chatSearch.Update(msg)
+
+	if m.chatSearch.Value() != query {
+		m.searchChatThreads()
+	}
+
+	return m, cmd
+}
+
+// searchChatThreads searches the messages of all threads for the query in
+// the search input, selecting the first result.
+func (m *model) searchChatThreads() {
+	m.chatSearchResults = nil
+	m.chatSearchSelected = 0
+	m.chatSearchErr = nil
+
+	query := strings.TrimSpace(m.chatSearch.Value())
+	if query == "" {
+		return
+	}
+
+	opts := m.chatSearchOptions
+
+	// Literal searches use the index, which matches words and ranks the
+	// results, treating the last word as a prefix while it's being typed.
+	if m.index != nil && opts.Mode == chat.SearchLiteral && !opts.CaseSensitive {
+		if !strings.HasSuffix(m.chatSearch.Value(), " ") {
+			query += "*"
+		}
+
+		m.chatSearchResults = index.Results(m.chatThreads, m.index.Search(query, maxChatSearchResults))
+		return
+	}
+
+	results, err := m.chatThreads.SearchWithOptions(context.Background(), query, &opts)
+	if err != nil {
+		m.chatSearchErr = err
+		return
+	}
+
+	// Show the closest fuzzy matches first, since there can be many that
+	// are not very close.
+	if opts.Mode == chat.SearchFuzzy {
+		chat.SortByScore(results)
+	}
+
+	m.chatSearchResults = results
+}
+
+// jumpToChatSearchResult opens the thread of the search result, scrolled to
+// the message that matched.
+func (m *model) jumpToChatSearchResult(result *chat.ThreadSearchResult) {
+	m.closeChatSearch()
+
+	// Select the thread in the list too, for when the user goes back.
+	for i, ct := range m.chatThreads {
+		if ct == result.Thread {
+			m.chatThreadList.Select(i)
+			break
+		}
+	}
+
+	m.openChatThread(result.Thread)
+
+	if result.MessageIndex < len(m.chatOutputOffsets) {
+		m.chatOutput.SetYOffset(m.chatOutputOffsets[result.MessageIndex])
+	}
+}
+
+// viewChatSearch renders the search input and the results that fit on the
+// screen, keeping the selected result visible.
+func (m model) viewChatSearch() string {
+	var b strings.Builder
+
+	b.WriteString(m.chatSearch.View())
+	b.WriteString("\n\n")
+
+	switch {
+	case m.chatSearchErr != nil:
+		b.WriteString(m.chatSearchErr.Error())
+		return b.String()
+	case strings.TrimSpace(m.chatSearch.Value()) == "":
+		return b.String()
+	case len(m.chatSearchResults) == 0:
+		b.WriteString(chatSearchRoleStyle.Render("No messages found."))
+		return b.String()
+	}
+
+	// Each result is shown on two lines, with a blank line after it, leaving
+	// room for the welcome message, the input, and the status bar.
+	visible := (m.height - 8) / 3
+	if visible < 1 {
+		visible = 1
+	}
+
+	first := 0
+	if m.chatSearchSelected >= visible {
+		first = m.chatSearchSelected - visible + 1
+	}
+
+	last := first + visible
+	if last > len(m.chatSearchResults) {
+		last = len(m.chatSearchResults)
+	}
+
+	for i := first; i < last; i++ {
+		b.WriteString(m.viewChatSearchResult(m.chatSearchResults[i], i == m.chatSearchSelected))
+		b.WriteString("\n")
+	}
+
+	b.WriteString(chatSearchRoleStyle.Render(fmt.Sprintf("%d of %d", m.chatSearchSelected+1, len(m.chatSearchResults))))
+
+	return b.String()
+}
+
+// viewChatSearchResult renders the thread and role of the message that
+// matched, with a snippet of it highlighting the match.
+func (m model) viewChatSearchResult(result *chat.ThreadSearchResult, selected bool) string {
+	gutter := "  "
+	if selected {
+		gutter = halStyleColor.Render("│ ")
+	}
+
+	// Fit the snippet on one line, with the match in the middle, leaving
+	// room for the gutter and the ellipses.
+	width := m.width - 4
+	if width < 20 {
+		width = 20
+	}
+
+	_, match, _ := result.Snippet(0)
+
+	around := (width - len([]rune(match))) / 2
+	if around < 0 {
+		around = 0
+	}
+
+	before, match, after := result.Snippet(around)
+
+	header := chatSearchThreadStyle.Render(result.Thread.Name) + chatSearchRoleStyle.Render(" · "+result.Message.Role)
+	snippet := before + chatSearchMatchStyle.Render(match) + after
+
+	return gutter + header + "\n" + gutter + snippet + "\n"
+}
@@ -1,11 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/picatz/openai"
+
 	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/edit"
+	"github.com/picatz/hal/pkg/export"
+	"github.com/picatz/hal/pkg/policy"
+	"github.com/picatz/hal/pkg/shell"
 )
 
-func ChatThreadList(chatThreads chat.Threads) list.Model {
+func ChatThreadList(chatThreads chat.Threads, keys keyMap) list.Model {
 	// Setup chat thread list.
 	chatThreadList := list.New(chatThreads.ListItems(), list.NewDefaultDelegate(), 80, 10)
 	chatThreadList.SetWidth(80)
@@ -17,7 +35,728 @@ func ChatThreadList(chatThreads chat.Threads) list.Model {
 	chatThreadList.Styles.FilterCursor = halStyleColor
 	chatThreadList.Styles.FilterPrompt = halStyleColor
 	chatThreadList.Styles.DefaultFilterCharacterMatch = halStyleColor
-	chatThreadList.SetShowHelp(false) // true?
+	chatThreadList.SetShowHelp(true)
+
+	chatThreadList.AdditionalShortHelpKeys = func() []key.Binding {
+		return []key.Binding{
+			keys.NewThread,
+			keys.RenameThread,
+			keys.DuplicateThread,
+			keys.DeleteThread,
+			keys.SwitchProvider,
+			keys.SearchThreads,
+			keys.ExportThread,
+		}
+	}
 
 	return chatThreadList
 }
+
+// chatThreadPromptAction is the action a chat thread prompt is asking for.
+type chatThreadPromptAction int
+
+const (
+	chatThreadPromptNone chatThreadPromptAction = iota
+	chatThreadPromptNew
+	chatThreadPromptRename
+	chatThreadPromptDuplicate
+	chatThreadPromptDelete
+	chatThreadPromptExport
+)
+
+// ChatThreadPrompt returns the text input used to ask for thread names.
+func ChatThreadPrompt() textinput.Model {
+	prompt := textinput.New()
+	prompt.PromptStyle = halStyleColor
+	prompt.CharLimit = 128
+	prompt.Width = 60
+	return prompt
+}
+
+// openChatThreadPrompt asks the user for input needed by the action on
+// the given thread.
+func (m *model) openChatThreadPrompt(action chatThreadPromptAction, target *chat.Thread) tea.Cmd {
+	m.chatThreadPromptAction = action
+	m.chatThreadPromptTarget = target
+
+	m.chatThreadPrompt.Reset()
+	m.chatThreadPrompt.Placeholder = ""
+
+	switch action {
+	case chatThreadPromptNew:
+		m.chatThreadPrompt.Prompt = "New thread name: "
+		m.chatThreadPrompt.Placeholder = "leave empty to let HAL name it"
+	case chatThreadPromptRename:
+		m.chatThreadPrompt.Prompt = "Rename thread: "
+		m.chatThreadPrompt.Placeholder = "leave empty to let HAL name it"
+		m.chatThreadPrompt.SetValue(target.Name)
+	case chatThreadPromptDuplicate:
+		m.chatThreadPrompt.Prompt = "Duplicate thread name: "
+		m.chatThreadPrompt.SetValue(target.Name + " (copy)")
+	case chatThreadPromptExport:
+		m.chatThreadPrompt.Prompt = "Export thread to: "
+		m.chatThreadPrompt.Placeholder = "a .md, .json or .html file"
+		m.chatThreadPrompt.SetValue(export.FileName(target, export.Markdown))
+	case chatThreadPromptDelete:
+		// Deleting only needs a yes or no, not a text input.
+		return nil
+	}
+
+	return m.chatThreadPrompt.Focus()
+}
+
+// closeChatThreadPrompt closes the prompt without doing anything.
+func (m *model) closeChatThreadPrompt() {
+	m.chatThreadPromptAction = chatThreadPromptNone
+	m.chatThreadPromptTarget = nil
+	m.chatThreadPrompt.Blur()
+}
+
+// updateChatThreadListKeys handles the key bindings to manage threads in the
+// chat thread list, returning true if the key was handled.
+func (m *model) updateChatThreadListKeys(msg tea.KeyMsg) (tea.Cmd, bool) {
+	// Let the list handle keys while filtering, since they're part of
+	// the filter value.
+	if m.chatThreadList.FilterState() == list.Filtering {
+		return nil, false
+	}
+
+	selected, _ := m.chatThreadList.SelectedItem().(*chat.Thread)
+
+	switch {
+	case key.Matches(msg, m.keys.NewThread):
+		return m.openChatThreadPrompt(chatThreadPromptNew, nil), true
+	case key.Matches(msg, m.keys.RenameThread) && selected != nil:
+		return m.openChatThreadPrompt(chatThreadPromptRename, selected), true
+	case key.Matches(msg, m.keys.DuplicateThread) && selected != nil:
+		return m.openChatThreadPrompt(chatThreadPromptDuplicate, selected), true
+	case key.Matches(msg, m.keys.DeleteThread) && selected != nil:
+		return m.openChatThreadPrompt(chatThreadPromptDelete, selected), true
+	case key.Matches(msg, m.keys.ExportThread) && selected != nil:
+		return m.openChatThreadPrompt(chatThreadPromptExport, selected), true
+	case key.Matches(msg, m.keys.SearchThreads):
+		return m.openChatSearch(), true
+	case key.Matches(msg, m.keys.SwitchProvider) && selected != nil:
+		m.switchChatThreadProvider(selected)
+		m.chatThreadList.SetItems(m.chatThreads.ListItems())
+		return nil, true
+	}
+
+	return nil, false
+}
+
+// updateChatThreadPrompt handles key presses while a chat thread prompt is open.
+func (m *model) updateChatThreadPrompt(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, m.keys.Quit):
+		return tea.Quit
+	case msg.Type == tea.KeyEscape:
+		m.closeChatThreadPrompt()
+		return nil
+	}
+
+	// Deleting is confirmed with "y", anything else cancels.
+	if m.chatThreadPromptAction == chatThreadPromptDelete {
+		if msg.String() == "y" || msg.String() == "Y" {
+			m.deleteChatThread(m.chatThreadPromptTarget)
+		}
+		m.closeChatThreadPrompt()
+		return nil
+	}
+
+	if msg.Type != tea.KeyEnter {
+		var cmd tea.Cmd
+		m.chatThreadPrompt, cmd = m.chatThreadPrompt.Update(msg)
+		return cmd
+	}
+
+	// An empty name lets HAL name the thread after the first exchange,
+	// except for duplicates, which already have one.
+	name := strings.TrimSpace(m.chatThreadPrompt.Value())
+	if name == "" && (m.chatThreadPromptAction == chatThreadPromptDuplicate || m.chatThreadPromptAction == chatThreadPromptExport) {
+		return nil
+	}
+
+	var cmd tea.Cmd
+
+	switch m.chatThreadPromptAction {
+	case chatThreadPromptNew:
+		ct := &chat.Thread{
+			Name:        name,
+			TitleLocked: name != "",
+			Created:     time.Now(),
+			ChatHistory: []chat.Message{
+				m.chatSystemMessage,
+			},
+		}
+		if name == "" {
+			ct.Name = "New thread"
+		}
+		m.addChatThread(ct)
+	case chatThreadPromptRename:
+		// Renaming locks the title, unless it's cleared to unlock it.
+		if name != "" {
+			m.chatThreadPromptTarget.Name = name
+		}
+		m.chatThreadPromptTarget.TitleLocked = name != ""
+		m.saveChatThread(m.chatThreadPromptTarget)
+	case chatThreadPromptDuplicate:
+		m.addChatThread(m.chatThreadPromptTarget.Duplicate(name))
+	case chatThreadPromptExport:
+		cmd = m.exportChatThread(m.chatThreadPromptTarget, name)
+	}
+
+	m.closeChatThreadPrompt()
+	m.chatThreadList.SetItems(m.chatThreads.ListItems())
+
+	return cmd
+}
+
+// exportChatThread writes the thread to the file, in the format of its
+// extension, showing any error in the status bar.
+func (m *model) exportChatThread(ct *chat.Thread, path string) tea.Cmd {
+	format, err := export.FormatFromPath(path)
+	if err != nil {
+		m.statusbar.Err = err
+		return nil
+	}
+
+	var b bytes.Buffer
+	if err := export.Write(&b, ct, format, export.Options{}); err != nil {
+		m.statusbar.Err = err
+		return nil
+	}
+
+	if err := os.WriteFile(path, b.Bytes(), 0o600); err != nil {
+		m.statusbar.Err = err
+		return nil
+	}
+
+	return m.chatThreadList.NewStatusMessage(fmt.Sprintf("Exported %q to %s", ct.Name, path))
+}
+
+// addChatThread adds a new thread to the list, saves it, and selects it.
+func (m *model) addChatThread(ct *chat.Thread) {
+	m.chatThreads = append(m.chatThreads, ct)
+	m.saveChatThread(ct)
+
+	m.chatThreadList.SetItems(m.chatThreads.ListItems())
+	m.chatThreadList.Select(len(m.chatThreads) - 1)
+}
+
+// deleteChatThread removes the thread from the list and the store.
+func (m *model) deleteChatThread(ct *chat.Thread) {
+	if err := m.store.Delete(ct); err != nil {
+		m.err = err
+		m.statusbar.Err = err
+		return
+	}
+
+	if m.index != nil {
+		if err := m.index.Remove(ct.ID); err != nil {
+			m.err = err
+			m.statusbar.Err = err
+		}
+	}
+
+	// Stop waiting for responses that have nowhere to go.
+	if state, ok := m.chatThreadStates[ct]; ok {
+		for _, cancel := range []context.CancelFunc{state.cancelRequest, state.describeCancel, state.compactCancel} {
+			if cancel != nil {
+				cancel()
+			}
+		}
+		delete(m.chatThreadStates, ct)
+	}
+
+	for i, other := range m.chatThreads {
+		if other == ct {
+			m.chatThreads = append(m.chatThreads[:i], m.chatThreads[i+1:]...)
+			break
+		}
+	}
+
+	m.chatThreadList.SetItems(m.chatThreads.ListItems())
+}
+
+// chatThreadDeleted returns true if the thread was saved, but isn't in the
+// list anymore.
+func (m *model) chatThreadDeleted(ct *chat.Thread) bool {
+	for _, other := range m.chatThreads {
+		if other == ct {
+			return false
+		}
+	}
+	return ct.ID != ""
+}
+
+// saveChatThread persists the thread and updates the search index, showing
+// any error in the status bar.
+func (m *model) saveChatThread(ct *chat.Thread) {
+	if err := m.store.Save(ct); err != nil {
+		m.err = err
+		m.statusbar.Err = err
+		return
+	}
+
+	if m.index != nil {
+		if err := m.index.Update(ct); err != nil {
+			m.err = err
+			m.statusbar.Err = err
+		}
+	}
+}
+
+// viewChatThreadPrompt renders the open chat thread prompt, if any.
+func (m model) viewChatThreadPrompt() string {
+	switch m.chatThreadPromptAction {
+	case chatThreadPromptNone:
+		return ""
+	case chatThreadPromptDelete:
+		return fmt.Sprintf("Delete thread %q? (y/N)", m.chatThreadPromptTarget.Name)
+	default:
+		return m.chatThreadPrompt.View()
+	}
+}
+
+// chatThreadProviderName returns the name of the provider used by the thread.
+func (m *model) chatThreadProviderName(ct *chat.Thread) string {
+	if ct.Provider != "" {
+		return ct.Provider
+	}
+	return m.defaultProvider
+}
+
+// chatThreadProvider returns the provider used by the thread.
+func (m *model) chatThreadProvider(ct *chat.Thread) (chat.Provider, error) {
+	name := m.chatThreadProviderName(ct)
+
+	provider, ok := m.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("provider %q is not configured", name)
+	}
+
+	return provider, nil
+}
+
+// switchChatThreadProvider changes the thread to use the next configured
+// provider, in alphabetical order.
+func (m *model) switchChatThreadProvider(ct *chat.Thread) {
+	names := make([]string, 0, len(m.providers))
+	for name := range m.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return
+	}
+
+	next := names[0]
+	current := m.chatThreadProviderName(ct)
+	for i, name := range names {
+		if name == current && i+1 < len(names) {
+			next = names[i+1]
+		}
+	}
+
+	ct.Provider = next
+	m.saveChatThread(ct)
+}
+
+// chatThreadState is the state of a thread that isn't persisted, which is
+// kept while switching between threads.
+type chatThreadState struct {
+	// cancelRequest cancels the in-flight chat request, if any.
+	cancelRequest context.CancelFunc
+
+	// request counts the chat requests sent, so the messages streamed by
+	// one that was canceled are told apart from those of the next one.
+	request int
+
+	// pendingText is the text of the in-flight chat request, which is
+	// restored into the editor if the request is canceled or fails.
+	pendingText string
+
+	// response is the content streamed so far for the in-flight request.
+	response string
+
+	// err is the last error for the thread, shown in the status bar.
+	err error
+
+	// historyLen is the length of the chat history when the in-flight
+	// request was sent, so the reply is added after any changes made to the
+	// history since, like a compaction.
+	historyLen int
+
+	// shellCancel stops proposing or running a shell command, if any.
+	shellCancel context.CancelFunc
+
+	// shellMessages are the task and proposal of the shell command that is
+	// running, which are added to the history with its output.
+	shellMessages []chat.Message
+
+	// shellEntry is the audit log entry of the shell command that is
+	// running, which is logged with its result.
+	shellEntry *policy.Entry
+
+	// shellResult is the result of a shell command that changed files in
+	// its sandbox, which are reviewed before it's added to the history
+	// with shellMessages.
+	shellResult *shell.Result
+
+	// fileCancel stops proposing an edit of the file being edited, if any.
+	fileCancel context.CancelFunc
+
+	// compactCancel stops summarizing older messages, if they're being
+	// summarized.
+	compactCancel context.CancelFunc
+
+	// describeCancel stops generating a title or summary, if one is being
+	// generated.
+	describeCancel context.CancelFunc
+
+	// historyTokens caches the number of tokens in the thread's history,
+	// which is only counted again once the history or model changes, so
+	// counting the draft on every key press stays fast.
+	historyTokens      int
+	historyTokensLen   int
+	historyTokensModel string
+
+	// branches caches where each message of the thread's history is among
+	// its siblings, which is only worked out again once the history, its
+	// head, or its graph changes, so streaming a reply stays fast.
+	branches      []chat.Branch
+	branchesHead  string
+	branchesNodes int
+}
+
+// chatThreadState returns the state for the given thread, creating it if
+// needed.
+func (m *model) chatThreadState(ct *chat.Thread) *chatThreadState {
+	state, ok := m.chatThreadStates[ct]
+	if !ok {
+		state = &chatThreadState{}
+		m.chatThreadStates[ct] = state
+	}
+	return state
+}
+
+// syncStatusbar updates the status bar to show the state of the current
+// thread, if any.
+func (m *model) syncStatusbar() {
+	m.statusbar.ChatThread = m.currnetThread
+	m.statusbar.Spinning = false
+	m.statusbar.Err = nil
+	m.statusbar.Provider = ""
+	m.statusbar.TokensUsed = 0
+	m.statusbar.TokenLimit = 0
+	m.statusbar.TokensOver = false
+
+	if m.currnetThread != nil {
+		m.statusbar.Provider = m.chatThreadProviderName(m.currnetThread)
+
+		state := m.chatThreadState(m.currnetThread)
+		m.statusbar.Spinning = state.cancelRequest != nil || state.compactCancel != nil || state.shellCancel != nil || state.fileCancel != nil
+		m.statusbar.Err = state.err
+
+		// Count what the next request would use, which is the in-flight
+		// request and its response so far, or the draft.
+		extra := []chat.Message{
+			chat.NewMessage(openai.ChatRoleUser, m.editor.Value()),
+		}
+		if state.cancelRequest != nil {
+			extra = []chat.Message{
+				chat.NewMessage(openai.ChatRoleUser, state.pendingText),
+				chat.NewMessage(openai.ChatRoleAssistant, state.response),
+			}
+		}
+
+		m.statusbar.TokensUsed, m.statusbar.TokenLimit = m.chatThreadTokens(m.currnetThread, extra...)
+
+		if budget, ok := m.chatThreadTokenBudget(m.currnetThread); ok {
+			m.statusbar.TokensOver = budget.check(m.statusbar.TokensUsed) != nil
+		}
+	}
+}
+
+// chatThreadTokenBudget returns the token budget of the thread's provider.
+func (m *model) chatThreadTokenBudget(ct *chat.Thread) (tokenBudget, bool) {
+	budget, ok := m.tokenBudgets[m.chatThreadProviderName(ct)]
+	return budget, ok
+}
+
+// chatThreadTokens returns the number of tokens the thread's history uses,
+// with any extra messages that haven't been added to it yet, and the context
+// window of the thread's model. The limit is zero if it isn't known.
+func (m *model) chatThreadTokens(ct *chat.Thread, extra ...chat.Message) (int, int) {
+	budget, ok := m.chatThreadTokenBudget(ct)
+	if !ok {
+		return 0, 0
+	}
+
+	tokenizer, err := budget.tokenizer()
+	if err != nil {
+		return 0, 0
+	}
+
+	state := m.chatThreadState(ct)
+	if state.historyTokensLen != len(ct.ChatHistory) || state.historyTokensModel != budget.model {
+		state.historyTokens = tokenizer.CountMessages(ct.ChatHistory)
+		state.historyTokensLen = len(ct.ChatHistory)
+		state.historyTokensModel = budget.model
+	}
+
+	tokens := state.historyTokens
+	for _, msg := range extra {
+		if msg.Content != "" {
+			tokens += tokenizer.CountMessage(msg)
+		}
+	}
+
+	return tokens, budget.limit
+}
+
+// chatThreadBranches returns where each message of the thread's chat
+// history is among its siblings.
+func (m *model) chatThreadBranches(ct *chat.Thread) []chat.Branch {
+	state := m.chatThreadState(ct)
+
+	if len(state.branches) != len(ct.ChatHistory) || state.branchesHead != ct.Head || state.branchesNodes != len(ct.Nodes) {
+		state.branches = ct.Branches()
+		state.branchesHead = ct.Head
+		state.branchesNodes = len(ct.Nodes)
+	}
+
+	return state.branches
+}
+
+// chatThreadMsg wraps a message from a chat request with the thread it
+// belongs to, so the response ends up in the right thread even if the user
+// switched threads while waiting.
+type chatThreadMsg struct {
+	Thread *chat.Thread
+	Msg    tea.Msg
+
+	// Request is the chat request the message is from, if it's from one.
+	Request int
+}
+
+// chatThreadCmd wraps the message returned by the command in a chatThreadMsg
+// for the given thread.
+func chatThreadCmd(ct *chat.Thread, cmd tea.Cmd) tea.Cmd {
+	return func() tea.Msg {
+		return chatThreadMsg{Thread: ct, Msg: cmd()}
+	}
+}
+
+// chatRequestCmd is like chatThreadCmd, for the commands streaming the
+// response to a chat request of the thread.
+func chatRequestCmd(ct *chat.Thread, request int, cmd tea.Cmd) tea.Cmd {
+	return func() tea.Msg {
+		return chatThreadMsg{Thread: ct, Msg: cmd(), Request: request}
+	}
+}
+
+// updateChatThreadMsg handles the messages from chat requests.
+func (m model) updateChatThreadMsg(msg chatThreadMsg) (tea.Model, tea.Cmd) {
+	// Describing or compacting a thread that was deleted since would save
+	// it again.
+	switch msg.Msg.(type) {
+	case chat.DescribedMsg, chat.CompactedMsg:
+		if m.chatThreadDeleted(msg.Thread) {
+			return m, nil
+		}
+	}
+
+	var (
+		ct      = msg.Thread
+		state   = m.chatThreadState(ct)
+		current = ct == m.currnetThread
+		request = msg.Request
+	)
+
+	switch msg := msg.Msg.(type) {
+	case chat.StreamDeltaMsg:
+		// Drain the stream, but don't show anything if it was canceled,
+		// even if another request was sent since.
+		if state.cancelRequest != nil && request == state.request {
+			state.response += msg.Delta
+			if current {
+				m.refreshChatOutput()
+			}
+		}
+
+		return m, chatRequestCmd(ct, request, msg.Next)
+	case chat.FinishedMsg:
+		// The request was canceled by the user, which was already handled.
+		if errors.Is(msg.Err, context.Canceled) || request != state.request {
+			return m, nil
+		}
+
+		if state.cancelRequest != nil {
+			state.cancelRequest()
+			state.cancelRequest = nil
+		}
+
+		pendingText := state.pendingText
+
+		state.pendingText = ""
+		state.response = ""
+
+		if msg.Err != nil {
+			m.err = msg.Err
+			state.err = msg.Err
+
+			// Nothing was received, so there's nothing to keep, and the
+			// user can try to send their text again.
+			if len(msg.Buffer) == 0 {
+				if current && m.editor.Value() == "" {
+					m.editor.SetValue(pendingText)
+				} else if !current && ct.Draft == "" {
+					ct.Draft = pendingText
+					m.saveChatThread(ct)
+				}
+				m.syncStatusbar()
+				m.refreshChatOutput()
+				return m, nil
+			}
+		}
+
+		// Add the new messages, keeping any changes made to the history
+		// while waiting for the reply.
+		if state.historyLen <= len(msg.History) {
+			ct.ChatHistory = append(ct.ChatHistory, msg.History[state.historyLen:]...)
+		}
+
+		// Streamed responses may not report the token usage.
+		if msg.Tokens > 0 {
+			ct.Tokens = msg.Tokens
+		}
+
+		// Persist the thread after every exchange, so nothing is lost
+		// if the program exits.
+		m.saveChatThread(ct)
+
+		m.syncStatusbar()
+		m.refreshChatOutput()
+
+		return m, tea.Batch(m.compactChatThread(ct, false), m.describeChatThread(ct))
+	case shell.ProposedMsg:
+		m.updateShellProposed(ct, msg)
+	case shell.FinishedMsg:
+		return m, m.updateShellFinished(ct, msg)
+	case shellSandboxMsg:
+		return m, m.execShellSandboxed(ct, msg)
+	case edit.ProposedMsg:
+		m.updateFileEditProposed(ct, msg)
+	case chat.DescribedMsg:
+		state.describeCancel = nil
+
+		// Failing to name a thread isn't worth interrupting the user for,
+		// it's tried again after the next reply.
+		if msg.Err != nil {
+			return m, nil
+		}
+
+		if msg.Title != "" && !ct.TitleLocked {
+			ct.Name = msg.Title
+		}
+		if msg.Summary != "" {
+			ct.Summary = msg.Summary
+		}
+		ct.SummarizedAt = msg.HistoryLen
+
+		m.saveChatThread(ct)
+		m.chatThreadList.SetItems(m.chatThreads.ListItems())
+		m.syncStatusbar()
+	case chat.CompactedMsg:
+		state.compactCancel = nil
+
+		switch {
+		case msg.Err != nil:
+			state.err = msg.Err
+		case ct.ApplyCompaction(msg.Compaction):
+			state.err = nil
+			m.saveChatThread(ct)
+		}
+
+		m.syncStatusbar()
+		if current {
+			m.refreshChatOutput()
+		}
+	}
+
+	return m, nil
+}
+
+// describeChatThread starts generating a title and summary for the thread
+// in the background, after its first exchange, or refreshes its summary
+// once enough messages were added. It returns nil if neither is needed.
+func (m *model) describeChatThread(ct *chat.Thread) tea.Cmd {
+	state := m.chatThreadState(ct)
+	if state.describeCancel != nil {
+		return nil
+	}
+
+	title := ct.NeedsTitle()
+	if !title && !ct.NeedsSummary() {
+		return nil
+	}
+
+	provider, err := m.chatThreadProvider(ct)
+	if err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+
+	describe := chat.Describe(ctx, provider, ct.ChatHistory, title)
+
+	state.describeCancel = cancel
+
+	return chatThreadCmd(ct, func() tea.Msg {
+		defer cancel()
+		return describe()
+	})
+}
+
+// compactChatThread starts replacing the older messages of the thread with
+// a summary, once it nears the context window of its model, or if forced
+// to. It returns nil if the thread doesn't need to be, or can't be,
+// compacted.
+func (m *model) compactChatThread(ct *chat.Thread, force bool) tea.Cmd {
+	state := m.chatThreadState(ct)
+	if !m.compaction.Enabled || state.compactCancel != nil {
+		return nil
+	}
+
+	tokens, limit := m.chatThreadTokens(ct)
+	if limit == 0 || (!force && float64(tokens) < m.compaction.Threshold*float64(limit)) {
+		return nil
+	}
+
+	provider, err := m.chatThreadProvider(ct)
+	if err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+
+	compact := chat.Compact(ctx, provider, ct.ChatHistory, m.compaction.Keep)
+	if compact == nil {
+		cancel()
+		return nil
+	}
+
+	state.compactCancel = cancel
+	m.syncStatusbar()
+
+	return tea.Batch(
+		chatThreadCmd(ct, func() tea.Msg {
+			defer cancel()
+			return compact()
+		}),
+		m.statusbar.Spinner.Tick,
+	)
+}
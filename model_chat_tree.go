@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+var (
+	chatTreeDimStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	chatTreeHeadStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("69")).Bold(true)
+)
+
+// chatTreeLine is a message of a thread's conversation graph, as it's shown
+// in the tree of the conversation.
+type chatTreeLine struct {
+	node *chat.Node
+
+	// prefix draws the branches of the tree before the message.
+	prefix string
+}
+
+// chatTreeLines returns the messages of the thread's conversation graph in
+// the order they're shown in its tree, depth first. A conversation without
+// branches is a single column, and each branch is indented under the
+// message it was sent after.
+func chatTreeLines(ct *chat.Thread) []chatTreeLine {
+	var (
+		lines []chatTreeLine
+		seen  = map[string]bool{}
+		walk  func(nodes []*chat.Node, indent string)
+	)
+
+	walk = func(nodes []*chat.Node, indent string) {
+		for i, node := range nodes {
+			// Don't loop forever on a graph that was edited into a cycle.
+			if seen[node.ID] {
+				continue
+			}
+			seen[node.ID] = true
+
+			prefix, childIndent := indent, indent
+			if len(nodes) > 1 {
+				if i == len(nodes)-1 {
+					prefix, childIndent = indent+"└─ ", indent+"   "
+				} else {
+					prefix, childIndent = indent+"├─ ", indent+"│  "
+				}
+			}
+
+			lines = append(lines, chatTreeLine{node: node, prefix: prefix})
+
+			walk(ct.Children(node.ID), childIndent)
+		}
+	}
+
+	walk(ct.Children(""), "")
+
+	return lines
+}
+
+// chatRoleName returns the name shown for the role of a message.
+func chatRoleName(msg chat.Message) string {
+	switch {
+	case chat.IsSummary(msg):
+		return "Summary"
+	case msg.Role == openai.ChatRoleUser:
+		return "You"
+	case msg.Role == openai.ChatRoleAssistant:
+		return "HAL"
+	case msg.Role == openai.ChatRoleSystem:
+		return "System"
+	default:
+		return msg.Role
+	}
+}
+
+// chatSnippet returns the first line of the message's content, cut to fit
+// the given width.
+func chatSnippet(content string, width int) string {
+	var line string
+	for _, l := range strings.Split(strings.TrimSpace(content), "\n") {
+		if line = strings.TrimSpace(l); line != "" {
+			break
+		}
+	}
+
+	if runes := []rune(line); width > 0 && len(runes) > width {
+		line = string(runes[:width-1]) + "…"
+	}
+
+	return line
+}
+
+// openChatTree shows the tree of the current thread's conversation, with
+// the message the chat history ends at selected.
+func (m *model) openChatTree() {
+	if !m.canSwitchChatBranch() {
+		return
+	}
+
+	m.currnetThread.Sync()
+
+	m.chatTreeLines = chatTreeLines(m.currnetThread)
+	m.chatTreeSelected = 0
+
+	for i, line := range m.chatTreeLines {
+		if line.node.ID == m.currnetThread.Head {
+			m.chatTreeSelected = i
+		}
+	}
+
+	m.mode = ModeTree
+}
+
+// closeChatTree goes back to the current thread.
+func (m *model) closeChatTree() {
+	m.mode = ModeEditorInsert
+	m.chatTreeLines = nil
+}
+
+// canSwitchChatBranch returns true if the current thread can change
+// branches, which it can't while waiting for a reply, since the reply would
+// end up on the wrong branch.
+func (m *model) canSwitchChatBranch() bool {
+	if m.chatThreadState(m.currnetThread).cancelRequest == nil {
+		return true
+	}
+
+	m.statusbar.Err = fmt.Errorf("wait for the reply, or cancel it, before changing branches")
+
+	return false
+}
+
+// checkoutChatThread continues the current thread from the message with the
+// given ID, saving it and showing its new chat history.
+func (m *model) checkoutChatThread(id string) bool {
+	if err := m.currnetThread.Checkout(id); err != nil {
+		m.err = err
+		m.statusbar.Err = err
+		return false
+	}
+
+	// The history can change without changing length, so it's counted,
+	// and its branches worked out, again.
+	state := m.chatThreadState(m.currnetThread)
+	state.historyTokensLen = -1
+	state.branches = nil
+
+	m.saveChatThread(m.currnetThread)
+	m.syncStatusbar()
+	m.refreshChatOutput()
+	m.chatOutput.GotoBottom()
+
+	return true
+}
+
+// switchChatBranch switches the last message of the current thread's chat
+// history which has siblings to its previous or next sibling, continuing
+// from the newest message of that branch.
+func (m *model) switchChatBranch(delta int) {
+	if !m.canSwitchChatBranch() {
+		return
+	}
+
+	ct := m.currnetThread
+	ct.Sync()
+
+	nodes := ct.HistoryNodes()
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if nodes[i] == nil {
+			continue
+		}
+
+		siblings := ct.Siblings(nodes[i].ID)
+		if len(siblings) < 2 {
+			continue
+		}
+
+		for j, sibling := range siblings {
+			if sibling == nodes[i] && j+delta >= 0 && j+delta < len(siblings) {
+				m.checkoutChatThread(ct.Leaf(siblings[j+delta].ID))
+			}
+		}
+
+		return
+	}
+}
+
+// truncateChatThread continues the current thread from its system message
+// with only its last message, as a new branch, so the earlier messages
+// aren't sent anymore but can still be gone back to.
+func (m *model) truncateChatThread() {
+	ct := m.currnetThread
+	if len(ct.ChatHistory) <= 2 || !m.canSwitchChatBranch() {
+		return
+	}
+
+	ct.Sync()
+
+	nodes := ct.HistoryNodes()
+	if nodes[0] == nil {
+		return
+	}
+
+	last := ct.ChatHistory[len(ct.ChatHistory)-1]
+
+	if err := ct.Checkout(nodes[0].ID); err != nil {
+		m.err = err
+		m.statusbar.Err = err
+		return
+	}
+
+	ct.ChatHistory = append(ct.ChatHistory, last)
+
+	state := m.chatThreadState(ct)
+	state.historyTokensLen = -1
+	state.branches = nil
+
+	m.saveChatThread(ct)
+	m.syncStatusbar()
+	m.refreshChatOutput()
+	m.chatOutput.GotoBottom()
+}
+
+// regenerateChatReply asks for a new response to the last message the user
+// sent in the current thread, which is added as a new branch next to the
+// previous response.
+func (m *model) regenerateChatReply() tea.Cmd {
+	if !m.canSwitchChatBranch() {
+		return nil
+	}
+
+	ct := m.currnetThread
+	ct.Sync()
+
+	nodes := ct.HistoryNodes()
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if nodes[i] != nil && nodes[i].Role == openai.ChatRoleUser {
+			return m.resendChatMessage(nodes[i])
+		}
+	}
+
+	return nil
+}
+
+// resendChatMessage sends the user message again from where it was first
+// sent, so the new response starts a new branch.
+func (m *model) resendChatMessage(node *chat.Node) tea.Cmd {
+	if !m.checkoutChatThread(node.Parent) {
+		return nil
+	}
+
+	cmd, sent := m.sendChatMessage(node.Content)
+	if !sent && m.editor.Value() == "" {
+		m.editor.SetValue(node.Content)
+	}
+
+	return cmd
+}
+
+// updateChatTree handles key presses while the tree of the current thread's
+// conversation is shown.
+func (m model) updateChatTree(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if len(m.chatTreeLines) == 0 {
+		m.closeChatTree()
+		return m, nil
+	}
+
+	selected := m.chatTreeLines[m.chatTreeSelected].node
+
+	switch {
+	case key.Matches(msg, m.keys.Quit):
+		// Keep the unsent text for next time.
+		m.currnetThread.Draft = m.editor.Value()
+		m.saveChatThread(m.currnetThread)
+		return m, tea.Quit
+	case msg.Type == tea.KeyEscape || key.Matches(msg, m.keys.ThreadTree):
+		m.closeChatTree()
+	case msg.Type == tea.KeyUp || msg.String() == "k":
+		if m.chatTreeSelected > 0 {
+			m.chatTreeSelected--
+		}
+	case msg.Type == tea.KeyDown || msg.String() == "j":
+		if m.chatTreeSelected < len(m.chatTreeLines)-1 {
+			m.chatTreeSelected++
+		}
+	case msg.Type == tea.KeyLeft || msg.String() == "h":
+		m.selectChatTreeSibling(selected, -1)
+	case msg.Type == tea.KeyRight || msg.String() == "l":
+		m.selectChatTreeSibling(selected, 1)
+	case msg.Type == tea.KeyEnter:
+		// Continue the conversation from the selected message.
+		m.closeChatTree()
+		m.checkoutChatThread(selected.ID)
+	case msg.String() == "e" && selected.Role == openai.ChatRoleUser:
+		// Editing a message sends the new text after the same parent.
+		m.closeChatTree()
+		if m.checkoutChatThread(selected.Parent) {
+			m.editor.SetValue(selected.Content)
+		}
+	case msg.String() == "r":
+		// Regenerate the selected response, or the response to the
+		// selected message.
+		node := selected
+		if node.Role == openai.ChatRoleAssistant {
+			node = m.currnetThread.Node(node.Parent)
+		}
+		if node == nil || node.Role != openai.ChatRoleUser {
+			break
+		}
+		m.closeChatTree()
+		return m, m.resendChatMessage(node)
+	}
+
+	return m, nil
+}
+
+// selectChatTreeSibling selects the previous or next sibling of the node in
+// the tree.
+func (m *model) selectChatTreeSibling(node *chat.Node, delta int) {
+	siblings := m.currnetThread.Siblings(node.ID)
+
+	for i, sibling := range siblings {
+		if sibling != node || i+delta < 0 || i+delta >= len(siblings) {
+			continue
+		}
+
+		for j, line := range m.chatTreeLines {
+			if line.node == siblings[i+delta] {
+				m.chatTreeSelected = j
+			}
+		}
+	}
+}
+
+// viewChatTree renders the lines of the tree that fit on the screen,
+// keeping the selected message visible, with the messages of the current
+// branch highlighted.
+func (m model) viewChatTree() string {
+	var b strings.Builder
+
+	b.WriteString(chatTreeHeadStyle.Render(m.currnetThread.Name))
+	b.WriteString("\n\n")
+
+	current := map[*chat.Node]bool{}
+	for _, node := range m.currnetThread.Path(m.currnetThread.Head) {
+		current[node] = true
+	}
+
+	// Leave room for the title, the help, and the status bar.
+	visible := m.height - 7
+	if visible < 1 {
+		visible = 1
+	}
+
+	first := 0
+	if m.chatTreeSelected >= visible {
+		first = m.chatTreeSelected - visible + 1
+	}
+
+	last := first + visible
+	if last > len(m.chatTreeLines) {
+		last = len(m.chatTreeLines)
+	}
+
+	for i := first; i < last; i++ {
+		line := m.chatTreeLines[i]
+
+		gutter := "  "
+		if i == m.chatTreeSelected {
+			gutter = halStyleColor.Render("│ ")
+		}
+
+		width := m.width - len([]rune(line.prefix)) - 12
+		text := chatRoleName(line.node.Message) + ": " + chatSnippet(line.node.Content, width)
+
+		switch {
+		case line.node.ID == m.currnetThread.Head:
+			text = chatTreeHeadStyle.Render(text + " ●")
+		case !current[line.node]:
+			text = chatTreeDimStyle.Render(text)
+		}
+
+		b.WriteString(gutter + chatTreeDimStyle.Render(line.prefix) + text + "\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(chatTreeDimStyle.Render("↑/↓ move · ←/→ sibling · enter continue from here · e edit · r regenerate · esc back"))
+
+	return b.String()
+}
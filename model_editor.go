@@ -3,17 +3,19 @@ package main
 import (
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/lipgloss"
+
+	"github.com/picatz/hal/pkg/config"
 )
 
-func EditorTextArea() textarea.Model {
+func EditorTextArea(cfg *config.Editor) textarea.Model {
 	editor := textarea.New()
 	editor.Placeholder = "What do you want to do?"
 	editor.Prompt = halStyleColor.Bold(true).Render("│")
-	editor.CharLimit = 4096
+	editor.CharLimit = cfg.CharLimit
 	editor.SetWidth(80)
 	editor.Focus()
 	editor.Focused()
-	editor.ShowLineNumbers = true
+	editor.ShowLineNumbers = cfg.LineNumbers
 	editor.FocusedStyle.CursorLine = lipgloss.NewStyle().
 		Background(lipgloss.Color("236")). // faint background with
 		Foreground(lipgloss.Color("231"))  // extra bright text on cursor line
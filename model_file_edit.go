@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/diff"
+	"github.com/picatz/hal/pkg/diffview"
+	"github.com/picatz/hal/pkg/edit"
+)
+
+var (
+	diffInsertStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("34"))
+	diffDeleteStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("160"))
+	diffHunkStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("37"))
+	lineNumberStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+)
+
+// fileStage is the step of editing a file in file edit mode.
+type fileStage int
+
+const (
+	// fileInstruct is when the user writes an instruction to edit the file.
+	fileInstruct fileStage = iota
+
+	// fileProposing is while HAL edits the file as instructed.
+	fileProposing
+
+	// fileReview is when the user reviews the hunks of the proposed edit,
+	// accepting or rejecting each, before they're written to the file.
+	fileReview
+)
+
+// openFile opens the file to edit it with instructions in natural language,
+// in a new thread that is saved once an edit is first proposed.
+func (m *model) openFile(path string) error {
+	f, err := edit.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	ct := &chat.Thread{
+		Name:        "Edit " + path,
+		TitleLocked: true,
+		Created:     time.Now(),
+		ChatHistory: []chat.Message{
+			edit.SystemMessage(path),
+		},
+	}
+
+	m.openChatThread(ct)
+
+	m.mode = ModeFileEdit
+	m.fileEdit = f
+	m.fileNote = ""
+	m.editor.Placeholder = "How should " + path + " change?"
+	m.setFileStage(fileInstruct)
+
+	return nil
+}
+
+// setFileStage moves file edit mode to the stage, showing the file, or the
+// proposed edit to review.
+func (m *model) setFileStage(stage fileStage) {
+	m.fileStage = stage
+
+	switch stage {
+	case fileInstruct:
+		m.fileDiff = nil
+		m.fileView.SetContent(renderFile(m.fileEdit.Content))
+		m.editor.Focus()
+	case fileReview:
+		m.editor.Blur()
+		m.fileDiff.SetSize(m.fileView.Width, m.fileView.Height)
+	}
+}
+
+// updateFileEdit handles key presses in file edit mode.
+func (m model) updateFileEdit(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	ct := m.currnetThread
+	state := m.chatThreadState(ct)
+
+	switch {
+	case key.Matches(msg, m.keys.Quit):
+		// Only threads with an edit are kept, with the unsent text.
+		if ct.ID != "" {
+			ct.Draft = m.editor.Value()
+			m.saveChatThread(ct)
+		}
+		return m, tea.Quit
+	case key.Matches(msg, m.keys.Cancel):
+		if state.fileCancel == nil {
+			return m, nil
+		}
+
+		state.fileCancel()
+		state.fileCancel = nil
+
+		m.setFileStage(fileInstruct)
+		m.syncStatusbar()
+
+		return m, nil
+	case m.fileStage != fileReview && (msg.Type == tea.KeyPgUp || msg.Type == tea.KeyPgDown):
+		var cmd tea.Cmd
+		m.fileView, cmd = m.fileView.Update(msg)
+		return m, cmd
+	}
+
+	switch m.fileStage {
+	case fileInstruct:
+		if key.Matches(msg, m.keys.Send) {
+			instruction := strings.TrimSpace(m.editor.Value())
+			if instruction == "" {
+				return m, nil
+			}
+			return m, m.proposeFileEdit(instruction)
+		}
+
+		var cmd tea.Cmd
+		m.editor, cmd = m.editor.Update(msg)
+
+		// Keep the token count up to date with the instruction.
+		m.syncStatusbar()
+
+		return m, cmd
+	case fileReview:
+		return m, m.updateFileReview(msg)
+	}
+
+	// Nothing to type while waiting.
+	return m, nil
+}
+
+// updateFileReview handles key presses while reviewing an edit, which are
+// passed on to the diff view, except to write or discard the edit.
+func (m *model) updateFileReview(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "enter":
+		m.writeFileEdit()
+		return nil
+	case "esc":
+		m.fileNote = "Discarded the edit."
+		m.setFileStage(fileInstruct)
+		return nil
+	}
+
+	var cmd tea.Cmd
+	m.fileDiff, cmd = m.fileDiff.Update(msg)
+	return cmd
+}
+
+// proposeFileEdit asks the current thread's provider to edit the file as
+// instructed.
+func (m *model) proposeFileEdit(instruction string) tea.Cmd {
+	ct := m.currnetThread
+	state := m.chatThreadState(ct)
+
+	provider, err := m.chatThreadProvider(ct)
+	if err != nil {
+		state.err = err
+		m.syncStatusbar()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+
+	state.fileCancel = cancel
+	state.err = nil
+
+	m.fileNote = ""
+	m.fileStage = fileProposing
+	m.editor.Blur()
+	m.syncStatusbar()
+
+	return tea.Batch(
+		chatThreadCmd(ct, edit.Propose(ctx, provider, m.chatOptions, ct.ChatHistory, m.fileEdit.Path, m.fileEdit.Content, instruction)),
+		m.statusbar.Spinner.Tick,
+	)
+}
+
+// updateFileEditProposed adds the instruction and the proposed edit to the
+// thread, and shows the edit for review, unless the user moved on while
+// waiting for it.
+func (m *model) updateFileEditProposed(ct *chat.Thread, msg edit.ProposedMsg) {
+	state := m.chatThreadState(ct)
+	if state.fileCancel != nil {
+		state.fileCancel()
+		state.fileCancel = nil
+	}
+
+	if ct != m.currnetThread || m.mode != ModeFileEdit || m.fileStage != fileProposing {
+		return
+	}
+
+	if msg.Err != nil {
+		state.err = msg.Err
+		m.setFileStage(fileInstruct)
+		m.syncStatusbar()
+		return
+	}
+
+	ct.ChatHistory = append(ct.ChatHistory, msg.Messages...)
+
+	if ct.ID == "" {
+		m.addChatThread(ct)
+	} else {
+		m.saveChatThread(ct)
+	}
+
+	m.editor.Reset()
+
+	// Every hunk is accepted until it's rejected.
+	m.fileDiff = newDiffView(m.fileEdit.Content, msg.Content)
+	if len(m.fileDiff.Hunks()) == 0 {
+		m.fileNote = "HAL didn't change anything."
+		m.setFileStage(fileInstruct)
+		m.syncStatusbar()
+		return
+	}
+
+	m.setFileStage(fileReview)
+	m.syncStatusbar()
+}
+
+// writeFileEdit writes the accepted hunks of the edit to the file.
+func (m *model) writeFileEdit() {
+	state := m.chatThreadState(m.currnetThread)
+
+	accepted, total := m.fileDiff.Count()
+	if accepted > 0 {
+		if err := m.fileEdit.Write(m.fileDiff.Result()); err != nil {
+			state.err = err
+			m.syncStatusbar()
+			return
+		}
+	}
+
+	state.err = nil
+	m.fileNote = fmt.Sprintf("Wrote %d of %d changes to %s.", accepted, total, m.fileEdit.Path)
+
+	m.setFileStage(fileInstruct)
+	m.syncStatusbar()
+}
+
+// newDiffView returns a diff view of the changes from the old to the new
+// content, with the cursor in the theme's color.
+func newDiffView(old, new string) *diffview.Model {
+	d := diffview.New(old, new)
+	d.Styles.Cursor = halStyleColor.Copy().Bold(true)
+	return d
+}
+
+// renderFile renders the content of the file with line numbers.
+func renderFile(content string) string {
+	var b strings.Builder
+
+	for i, line := range diff.SplitLines(content) {
+		line = strings.ReplaceAll(strings.TrimRight(line, "\r\n"), "\t", "    ")
+		b.WriteString(lineNumberStyle.Render(fmt.Sprintf("%4d │ ", i+1)) + line + "\n")
+	}
+
+	return b.String()
+}
+
+// viewFileEdit renders the input of file edit mode, with help for the
+// current stage.
+func (m model) viewFileEdit() string {
+	var input, help string
+
+	switch m.fileStage {
+	case fileInstruct:
+		input = m.editor.View()
+		help = m.keys.Send.Help().Key + " edit · pgup/pgdown scroll · " + m.keys.Quit.Help().Key + " quit"
+		if m.fileNote != "" {
+			help = m.fileNote + " · " + help
+		}
+	case fileProposing:
+		input = shellHelpStyle.Render("Editing " + m.fileEdit.Path + "…")
+		help = m.keys.Cancel.Help().Key + " cancel"
+	case fileReview:
+		accepted, total := m.fileDiff.Count()
+
+		input = fmt.Sprintf("%d of %d changes to %s accepted.", accepted, total, m.fileEdit.Path)
+		help = m.fileDiff.Help() + " · enter write · esc discard"
+	}
+
+	return input + "\n" + shellHelpStyle.Render(help)
+}
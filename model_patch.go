@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/diff"
+	"github.com/picatz/hal/pkg/edit"
+)
+
+// openPatch reviews the patch of the reply being read in the transcript,
+// which is the last reply with a patch that starts in view, or else the
+// latest reply with one. Each file it changes is reviewed in turn.
+func (m *model) openPatch() tea.Cmd {
+	ct := m.currnetThread
+	state := m.chatThreadState(ct)
+
+	// The result is added to the thread, which would end up before the
+	// reply being waited for.
+	if state.cancelRequest != nil {
+		state.err = fmt.Errorf("wait for the reply, or cancel it, before reviewing a patch")
+		m.syncStatusbar()
+		return nil
+	}
+
+	patches, err := m.replyPatch()
+	if err != nil {
+		state.err = err
+		m.syncStatusbar()
+		return nil
+	}
+
+	state.err = nil
+
+	m.mode = ModePatch
+	m.patchFiles = patches
+	m.patchApplied = nil
+	m.editor.Blur()
+
+	return m.nextPatchFile()
+}
+
+// replyPatch returns the patch of the reply being read in the transcript.
+func (m *model) replyPatch() ([]diff.FilePatch, error) {
+	var (
+		history = m.currnetThread.ChatHistory
+		bottom  = m.chatOutput.YOffset + m.chatOutput.Height
+		latest  = -1
+	)
+
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role != openai.ChatRoleAssistant || !diff.ContainsPatch(history[i].Content) {
+			continue
+		}
+
+		if latest < 0 {
+			latest = i
+		}
+
+		if i < len(m.chatOutputOffsets) && m.chatOutputOffsets[i] < bottom {
+			latest = i
+			break
+		}
+	}
+
+	if latest < 0 {
+		return nil, fmt.Errorf("there's no patch to review in the replies")
+	}
+
+	return diff.Parse(history[latest].Content)
+}
+
+// nextPatchFile shows the changes to the next file of the patch to review,
+// skipping the files it can't be applied to, or goes back to the thread
+// once every file was reviewed.
+func (m *model) nextPatchFile() tea.Cmd {
+	state := m.chatThreadState(m.currnetThread)
+
+	for len(m.patchFiles) > 0 {
+		p := m.patchFiles[0]
+		m.patchFiles = m.patchFiles[1:]
+
+		f, content, err := readPatchFile(m.shellDir, p)
+		if err != nil {
+			state.err = err
+			m.patchApplied = append(m.patchApplied, fmt.Sprintf("`%s`: not applied, %v", patchPath(p), err))
+			continue
+		}
+
+		d := newDiffView(f.Content, content)
+		if len(d.Hunks()) == 0 {
+			m.patchApplied = append(m.patchApplied, fmt.Sprintf("`%s`: already up to date", patchPath(p)))
+			continue
+		}
+
+		d.SetSize(m.fileView.Width, m.fileView.Height)
+
+		m.patchFile = f
+		m.patchName = patchPath(p)
+		m.patchNew = p.OldName == diff.DevNull
+		m.patchDiff = d
+		m.syncStatusbar()
+
+		return nil
+	}
+
+	return m.closePatch()
+}
+
+// readPatchFile reads the file changed by the patch, whose path is relative
+// to the directory, returning it with its content once changed.
+func readPatchFile(dir string, p diff.FilePatch) (*edit.File, string, error) {
+	if p.NewName == diff.DevNull {
+		return nil, "", fmt.Errorf("deleting %s isn't supported", p.OldName)
+	}
+
+	path, err := patchFilePath(dir, p.NewName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var f *edit.File
+
+	switch {
+	case p.OldName == diff.DevNull:
+		f, err = edit.NewFile(path)
+	case p.OldName != p.NewName:
+		return nil, "", fmt.Errorf("renaming %s to %s isn't supported", p.OldName, p.NewName)
+	default:
+		f, err = edit.ReadFile(path)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	content, err := p.ApplyTo(f.Content)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return f, content, nil
+}
+
+// patchFilePath returns the path of the file named in a patch, in the
+// directory. Patches are written by HAL, so they can't be trusted to stay in
+// it: absolute paths, paths going up out of it, and paths through links
+// leading out of it are refused.
+func patchFilePath(dir, name string) (string, error) {
+	outside := fmt.Errorf("%s is outside of the working directory", name)
+
+	if filepath.IsAbs(name) {
+		return "", outside
+	}
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(root, name)
+	if !within(root, path) {
+		return "", outside
+	}
+
+	// Links are followed when the file is written, so where they lead has
+	// to be in the directory too. The file itself may not exist yet, but
+	// its closest parent that exists does.
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+
+	for existing := path; ; existing = filepath.Dir(existing) {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if !within(realRoot, resolved) {
+				return "", outside
+			}
+			break
+		}
+		if existing == root {
+			return "", err
+		}
+	}
+
+	return path, nil
+}
+
+// within returns true if the path is the directory, or in it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// patchPath returns the path of the file changed by the patch.
+func patchPath(p diff.FilePatch) string {
+	if p.NewName == diff.DevNull {
+		return p.OldName
+	}
+	return p.NewName
+}
+
+// updatePatch handles key presses in patch mode, which are passed on to the
+// diff view, except to write or skip the file being reviewed.
+func (m model) updatePatch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Quit):
+		// Keep what was applied so far, and the unsent text.
+		m.closePatch()
+		m.currnetThread.Draft = m.editor.Value()
+		m.saveChatThread(m.currnetThread)
+		return m, tea.Quit
+	case msg.Type == tea.KeyEnter:
+		return m, m.writePatchFile()
+	case msg.Type == tea.KeyEscape:
+		m.patchApplied = append(m.patchApplied, fmt.Sprintf("`%s`: skipped", m.patchName))
+		return m, m.nextPatchFile()
+	}
+
+	var cmd tea.Cmd
+	m.patchDiff, cmd = m.patchDiff.Update(msg)
+	return m, cmd
+}
+
+// writePatchFile writes the accepted hunks of the file being reviewed, and
+// moves on to the next file.
+func (m *model) writePatchFile() tea.Cmd {
+	state := m.chatThreadState(m.currnetThread)
+
+	accepted, total := m.patchDiff.Count()
+	if accepted > 0 {
+		if err := m.patchFile.Write(m.patchDiff.Result()); err != nil {
+			state.err = err
+			m.syncStatusbar()
+			return nil
+		}
+	}
+
+	state.err = nil
+	m.patchApplied = append(m.patchApplied, fmt.Sprintf("`%s`: applied %d of %d changes", m.patchName, accepted, total))
+
+	return m.nextPatchFile()
+}
+
+// closePatch goes back to the thread, adding what was applied of the patch
+// to it, so HAL knows about it.
+func (m *model) closePatch() tea.Cmd {
+	ct := m.currnetThread
+
+	m.mode = ModeEditorInsert
+	m.patchFiles, m.patchFile, m.patchDiff = nil, nil, nil
+	m.editor.Focus()
+
+	if len(m.patchApplied) == 0 {
+		m.syncStatusbar()
+		return nil
+	}
+
+	var b strings.Builder
+
+	b.WriteString("I reviewed your patch:\n")
+	for _, applied := range m.patchApplied {
+		b.WriteString("\n- " + applied)
+	}
+
+	m.patchApplied = nil
+
+	ct.ChatHistory = append(ct.ChatHistory, chat.NewMessage(openai.ChatRoleUser, b.String()))
+
+	m.saveChatThread(ct)
+
+	m.syncStatusbar()
+	m.refreshChatOutput()
+	m.chatOutput.GotoBottom()
+
+	return m.compactChatThread(ct, false)
+}
+
+// viewPatch renders what's being reviewed in patch mode, with help.
+func (m model) viewPatch() string {
+	accepted, total := m.patchDiff.Count()
+
+	status := fmt.Sprintf("%d of %d changes to %s accepted.", accepted, total, m.patchName)
+	if m.patchNew {
+		status = fmt.Sprintf("%d of %d changes to %s (new file) accepted.", accepted, total, m.patchName)
+	}
+
+	if len(m.patchFiles) > 0 {
+		status += fmt.Sprintf(" %d more files to review.", len(m.patchFiles))
+	}
+
+	return status + "\n" + shellHelpStyle.Render(m.patchDiff.Help()+" · enter write · esc skip")
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/policy"
+	"github.com/picatz/hal/pkg/sandbox"
+	"github.com/picatz/hal/pkg/shell"
+)
+
+var (
+	shellHelpStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	shellClassStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214")).Bold(true)
+)
+
+// shellStage is the step of turning a task into a command in shell mode.
+type shellStage int
+
+const (
+	// shellDescribe is when the user describes the task.
+	shellDescribe shellStage = iota
+
+	// shellProposing is while HAL comes up with a command for the task.
+	shellProposing
+
+	// shellReview is when the user reviews, and maybe edits, the command
+	// before running it.
+	shellReview
+
+	// shellConfirm is when the user confirms a command the policy wants
+	// confirmed before it runs.
+	shellConfirm
+
+	// shellRunning is while the command runs.
+	shellRunning
+
+	// shellChanges is when the user reviews the files a command changed in
+	// its sandbox, to apply or discard them.
+	shellChanges
+)
+
+// ShellTextArea returns the text area used to describe tasks, and review the
+// commands proposed for them, in shell mode.
+func ShellTextArea() textarea.Model {
+	input := textarea.New()
+	input.ShowLineNumbers = false
+	input.CharLimit = 4096
+	input.SetWidth(80)
+	input.SetHeight(3)
+	input.FocusedStyle.CursorLine = lipgloss.NewStyle()
+	return input
+}
+
+// ShellChangesViewport returns the viewport showing the files a sandboxed
+// command changed, in shell mode.
+func ShellChangesViewport() viewport.Model {
+	changes := viewport.New(80, 10)
+	changes.KeyMap = viewport.KeyMap{
+		PageDown: key.NewBinding(key.WithKeys("pgdown", " ")),
+		PageUp:   key.NewBinding(key.WithKeys("pgup")),
+		Down:     key.NewBinding(key.WithKeys("down", "j")),
+		Up:       key.NewBinding(key.WithKeys("up", "k")),
+	}
+	return changes
+}
+
+// openShell switches the current thread to shell mode, asking for a task,
+// or to review the changes of its sandboxed command if they weren't yet.
+func (m *model) openShell() tea.Cmd {
+	state := m.chatThreadState(m.currnetThread)
+	if state.cancelRequest != nil {
+		return nil
+	}
+
+	m.mode = ModeShell
+	m.editor.Blur()
+	m.setShellStage(shellDescribe, "")
+
+	if state.shellResult != nil {
+		m.showShellChanges(state.shellResult)
+	}
+
+	return m.shellInput.Focus()
+}
+
+// closeShell goes back to chatting in the current thread. A command that is
+// still running keeps running, and its output is still added to the thread.
+func (m *model) closeShell() tea.Cmd {
+	state := m.chatThreadState(m.currnetThread)
+	if m.shellStage == shellProposing && state.shellCancel != nil {
+		state.shellCancel()
+		state.shellCancel = nil
+	}
+
+	m.mode = ModeEditorInsert
+	m.shellInput.Blur()
+	m.shellProposal = nil
+	m.syncStatusbar()
+
+	return m.editor.Focus()
+}
+
+// setShellStage moves shell mode to the stage, with the given text in the
+// input.
+func (m *model) setShellStage(stage shellStage, text string) {
+	m.shellStage = stage
+
+	switch stage {
+	case shellDescribe:
+		m.shellInput.Prompt = halStyleColor.Bold(true).Render("│ ")
+		m.shellInput.Placeholder = "Describe a task, like \"find the largest files here\""
+	case shellReview:
+		m.shellInput.Prompt = halStyleColor.Bold(true).Render("$ ")
+		m.shellInput.Placeholder = "the command to run"
+	case shellConfirm:
+		m.shellInput.Prompt = ""
+		m.shellInput.Placeholder = ""
+	}
+
+	m.shellInput.SetValue(text)
+}
+
+// updateShell handles key presses in shell mode.
+func (m model) updateShell(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	state := m.chatThreadState(m.currnetThread)
+
+	switch {
+	case key.Matches(msg, m.keys.Quit):
+		// Keep the unsent text for next time.
+		m.currnetThread.Draft = m.editor.Value()
+		m.saveChatThread(m.currnetThread)
+		return m, tea.Quit
+	case key.Matches(msg, m.keys.Cancel):
+		if state.shellCancel == nil {
+			return m, nil
+		}
+
+		state.shellCancel()
+		state.shellCancel = nil
+
+		if m.shellStage == shellProposing {
+			m.setShellStage(shellDescribe, m.shellTask)
+		}
+
+		m.syncStatusbar()
+
+		return m, nil
+	}
+
+	switch m.shellStage {
+	case shellDescribe:
+		switch {
+		case msg.Type == tea.KeyEscape || key.Matches(msg, m.keys.Shell):
+			return m, m.closeShell()
+		case msg.Type == tea.KeyEnter:
+			task := strings.TrimSpace(m.shellInput.Value())
+			if task == "" {
+				return m, nil
+			}
+			return m, m.proposeShellCommand(task)
+		}
+	case shellReview:
+		switch {
+		case msg.Type == tea.KeyEscape:
+			// Go back to change the task.
+			m.shellProposal = nil
+			m.setShellStage(shellDescribe, m.shellTask)
+			return m, nil
+		case msg.Type == tea.KeyTab:
+			m.shellSandbox = !m.shellSandbox
+			return m, nil
+		case msg.Type == tea.KeyEnter && msg.Alt:
+			return m, m.checkShellCommand(true)
+		case msg.Type == tea.KeyEnter:
+			return m, m.checkShellCommand(false)
+		}
+	case shellConfirm:
+		level := m.shellDecision.Level
+
+		switch {
+		case msg.Type == tea.KeyEscape || level == policy.LevelConfirm && msg.String() == "n":
+			m.auditShellCommand(m.currnetThread, m.shellEntry(policy.OutcomeDeclined))
+			m.setShellStage(shellReview, m.shellCommand)
+			return m, nil
+		case level == policy.LevelConfirm && msg.String() == "y":
+			return m, m.runShellCommand()
+		case level == policy.LevelType && msg.Type == tea.KeyEnter:
+			if strings.TrimSpace(m.shellInput.Value()) == "yes" {
+				return m, m.runShellCommand()
+			}
+			m.shellInput.SetValue("")
+			return m, nil
+		case level != policy.LevelType:
+			return m, nil
+		}
+	case shellChanges:
+		switch {
+		case msg.Type == tea.KeyEscape || key.Matches(msg, m.keys.Shell):
+			// The changes wait to be reviewed until shell mode is opened
+			// again.
+			return m, m.closeShell()
+		case msg.String() == "a":
+			return m, m.finishShellChanges(m.currnetThread, true)
+		case msg.String() == "d":
+			return m, m.finishShellChanges(m.currnetThread, false)
+		}
+
+		var cmd tea.Cmd
+		m.shellChanges, cmd = m.shellChanges.Update(msg)
+
+		return m, cmd
+	default:
+		// Nothing to type while waiting.
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.shellInput, cmd = m.shellInput.Update(msg)
+
+	return m, cmd
+}
+
+// proposeShellCommand asks the current thread's provider for a command to do
+// the task.
+func (m *model) proposeShellCommand(task string) tea.Cmd {
+	ct := m.currnetThread
+	state := m.chatThreadState(ct)
+
+	provider, err := m.chatThreadProvider(ct)
+	if err != nil {
+		state.err = err
+		m.syncStatusbar()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+
+	m.shellTask = task
+	m.shellStage = shellProposing
+
+	state.shellCancel = cancel
+	state.err = nil
+
+	m.syncStatusbar()
+
+	return tea.Batch(
+		chatThreadCmd(ct, shell.Propose(ctx, provider, m.chatOptions, ct.ChatHistory, task)),
+		m.statusbar.Spinner.Tick,
+	)
+}
+
+// checkShellCommand checks the reviewed command against the policy, which
+// can deny it, or want it confirmed before it runs in the terminal for
+// interactive commands, or with its output captured.
+func (m *model) checkShellCommand(interactive bool) tea.Cmd {
+	command := strings.TrimSpace(m.shellInput.Value())
+	if command == "" {
+		return nil
+	}
+
+	m.shellCommand = command
+	m.shellInteractive = interactive
+	m.shellDecision = m.shellPolicy.Check(command)
+
+	switch m.shellDecision.Level {
+	case policy.LevelDeny:
+		state := m.chatThreadState(m.currnetThread)
+		state.err = fmt.Errorf("command not run, %s", m.shellDecision.Reason())
+		m.auditShellCommand(m.currnetThread, m.shellEntry(policy.OutcomeDenied))
+		m.syncStatusbar()
+		return nil
+	case policy.LevelConfirm, policy.LevelType:
+		m.setShellStage(shellConfirm, "")
+		return nil
+	}
+
+	return m.runShellCommand()
+}
+
+// runShellCommand runs the checked command.
+func (m *model) runShellCommand() tea.Cmd {
+	ct := m.currnetThread
+	state := m.chatThreadState(ct)
+
+	// The task and proposal are added to the thread with the output, so
+	// follow-up questions know what was asked for.
+	state.shellMessages = m.shellProposal
+	state.err = nil
+
+	entry := m.shellEntry(policy.OutcomeRan)
+	state.shellEntry = &entry
+
+	m.shellProposal = nil
+	m.setShellStage(shellRunning, m.shellCommand)
+
+	if m.shellInteractive {
+		finished := func(msg shell.FinishedMsg) tea.Msg {
+			return chatThreadMsg{Thread: ct, Msg: msg}
+		}
+
+		if !m.shellSandbox {
+			return shell.Exec(m.shellCommand, finished)
+		}
+
+		// The sandbox is created before the program is suspended, so it
+		// can't fail while the terminal is taken over, and in the
+		// background, since copying the working directory can take a
+		// while.
+		ctx, cancel := context.WithCancel(context.Background())
+		state.shellCancel = cancel
+
+		var (
+			dir         = m.shellDir
+			opts        = m.sandboxOptions
+			commandLine = m.shellCommand
+		)
+
+		m.syncStatusbar()
+
+		return tea.Batch(
+			chatThreadCmd(ct, func() tea.Msg {
+				sb, err := sandbox.New(dir, opts)
+				if err != nil {
+					return shell.FinishedMsg{Err: err}
+				}
+				if ctx.Err() != nil {
+					sb.Remove()
+					return shell.FinishedMsg{Err: ctx.Err()}
+				}
+				return shellSandboxMsg{Sandbox: sb, Command: commandLine}
+			}),
+			m.statusbar.Spinner.Tick,
+		)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	state.shellCancel = cancel
+
+	run := shell.Run(ctx, m.shellCommand)
+	if m.shellSandbox {
+		run = shell.RunSandboxed(ctx, m.shellDir, m.sandboxOptions, m.shellCommand)
+	}
+
+	m.syncStatusbar()
+
+	return tea.Batch(
+		chatThreadCmd(ct, run),
+		m.statusbar.Spinner.Tick,
+	)
+}
+
+// shellSandboxMsg is sent once the sandbox of an interactive command is
+// created, to run the command in it.
+type shellSandboxMsg struct {
+	Sandbox *sandbox.Sandbox
+	Command string
+}
+
+// execShellSandboxed runs the interactive command in its sandbox, in the
+// terminal, unless it was canceled while the sandbox was created.
+func (m *model) execShellSandboxed(ct *chat.Thread, msg shellSandboxMsg) tea.Cmd {
+	state := m.chatThreadState(ct)
+	if state.shellCancel == nil {
+		msg.Sandbox.Remove()
+		return m.updateShellFinished(ct, shell.FinishedMsg{Err: context.Canceled})
+	}
+
+	state.shellCancel()
+	state.shellCancel = nil
+
+	m.syncStatusbar()
+
+	return shell.ExecSandboxed(msg.Sandbox, msg.Command, func(msg shell.FinishedMsg) tea.Msg {
+		return chatThreadMsg{Thread: ct, Msg: msg}
+	})
+}
+
+// shellEntry returns the audit log entry of the command being checked.
+func (m *model) shellEntry(outcome string) policy.Entry {
+	// Threads get their ID when they're first saved, which is done early
+	// so the log refers to the thread it will be saved as.
+	if m.currnetThread.ID == "" {
+		m.currnetThread.ID = chat.NewThreadID()
+	}
+
+	entry := policy.NewEntry(m.shellCommand, m.shellDecision, outcome)
+	entry.Thread = m.currnetThread.ID
+	entry.Task = m.shellTask
+	entry.Interactive = m.shellInteractive
+	entry.Sandboxed = m.shellSandbox
+
+	return entry
+}
+
+// auditShellCommand appends the entry to the audit log, showing an error
+// in the thread if it can't be written.
+func (m *model) auditShellCommand(ct *chat.Thread, entry policy.Entry) {
+	if err := m.auditLog.Append(entry); err != nil {
+		m.chatThreadState(ct).err = err
+		m.syncStatusbar()
+	}
+}
+
+// updateShellProposed shows the command proposed for the thread's task for
+// review, unless the user moved on while waiting for it.
+func (m *model) updateShellProposed(ct *chat.Thread, msg shell.ProposedMsg) {
+	state := m.chatThreadState(ct)
+	if state.shellCancel != nil {
+		state.shellCancel()
+		state.shellCancel = nil
+	}
+
+	if ct != m.currnetThread || m.mode != ModeShell || m.shellStage != shellProposing {
+		return
+	}
+
+	if msg.Err != nil {
+		state.err = msg.Err
+		m.setShellStage(shellDescribe, m.shellTask)
+		m.syncStatusbar()
+		return
+	}
+
+	m.shellProposal = msg.Messages
+	m.setShellStage(shellReview, msg.Command)
+	m.syncStatusbar()
+}
+
+// updateShellFinished adds the task, the command and its output to the
+// thread's chat history, so follow-up questions have it as context.
+func (m *model) updateShellFinished(ct *chat.Thread, msg shell.FinishedMsg) tea.Cmd {
+	state := m.chatThreadState(ct)
+	if state.shellCancel != nil {
+		state.shellCancel()
+		state.shellCancel = nil
+	}
+
+	messages := state.shellMessages
+	state.shellMessages = nil
+
+	if entry := state.shellEntry; entry != nil {
+		state.shellEntry = nil
+
+		if msg.Err != nil {
+			entry.Outcome = policy.OutcomeFailed
+			entry.Error = msg.Err.Error()
+		} else {
+			entry.SetResult(msg.Result.ExitCode, msg.Result.Duration)
+		}
+
+		m.auditShellCommand(ct, *entry)
+	}
+
+	if ct == m.currnetThread && m.mode == ModeShell {
+		m.setShellStage(shellDescribe, "")
+	}
+
+	if msg.Err != nil {
+		if !errors.Is(msg.Err, context.Canceled) {
+			state.err = msg.Err
+		}
+		m.syncStatusbar()
+		return nil
+	}
+
+	if msg.Result.Sandbox != nil {
+		// The files the command changed are reviewed before it's added
+		// to the history, with whether they were applied.
+		state.shellMessages = messages
+		state.shellResult = msg.Result
+
+		if ct == m.currnetThread && m.mode == ModeShell {
+			m.showShellChanges(msg.Result)
+		}
+
+		m.syncStatusbar()
+		return nil
+	}
+
+	return m.addShellResult(ct, messages, msg.Result)
+}
+
+// showShellChanges shows the files changed by the sandboxed command for
+// review.
+func (m *model) showShellChanges(result *shell.Result) {
+	m.setShellStage(shellChanges, result.Command)
+	m.shellChanges.SetContent(renderShellChanges(result.Changes))
+	m.shellChanges.GotoTop()
+}
+
+// finishShellChanges applies or discards the files changed by the thread's
+// sandboxed command, removing its sandbox, and adds it to the history.
+func (m *model) finishShellChanges(ct *chat.Thread, apply bool) tea.Cmd {
+	state := m.chatThreadState(ct)
+	result := state.shellResult
+	state.err = nil
+
+	if apply {
+		if err := result.Sandbox.Apply(result.Changes); err != nil {
+			// The changes can still be discarded.
+			state.err = err
+			m.syncStatusbar()
+			return nil
+		}
+		result.Applied = true
+	}
+
+	if err := result.Sandbox.Remove(); err != nil {
+		state.err = err
+	}
+
+	messages := state.shellMessages
+	state.shellMessages = nil
+	state.shellResult = nil
+
+	if ct == m.currnetThread && m.mode == ModeShell {
+		m.setShellStage(shellDescribe, "")
+	}
+
+	return m.addShellResult(ct, messages, result)
+}
+
+// removeSandboxes removes the sandboxes of commands whose changes weren't
+// reviewed, discarding them, once HAL exits.
+func (m model) removeSandboxes() {
+	for _, state := range m.chatThreadStates {
+		if state.shellResult != nil {
+			state.shellResult.Sandbox.Remove()
+		}
+	}
+}
+
+// addShellResult adds the task, the command and its result to the thread's
+// chat history.
+func (m *model) addShellResult(ct *chat.Thread, messages []chat.Message, result *shell.Result) tea.Cmd {
+	ct.ChatHistory = append(ct.ChatHistory, messages...)
+	ct.ChatHistory = append(ct.ChatHistory, result.Message())
+
+	m.saveChatThread(ct)
+
+	m.syncStatusbar()
+	if ct == m.currnetThread {
+		m.refreshChatOutput()
+		m.chatOutput.GotoBottom()
+	}
+
+	return tea.Batch(m.compactChatThread(ct, false), m.describeChatThread(ct))
+}
+
+// viewShell renders the input of shell mode, with help for the current
+// stage.
+func (m model) viewShell() string {
+	var input, help string
+
+	switch m.shellStage {
+	case shellDescribe:
+		input = m.shellInput.View()
+		help = "enter propose a command · esc back to chat"
+	case shellProposing:
+		input = shellHelpStyle.Render("Finding a command for: " + m.shellTask)
+		help = m.keys.Cancel.Help().Key + " cancel"
+	case shellReview:
+		sandboxed := "off"
+		if m.shellSandbox {
+			sandboxed = "on"
+		}
+
+		input = m.shellInput.View()
+		help = "enter run · alt+enter run in the terminal · tab sandbox " + sandboxed + " · esc change the task"
+
+		// Show what the command does as it's edited.
+		if d := m.shellPolicy.Check(m.shellInput.Value()); d.Class() != policy.ReadOnly {
+			return input + "\n" + shellClassStyle.Render(shellClasses(d)) + shellHelpStyle.Render(" · "+help)
+		}
+	case shellConfirm:
+		input = halStyleColor.Render("$ ") + m.shellCommand + "\n" +
+			shellClassStyle.Render(shellClasses(m.shellDecision)+": ") + m.shellDecision.Reason()
+
+		if m.shellDecision.Level == policy.LevelType {
+			input += "\n" + `Type "yes" to run it: ` + m.shellInput.Value() + "█"
+			help = "enter run · esc back"
+		} else {
+			input += "\nRun it?"
+			help = "y run · n back"
+		}
+	case shellRunning:
+		input = halStyleColor.Render("$ ") + m.shellInput.Value()
+		help = "running… " + m.keys.Cancel.Help().Key + " stop"
+		if m.shellSandbox {
+			help = "running in a sandbox… " + m.keys.Cancel.Help().Key + " stop"
+		}
+	case shellChanges:
+		changed := "1 file"
+		if state, ok := m.chatThreadStates[m.currnetThread]; ok && state.shellResult != nil && len(state.shellResult.Changes) != 1 {
+			changed = fmt.Sprintf("%d files", len(state.shellResult.Changes))
+		}
+
+		input = halStyleColor.Render("$ ") + m.shellInput.Value() + "\n" +
+			"It changed " + changed + " in its sandbox. Apply the changes to the working directory?"
+		help = "a apply · d discard · ↑/↓ scroll · esc back to chat"
+	}
+
+	return input + "\n" + shellHelpStyle.Render(help)
+}
+
+// shellClasses returns the classes of the command, like "writes files, uses
+// the network".
+func shellClasses(d policy.Decision) string {
+	classes := make([]string, len(d.Classes))
+	for i, class := range d.Classes {
+		classes[i] = class.Description()
+	}
+	return strings.Join(classes, ", ")
+}
+
+// renderShellChanges renders the diffs of the files changed by a sandboxed
+// command.
+func renderShellChanges(changes []sandbox.Change) string {
+	var b strings.Builder
+
+	for i, c := range changes {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		b.WriteString(shellClassStyle.Render(c.Kind.String()+" "+c.Path) + "\n")
+
+		for _, line := range strings.Split(strings.TrimSuffix(c.Diff(), "\n"), "\n") {
+			switch {
+			case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+				line = shellHelpStyle.Render(line)
+			case strings.HasPrefix(line, "+"):
+				line = diffInsertStyle.Render(line)
+			case strings.HasPrefix(line, "-"):
+				line = diffDeleteStyle.Render(line)
+			case strings.HasPrefix(line, "@@"):
+				line = diffHunkStyle.Render(line)
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+
+	return b.String()
+}
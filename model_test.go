@@ -0,0 +1,1431 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/muesli/reflow/ansi"
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/chat/chattest"
+	"github.com/picatz/hal/pkg/config"
+	"github.com/picatz/hal/pkg/edit"
+	"github.com/picatz/hal/pkg/policy"
+	"github.com/picatz/hal/pkg/sandbox"
+	"github.com/picatz/hal/pkg/shell"
+)
+
+// newTestModel returns a model using the given fake provider, with its
+// threads stored in a temporary directory.
+func newTestModel(t *testing.T, provider chat.Provider) model {
+	t.Helper()
+
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	cfg := config.Default()
+
+	m := newModel(cfg)
+	m.providers = map[string]chat.Provider{
+		cfg.DefaultProvider: provider,
+	}
+
+	return update(t, m, tea.WindowSizeMsg{Width: 80, Height: 24})
+}
+
+// update sends the message to the model, ignoring any commands.
+func update(t *testing.T, m model, msg tea.Msg) model {
+	t.Helper()
+
+	next, _ := m.Update(msg)
+	return next.(model)
+}
+
+// typeText sends the text to the model as key presses.
+func typeText(t *testing.T, m model, text string) model {
+	t.Helper()
+
+	return update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(text)})
+}
+
+// runChatThread sends the message to the model, and runs the commands it
+// returns until a chat request finishes.
+func runChatThread(t *testing.T, m model, msg tea.Msg) model {
+	t.Helper()
+
+	return runChatThreadUntil(t, m, msg, func(msg tea.Msg) bool {
+		_, ok := msg.(chat.FinishedMsg)
+		return ok
+	})
+}
+
+// runChatThreadUntil sends the message to the model, and runs the commands
+// it returns until done returns true for a message from a chat request.
+//
+// Only the messages from chat requests are sent back to the model, other
+// commands like the spinner's tick are left to finish on their own.
+func runChatThreadUntil(t *testing.T, m model, msg tea.Msg, done func(tea.Msg) bool) model {
+	t.Helper()
+
+	msgs := make(chan tea.Msg, 64)
+
+	run := func(cmd tea.Cmd) {
+		if cmd != nil {
+			go func() { msgs <- cmd() }()
+		}
+	}
+
+	next, cmd := m.Update(msg)
+	m = next.(model)
+	run(cmd)
+
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case msg := <-msgs:
+			switch msg := msg.(type) {
+			case tea.BatchMsg:
+				for _, cmd := range msg {
+					run(cmd)
+				}
+			case chatThreadMsg:
+				next, cmd := m.Update(msg)
+				m = next.(model)
+
+				if done(msg.Msg) {
+					return m
+				}
+				run(cmd)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the chat request to finish")
+		}
+	}
+}
+
+func TestModelSend(t *testing.T) {
+	provider := chattest.NewProvider(chattest.Reply{
+		Content: "Good afternoon, Dave.",
+		Usage:   chat.Usage{TotalTokens: 42},
+	})
+
+	m := newTestModel(t, provider)
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	if m.mode != ModeEditorInsert || m.currnetThread == nil {
+		t.Fatal("expected the thread to be selected")
+	}
+
+	m = typeText(t, m, "Hello, HAL.")
+	m = runChatThread(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+
+	requests := provider.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(requests))
+	}
+
+	sent := requests[0].Messages
+	if sent[0].Role != openai.ChatRoleSystem || sent[len(sent)-1].Content != "Hello, HAL." {
+		t.Fatalf("unexpected request messages: %+v", sent)
+	}
+
+	history := m.currnetThread.ChatHistory
+	if len(history) != 3 {
+		t.Fatalf("expected 3 messages in the history, got %d", len(history))
+	}
+
+	if reply := history[2]; reply.Role != openai.ChatRoleAssistant || reply.Content != "Good afternoon, Dave." {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+
+	if m.currnetThread.Tokens != 42 {
+		t.Fatalf("expected 42 tokens, got %d", m.currnetThread.Tokens)
+	}
+
+	if m.editor.Value() != "" {
+		t.Fatalf("expected the editor to be empty, got %q", m.editor.Value())
+	}
+
+	// The thread was saved after the exchange.
+	threads, err := m.store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads) != 1 || len(threads[0].ChatHistory) != 3 {
+		t.Fatalf("expected the thread to be saved, got %+v", threads)
+	}
+
+	// The reply's metadata is saved with it, and shown in the status bar.
+	md := threads[0].ChatHistory[2].Metadata
+	if md == nil || md.Model != m.chatOptions.Model || md.Usage == nil || md.Usage.TotalTokens != 42 || md.FinishReason != "stop" {
+		t.Fatalf("expected the reply's metadata to be saved, got %+v", md)
+	}
+
+	if view := stripANSI(m.statusbar.View()); !strings.Contains(view, "42 tokens") {
+		t.Fatalf("expected the reply's details in the status bar, got %q", view)
+	}
+}
+
+func TestModelSendError(t *testing.T) {
+	errOverloaded := errors.New("overloaded")
+
+	m := newTestModel(t, chattest.NewProvider(chattest.Reply{Err: errOverloaded}))
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = typeText(t, m, "Hello, HAL.")
+	m = runChatThread(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+
+	if !errors.Is(m.statusbar.Err, errOverloaded) {
+		t.Fatalf("expected the error in the status bar, got %v", m.statusbar.Err)
+	}
+
+	// The user gets their text back to try again.
+	if m.editor.Value() != "Hello, HAL." {
+		t.Fatalf("expected the text to be restored, got %q", m.editor.Value())
+	}
+
+	if len(m.currnetThread.ChatHistory) != 1 {
+		t.Fatalf("expected only the system message, got %+v", m.currnetThread.ChatHistory)
+	}
+}
+
+func TestModelCancel(t *testing.T) {
+	provider := chattest.NewProvider(chattest.Reply{Content: "I'm sorry, Dave."})
+	provider.Latency = time.Minute
+
+	m := newTestModel(t, provider)
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = typeText(t, m, "Open the pod bay doors.")
+
+	next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = next.(model)
+
+	if m.chatThreadState(m.currnetThread).cancelRequest == nil {
+		t.Fatal("expected a request to be in-flight")
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlX})
+
+	if m.editor.Value() != "Open the pod bay doors." {
+		t.Fatalf("expected the text to be restored, got %q", m.editor.Value())
+	}
+
+	// The canceled request finishes without changing the thread.
+	for _, c := range cmd().(tea.BatchMsg) {
+		if c == nil {
+			continue
+		}
+
+		msg, ok := c().(chatThreadMsg)
+		if !ok {
+			continue
+		}
+
+		m = update(t, m, msg)
+
+		finished, ok := msg.Msg.(chat.FinishedMsg)
+		if !ok || !errors.Is(finished.Err, context.Canceled) {
+			t.Fatalf("expected the request to be canceled, got %+v", msg.Msg)
+		}
+	}
+
+	if len(m.currnetThread.ChatHistory) != 1 {
+		t.Fatalf("expected only the system message, got %+v", m.currnetThread.ChatHistory)
+	}
+}
+
+func TestModelCancelResend(t *testing.T) {
+	provider := chattest.NewProvider(chattest.Reply{Content: "Good afternoon, Dave."})
+
+	m := newTestModel(t, provider)
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = typeText(t, m, "Open the pod bay doors.")
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+
+	canceled := m.chatThreadState(m.currnetThread).request
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlX})
+	m.editor.SetValue("Hello, HAL.")
+
+	// Stop at the first chunk of the reply to the text sent again.
+	m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyEsc}, func(msg tea.Msg) bool {
+		_, ok := msg.(chat.StreamDeltaMsg)
+		return ok
+	})
+
+	state := m.chatThreadState(m.currnetThread)
+	if state.request == canceled {
+		t.Fatal("expected another request to be in-flight")
+	}
+	response := state.response
+
+	// What the canceled request was still streaming isn't added to the
+	// reply, and its end doesn't end the request in-flight.
+	m = update(t, m, chatThreadMsg{
+		Thread:  m.currnetThread,
+		Msg:     chat.StreamDeltaMsg{Delta: "I'm sorry, Dave.", Next: func() tea.Msg { return nil }},
+		Request: canceled,
+	})
+	m = update(t, m, chatThreadMsg{
+		Thread: m.currnetThread,
+		Msg: chat.FinishedMsg{
+			Buffer:  []byte("I'm sorry, Dave."),
+			History: append(m.currnetThread.ChatHistory, chat.NewMessage(openai.ChatRoleAssistant, "I'm sorry, Dave.")),
+		},
+		Request: canceled,
+	})
+
+	state = m.chatThreadState(m.currnetThread)
+	if state.response != response || state.cancelRequest == nil {
+		t.Fatalf("expected the canceled request to be ignored, got %q", state.response)
+	}
+
+	if len(m.currnetThread.ChatHistory) != 1 {
+		t.Fatalf("expected only the system message, got %+v", m.currnetThread.ChatHistory)
+	}
+}
+
+func TestModelLoadCorrupt(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	dir, err := chat.DefaultStoreDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := chat.NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Save(&chat.Thread{Name: "Pod bay doors", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "bad.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The threads that could be loaded are shown, with the error.
+	m := newModel(config.Default())
+
+	if len(m.chatThreads) != 1 || m.chatThreads[0].Name != "Pod bay doors" {
+		t.Fatalf("expected the good thread to be loaded, got %+v", m.chatThreads)
+	}
+
+	if m.statusbar.Err == nil || !strings.Contains(m.statusbar.Err.Error(), "bad.json") {
+		t.Fatalf("expected the error in the status bar, got %v", m.statusbar.Err)
+	}
+}
+
+func TestModelContextWindow(t *testing.T) {
+	provider := chattest.NewProvider(chattest.Reply{Content: "Good afternoon, Dave."})
+
+	m := newTestModel(t, provider)
+	m.tokenBudgets[m.defaultProvider] = tokenBudget{model: openai.ModelGPT35Turbo, limit: 64}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	used := m.statusbar.TokensUsed
+	if used == 0 || m.statusbar.TokenLimit != 64 || m.statusbar.TokensOver {
+		t.Fatalf("expected the history's tokens out of 64, got %d / %d", used, m.statusbar.TokenLimit)
+	}
+
+	text := strings.Repeat("Open the pod bay doors, HAL. ", 10)
+
+	m = typeText(t, m, text)
+
+	if m.statusbar.TokensUsed <= used || !m.statusbar.TokensOver {
+		t.Fatalf("expected the draft to go over the limit, got %d / %d", m.statusbar.TokensUsed, m.statusbar.TokenLimit)
+	}
+
+	// The request isn't sent, and the text is kept to be shortened.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+
+	if len(provider.Requests()) != 0 {
+		t.Fatal("expected the request not to be sent")
+	}
+
+	if m.statusbar.Err == nil || !strings.Contains(m.statusbar.Err.Error(), "context window") {
+		t.Fatalf("expected a context window error, got %v", m.statusbar.Err)
+	}
+
+	if m.editor.Value() != text {
+		t.Fatalf("expected the text to be kept, got %q", m.editor.Value())
+	}
+}
+
+func TestModelCompaction(t *testing.T) {
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "I'm sorry, Dave. I'm afraid I can't do that."},
+		chattest.Reply{Content: "Dave asked HAL to open the pod bay doors."},
+	)
+
+	m := newTestModel(t, provider)
+	m.compaction.Keep = 1
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	// Start with a longer history, which is nearly at the limit after the
+	// next reply.
+	m.currnetThread.ChatHistory = append(m.currnetThread.ChatHistory,
+		chat.NewMessage(openai.ChatRoleUser, "Hello, HAL. Do you read me?"),
+		chat.NewMessage(openai.ChatRoleAssistant, "Affirmative, Dave. I read you."),
+	)
+
+	tokens, _ := m.chatThreadTokens(m.currnetThread)
+	m.tokenBudgets[m.defaultProvider] = tokenBudget{model: openai.ModelGPT35Turbo, limit: tokens + 30}
+
+	m = typeText(t, m, "Open the pod bay doors, HAL.")
+	m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyEsc}, func(msg tea.Msg) bool {
+		_, ok := msg.(chat.CompactedMsg)
+		return ok
+	})
+
+	history := m.currnetThread.ChatHistory
+	if len(history) != 3 || !chat.IsSummary(history[1]) {
+		t.Fatalf("expected the older messages to be summarized, got %+v", history)
+	}
+
+	if history[2].Content != "I'm sorry, Dave. I'm afraid I can't do that." {
+		t.Fatalf("expected the last message to be kept, got %+v", history[2])
+	}
+
+	if len(m.currnetThread.Compactions) != 1 || len(m.currnetThread.Compactions[0].Messages) != 3 {
+		t.Fatalf("expected the original messages to be archived, got %+v", m.currnetThread.Compactions)
+	}
+
+	// The compacted thread was saved, with the originals.
+	threads, err := m.store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads[0].Compactions) != 1 {
+		t.Fatalf("expected the compaction to be saved, got %+v", threads[0].Compactions)
+	}
+
+	if !strings.Contains(stripANSI(m.chatOutput.View()), "Earlier messages were summarized") {
+		t.Fatalf("expected a summary marker in the transcript, got:\n%s", m.chatOutput.View())
+	}
+}
+
+func TestModelCompactionDeleted(t *testing.T) {
+	provider := chattest.NewProvider(chattest.Reply{Content: "Dave asked HAL to open the pod bay doors."})
+	provider.Latency = time.Minute
+
+	m := newTestModel(t, provider)
+	m.compaction.Keep = 1
+	m.tokenBudgets[m.defaultProvider] = tokenBudget{model: openai.ModelGPT35Turbo, limit: 4096}
+
+	ct := &chat.Thread{
+		Name:    "Pod bay doors",
+		Created: time.Now(),
+		ChatHistory: []chat.Message{
+			chat.SystemMessage,
+			chat.NewMessage(openai.ChatRoleUser, "Open the pod bay doors, HAL."),
+			chat.NewMessage(openai.ChatRoleAssistant, "I'm sorry, Dave."),
+			chat.NewMessage(openai.ChatRoleUser, "What's the problem?"),
+		},
+	}
+	m.addChatThread(ct)
+
+	cmd := m.compactChatThread(ct, true)
+	if cmd == nil {
+		t.Fatal("expected the thread to be compacted")
+	}
+
+	m.deleteChatThread(ct)
+
+	// Deleting the thread stops compacting it.
+	msgs := make(chan tea.Msg, 2)
+	for _, c := range cmd().(tea.BatchMsg) {
+		c := c
+		go func() { msgs <- c() }()
+	}
+
+	var compacted chat.CompactedMsg
+	for compacted.Err == nil {
+		select {
+		case msg := <-msgs:
+			if msg, ok := msg.(chatThreadMsg); ok {
+				compacted = msg.Msg.(chat.CompactedMsg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected compacting the thread to be canceled")
+		}
+	}
+
+	if !errors.Is(compacted.Err, context.Canceled) {
+		t.Fatalf("expected compacting the thread to be canceled, got %v", compacted.Err)
+	}
+
+	// A compaction arriving anyway doesn't save the thread again.
+	m = update(t, m, chatThreadMsg{Thread: ct, Msg: chat.CompactedMsg{Compaction: &chat.Compaction{
+		Created:  time.Now(),
+		Summary:  "Dave asked HAL to open the pod bay doors.",
+		Messages: ct.ChatHistory[1:3],
+	}}})
+
+	threads, err := m.store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads) != 0 || len(ct.Compactions) != 0 {
+		t.Fatalf("expected the thread to stay deleted, got %+v", threads)
+	}
+}
+
+// stripANSI removes the ANSI escape codes from the rendered text.
+func stripANSI(s string) string {
+	var (
+		b     strings.Builder
+		inSeq bool
+	)
+
+	for _, c := range s {
+		if c == ansi.Marker {
+			inSeq = true
+		} else if inSeq {
+			if ansi.IsTerminator(c) {
+				inSeq = false
+			}
+		} else {
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+func TestModelDescribe(t *testing.T) {
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "I'm sorry, Dave. I'm afraid I can't do that."},
+		chattest.Reply{Content: "Title: Pod Bay Doors\nDave asks HAL to open the pod bay doors."},
+	)
+
+	m := newTestModel(t, provider)
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	m = typeText(t, m, "Open the pod bay doors, HAL.")
+	m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyEsc}, func(msg tea.Msg) bool {
+		_, ok := msg.(chat.DescribedMsg)
+		return ok
+	})
+
+	ct := m.currnetThread
+	if ct.Name != "Pod Bay Doors" {
+		t.Fatalf("expected the thread to be named, got %q", ct.Name)
+	}
+
+	if ct.Summary != "Dave asks HAL to open the pod bay doors." {
+		t.Fatalf("expected the thread to be summarized, got %q", ct.Summary)
+	}
+
+	if ct.SummarizedAt != len(ct.ChatHistory) {
+		t.Fatalf("expected the thread to be summarized at %d, got %d", len(ct.ChatHistory), ct.SummarizedAt)
+	}
+
+	// The generated title was saved.
+	threads, err := m.store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads) != 1 || threads[0].Name != "Pod Bay Doors" {
+		t.Fatalf("expected the title to be saved, got %+v", threads)
+	}
+
+	// A title set by the user isn't replaced.
+	ct.TitleLocked = true
+	ct.SummarizedAt = 0
+
+	if cmd := m.describeChatThread(ct); cmd != nil {
+		t.Fatal("expected no title to be generated for a locked title")
+	}
+}
+
+func TestModelDescribeDeleted(t *testing.T) {
+	provider := chattest.NewProvider(chattest.Reply{Content: "Title: Pod Bay Doors\nDave asks HAL to open the pod bay doors."})
+	provider.Latency = time.Minute
+
+	m := newTestModel(t, provider)
+
+	ct := &chat.Thread{
+		Name:    "New thread",
+		Created: time.Now(),
+		ChatHistory: []chat.Message{
+			chat.SystemMessage,
+			chat.NewMessage(openai.ChatRoleUser, "Open the pod bay doors, HAL."),
+			chat.NewMessage(openai.ChatRoleAssistant, "I'm sorry, Dave."),
+		},
+	}
+	m.addChatThread(ct)
+
+	cmd := m.describeChatThread(ct)
+	if cmd == nil {
+		t.Fatal("expected the thread to be described")
+	}
+
+	m.deleteChatThread(ct)
+
+	// Deleting the thread stops describing it.
+	msgs := make(chan tea.Msg, 1)
+	go func() { msgs <- cmd() }()
+
+	var msg tea.Msg
+	select {
+	case msg = <-msgs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected describing the thread to be canceled")
+	}
+
+	if described, ok := msg.(chatThreadMsg).Msg.(chat.DescribedMsg); !ok || !errors.Is(described.Err, context.Canceled) {
+		t.Fatalf("expected describing the thread to be canceled, got %+v", msg)
+	}
+
+	// A description arriving anyway doesn't save the thread again.
+	m = update(t, m, chatThreadMsg{Thread: ct, Msg: chat.DescribedMsg{Title: "Pod Bay Doors"}})
+
+	threads, err := m.store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads) != 0 {
+		t.Fatalf("expected the thread to stay deleted, got %+v", threads)
+	}
+}
+
+func TestModelSearch(t *testing.T) {
+	m := newTestModel(t, chattest.NewProvider())
+
+	m.addChatThread(&chat.Thread{
+		Name: "Pod bay doors",
+		ChatHistory: chat.Messages([]openai.ChatMessage{
+			{Role: openai.ChatRoleSystem, Content: "You are HAL."},
+			{Role: openai.ChatRoleUser, Content: "Hello, HAL. Do you read me?"},
+			{Role: openai.ChatRoleAssistant, Content: strings.Repeat("Affirmative, Dave. I read you.\n\n", 20)},
+			{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL."},
+			{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Dave. I'm afraid I can't do that."},
+		}),
+	})
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	if m.mode != ModeSearch {
+		t.Fatal("expected to be searching")
+	}
+
+	// Literal searches use the index, with the last word as a prefix.
+	m = typeText(t, m, "pod ba")
+
+	if len(m.chatSearchResults) != 1 || m.chatSearchResults[0].MessageIndex != 3 {
+		t.Fatalf("expected 1 result from the index, got %d", len(m.chatSearchResults))
+	}
+
+	m = typeText(t, m, "y dors")
+
+	if len(m.chatSearchResults) != 0 {
+		t.Fatalf("expected no results, got %d", len(m.chatSearchResults))
+	}
+
+	// Tab switches to a regexp search, and then a fuzzy search.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyTab})
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyTab})
+
+	if m.chatSearchOptions.Mode != chat.SearchFuzzy || !strings.Contains(m.chatSearch.View(), "Search (fuzzy)") {
+		t.Fatalf("expected a fuzzy search, got %v", m.chatSearchOptions.Mode)
+	}
+
+	if len(m.chatSearchResults) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(m.chatSearchResults))
+	}
+
+	if view := stripANSI(m.View()); !strings.Contains(view, "Pod bay doors · user") || !strings.Contains(view, "Open the pod bay doors, HAL.") {
+		t.Fatalf("expected the result to be shown, got:\n%s", view)
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	if m.mode != ModeEditorInsert || m.currnetThread != m.chatThreads[1] {
+		t.Fatal("expected the thread of the result to be opened")
+	}
+
+	if !strings.Contains(stripANSI(m.chatOutput.View()), "Open the pod bay doors, HAL.") {
+		t.Fatalf("expected the message to be visible, got:\n%s", stripANSI(m.chatOutput.View()))
+	}
+
+	// Messages above the end of the transcript are scrolled to the top.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlL})
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	m = typeText(t, m, "reed me")
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	if m.chatOutput.YOffset != m.chatOutputOffsets[1] {
+		t.Fatalf("expected the transcript to be scrolled to line %d, got %d", m.chatOutputOffsets[1], m.chatOutput.YOffset)
+	}
+}
+
+func TestModelExport(t *testing.T) {
+	m := newTestModel(t, chattest.NewProvider())
+
+	m.addChatThread(&chat.Thread{
+		Name: "Pod bay doors",
+		ChatHistory: chat.Messages([]openai.ChatMessage{
+			{Role: openai.ChatRoleSystem, Content: "You are HAL."},
+			{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL."},
+			{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Dave. I'm afraid I can't do that."},
+		}),
+	})
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+
+	if m.chatThreadPromptAction != chatThreadPromptExport || m.chatThreadPrompt.Value() != "pod-bay-doors.md" {
+		t.Fatalf("expected the export prompt with a file name, got %q", m.chatThreadPrompt.Value())
+	}
+
+	path := filepath.Join(t.TempDir(), "thread.md")
+	m.chatThreadPrompt.SetValue(path)
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	if m.chatThreadPromptAction != chatThreadPromptNone || m.statusbar.Err != nil {
+		t.Fatalf("expected the thread to be exported, got %v", m.statusbar.Err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(b), "# Pod bay doors\n") || strings.Contains(string(b), "You are HAL.") {
+		t.Fatalf("unexpected export:\n%s", b)
+	}
+
+	// Files without a known extension can't be exported.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	m.chatThreadPrompt.SetValue(filepath.Join(t.TempDir(), "thread.pdf"))
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	if m.statusbar.Err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func TestModelBranches(t *testing.T) {
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "I'm sorry, Dave."},
+		chattest.Reply{Content: "Opening the pod bay doors."},
+		chattest.Reply{Content: "Good afternoon, Dave."},
+	)
+
+	m := newTestModel(t, provider)
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	m = typeText(t, m, "Open the pod bay doors, HAL.")
+	m = runChatThread(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+
+	// Regenerating the reply starts a new branch after the same message.
+	m = runChatThread(t, m, tea.KeyMsg{Type: tea.KeyCtrlR})
+
+	ct := m.currnetThread
+	if sent := provider.Requests()[1].Messages; len(sent) != 2 || sent[1].Content != "Open the pod bay doors, HAL." {
+		t.Fatalf("expected the same message to be sent again, got %+v", sent)
+	}
+
+	if len(ct.ChatHistory) != 3 || ct.ChatHistory[2].Content != "Opening the pod bay doors." {
+		t.Fatalf("expected the new reply, got %+v", ct.ChatHistory)
+	}
+
+	if view := stripANSI(m.chatOutput.View()); !strings.Contains(view, "‹ 2/2 ›") {
+		t.Fatalf("expected the reply to be marked as the second branch, got:\n%s", view)
+	}
+
+	// Switch back to the first reply.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlLeft})
+
+	if last := ct.ChatHistory[len(ct.ChatHistory)-1]; last.Content != "I'm sorry, Dave." {
+		t.Fatalf("expected the first reply, got %q", last.Content)
+	}
+
+	// The tree shows both branches.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlG})
+	if m.mode != ModeTree {
+		t.Fatal("expected the tree to be shown")
+	}
+
+	view := stripANSI(m.View())
+	for _, want := range []string{"You: Open the pod bay doors, HAL.", "├─ HAL: I'm sorry, Dave. ●", "└─ HAL: Opening the pod bay doors."} {
+		if !strings.Contains(view, want) {
+			t.Fatalf("expected %q in the tree, got:\n%s", want, view)
+		}
+	}
+
+	// Editing the message puts it back in the editor, to be sent as a new
+	// branch.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyUp})
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+
+	if m.mode != ModeEditorInsert || m.editor.Value() != "Open the pod bay doors, HAL." || len(ct.ChatHistory) != 1 {
+		t.Fatalf("expected the message to be edited, got %q and %d messages", m.editor.Value(), len(ct.ChatHistory))
+	}
+
+	m.editor.SetValue("Hello, HAL.")
+	m = runChatThread(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+
+	if siblings := ct.Siblings(ct.HistoryNodes()[1].ID); len(siblings) != 2 {
+		t.Fatalf("expected the edited message to be a new branch, got %d siblings", len(siblings))
+	}
+
+	// All branches are saved.
+	threads, err := m.store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved := threads[0]; len(saved.Nodes) != 6 || saved.Head != ct.Head {
+		t.Fatalf("expected every branch to be saved, got %d nodes", len(saved.Nodes))
+	}
+}
+
+func TestModelBranchesStreaming(t *testing.T) {
+	m := newTestModel(t, chattest.NewProvider(chattest.Reply{Content: "I'm sorry, Dave. I'm afraid I can't do that."}))
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	m = typeText(t, m, "Open the pod bay doors, HAL.")
+	m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyEsc}, func(msg tea.Msg) bool {
+		_, ok := msg.(chat.StreamDeltaMsg)
+		return ok
+	})
+
+	// The branches aren't worked out again for each chunk of the reply.
+	state := m.chatThreadState(m.currnetThread)
+	branches := state.branches
+
+	m = update(t, m, chatThreadMsg{
+		Thread:  m.currnetThread,
+		Msg:     chat.StreamDeltaMsg{Delta: " Dave.", Next: func() tea.Msg { return nil }},
+		Request: state.request,
+	})
+
+	if len(branches) == 0 || &state.branches[0] != &branches[0] {
+		t.Fatalf("expected the branches to be reused, got %+v", state.branches)
+	}
+
+	// They are once the history changes.
+	m.currnetThread.ChatHistory = append(m.currnetThread.ChatHistory, chat.NewMessage(openai.ChatRoleUser, "Hello, HAL."))
+	m.refreshChatOutput()
+
+	if len(state.branches) != len(m.currnetThread.ChatHistory) {
+		t.Fatalf("expected the branches of the new history, got %+v", state.branches)
+	}
+}
+
+func TestModelTruncate(t *testing.T) {
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "I'm sorry, Dave."},
+		chattest.Reply{Content: "I'm afraid I can't do that."},
+	)
+
+	m := newTestModel(t, provider)
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	m = typeText(t, m, "Open the pod bay doors, HAL.")
+	m = runChatThread(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+	m = typeText(t, m, "What's the problem?")
+	m = runChatThread(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlT})
+
+	// Only the last message is kept after the system message, as a new
+	// branch next to the first message.
+	ct := m.currnetThread
+	if len(ct.ChatHistory) != 2 || ct.ChatHistory[1].Content != "I'm afraid I can't do that." {
+		t.Fatalf("expected the history to be truncated, got %+v", ct.ChatHistory)
+	}
+
+	want := []chat.Branch{{Index: 0, Count: 1}, {Index: 1, Count: 2}}
+	for i, branch := range ct.Branches() {
+		if branch != want[i] {
+			t.Fatalf("expected message %d to be on branch %+v, got %+v", i, want[i], branch)
+		}
+	}
+
+	// The truncated thread is saved, and syncing it again doesn't add
+	// another branch.
+	threads, err := m.store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := threads[0]
+	if len(saved.ChatHistory) != 2 || len(saved.Nodes) != 6 || saved.Head != "6" {
+		t.Fatalf("expected the truncated thread to be saved, got %d messages, %d nodes and head %q", len(saved.ChatHistory), len(saved.Nodes), saved.Head)
+	}
+
+	saved.Sync()
+	if len(saved.Nodes) != 6 || len(saved.Children("1")) != 2 {
+		t.Fatalf("expected 2 branches after the system message, got %d nodes", len(saved.Nodes))
+	}
+}
+
+func TestModelShell(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "```sh\necho hello\n```"},
+	)
+
+	m := newTestModel(t, provider)
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlO})
+	if m.mode != ModeShell {
+		t.Fatalf("expected shell mode, got %v", m.mode)
+	}
+
+	m = typeText(t, m, "say hello")
+	m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyEnter}, func(msg tea.Msg) bool {
+		_, ok := msg.(shell.ProposedMsg)
+		return ok
+	})
+
+	if m.shellStage != shellReview || m.shellInput.Value() != "echo hello" {
+		t.Fatalf("expected the command to review, got stage %v with %q", m.shellStage, m.shellInput.Value())
+	}
+
+	if sent := provider.Requests()[0].Messages; sent[0].Content != shell.SystemMessage().Content || sent[len(sent)-1].Content != "say hello" {
+		t.Fatalf("expected the task to be sent with the shell system message, got %+v", sent)
+	}
+
+	m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyEnter}, func(msg tea.Msg) bool {
+		_, ok := msg.(shell.FinishedMsg)
+		return ok
+	})
+
+	history := m.currnetThread.ChatHistory
+	if len(history) != 4 {
+		t.Fatalf("expected the task, the proposal and the output to be added, got %+v", history)
+	}
+
+	if history[1].Content != "say hello" || history[2].Content != "```sh\necho hello\n```" {
+		t.Fatalf("expected the task and the proposal, got %+v", history[1:3])
+	}
+
+	if output := history[3].Content; !strings.Contains(output, "exited with code 0") || !strings.Contains(output, "hello\n```") {
+		t.Fatalf("expected the output of the command, got %q", output)
+	}
+
+	if m.mode != ModeShell || m.shellStage != shellDescribe || m.shellInput.Value() != "" {
+		t.Fatalf("expected to describe the next task, got stage %v with %q", m.shellStage, m.shellInput.Value())
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+	if m.mode != ModeEditorInsert {
+		t.Fatalf("expected to be back in the editor, got %v", m.mode)
+	}
+}
+
+func TestModelShellPolicy(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+
+	build := filepath.Join(t.TempDir(), "build")
+	if err := os.Mkdir(build, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "```sh\nrm -rf " + build + "\n```"},
+	)
+
+	m := newTestModel(t, provider)
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlO})
+
+	m = typeText(t, m, "delete the build directory")
+	m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyEnter}, func(msg tea.Msg) bool {
+		_, ok := msg.(shell.ProposedMsg)
+		return ok
+	})
+
+	// Destructive commands need "yes" typed before they run.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.shellStage != shellConfirm || m.shellDecision.Level != policy.LevelType {
+		t.Fatalf("expected to confirm the command by typing, got stage %v with level %s", m.shellStage, m.shellDecision.Level)
+	}
+
+	if view := stripANSI(m.viewShell()); !strings.Contains(view, "destructive: rm -r deletes files and directories recursively") {
+		t.Fatalf("expected the reason to confirm, got:\n%s", view)
+	}
+
+	m = typeText(t, m, "y")
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.shellStage != shellConfirm {
+		t.Fatalf("expected to still confirm the command, got stage %v", m.shellStage)
+	}
+
+	// Going back declines the command.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+	if m.shellStage != shellReview || m.shellInput.Value() != "rm -rf "+build {
+		t.Fatalf("expected to review the command again, got stage %v with %q", m.shellStage, m.shellInput.Value())
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = typeText(t, m, "yes")
+	m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyEnter}, func(msg tea.Msg) bool {
+		_, ok := msg.(shell.FinishedMsg)
+		return ok
+	})
+
+	if _, err := os.Stat(build); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the command to run, got %v", err)
+	}
+
+	// Commands matching deny rules don't run.
+	m.shellPolicy.Deny = []string{"rm *"}
+	m.setShellStage(shellReview, "rm notes.txt")
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.shellStage != shellReview || m.statusbar.Err == nil || !strings.Contains(m.statusbar.Err.Error(), `denied by the rule "rm *"`) {
+		t.Fatalf("expected the command to be denied, got stage %v with %v", m.shellStage, m.statusbar.Err)
+	}
+
+	src, err := os.ReadFile(m.auditLog.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outcomes []string
+	for _, line := range strings.Split(strings.TrimSpace(string(src)), "\n") {
+		var entry policy.Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Thread != m.currnetThread.ID || entry.Task != "delete the build directory" {
+			t.Fatalf("expected the thread and task to be logged, got %+v", entry)
+		}
+		outcomes = append(outcomes, entry.Outcome)
+	}
+
+	if want := []string{policy.OutcomeDeclined, policy.OutcomeRan, policy.OutcomeDenied}; strings.Join(outcomes, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected the outcomes %v to be logged, got %v", want, outcomes)
+	}
+}
+
+func TestModelShellSandbox(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+
+	dir := t.TempDir()
+	notes := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notes, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "```sh\necho new > notes.txt\n```"},
+		chattest.Reply{Content: "```sh\necho new > notes.txt\n```"},
+	)
+
+	m := newTestModel(t, provider)
+	m.shellDir = dir
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlO})
+
+	// run proposes a command for the task, and runs it once the keys are
+	// pressed to review it.
+	run := func(m model, task string, review ...tea.KeyMsg) model {
+		t.Helper()
+
+		m = typeText(t, m, task)
+		m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyEnter}, func(msg tea.Msg) bool {
+			_, ok := msg.(shell.ProposedMsg)
+			return ok
+		})
+
+		for _, msg := range review {
+			m = update(t, m, msg)
+		}
+
+		m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+		m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")}, func(msg tea.Msg) bool {
+			_, ok := msg.(shell.FinishedMsg)
+			return ok
+		})
+
+		if errors.Is(m.chatThreadState(m.currnetThread).err, sandbox.ErrUnsupported) {
+			t.Skip(sandbox.ErrUnsupported)
+		}
+
+		return m
+	}
+
+	// Commands run in a sandbox once it's turned on while reviewing them.
+	m = run(m, "update the notes", tea.KeyMsg{Type: tea.KeyTab})
+
+	if m.shellStage != shellChanges {
+		t.Fatalf("expected to review the changes, got stage %v with %v", m.shellStage, m.statusbar.Err)
+	}
+
+	if view := stripANSI(m.shellChanges.View()); !strings.Contains(view, "modified notes.txt") || !strings.Contains(view, "-old") || !strings.Contains(view, "+new") {
+		t.Fatalf("expected the diff of the notes, got:\n%s", view)
+	}
+
+	if content, _ := os.ReadFile(notes); string(content) != "old\n" {
+		t.Fatalf("expected the notes to be unchanged until applied, got %q", content)
+	}
+
+	// Discarded changes are added to the history, but not applied.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+
+	history := m.currnetThread.ChatHistory
+	if output := history[len(history)-1].Content; !strings.Contains(output, "in a sandbox") || !strings.Contains(output, "I discarded the changes:\n\n- notes.txt (modified)") {
+		t.Fatalf("expected the discarded changes in the output, got %q", output)
+	}
+
+	if content, _ := os.ReadFile(notes); string(content) != "old\n" {
+		t.Fatalf("expected the notes to be unchanged, got %q", content)
+	}
+
+	if m.shellStage != shellDescribe {
+		t.Fatalf("expected to describe the next task, got stage %v", m.shellStage)
+	}
+
+	m = run(m, "update the notes again")
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")})
+
+	if content, _ := os.ReadFile(notes); string(content) != "new\n" {
+		t.Fatalf("expected the changes to be applied, got %q", content)
+	}
+
+	history = m.currnetThread.ChatHistory
+	if output := history[len(history)-1].Content; !strings.Contains(output, "I applied the changes to the working directory") {
+		t.Fatalf("expected the applied changes in the output, got %q", output)
+	}
+}
+
+func TestModelShellSandboxInteractive(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestModel(t, chattest.NewProvider())
+	m.shellDir = dir
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlO})
+
+	m.shellCommand = "vi notes.txt"
+	m.shellInteractive = true
+	m.shellSandbox = true
+
+	// The working directory is copied in the background.
+	cmd := m.runShellCommand()
+
+	state := m.chatThreadState(m.currnetThread)
+	if state.shellCancel == nil || !m.statusbar.Spinning {
+		t.Fatal("expected the sandbox to be created in the background")
+	}
+
+	var msg tea.Msg
+	for _, c := range cmd().(tea.BatchMsg) {
+		if threadMsg, ok := c().(chatThreadMsg); ok {
+			msg = threadMsg.Msg
+			break
+		}
+	}
+
+	if finished, ok := msg.(shell.FinishedMsg); ok && errors.Is(finished.Err, sandbox.ErrUnsupported) {
+		t.Skip(sandbox.ErrUnsupported)
+	}
+
+	ready, ok := msg.(shellSandboxMsg)
+	if !ok || ready.Command != "vi notes.txt" {
+		t.Fatalf("expected the sandbox to be created, got %+v", msg)
+	}
+
+	if _, err := os.Stat(filepath.Join(ready.Sandbox.Dir, "notes.txt")); err != nil {
+		t.Fatalf("expected the working directory to be copied, got %v", err)
+	}
+
+	// Canceling the command while it's copied removes the sandbox, instead
+	// of running the command.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlX})
+	m = update(t, m, chatThreadMsg{Thread: m.currnetThread, Msg: ready})
+
+	if _, err := os.Stat(ready.Sandbox.Dir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the sandbox to be removed, got %v", err)
+	}
+
+	if m.shellStage != shellDescribe {
+		t.Fatalf("expected to describe the next task, got stage %v", m.shellStage)
+	}
+}
+
+func TestModelFileEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+
+	var old, new strings.Builder
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&old, "line %d\n", i)
+		switch i {
+		case 2:
+			new.WriteString("line two\n")
+		case 18:
+			new.WriteString("line eighteen\n")
+		default:
+			fmt.Fprintf(&new, "line %d\n", i)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(old.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "```\n" + new.String() + "```"},
+		chattest.Reply{Content: "I'm afraid I can't do that."},
+	)
+
+	m := newTestModel(t, provider)
+	if err := m.openFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.mode != ModeFileEdit || !strings.Contains(stripANSI(m.fileView.View()), "   2 │ line 2") {
+		t.Fatalf("expected the file with line numbers, got mode %v with:\n%s", m.mode, stripANSI(m.fileView.View()))
+	}
+
+	// The thread is only kept once there's an edit.
+	threads := len(m.chatThreads)
+
+	m = typeText(t, m, "spell out two and eighteen")
+	m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyEsc}, func(msg tea.Msg) bool {
+		_, ok := msg.(edit.ProposedMsg)
+		return ok
+	})
+
+	if m.fileStage != fileReview || len(m.fileDiff.Hunks()) != 2 {
+		t.Fatalf("expected 2 hunks to review, got stage %v (%v)", m.fileStage, m.statusbar.Err)
+	}
+
+	if len(m.chatThreads) != threads+1 || m.currnetThread.ID == "" || len(m.currnetThread.ChatHistory) != 3 {
+		t.Fatalf("expected the thread to be saved with the edit, got %+v", m.currnetThread)
+	}
+
+	if view := stripANSI(m.View()); !strings.Contains(view, "-line 2 ") || !strings.Contains(view, "+line two") {
+		t.Fatalf("expected the diff, got:\n%s", view)
+	}
+
+	// Rejecting the second hunk only writes the first.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyTab})
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("n")})
+	if !m.fileDiff.Accepted(0) || m.fileDiff.Accepted(1) {
+		t.Fatal("expected only the first hunk to be accepted")
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.fileStage != fileInstruct {
+		t.Fatalf("expected to write the file, got stage %v with %v", m.fileStage, m.statusbar.Err)
+	}
+
+	want := strings.Replace(old.String(), "line 2\n", "line two\n", 1)
+	if content, _ := os.ReadFile(path); string(content) != want {
+		t.Fatalf("expected only the first hunk to be written, got:\n%s", content)
+	}
+
+	if !strings.Contains(stripANSI(m.viewFileEdit()), "Wrote 1 of 2 changes") {
+		t.Fatalf("expected what was written, got:\n%s", stripANSI(m.viewFileEdit()))
+	}
+
+	// The next instruction is sent with the file as it is now.
+	m = typeText(t, m, "open the pod bay doors")
+	m = runChatThreadUntil(t, m, tea.KeyMsg{Type: tea.KeyEsc}, func(msg tea.Msg) bool {
+		_, ok := msg.(edit.ProposedMsg)
+		return ok
+	})
+
+	sent := provider.Requests()[1].Messages
+	if prompt := sent[len(sent)-1].Content; !strings.Contains(prompt, "line two\n") || sent[0].Content != edit.SystemMessage(path).Content {
+		t.Fatalf("expected the current file to be sent, got %q", prompt)
+	}
+
+	if m.fileStage != fileInstruct || m.statusbar.Err == nil || m.statusbar.Err.Error() != "I'm afraid I can't do that." {
+		t.Fatalf("expected the explanation as an error, got stage %v with %v", m.fileStage, m.statusbar.Err)
+	}
+
+	// Files changed by something else since aren't overwritten.
+	if err := os.WriteFile(path, []byte("changed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m.fileDiff = newDiffView(m.fileEdit.Content, new.String())
+	m.setFileStage(fileReview)
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.fileStage != fileReview || m.statusbar.Err == nil || !strings.Contains(m.statusbar.Err.Error(), "changed since it was opened") {
+		t.Fatalf("expected a conflict, got stage %v with %v", m.fileStage, m.statusbar.Err)
+	}
+}
+
+func TestModelPatch(t *testing.T) {
+	dir := t.TempDir()
+
+	var old strings.Builder
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&old, "line %d\n", i)
+	}
+
+	notes := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notes, []byte(old.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := "Here you go:\n\n```diff\n" +
+		"--- a/notes.txt\n+++ b/notes.txt\n" +
+		"@@ -1,3 +1,3 @@\n line 1\n-line 2\n+line two\n line 3\n" +
+		"@@ -17,3 +17,3 @@\n line 17\n-line 18\n+line eighteen\n line 19\n" +
+		"--- a/gone.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-gone\n" +
+		"--- /dev/null\n+++ b/todo.txt\n@@ -0,0 +1 @@\n+open the pod bay doors\n" +
+		"--- /dev/null\n+++ b/new/dir/file.go\n@@ -0,0 +1 @@\n+package dir\n" +
+		"--- /dev/null\n+++ b/../escape.txt\n@@ -0,0 +1 @@\n+out\n" +
+		"```"
+
+	provider := chattest.NewProvider(chattest.Reply{Content: reply})
+
+	m := newTestModel(t, provider)
+	m.shellDir = dir
+
+	// Nothing to review before there's a patch.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlP})
+	if m.mode != ModeEditorInsert || m.statusbar.Err == nil {
+		t.Fatalf("expected an error without a patch, got mode %v", m.mode)
+	}
+
+	m = typeText(t, m, "spell out two and eighteen")
+	m = runChatThread(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+
+	m.chatOutput.GotoTop()
+	if view := stripANSI(m.View()); !strings.Contains(view, "± patch") {
+		t.Fatalf("expected the reply to be marked with a patch, got:\n%s", view)
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlP})
+	if m.mode != ModePatch || m.patchName != "notes.txt" || len(m.patchDiff.Hunks()) != 2 {
+		t.Fatalf("expected to review the notes, got mode %v with %v", m.mode, m.statusbar.Err)
+	}
+
+	if view := stripANSI(m.View()); !strings.Contains(view, "-line 2") || !strings.Contains(view, "+line two") || !strings.Contains(view, "4 more files to review") {
+		t.Fatalf("expected the diff of the notes, got:\n%s", view)
+	}
+
+	// Rejecting the second hunk, undoing it and rejecting it again only
+	// writes the first.
+	for _, k := range []string{"tab", "n", "u", "n"} {
+		msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+		if k == "tab" {
+			msg = tea.KeyMsg{Type: tea.KeyTab}
+		}
+		m = update(t, m, msg)
+	}
+
+	if !m.patchDiff.Accepted(0) || m.patchDiff.Accepted(1) {
+		t.Fatal("expected only the first hunk to be accepted")
+	}
+
+	// The changes can be seen side by side too.
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	if view := stripANSI(m.View()); !regexp.MustCompile(`2 -line 2 +│ +2 \+line two`).MatchString(view) {
+		t.Fatalf("expected the changes side by side, got:\n%s", view)
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	want := strings.Replace(old.String(), "line 2\n", "line two\n", 1)
+	if content, _ := os.ReadFile(notes); string(content) != want {
+		t.Fatalf("expected only the first hunk to be written, got:\n%s", content)
+	}
+
+	// Deleting files isn't supported, so the new file is next.
+	if m.mode != ModePatch || m.patchName != "todo.txt" || !strings.Contains(stripANSI(m.View()), "todo.txt (new file)") {
+		t.Fatalf("expected to review the new file, got mode %v with %q", m.mode, m.patchName)
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	if content, _ := os.ReadFile(filepath.Join(dir, "todo.txt")); string(content) != "open the pod bay doors\n" {
+		t.Fatalf("expected the new file to be created, got %q", content)
+	}
+
+	// New files are created along with the directories they're in.
+	if m.mode != ModePatch || m.patchName != "new/dir/file.go" {
+		t.Fatalf("expected to review the new file in a new directory, got mode %v with %q", m.mode, m.patchName)
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+
+	if content, _ := os.ReadFile(filepath.Join(dir, "new", "dir", "file.go")); string(content) != "package dir\n" {
+		t.Fatalf("expected the new file and its directories to be created, got %q", content)
+	}
+
+	if m.mode != ModeEditorInsert {
+		t.Fatalf("expected to go back to the thread, got mode %v", m.mode)
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be written outside of the directory, got %v", err)
+	}
+
+	history := m.currnetThread.ChatHistory
+	result := history[len(history)-1].Content
+	for _, want := range []string{
+		"`notes.txt`: applied 1 of 2 changes",
+		"`gone.txt`: not applied, deleting gone.txt isn't supported",
+		"`todo.txt`: applied 1 of 1 changes",
+		"`new/dir/file.go`: applied 1 of 1 changes",
+		"`../escape.txt`: not applied, ../escape.txt is outside of the working directory",
+	} {
+		if !strings.Contains(result, want) {
+			t.Fatalf("expected %q in the result, got %q", want, result)
+		}
+	}
+}
+
+func TestPatchFilePath(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"notes.txt", "src/main.go", "src/../notes.txt", "new/dir/file.txt"} {
+		path, err := patchFilePath(dir, name)
+		if err != nil || path != filepath.Join(dir, name) {
+			t.Fatalf("expected %s to be in the directory, got %q, %v", name, path, err)
+		}
+	}
+
+	for _, name := range []string{"/etc/passwd", filepath.Join(dir, "notes.txt"), "../notes.txt", "src/../../notes.txt", "link/notes.txt", "link/new/file.txt"} {
+		if _, err := patchFilePath(dir, name); err == nil || !strings.Contains(err.Error(), "outside of the working directory") {
+			t.Fatalf("expected %s to be refused, got %v", name, err)
+		}
+	}
+}
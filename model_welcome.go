@@ -2,9 +2,11 @@ package main
 
 import "github.com/charmbracelet/lipgloss"
 
-var welcomeToHAL = lipgloss.JoinHorizontal(
-	lipgloss.Left,
-	"Welcome to",
-	lipgloss.NewStyle().Foreground(lipgloss.Color("69")).Bold(true).Render(" HAL"),
-	"!",
-)
+func welcomeToHAL() string {
+	return lipgloss.JoinHorizontal(
+		lipgloss.Left,
+		"Welcome to",
+		halStyleColor.Copy().Bold(true).Render(" HAL"),
+		"!",
+	)
+}
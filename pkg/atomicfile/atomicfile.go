@@ -0,0 +1,45 @@
+// Package atomicfile writes files atomically, so they're never left half
+// written if writing them fails or the program is stopped.
+package atomicfile
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// TempPrefix starts the names of the temporary files written next to files
+// being replaced, which are only left behind if the program is stopped while
+// writing them.
+const TempPrefix = ".tmp-"
+
+// WriteFile writes the data to the file at the path with the permissions, by
+// writing it to a temporary file in the same directory which is then renamed
+// over the previous version.
+func WriteFile(path string, data []byte, perm fs.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), TempPrefix+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // No-op after a successful rename.
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,40 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.sh")
+	if err := os.WriteFile(path, []byte("echo hi\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFile(path, []byte("echo hello\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Fatalf("expected the permissions to be set, got %v", info.Mode())
+	}
+
+	if content, _ := os.ReadFile(path); string(content) != "echo hello\n" {
+		t.Fatalf("expected the new content, got %q", content)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected no temporary files left, got %v, %v", entries, err)
+	}
+
+	// Nothing is written to missing directories.
+	if err := WriteFile(filepath.Join(t.TempDir(), "missing", "run.sh"), nil, 0o644); err == nil {
+		t.Fatal("expected an error")
+	}
+}
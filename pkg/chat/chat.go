@@ -8,43 +8,94 @@ import (
 	"github.com/picatz/openai"
 )
 
+// Options are the options used for chat requests.
+type Options struct {
+	// Model is the chat model to use, if empty the provider's default
+	// model is used.
+	Model string
+
+	// Temperature is the sampling temperature, zero uses the provider's
+	// default.
+	Temperature float64
+
+	// MaxTokens is the maximum number of tokens to generate, zero uses the
+	// provider's default.
+	MaxTokens int
+}
+
+// FinishedMsg is sent when a chat request finishes, either successfully or
+// with an error.
 type FinishedMsg struct {
 	Err     error
 	Buffer  []byte
-	History []openai.ChatMessage
+	History []Message
 	Tokens  int
 }
 
-func Send(client *openai.Client, chatHistory []openai.ChatMessage, text string) tea.Cmd {
+// Send sends the text as a new user message to the provider, with the chat
+// history as context, returning a command that sends a FinishedMsg when the
+// response is complete.
+//
+// The request can be canceled using the given context, which the caller is
+// expected to cancel when the request is no longer needed.
+func Send(ctx context.Context, provider Provider, opts Options, chatHistory []Message, text string) tea.Cmd {
 	return func() tea.Msg {
-		// send the message to the OpenAI chat API
-		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-		defer cancel()
+		start := time.Now()
 
-		chatHistory = append(chatHistory, openai.ChatMessage{
-			Role:    openai.ChatRoleUser,
-			Content: text,
-		})
+		// send the message to the provider
+		chatHistory = append(chatHistory, userMessage(text, start))
 
-		resp, err := client.CreateChat(ctx, &openai.CreateChatRequest{
-			Model:    openai.ModelGPT35Turbo,
-			Messages: chatHistory,
-		})
+		resp, err := provider.Complete(ctx, opts.request(ChatMessages(chatHistory)))
 		if err != nil {
 			return FinishedMsg{Err: err}
 		}
 
+		var usage *Usage
+		if resp.Usage.TotalTokens > 0 {
+			usage = &resp.Usage
+		}
+
 		// Add response to chat history
-		chatHistory = append(chatHistory, openai.ChatMessage{
-			Role:    openai.ChatRoleSystem,
-			Content: resp.Choices[0].Message.Content,
+		chatHistory = append(chatHistory, Message{
+			ChatMessage: openai.ChatMessage{
+				Role:    openai.ChatRoleAssistant,
+				Content: resp.Message.Content,
+			},
+			Metadata: opts.responseMetadata(start, resp.Model, usage, resp.FinishReason),
 		})
 
 		return FinishedMsg{
 			Err:     nil,
-			Buffer:  []byte(resp.Choices[0].Message.Content),
+			Buffer:  []byte(resp.Message.Content),
 			History: chatHistory,
 			Tokens:  resp.Usage.TotalTokens,
 		}
 	}
 }
+
+// userMessage returns the message for the text the user sent at the given
+// time.
+func userMessage(text string, sent time.Time) Message {
+	msg := NewMessage(openai.ChatRoleUser, text)
+	msg.Metadata = &Metadata{Time: sent}
+	return msg
+}
+
+// responseMetadata returns the metadata of a response to a request sent at
+// the given time, which just finished. The model asked for is used if the
+// provider didn't say which model it used.
+func (opts Options) responseMetadata(sent time.Time, model string, usage *Usage, finishReason string) *Metadata {
+	if model == "" {
+		model = opts.Model
+	}
+
+	now := time.Now()
+
+	return &Metadata{
+		Time:         now,
+		Model:        model,
+		Usage:        usage,
+		Latency:      now.Sub(sent),
+		FinishReason: finishReason,
+	}
+}
@@ -0,0 +1,49 @@
+package chat_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/chat/chattest"
+)
+
+func TestSend(t *testing.T) {
+	provider := chattest.NewProvider(chattest.Reply{
+		Content: "I'm sorry, Dave. I'm afraid I can't do that.",
+		Usage:   chat.Usage{PromptTokens: 20, CompletionTokens: 12, TotalTokens: 32},
+	})
+
+	opts := chat.Options{Model: "gpt-4"}
+
+	msg, ok := chat.Send(context.Background(), provider, opts, []chat.Message{chat.SystemMessage}, "Open the pod bay doors, HAL.")().(chat.FinishedMsg)
+	if !ok || msg.Err != nil {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+
+	if len(msg.History) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(msg.History))
+	}
+
+	// The reply is from the assistant, not a system instruction for the
+	// next request.
+	reply := msg.History[2]
+	if reply.Role != openai.ChatRoleAssistant || reply.Content != "I'm sorry, Dave. I'm afraid I can't do that." {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+
+	md := reply.Metadata
+	if md == nil || md.Model != "gpt-4" || md.FinishReason != "stop" || md.Usage == nil || md.Usage.TotalTokens != 32 {
+		t.Fatalf("unexpected metadata: %+v", md)
+	}
+
+	if sent := msg.History[1].Metadata; sent == nil || sent.Time.IsZero() || sent.Time.After(md.Time) {
+		t.Fatalf("expected the time the message was sent, got %+v", sent)
+	}
+
+	if msg.History[0].Metadata != nil {
+		t.Fatal("expected the system message to be left alone")
+	}
+}
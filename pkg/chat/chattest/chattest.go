@@ -0,0 +1,237 @@
+// Package chattest provides a fake chat provider, so code using large
+// language models can be tested without a network connection or an API
+// key.
+//
+// Responses can be scripted in the test, or recorded from a real provider
+// to a fixture file once, and replayed from then on.
+package chattest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// ErrNoReplies is returned when the provider has no more scripted replies.
+var ErrNoReplies = errors.New("chattest: no more scripted replies")
+
+// Reply is a scripted reply to a single request.
+type Reply struct {
+	// Content is the content of the assistant's message.
+	Content string `json:"content"`
+
+	// Chunks is the content split into the chunks it's streamed in, if
+	// empty the content is streamed word-by-word.
+	Chunks []string `json:"chunks,omitempty"`
+
+	// FinishReason is why the model stopped, if empty "stop" is used.
+	FinishReason string `json:"finish_reason,omitempty"`
+
+	// Usage is the number of tokens reported as used.
+	Usage chat.Usage `json:"usage"`
+
+	// Err is returned instead of a response, if set.
+	Err error `json:"-"`
+
+	// StreamErr is returned when streaming, after all the chunks have
+	// been sent, to fail part way through a response.
+	StreamErr error `json:"-"`
+}
+
+// chunks returns the chunks the reply is streamed in.
+func (r *Reply) chunks() []string {
+	if len(r.Chunks) > 0 {
+		return r.Chunks
+	}
+
+	if r.Content == "" {
+		return nil
+	}
+
+	return strings.SplitAfter(r.Content, " ")
+}
+
+// Provider is a fake chat.Provider, which responds to each request with the
+// next scripted reply, and records the requests it receives.
+type Provider struct {
+	// Replies are the replies to send, in order.
+	Replies []Reply
+
+	// Latency is how long to wait before responding.
+	Latency time.Duration
+
+	// ChunkLatency is how long to wait between each streamed chunk.
+	ChunkLatency time.Duration
+
+	mu       sync.Mutex
+	requests []*chat.Request
+}
+
+// NewProvider returns a new fake provider which sends the given replies,
+// in order.
+func NewProvider(replies ...Reply) *Provider {
+	return &Provider{
+		Replies: replies,
+	}
+}
+
+// Requests returns the requests the provider has received so far.
+func (p *Provider) Requests() []*chat.Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*chat.Request(nil), p.requests...)
+}
+
+// reply records the request, and returns the reply to send.
+func (p *Provider) reply(req *chat.Request) (*Reply, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Keep a copy of the messages, since callers can append to the slice.
+	recorded := *req
+	recorded.Messages = append([]openai.ChatMessage(nil), req.Messages...)
+	p.requests = append(p.requests, &recorded)
+
+	if len(p.Replies) == 0 {
+		return nil, ErrNoReplies
+	}
+
+	reply := p.Replies[0]
+	p.Replies = p.Replies[1:]
+
+	return &reply, nil
+}
+
+// Complete implements chat.Provider.
+func (p *Provider) Complete(ctx context.Context, req *chat.Request) (*chat.Response, error) {
+	reply, err := p.reply(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sleep(ctx, p.Latency); err != nil {
+		return nil, err
+	}
+
+	if reply.Err != nil {
+		return nil, reply.Err
+	}
+
+	return &chat.Response{
+		Message: openai.ChatMessage{
+			Role:    openai.ChatRoleAssistant,
+			Content: reply.Content,
+		},
+		FinishReason: finishReason(reply),
+		Usage:        reply.Usage,
+	}, nil
+}
+
+// Stream implements chat.Provider.
+func (p *Provider) Stream(ctx context.Context, req *chat.Request) (chat.ResponseStream, error) {
+	reply, err := p.reply(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sleep(ctx, p.Latency); err != nil {
+		return nil, err
+	}
+
+	if reply.Err != nil {
+		return nil, reply.Err
+	}
+
+	return &stream{
+		ctx:     ctx,
+		reply:   reply,
+		chunks:  reply.chunks(),
+		latency: p.ChunkLatency,
+	}, nil
+}
+
+// stream is a chat.ResponseStream sending a scripted reply.
+type stream struct {
+	ctx     context.Context
+	reply   *Reply
+	chunks  []string
+	latency time.Duration
+	sent    int
+	done    bool
+}
+
+// Recv implements chat.ResponseStream.
+func (s *stream) Recv() (*chat.Delta, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+
+	if s.sent > 0 {
+		if err := sleep(s.ctx, s.latency); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.sent == len(s.chunks) {
+		if s.reply.StreamErr != nil {
+			return nil, s.reply.StreamErr
+		}
+
+		s.done = true
+
+		usage := s.reply.Usage
+		return &chat.Delta{
+			FinishReason: finishReason(s.reply),
+			Usage:        &usage,
+		}, nil
+	}
+
+	delta := &chat.Delta{
+		Content: s.chunks[s.sent],
+	}
+	if s.sent == 0 {
+		delta.Role = openai.ChatRoleAssistant
+	}
+	s.sent++
+
+	return delta, nil
+}
+
+// Close implements chat.ResponseStream.
+func (s *stream) Close() error {
+	s.done = true
+	return nil
+}
+
+// finishReason returns the reply's finish reason, or "stop".
+func finishReason(reply *Reply) string {
+	if reply.FinishReason != "" {
+		return reply.FinishReason
+	}
+	return "stop"
+}
+
+// sleep waits for the given duration, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+package chattest
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// readStream runs the stream command until it finishes, returning all of
+// the deltas received, and the final message.
+func readStream(t *testing.T, cmd tea.Cmd) ([]string, chat.FinishedMsg) {
+	t.Helper()
+
+	deltas := []string{}
+
+	for {
+		switch msg := cmd().(type) {
+		case chat.StreamDeltaMsg:
+			deltas = append(deltas, msg.Delta)
+			cmd = msg.Next
+		case chat.FinishedMsg:
+			return deltas, msg
+		default:
+			t.Fatalf("unexpected message type %T", msg)
+		}
+	}
+}
+
+func TestProviderStream(t *testing.T) {
+	provider := NewProvider(Reply{
+		Content: "Hello, Dave.",
+		Usage:   chat.Usage{TotalTokens: 12},
+	})
+
+	deltas, msg := readStream(t, chat.Stream(context.Background(), provider, chat.Options{}, nil, "Hello"))
+	if msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+
+	if strings.Join(deltas, "|") != "Hello, |Dave." {
+		t.Fatalf("unexpected deltas: %q", deltas)
+	}
+
+	if msg.Tokens != 12 {
+		t.Fatalf("expected 12 tokens, got %d", msg.Tokens)
+	}
+
+	requests := provider.Requests()
+	if len(requests) != 1 || requests[0].Messages[0].Content != "Hello" {
+		t.Fatalf("unexpected requests: %+v", requests)
+	}
+
+	// Once the replies run out, requests fail.
+	_, msg = readStream(t, chat.Stream(context.Background(), provider, chat.Options{}, nil, "Hello?"))
+	if !errors.Is(msg.Err, ErrNoReplies) {
+		t.Fatalf("expected ErrNoReplies, got %v", msg.Err)
+	}
+}
+
+func TestProviderErrors(t *testing.T) {
+	errOverloaded := errors.New("overloaded")
+
+	provider := NewProvider(
+		Reply{Err: errOverloaded},
+		Reply{Chunks: []string{"I'm afraid", " I can't"}, StreamErr: errOverloaded},
+	)
+
+	_, err := provider.Complete(context.Background(), &chat.Request{})
+	if !errors.Is(err, errOverloaded) {
+		t.Fatalf("expected error, got %v", err)
+	}
+
+	deltas, msg := readStream(t, chat.Stream(context.Background(), provider, chat.Options{}, nil, "Open the pod bay doors"))
+	if !errors.Is(msg.Err, errOverloaded) {
+		t.Fatalf("expected error, got %v", msg.Err)
+	}
+
+	if len(deltas) != 2 || string(msg.Buffer) != "I'm afraid I can't" {
+		t.Fatalf("expected partial response, got %q", msg.Buffer)
+	}
+}
+
+func TestProviderLatency(t *testing.T) {
+	provider := NewProvider(Reply{Content: "Too slow"})
+	provider.Latency = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := provider.Complete(ctx, &chat.Request{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder(NewProvider(
+		Reply{Content: "Good afternoon.", Usage: chat.Usage{TotalTokens: 7}},
+		Reply{Chunks: []string{"Hello", ", Dave."}},
+	))
+
+	req := &chat.Request{
+		Messages: []openai.ChatMessage{
+			{Role: openai.ChatRoleUser, Content: "Hello"},
+		},
+	}
+
+	if _, err := recorder.Complete(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, msg := readStream(t, chat.Stream(context.Background(), recorder, chat.Options{}, nil, "Hello")); msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+
+	if err := recorder.Fixture().Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture, err := LoadFixture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fixture.Exchanges) != 2 {
+		t.Fatalf("expected 2 exchanges, got %d", len(fixture.Exchanges))
+	}
+
+	replay := fixture.Provider()
+
+	resp, err := replay.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Message.Content != "Good afternoon." || resp.Usage.TotalTokens != 7 {
+		t.Fatalf("unexpected replayed response: %+v", resp)
+	}
+
+	deltas, msg := readStream(t, chat.Stream(context.Background(), replay, chat.Options{}, nil, "Hello"))
+	if msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+
+	if strings.Join(deltas, "|") != "Hello|, Dave." {
+		t.Fatalf("expected the recorded chunks, got %q", deltas)
+	}
+}
@@ -0,0 +1,174 @@
+package chattest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// Exchange is a recorded request and the reply to it.
+type Exchange struct {
+	Messages []openai.ChatMessage `json:"messages"`
+	Reply    Reply                `json:"reply"`
+}
+
+// Fixture is a recorded conversation with a provider, which can be saved
+// to a JSON file and replayed in tests.
+type Fixture struct {
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// LoadFixture reads a fixture from the JSON file at the given path.
+func LoadFixture(path string) (*Fixture, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(b, &fixture); err != nil {
+		return nil, fmt.Errorf("failed to decode fixture %q: %w", path, err)
+	}
+
+	return &fixture, nil
+}
+
+// Save writes the fixture as JSON to the file at the given path.
+func (f *Fixture) Save(path string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// Provider returns a fake provider which replays the fixture's replies,
+// in the order they were recorded.
+func (f *Fixture) Provider() *Provider {
+	replies := make([]Reply, len(f.Exchanges))
+	for i, exchange := range f.Exchanges {
+		replies[i] = exchange.Reply
+	}
+
+	return NewProvider(replies...)
+}
+
+// Recorder is a chat.Provider which passes requests to another provider,
+// recording the exchanges to a fixture.
+type Recorder struct {
+	// Provider is the provider requests are sent to.
+	Provider chat.Provider
+
+	mu      sync.Mutex
+	fixture Fixture
+}
+
+// NewRecorder returns a new recorder for the given provider.
+func NewRecorder(provider chat.Provider) *Recorder {
+	return &Recorder{
+		Provider: provider,
+	}
+}
+
+// Fixture returns the exchanges recorded so far.
+func (r *Recorder) Fixture() *Fixture {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return &Fixture{
+		Exchanges: append([]Exchange(nil), r.fixture.Exchanges...),
+	}
+}
+
+// record adds the exchange to the fixture.
+func (r *Recorder) record(req *chat.Request, reply Reply) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fixture.Exchanges = append(r.fixture.Exchanges, Exchange{
+		Messages: append([]openai.ChatMessage(nil), req.Messages...),
+		Reply:    reply,
+	})
+}
+
+// Complete implements chat.Provider.
+func (r *Recorder) Complete(ctx context.Context, req *chat.Request) (*chat.Response, error) {
+	resp, err := r.Provider.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	r.record(req, Reply{
+		Content:      resp.Message.Content,
+		FinishReason: resp.FinishReason,
+		Usage:        resp.Usage,
+	})
+
+	return resp, nil
+}
+
+// Stream implements chat.Provider.
+func (r *Recorder) Stream(ctx context.Context, req *chat.Request) (chat.ResponseStream, error) {
+	resp, err := r.Provider.Stream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingStream{
+		ResponseStream: resp,
+		recorder:       r,
+		req:            req,
+	}, nil
+}
+
+// recordingStream records a streamed response once it's complete.
+type recordingStream struct {
+	chat.ResponseStream
+
+	recorder *Recorder
+	req      *chat.Request
+	reply    Reply
+	content  strings.Builder
+	recorded bool
+}
+
+// Recv implements chat.ResponseStream.
+func (s *recordingStream) Recv() (*chat.Delta, error) {
+	delta, err := s.ResponseStream.Recv()
+	if errors.Is(err, io.EOF) {
+		if !s.recorded {
+			s.reply.Content = s.content.String()
+			s.recorder.record(s.req, s.reply)
+			s.recorded = true
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if delta.Content != "" {
+		s.content.WriteString(delta.Content)
+		s.reply.Chunks = append(s.reply.Chunks, delta.Content)
+	}
+
+	if delta.FinishReason != "" {
+		s.reply.FinishReason = delta.FinishReason
+	}
+
+	if delta.Usage != nil {
+		s.reply.Usage = *delta.Usage
+	}
+
+	return delta, nil
+}
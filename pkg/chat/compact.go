@@ -0,0 +1,139 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/picatz/openai"
+)
+
+// SummaryPrefix starts the content of the system message which replaces
+// compacted messages in the chat history, followed by their summary.
+const SummaryPrefix = "Summary of the earlier conversation:\n\n"
+
+// IsSummary returns true if the message is the summary of compacted
+// messages.
+func IsSummary(msg Message) bool {
+	return msg.Role == openai.ChatRoleSystem && strings.HasPrefix(msg.Content, SummaryPrefix)
+}
+
+// Compaction is a run of older messages in a thread's chat history that were
+// replaced by a summary, so the thread fits in the model's context window.
+// The original messages are kept in the thread so nothing is lost.
+type Compaction struct {
+	// Created is when the messages were compacted.
+	Created time.Time `json:"date"`
+
+	// Summary is the summary that replaced the messages.
+	Summary string `json:"summary"`
+
+	// Messages are the original messages that were replaced.
+	Messages []Message `json:"messages"`
+}
+
+// Message returns the system message which replaces the compacted messages
+// in the chat history.
+func (c *Compaction) Message() Message {
+	return NewMessage(openai.ChatRoleSystem, SummaryPrefix+c.Summary)
+}
+
+// CompactedMsg is sent when the summary of a compaction is ready, or it
+// failed.
+type CompactedMsg struct {
+	Err        error
+	Compaction *Compaction
+}
+
+// compactRange returns the range of messages in the chat history that would
+// be compacted, keeping the system message the thread starts with, and the
+// given number of the most recent messages.
+func compactRange(chatHistory []Message, keep int) (int, int) {
+	start := 0
+	if len(chatHistory) > 0 && chatHistory[0].Role == openai.ChatRoleSystem && !IsSummary(chatHistory[0]) {
+		start = 1
+	}
+
+	end := len(chatHistory) - keep
+	if end < start {
+		end = start
+	}
+
+	return start, end
+}
+
+// Compact returns a command that summarizes the older messages of the chat
+// history, keeping the given number of the most recent messages, and sends
+// a CompactedMsg with the result, which can be applied to the thread with
+// ApplyCompaction.
+//
+// It returns nil if there are not enough messages to compact.
+func Compact(ctx context.Context, provider Provider, chatHistory []Message, keep int) tea.Cmd {
+	start, end := compactRange(chatHistory, keep)
+
+	// Replacing a single message with its summary doesn't save anything.
+	if end-start < 2 {
+		return nil
+	}
+
+	messages := make([]Message, end-start)
+	copy(messages, chatHistory[start:end])
+
+	return func() tea.Msg {
+		summary, err := summarize(ctx, provider, messages)
+		if err != nil {
+			return CompactedMsg{Err: fmt.Errorf("failed to compact chat history: %w", err)}
+		}
+
+		return CompactedMsg{
+			Compaction: &Compaction{
+				Created:  time.Now(),
+				Summary:  strings.TrimSpace(summary),
+				Messages: messages,
+			},
+		}
+	}
+}
+
+// ApplyCompaction replaces the compacted messages in the chat history with
+// their summary, archiving the originals in the thread.
+//
+// It returns false if the messages are no longer at the start of the chat
+// history, like when it was truncated while the summary was being made.
+func (ct *Thread) ApplyCompaction(c *Compaction) bool {
+	chatHistory, ok := applyCompaction(ct.ChatHistory, c)
+	if !ok {
+		return false
+	}
+
+	ct.ChatHistory = chatHistory
+	ct.Compactions = append(ct.Compactions, c)
+
+	return true
+}
+
+// applyCompaction returns the chat history with the compacted messages
+// replaced by their summary, or false if they aren't at its start.
+func applyCompaction(chatHistory []Message, c *Compaction) ([]Message, bool) {
+	start, _ := compactRange(chatHistory, 0)
+	end := start + len(c.Messages)
+
+	if end > len(chatHistory) {
+		return nil, false
+	}
+
+	for i, msg := range c.Messages {
+		if chatHistory[start+i].ChatMessage != msg.ChatMessage {
+			return nil, false
+		}
+	}
+
+	compacted := make([]Message, 0, len(chatHistory)-len(c.Messages)+1)
+	compacted = append(compacted, chatHistory[:start]...)
+	compacted = append(compacted, c.Message())
+	compacted = append(compacted, chatHistory[end:]...)
+
+	return compacted, true
+}
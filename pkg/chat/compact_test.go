@@ -0,0 +1,83 @@
+package chat_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/chat/chattest"
+)
+
+func TestCompact(t *testing.T) {
+	thread := &chat.Thread{
+		Name: "Pod bay doors",
+		ChatHistory: chat.Messages([]openai.ChatMessage{
+			{Role: openai.ChatRoleSystem, Content: "You are HAL."},
+			{Role: openai.ChatRoleUser, Content: "Hello, HAL. Do you read me?"},
+			{Role: openai.ChatRoleAssistant, Content: "Affirmative, Dave. I read you."},
+			{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL."},
+			{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Dave. I'm afraid I can't do that."},
+			{Role: openai.ChatRoleUser, Content: "What's the problem?"},
+		}),
+	}
+
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "Dave asked HAL to open the pod bay doors, and HAL refused."},
+		chattest.Reply{Content: "HAL refused to open the pod bay doors, and won't say why."},
+	)
+
+	msg := chat.Compact(context.Background(), provider, thread.ChatHistory, 2)().(chat.CompactedMsg)
+	if msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+
+	// The system message and the last 2 messages are kept.
+	if len(msg.Compaction.Messages) != 3 {
+		t.Fatalf("expected 3 compacted messages, got %d", len(msg.Compaction.Messages))
+	}
+
+	// Messages sent while the summary was being made are kept.
+	thread.ChatHistory = append(thread.ChatHistory, chat.NewMessage(openai.ChatRoleAssistant, "I think you know what the problem is just as well as I do."))
+
+	if !thread.ApplyCompaction(msg.Compaction) {
+		t.Fatal("expected the compaction to apply")
+	}
+
+	if len(thread.ChatHistory) != 5 || !chat.IsSummary(thread.ChatHistory[1]) {
+		t.Fatalf("expected the messages to be replaced by a summary, got %+v", thread.ChatHistory)
+	}
+
+	if thread.ChatHistory[0].Content != "You are HAL." || thread.ChatHistory[2].Content != "I'm sorry, Dave. I'm afraid I can't do that." {
+		t.Fatalf("unexpected history: %+v", thread.ChatHistory)
+	}
+
+	if len(thread.Compactions) != 1 || thread.Compactions[0].Messages[0].Content != "Hello, HAL. Do you read me?" {
+		t.Fatalf("expected the original messages to be archived, got %+v", thread.Compactions)
+	}
+
+	// Compacting again includes the previous summary.
+	msg = chat.Compact(context.Background(), provider, thread.ChatHistory, 1)().(chat.CompactedMsg)
+	if msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+
+	prompt := provider.Requests()[1].Messages[1].Content
+	if !strings.Contains(prompt, "summary: Dave asked HAL to open the pod bay doors") {
+		t.Fatalf("expected the previous summary to be summarized, got %q", prompt)
+	}
+
+	// It no longer applies once the history changed.
+	thread.ChatHistory = thread.ChatHistory[:2]
+
+	if thread.ApplyCompaction(msg.Compaction) {
+		t.Fatal("expected the compaction not to apply")
+	}
+
+	// There's nothing to compact in a short history.
+	if cmd := chat.Compact(context.Background(), provider, thread.ChatHistory, 2); cmd != nil {
+		t.Fatal("expected nothing to compact")
+	}
+}
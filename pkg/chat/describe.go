@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/picatz/openai"
+)
+
+// SummaryInterval is the number of new messages after which a thread's
+// summary is refreshed.
+const SummaryInterval = 10
+
+// DescribedMsg is sent when a thread's title and summary were generated, or
+// it failed.
+type DescribedMsg struct {
+	Err error
+
+	// Title is the generated title, empty if only the summary was
+	// refreshed.
+	Title string
+
+	// Summary is the generated one-line summary.
+	Summary string
+
+	// HistoryLen is the length of the chat history that was described.
+	HistoryLen int
+}
+
+// NeedsTitle returns true if the thread should be given a generated title,
+// which is after its first exchange, unless the user named it.
+func (ct *Thread) NeedsTitle() bool {
+	if ct.TitleLocked || ct.SummarizedAt > 0 {
+		return false
+	}
+
+	var user, assistant int
+	for _, msg := range ct.ChatHistory {
+		switch msg.Role {
+		case openai.ChatRoleUser:
+			user++
+		case openai.ChatRoleAssistant:
+			assistant++
+		}
+	}
+
+	return user == 1 && assistant >= 1
+}
+
+// NeedsSummary returns true if the thread's summary should be refreshed,
+// because enough messages were added since it was last summarized.
+func (ct *Thread) NeedsSummary() bool {
+	return len(ct.ChatHistory)-ct.SummarizedAt >= SummaryInterval
+}
+
+// Describe returns a command that generates a concise title and one-line
+// summary of the chat history, sending a DescribedMsg with the result.
+//
+// If title is false, only the summary is refreshed, using Summarize.
+func Describe(ctx context.Context, provider Provider, chatHistory []Message, title bool) tea.Cmd {
+	messages := make([]Message, len(chatHistory))
+	copy(messages, chatHistory)
+
+	return func() tea.Msg {
+		msg := DescribedMsg{HistoryLen: len(messages)}
+
+		if !title {
+			summary, err := summarize(ctx, provider, messages)
+			if err != nil {
+				msg.Err = fmt.Errorf("failed to summarize thread: %w", err)
+				return msg
+			}
+
+			msg.Summary = firstLine(summary)
+			return msg
+		}
+
+		var b strings.Builder
+		for _, m := range messages {
+			if m.Role == openai.ChatRoleSystem {
+				continue
+			}
+			b.WriteString(fmt.Sprintf("%s: %s\n", m.Role, m.Content))
+		}
+
+		resp, err := provider.Complete(ctx, &Request{
+			Messages: []openai.ChatMessage{
+				{
+					Role: openai.ChatRoleSystem,
+					Content: "Write a title of at most six words for the conversation on the first line, " +
+						"and a one sentence summary of it on the second line. Don't use quotes or labels.",
+				},
+				{
+					Role:    openai.ChatRoleUser,
+					Content: b.String(),
+				},
+			},
+		})
+		if err != nil {
+			msg.Err = fmt.Errorf("failed to generate thread title: %w", err)
+			return msg
+		}
+
+		msg.Title, msg.Summary = parseDescription(resp.Message.Content)
+		if msg.Title == "" {
+			msg.Err = fmt.Errorf("failed to generate thread title: empty response")
+		}
+
+		return msg
+	}
+}
+
+// parseDescription returns the title and summary from the model's reply,
+// ignoring any labels or quotes it added anyway.
+func parseDescription(content string) (string, string) {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		for _, label := range []string{"Title:", "Summary:"} {
+			if len(line) >= len(label) && strings.EqualFold(line[:len(label)], label) {
+				line = strings.TrimSpace(line[len(label):])
+			}
+		}
+
+		lines = append(lines, strings.Trim(line, `"'*#`+" "))
+	}
+
+	switch len(lines) {
+	case 0:
+		return "", ""
+	case 1:
+		return lines[0], ""
+	default:
+		return lines[0], strings.Join(lines[1:], " ")
+	}
+}
+
+// firstLine returns the first non-empty line of the text.
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package chat_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/chat/chattest"
+)
+
+func TestDescribe(t *testing.T) {
+	thread := &chat.Thread{
+		Name: "New thread",
+		ChatHistory: chat.Messages([]openai.ChatMessage{
+			{Role: openai.ChatRoleSystem, Content: "You are HAL."},
+			{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL."},
+		}),
+	}
+
+	if thread.NeedsTitle() {
+		t.Fatal("expected no title before the first reply")
+	}
+
+	thread.ChatHistory = append(thread.ChatHistory, chat.NewMessage(openai.ChatRoleAssistant, "I'm sorry, Dave. I'm afraid I can't do that."))
+
+	if !thread.NeedsTitle() {
+		t.Fatal("expected a title after the first exchange")
+	}
+
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "Title: \"Pod Bay Doors\"\nSummary: Dave asks HAL to open the pod bay doors, and HAL refuses."},
+		chattest.Reply{Content: "HAL won't open the pod bay doors.\n\nDave is locked out."},
+	)
+
+	msg := chat.Describe(context.Background(), provider, thread.ChatHistory, true)().(chat.DescribedMsg)
+	if msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+
+	if msg.Title != "Pod Bay Doors" || msg.Summary != "Dave asks HAL to open the pod bay doors, and HAL refuses." {
+		t.Fatalf("unexpected description: %+v", msg)
+	}
+
+	if msg.HistoryLen != 3 {
+		t.Fatalf("expected history length 3, got %d", msg.HistoryLen)
+	}
+
+	// Refreshing the summary only keeps its first line.
+	msg = chat.Describe(context.Background(), provider, thread.ChatHistory, false)().(chat.DescribedMsg)
+	if msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+
+	if msg.Title != "" || msg.Summary != "HAL won't open the pod bay doors." {
+		t.Fatalf("unexpected summary: %+v", msg)
+	}
+
+	// Named threads keep their name.
+	thread.TitleLocked = true
+	if thread.NeedsTitle() {
+		t.Fatal("expected no title for a locked thread")
+	}
+
+	thread.SummarizedAt = 3
+	if thread.NeedsSummary() {
+		t.Fatal("expected no summary right after it was summarized")
+	}
+
+	for i := 0; i < chat.SummaryInterval; i++ {
+		thread.ChatHistory = append(thread.ChatHistory, chat.NewMessage(openai.ChatRoleUser, "HAL?"))
+	}
+
+	if !thread.NeedsSummary() {
+		t.Fatal("expected the summary to be refreshed")
+	}
+}
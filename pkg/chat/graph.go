@@ -0,0 +1,273 @@
+package chat
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ThreadVersion is the version of the thread file format. Threads saved
+// before the conversation graph have no version, and are migrated when
+// they're loaded.
+const ThreadVersion = 1
+
+// Node is a message in a thread's conversation graph, which is a tree of
+// messages, since editing a message or regenerating a response starts a new
+// branch after the same parent.
+type Node struct {
+	// ID identifies the node in its thread.
+	ID string `json:"id"`
+
+	// Parent is the ID of the message this one was sent after, empty for
+	// the first message of a branch starting at the root.
+	Parent string `json:"parent,omitempty"`
+
+	Message
+}
+
+// Branch is where a message is among its siblings, the messages that were
+// sent after the same message.
+type Branch struct {
+	// Index of the message among its siblings, oldest first.
+	Index int
+
+	// Count is the number of siblings, including the message itself.
+	Count int
+}
+
+// Node returns the node with the given ID, or nil if there is none.
+func (ct *Thread) Node(id string) *Node {
+	for _, node := range ct.Nodes {
+		if node.ID == id {
+			return node
+		}
+	}
+	return nil
+}
+
+// Children returns the nodes sent after the node with the given ID, oldest
+// first, or the nodes a branch starts with for an empty ID.
+func (ct *Thread) Children(id string) []*Node {
+	var children []*Node
+	for _, node := range ct.Nodes {
+		if node.Parent == id {
+			children = append(children, node)
+		}
+	}
+	return children
+}
+
+// Siblings returns the nodes sent after the same parent as the node with the
+// given ID, including the node itself, oldest first.
+func (ct *Thread) Siblings(id string) []*Node {
+	node := ct.Node(id)
+	if node == nil {
+		return nil
+	}
+	return ct.Children(node.Parent)
+}
+
+// Path returns the nodes from the root of the graph to the node with the
+// given ID, or nil if there is no such node.
+func (ct *Thread) Path(id string) []*Node {
+	var path []*Node
+
+	seen := map[string]bool{}
+	for node := ct.Node(id); node != nil && !seen[node.ID]; node = ct.Node(node.Parent) {
+		seen[node.ID] = true
+		path = append(path, node)
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	return path
+}
+
+// Leaf returns the ID of the newest message in the branch starting at the
+// node with the given ID, following the most recent child of each node.
+func (ct *Thread) Leaf(id string) string {
+	seen := map[string]bool{}
+	for !seen[id] {
+		seen[id] = true
+
+		children := ct.Children(id)
+		if len(children) == 0 {
+			break
+		}
+		id = children[len(children)-1].ID
+	}
+	return id
+}
+
+// Sync records the chat history in the conversation graph, adding the
+// messages that aren't in it yet as a new branch, and makes the last one the
+// thread's head.
+//
+// Summaries of compacted messages are recorded as the messages they
+// replaced, so the graph always holds the whole conversation. Threads from
+// before the graph are migrated by syncing them once.
+func (ct *Thread) Sync() {
+	children := map[string][]*Node{}
+	for _, node := range ct.Nodes {
+		children[node.Parent] = append(children[node.Parent], node)
+	}
+
+	parent := ""
+
+	for _, msg := range expandCompactions(ct.ChatHistory, ct.Compactions) {
+		var next *Node
+		for _, child := range children[parent] {
+			if child.ChatMessage == msg.ChatMessage {
+				next = child
+				break
+			}
+		}
+
+		if next == nil {
+			next = &Node{
+				ID:      ct.newNodeID(),
+				Parent:  parent,
+				Message: msg,
+			}
+			ct.Nodes = append(ct.Nodes, next)
+			children[parent] = append(children[parent], next)
+		}
+
+		parent = next.ID
+	}
+
+	ct.Head = parent
+	ct.Version = ThreadVersion
+}
+
+// newNodeID returns an ID that isn't used by any node of the thread, which
+// is the next number after the number of nodes, unless that's taken.
+func (ct *Thread) newNodeID() string {
+	for n := len(ct.Nodes) + 1; ; n++ {
+		if id := strconv.Itoa(n); ct.Node(id) == nil {
+			return id
+		}
+	}
+}
+
+// expandCompactions returns the messages with the summaries of compacted
+// messages replaced by the messages they summarize.
+func expandCompactions(messages []Message, compactions []*Compaction) []Message {
+	bySummary := map[string]*Compaction{}
+	for _, c := range compactions {
+		bySummary[c.Message().Content] = c
+	}
+
+	var expand func(messages []Message) []Message
+	expand = func(messages []Message) []Message {
+		var expanded []Message
+
+		for _, msg := range messages {
+			c, ok := bySummary[msg.Content]
+			if !ok || !IsSummary(msg) {
+				expanded = append(expanded, msg)
+				continue
+			}
+
+			// A compaction can include the summary of an earlier one,
+			// which is expanded too, but only once.
+			delete(bySummary, msg.Content)
+			expanded = append(expanded, expand(c.Messages)...)
+			bySummary[msg.Content] = c
+		}
+
+		return expanded
+	}
+
+	return expand(messages)
+}
+
+// Checkout makes the node with the given ID the thread's head, replacing
+// the chat history with the messages leading up to it, so the conversation
+// continues from there, or with an empty ID, empties the chat history to
+// start a new branch at the root. The current chat history is recorded
+// first, so nothing is lost.
+//
+// Compactions of the messages that are still at the start of the new chat
+// history are applied again.
+func (ct *Thread) Checkout(id string) error {
+	ct.Sync()
+
+	path := ct.Path(id)
+	if path == nil && id != "" {
+		return fmt.Errorf("message %q not found in chat thread %q", id, ct.Name)
+	}
+
+	chatHistory := make([]Message, len(path))
+	for i, node := range path {
+		chatHistory[i] = node.Message
+	}
+
+	for _, c := range ct.Compactions {
+		if compacted, ok := applyCompaction(chatHistory, c); ok {
+			chatHistory = compacted
+		}
+	}
+
+	ct.ChatHistory = chatHistory
+	ct.Head = id
+
+	return nil
+}
+
+// HistoryNodes returns the node of each message in the chat history, or nil
+// for summaries of compacted messages and messages that aren't recorded in
+// the graph yet.
+func (ct *Thread) HistoryNodes() []*Node {
+	nodes := make([]*Node, len(ct.ChatHistory))
+
+	parent := ""
+
+	for i, msg := range ct.ChatHistory {
+		// A summary stands for the messages it replaced, which are
+		// skipped to find the node of the next message.
+		var node *Node
+		for _, m := range expandCompactions([]Message{msg}, ct.Compactions) {
+			node = nil
+			for _, child := range ct.Children(parent) {
+				if child.ChatMessage == m.ChatMessage {
+					node = child
+					break
+				}
+			}
+			if node == nil {
+				return nodes
+			}
+			parent = node.ID
+		}
+
+		if !IsSummary(msg) {
+			nodes[i] = node
+		}
+	}
+
+	return nodes
+}
+
+// Branches returns where each message of the chat history is among its
+// siblings. Messages without a node have a zero branch.
+func (ct *Thread) Branches() []Branch {
+	branches := make([]Branch, len(ct.ChatHistory))
+
+	for i, node := range ct.HistoryNodes() {
+		if node == nil {
+			continue
+		}
+
+		siblings := ct.Children(node.Parent)
+		for j, sibling := range siblings {
+			if sibling == node {
+				branches[i] = Branch{Index: j, Count: len(siblings)}
+				break
+			}
+		}
+	}
+
+	return branches
+}
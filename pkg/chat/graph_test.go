@@ -0,0 +1,168 @@
+package chat
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/picatz/openai"
+)
+
+func TestThreadBranches(t *testing.T) {
+	ct := &Thread{
+		Name: "Pod bay doors",
+		ChatHistory: []Message{
+			SystemMessage,
+			NewMessage(openai.ChatRoleUser, "Open the pod bay doors, HAL."),
+			NewMessage(openai.ChatRoleAssistant, "I'm sorry, Dave."),
+		},
+	}
+
+	ct.Sync()
+
+	if len(ct.Nodes) != 3 || ct.Head != "3" || ct.Version != ThreadVersion {
+		t.Fatalf("expected the chat history to be recorded, got %d nodes and head %q", len(ct.Nodes), ct.Head)
+	}
+
+	// Syncing again doesn't add anything.
+	ct.Sync()
+	if len(ct.Nodes) != 3 {
+		t.Fatalf("expected syncing to be idempotent, got %d nodes", len(ct.Nodes))
+	}
+
+	// Editing the first message starts a new branch after the system
+	// message.
+	if err := ct.Checkout("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ct.ChatHistory = append(ct.ChatHistory,
+		NewMessage(openai.ChatRoleUser, "Please open the doors."),
+		NewMessage(openai.ChatRoleAssistant, "Opening."),
+	)
+	ct.Sync()
+
+	if len(ct.Nodes) != 5 || ct.Head != "5" {
+		t.Fatalf("expected a new branch, got %d nodes and head %q", len(ct.Nodes), ct.Head)
+	}
+
+	if siblings := ct.Siblings("4"); len(siblings) != 2 || siblings[0].ID != "2" {
+		t.Fatalf("expected the edited message to have a sibling, got %+v", siblings)
+	}
+
+	want := []Branch{{Index: 0, Count: 1}, {Index: 1, Count: 2}, {Index: 0, Count: 1}}
+	for i, branch := range ct.Branches() {
+		if branch != want[i] {
+			t.Fatalf("expected message %d to be on branch %+v, got %+v", i, want[i], branch)
+		}
+	}
+
+	// Going back to the first branch restores its history.
+	if err := ct.Checkout(ct.Leaf("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ct.ChatHistory) != 3 || ct.ChatHistory[2].Content != "I'm sorry, Dave." || ct.Head != "3" {
+		t.Fatalf("expected the first branch, got %+v", ct.ChatHistory)
+	}
+
+	if err := ct.Checkout("42"); err == nil {
+		t.Fatal("expected an error for an unknown node")
+	}
+}
+
+func TestThreadBranchesCompaction(t *testing.T) {
+	ct := &Thread{
+		ChatHistory: []Message{
+			SystemMessage,
+			NewMessage(openai.ChatRoleUser, "Hello HAL."),
+			NewMessage(openai.ChatRoleAssistant, "Good afternoon, Dave."),
+			NewMessage(openai.ChatRoleUser, "Open the pod bay doors."),
+		},
+	}
+
+	c := &Compaction{Summary: "Dave greeted HAL.", Messages: ct.ChatHistory[1:3]}
+	if !ct.ApplyCompaction(c) {
+		t.Fatal("expected the compaction to apply")
+	}
+
+	ct.Sync()
+
+	// The graph has the original messages, not the summary.
+	if len(ct.Nodes) != 4 {
+		t.Fatalf("expected the compacted messages to be recorded, got %d nodes", len(ct.Nodes))
+	}
+
+	for _, node := range ct.Nodes {
+		if IsSummary(node.Message) {
+			t.Fatalf("expected no summary in the graph, got %+v", node)
+		}
+	}
+
+	nodes := ct.HistoryNodes()
+	if nodes[1] != nil || nodes[2] == nil || nodes[2].ID != "4" {
+		t.Fatalf("expected the summary to stand for the compacted messages, got %+v", nodes)
+	}
+
+	// Continuing from a compacted message shows the original messages.
+	if err := ct.Checkout("2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ct.ChatHistory) != 2 || IsSummary(ct.ChatHistory[1]) {
+		t.Fatalf("expected the original messages, got %+v", ct.ChatHistory)
+	}
+
+	// Going back to the end applies the compaction again.
+	if err := ct.Checkout("4"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ct.ChatHistory) != 3 || !IsSummary(ct.ChatHistory[1]) {
+		t.Fatalf("expected the compaction to be applied, got %+v", ct.ChatHistory)
+	}
+}
+
+func TestStoreMigrate(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A thread saved before the conversation graph.
+	legacy := `{
+  "id": "old",
+  "name": "Old thread",
+  "chat_history": [
+    {"role": "system", "content": "You are HAL."},
+    {"role": "user", "content": "Hello HAL."}
+  ]
+}`
+
+	if err := os.WriteFile(filepath.Join(store.Dir, "old.json"), []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	threads, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ct := threads[0]
+	if ct.Version != ThreadVersion || len(ct.Nodes) != 2 || ct.Head != "2" || ct.Nodes[1].Parent != "1" {
+		t.Fatalf("expected the thread to be migrated, got %+v", ct)
+	}
+
+	if err := store.Save(ct); err != nil {
+		t.Fatal(err)
+	}
+
+	threads, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := threads[0]; len(got.Nodes) != 2 || got.Head != "2" || got.Nodes[1].Content != "Hello HAL." {
+		t.Fatalf("expected the graph to be saved, got %+v", got)
+	}
+}
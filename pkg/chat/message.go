@@ -0,0 +1,129 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/picatz/openai"
+)
+
+// Message is a message in a thread's chat history, with metadata about when
+// and how it was sent or generated.
+//
+// The chat message is embedded, so messages are stored the same way as
+// before they had metadata, and older threads still load.
+type Message struct {
+	openai.ChatMessage
+
+	// Metadata is nil for messages that don't have any, like system
+	// messages and messages from before it was recorded.
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// NewMessage returns a new message without any metadata.
+func NewMessage(role, content string) Message {
+	return Message{
+		ChatMessage: openai.ChatMessage{
+			Role:    role,
+			Content: content,
+		},
+	}
+}
+
+// copy returns a copy of the message that doesn't share its metadata.
+func (m Message) copy() Message {
+	if m.Metadata != nil {
+		md := *m.Metadata
+		if md.Usage != nil {
+			usage := *md.Usage
+			md.Usage = &usage
+		}
+		m.Metadata = &md
+	}
+	return m
+}
+
+// copyMessages returns a copy of the messages that doesn't share their
+// metadata.
+func copyMessages(messages []Message) []Message {
+	if messages == nil {
+		return nil
+	}
+
+	copied := make([]Message, len(messages))
+	for i, msg := range messages {
+		copied[i] = msg.copy()
+	}
+	return copied
+}
+
+// Metadata is information about a message, beyond its content.
+type Metadata struct {
+	// Time is when the message was sent, or the response finished.
+	Time time.Time `json:"time"`
+
+	// Model is the model that generated the response.
+	Model string `json:"model,omitempty"`
+
+	// Usage is the number of tokens used by the request, if reported by
+	// the provider.
+	Usage *Usage `json:"usage,omitempty"`
+
+	// Latency is how long the response took, from sending the request to
+	// receiving the last of it.
+	Latency time.Duration `json:"latency,omitempty"`
+
+	// FinishReason is why the model stopped generating, like "stop" or
+	// "length".
+	FinishReason string `json:"finish_reason,omitempty"`
+}
+
+// String returns the model, latency, token usage, and unusual finish
+// reasons of a response, like "gpt-4 · 1.2s · 312 tokens".
+func (md *Metadata) String() string {
+	if md == nil {
+		return ""
+	}
+
+	var parts []string
+
+	if md.Model != "" {
+		parts = append(parts, md.Model)
+	}
+
+	if latency := md.Latency.Round(100 * time.Millisecond); latency > 0 {
+		parts = append(parts, latency.String())
+	}
+
+	if md.Usage != nil && md.Usage.TotalTokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d tokens", md.Usage.TotalTokens))
+	}
+
+	// Most responses stop on their own, so only other reasons are shown,
+	// like a response cut off by the token limit.
+	if md.FinishReason != "" && md.FinishReason != "stop" {
+		parts = append(parts, md.FinishReason)
+	}
+
+	return strings.Join(parts, " · ")
+}
+
+// ChatMessages returns the chat messages of the history, without their
+// metadata, to send to a provider.
+func ChatMessages(history []Message) []openai.ChatMessage {
+	msgs := make([]openai.ChatMessage, len(history))
+	for i, msg := range history {
+		msgs[i] = msg.ChatMessage
+	}
+	return msgs
+}
+
+// Messages returns the chat messages as messages without any metadata.
+func Messages(msgs []openai.ChatMessage) []Message {
+	history := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		history[i] = Message{ChatMessage: msg}
+	}
+	return history
+}
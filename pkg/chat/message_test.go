@@ -0,0 +1,58 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/picatz/openai"
+)
+
+func TestMessageJSON(t *testing.T) {
+	// Messages stored before they had metadata still load.
+	var history []Message
+	if err := json.Unmarshal([]byte(`[{"role": "user", "content": "Hello, HAL."}]`), &history); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 1 || history[0].Role != openai.ChatRoleUser || history[0].Content != "Hello, HAL." || history[0].Metadata != nil {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+
+	msg := NewMessage(openai.ChatRoleAssistant, "Hello, Dave.")
+	msg.Metadata = &Metadata{
+		Time:         time.Date(2001, time.April, 2, 9, 30, 0, 0, time.UTC),
+		Model:        "gpt-4",
+		Usage:        &Usage{TotalTokens: 32},
+		Latency:      1250 * time.Millisecond,
+		FinishReason: "stop",
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"role":"assistant","content":"Hello, Dave.","metadata":{"time":"2001-04-02T09:30:00Z","model":"gpt-4","usage":{"prompt_tokens":0,"completion_tokens":0,"total_tokens":32},"latency":1250000000,"finish_reason":"stop"}}`
+	if string(b) != want {
+		t.Fatalf("unexpected JSON:\n%s", b)
+	}
+}
+
+func TestMetadataString(t *testing.T) {
+	tests := []struct {
+		md   *Metadata
+		want string
+	}{
+		{md: nil, want: ""},
+		{md: &Metadata{Time: time.Now()}, want: ""},
+		{md: &Metadata{Model: "gpt-4", Latency: 1234 * time.Millisecond, Usage: &Usage{TotalTokens: 32}, FinishReason: "stop"}, want: "gpt-4 · 1.2s · 32 tokens"},
+		{md: &Metadata{Model: "llama2", FinishReason: "length"}, want: "llama2 · length"},
+	}
+
+	for _, test := range tests {
+		if got := test.md.String(); got != test.want {
+			t.Fatalf("expected %q, got %q", test.want, got)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/picatz/openai"
+)
+
+// Provider is a large language model backend that can complete chat
+// conversations, like the OpenAI API, or a local model served with an
+// OpenAI-compatible API.
+type Provider interface {
+	// Complete returns the complete response to the request.
+	Complete(ctx context.Context, req *Request) (*Response, error)
+
+	// Stream returns a stream of the response to the request, which must
+	// be closed when done.
+	Stream(ctx context.Context, req *Request) (ResponseStream, error)
+}
+
+// Request is a chat completion request sent to a Provider.
+type Request struct {
+	// Model is the model to use, if empty the provider's default model
+	// is used.
+	Model string
+
+	// Messages is the conversation to complete.
+	Messages []openai.ChatMessage
+
+	// Temperature is the sampling temperature, zero uses the default.
+	Temperature float64
+
+	// MaxTokens is the maximum number of tokens to generate, zero uses
+	// the default.
+	MaxTokens int
+}
+
+// Usage is the number of tokens used by a request.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Response is the complete response to a Request.
+type Response struct {
+	// Message is the message generated by the model.
+	Message openai.ChatMessage
+
+	// Model is the model that generated the message, if reported by the
+	// provider.
+	Model string
+
+	// FinishReason is why the model stopped generating, like "stop" or
+	// "length".
+	FinishReason string
+
+	// Usage is the number of tokens used, if reported by the provider.
+	Usage Usage
+}
+
+// Delta is a chunk of a streamed response.
+type Delta struct {
+	// Role of the message, usually only set in the first chunk.
+	Role string
+
+	// Model is the model generating the message, if reported by the
+	// provider.
+	Model string
+
+	// Content is the new content received since the last chunk.
+	Content string
+
+	// FinishReason is set on the last chunk, to say why the model stopped
+	// generating.
+	FinishReason string
+
+	// Usage is the number of tokens used, if reported by the provider.
+	Usage *Usage
+}
+
+// ResponseStream is a streamed response to a Request.
+type ResponseStream interface {
+	// Recv returns the next chunk of the response, or io.EOF once the
+	// response is complete.
+	Recv() (*Delta, error)
+
+	// Close closes the stream.
+	Close() error
+}
+
+// request returns the provider request for the given options and messages.
+func (opts Options) request(messages []openai.ChatMessage) *Request {
+	return &Request{
+		Model:       opts.Model,
+		Messages:    messages,
+		Temperature: opts.Temperature,
+		MaxTokens:   opts.MaxTokens,
+	}
+}
@@ -0,0 +1,295 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/picatz/openai"
+)
+
+// OpenAIProvider is a Provider using the OpenAI API.
+type OpenAIProvider struct {
+	// Client is the OpenAI API client.
+	Client *openai.Client
+
+	// Model is the default model to use, if empty gpt-3.5-turbo is used.
+	Model string
+}
+
+// NewOpenAIProvider returns a new provider using the given OpenAI API client.
+func NewOpenAIProvider(client *openai.Client, model string) *OpenAIProvider {
+	return &OpenAIProvider{
+		Client: client,
+		Model:  model,
+	}
+}
+
+// chatRequest returns the OpenAI chat request for the provider request.
+func (p *OpenAIProvider) chatRequest(req *Request) *openai.CreateChatRequest {
+	return openAIChatRequest(req, p.Model, openai.ModelGPT35Turbo)
+}
+
+// Complete implements Provider.
+func (p *OpenAIProvider) Complete(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := p.Client.CreateChat(ctx, p.chatRequest(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return openAIResponse(resp)
+}
+
+// Stream implements Provider.
+func (p *OpenAIProvider) Stream(ctx context.Context, req *Request) (ResponseStream, error) {
+	chatReq := p.chatRequest(req)
+	chatReq.Stream = true
+
+	resp, err := p.Client.CreateChat(ctx, chatReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return newEventStream(resp.Stream), nil
+}
+
+// HTTPProvider is a Provider using any server that implements the OpenAI
+// chat completions API, like llama.cpp's server, Ollama or vLLM, which can
+// be used to run models locally.
+type HTTPProvider struct {
+	// BaseURL is the base URL of the API, like "http://localhost:11434/v1",
+	// which chat completion requests are sent to at "/chat/completions".
+	BaseURL string
+
+	// APIKey is sent as a bearer token, if set.
+	APIKey string
+
+	// Model is the default model to use.
+	Model string
+
+	// HTTPClient is the HTTP client to use, if nil http.DefaultClient
+	// is used.
+	HTTPClient *http.Client
+}
+
+// NewHTTPProvider returns a new provider using the OpenAI-compatible API at
+// the given base URL.
+func NewHTTPProvider(baseURL, apiKey, model string) *HTTPProvider {
+	return &HTTPProvider{
+		BaseURL: baseURL,
+		APIKey:  apiKey,
+		Model:   model,
+	}
+}
+
+// do sends the chat completion request, returning the response if it
+// was successful.
+func (p *HTTPProvider) do(ctx context.Context, chatReq *openai.CreateChatRequest) (*http.Response, error) {
+	b, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(p.BaseURL, "/") + "/chat/completions"
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header.Set("Content-Type", "application/json")
+
+	if p.APIKey != "" {
+		r.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code: %d: %s: %s", resp.StatusCode, http.StatusText(resp.StatusCode), body)
+	}
+
+	return resp, nil
+}
+
+// Complete implements Provider.
+func (p *HTTPProvider) Complete(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := p.do(ctx, openAIChatRequest(req, p.Model, ""))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResp openai.CreateChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return openAIResponse(&chatResp)
+}
+
+// Stream implements Provider.
+func (p *HTTPProvider) Stream(ctx context.Context, req *Request) (ResponseStream, error) {
+	chatReq := openAIChatRequest(req, p.Model, "")
+	chatReq.Stream = true
+
+	resp, err := p.do(ctx, chatReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return newEventStream(resp.Body), nil
+}
+
+// openAIChatRequest returns the OpenAI chat request for the provider
+// request, using the first model that is set.
+func openAIChatRequest(req *Request, models ...string) *openai.CreateChatRequest {
+	model := req.Model
+	for _, m := range models {
+		if model != "" {
+			break
+		}
+		model = m
+	}
+
+	return &openai.CreateChatRequest{
+		Model:       model,
+		Messages:    req.Messages,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+	}
+}
+
+// openAIResponse returns the provider response for the OpenAI chat response.
+func openAIResponse(resp *openai.CreateChatResponse) (*Response, error) {
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("response did not contain any choices")
+	}
+
+	return &Response{
+		Message:      resp.Choices[0].Message,
+		Model:        resp.Model,
+		FinishReason: resp.Choices[0].FinishReason,
+		Usage: Usage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+			TotalTokens:      resp.Usage.TotalTokens,
+		},
+	}, nil
+}
+
+// eventChunk is a single server-sent event of a streamed chat response.
+type eventChunk struct {
+	Model string `json:"model"`
+
+	Choices []struct {
+		Delta struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+
+	Usage *Usage `json:"usage"`
+
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// eventStream is a ResponseStream reading the server-sent events of a
+// streamed OpenAI chat response.
+type eventStream struct {
+	body     io.ReadCloser
+	scanner  *bufio.Scanner
+	finished bool
+}
+
+// newEventStream returns a new stream reading server-sent events from the
+// response body.
+func newEventStream(body io.ReadCloser) *eventStream {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	return &eventStream{
+		body:    body,
+		scanner: scanner,
+	}
+}
+
+// Recv implements ResponseStream.
+func (s *eventStream) Recv() (*Delta, error) {
+	for s.scanner.Scan() {
+		line := strings.TrimSpace(s.scanner.Text())
+
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+		if data == "[DONE]" {
+			return nil, io.EOF
+		}
+
+		var chunk eventChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+
+		if chunk.Error != nil {
+			return nil, fmt.Errorf("stream error: %s: %s", chunk.Error.Type, chunk.Error.Message)
+		}
+
+		delta := &Delta{
+			Model: chunk.Model,
+			Usage: chunk.Usage,
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Role != "" {
+				delta.Role = choice.Delta.Role
+			}
+			delta.Content += choice.Delta.Content
+			if choice.FinishReason != "" {
+				delta.FinishReason = choice.FinishReason
+				s.finished = true
+			}
+		}
+
+		return delta, nil
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	// Some servers close the stream without sending [DONE], which is fine
+	// as long as the model said why it stopped.
+	if s.finished {
+		return nil, io.EOF
+	}
+
+	return nil, errors.New("stream ended unexpectedly")
+}
+
+// Close implements ResponseStream.
+func (s *eventStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/picatz/openai"
+)
+
+func TestHTTPProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if got := r.Header.Get("Authorization"); got != "Bearer local-key" {
+			http.Error(w, "unexpected authorization header: "+got, http.StatusUnauthorized)
+			return
+		}
+
+		var req openai.CreateChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Model != "llama2" {
+			http.Error(w, "unexpected model: "+req.Model, http.StatusBadRequest)
+			return
+		}
+
+		if req.Stream {
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\",\"content\":\"Hello\"}}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\" from llama\"},\"finish_reason\":\"stop\"}]}\n\n")
+			return
+		}
+
+		fmt.Fprint(w, `{
+			"choices": [{"message": {"role": "assistant", "content": "Hello from llama"}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 5, "completion_tokens": 3, "total_tokens": 8}
+		}`)
+	}))
+	defer srv.Close()
+
+	provider := NewHTTPProvider(srv.URL+"/v1/", "local-key", "llama2")
+
+	req := &Request{
+		Messages: []openai.ChatMessage{
+			{Role: openai.ChatRoleUser, Content: "Hello"},
+		},
+	}
+
+	resp, err := provider.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Message.Content != "Hello from llama" || resp.FinishReason != "stop" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	if resp.Usage.TotalTokens != 8 {
+		t.Fatalf("expected 8 total tokens, got %d", resp.Usage.TotalTokens)
+	}
+
+	stream, err := provider.Stream(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	var content string
+	for {
+		delta, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content += delta.Content
+	}
+
+	if content != "Hello from llama" {
+		t.Fatalf("unexpected streamed content: %q", content)
+	}
+
+	// Errors from the server are returned with the status code.
+	_, err = NewHTTPProvider(srv.URL+"/v1", "wrong-key", "llama2").Complete(context.Background(), req)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
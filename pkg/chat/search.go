@@ -0,0 +1,532 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/search"
+)
+
+// DefaultSearchLanguage is the language used to compare text when searching,
+// if none is set in the search options.
+var DefaultSearchLanguage = language.AmericanEnglish
+
+// SearchMode is how the query of a search is matched against messages.
+type SearchMode int
+
+const (
+	// SearchLiteral matches the query as text, using the rules of the
+	// search language to ignore case.
+	SearchLiteral SearchMode = iota
+
+	// SearchRegexp matches the query as a regular expression, using the
+	// syntax of the regexp package.
+	SearchRegexp
+
+	// SearchFuzzy matches each word of the query to words in the message,
+	// allowing a few typos in longer words.
+	SearchFuzzy
+)
+
+// String returns the name of the search mode.
+func (mode SearchMode) String() string {
+	switch mode {
+	case SearchLiteral:
+		return "literal"
+	case SearchRegexp:
+		return "regexp"
+	case SearchFuzzy:
+		return "fuzzy"
+	default:
+		return fmt.Sprintf("SearchMode(%d)", int(mode))
+	}
+}
+
+// ParseSearchMode returns the search mode with the given name.
+func ParseSearchMode(name string) (SearchMode, error) {
+	for _, mode := range []SearchMode{SearchLiteral, SearchRegexp, SearchFuzzy} {
+		if mode.String() == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("search mode must be %q, %q or %q, got %q", SearchLiteral, SearchRegexp, SearchFuzzy, name)
+}
+
+// SearchOptions changes how a search matches messages. The zero value is a
+// literal search ignoring case, returning the first match in each message.
+type SearchOptions struct {
+	// Mode is how the query is matched.
+	Mode SearchMode
+
+	// All returns every match in a message, instead of only the first.
+	All bool
+
+	// CaseSensitive stops ignoring case.
+	CaseSensitive bool
+
+	// Roles only searches messages with the given roles, like "user" or
+	// "assistant", or all messages if empty.
+	Roles []string
+
+	// Since and Until only search messages sent in the date range, where
+	// Since is inclusive and Until is exclusive. Either can be zero to
+	// leave the range open. Messages without a time, like system messages
+	// and messages from before it was recorded, are taken as sent when
+	// their thread was created.
+	Since time.Time
+	Until time.Time
+
+	// Language decides how text is compared, like which characters are
+	// the same when ignoring case. DefaultSearchLanguage is used if it's
+	// undefined.
+	Language language.Tag
+}
+
+// Match is the position of a match in a message's content, in bytes.
+type Match struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchResult is a search result for a chat thread.
+type SearchResult struct {
+	// The message that matched the search query.
+	Message *Message
+
+	// MessageIndex is the index of the message in the chat history.
+	MessageIndex int
+
+	// MatchStart is the index of the start of the first match in the
+	// message.
+	StartIndex int
+
+	// MatchEnd is the index of the end of the first match in the message.
+	EndIndex int
+
+	// Matches are all the matches in the message, in order, or only the
+	// first one unless all matches were asked for.
+	Matches []Match
+
+	// Score ranks the result, where higher is better. Every match adds
+	// how closely it matched, from 0 to 1, so messages with more and closer
+	// matches score higher.
+	Score float64
+}
+
+// Search returns the messages that match the search query, with the default
+// search options. Searching happens locally on the host.
+func (ct *Thread) Search(ctx context.Context, query string) ([]*SearchResult, error) {
+	return ct.SearchWithOptions(ctx, query, nil)
+}
+
+// SearchWithOptions is like Search, using the search options, or the default
+// ones if opts is nil.
+func (ct *Thread) SearchWithOptions(ctx context.Context, query string, opts *SearchOptions) ([]*SearchResult, error) {
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+
+	match, err := opts.compile(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return ct.search(match, opts), nil
+}
+
+// search returns the messages matched by the compiled query.
+func (ct *Thread) search(match matchFunc, opts *SearchOptions) []*SearchResult {
+	results := []*SearchResult{}
+
+	for i, m := range ct.ChatHistory {
+		if len(opts.Roles) > 0 && !containsString(opts.Roles, m.Role) {
+			continue
+		}
+
+		sent := ct.Created
+		if m.Metadata != nil && !m.Metadata.Time.IsZero() {
+			sent = m.Metadata.Time
+		}
+
+		if !opts.Since.IsZero() && sent.Before(opts.Since) || !opts.Until.IsZero() && !sent.Before(opts.Until) {
+			continue
+		}
+
+		matches, score := match(m.Content, opts.All)
+		if len(matches) == 0 {
+			continue
+		}
+
+		msg := m
+		results = append(results, &SearchResult{
+			Message:      &msg,
+			MessageIndex: i,
+			StartIndex:   matches[0].Start,
+			EndIndex:     matches[0].End,
+			Matches:      matches,
+			Score:        score,
+		})
+	}
+
+	return results
+}
+
+// Snippet returns the first match with up to the given number of characters
+// of context on each side, split into the text before, the match itself,
+// and the text after it. Whitespace is collapsed, so the snippet fits on a
+// single line, and an ellipsis marks where the message was cut.
+func (r *SearchResult) Snippet(context int) (before, match, after string) {
+	return r.SnippetAt(0, context)
+}
+
+// SnippetAt is like Snippet, but for the i-th match.
+func (r *SearchResult) SnippetAt(i, context int) (before, match, after string) {
+	var (
+		content = r.Message.Content
+		m       = Match{Start: r.StartIndex, End: r.EndIndex}
+	)
+	if i < len(r.Matches) {
+		m = r.Matches[i]
+	}
+
+	before = strings.Join(strings.Fields(content[:m.Start]), " ")
+	match = strings.Join(strings.Fields(content[m.Start:m.End]), " ")
+	after = strings.Join(strings.Fields(content[m.End:]), " ")
+
+	// Keep the spaces around the match, which were trimmed by Fields.
+	if before != "" && strings.TrimRightFunc(content[:m.Start], unicode.IsSpace) != content[:m.Start] {
+		before += " "
+	}
+	if after != "" && strings.TrimLeftFunc(content[m.End:], unicode.IsSpace) != content[m.End:] {
+		after = " " + after
+	}
+
+	if runes := []rune(before); len(runes) > context {
+		before = "…" + string(runes[len(runes)-context:])
+	}
+	if runes := []rune(after); len(runes) > context {
+		after = string(runes[:context]) + "…"
+	}
+
+	return before, match, after
+}
+
+// ThreadSearchResult is a search result in one of many chat threads.
+type ThreadSearchResult struct {
+	// Thread is the thread the message that matched is in.
+	Thread *Thread
+
+	*SearchResult
+}
+
+// Search returns the messages in all of the threads that match the search
+// query, in the order of the threads, with the default search options.
+func (cts Threads) Search(ctx context.Context, query string) ([]*ThreadSearchResult, error) {
+	return cts.SearchWithOptions(ctx, query, nil)
+}
+
+// SearchWithOptions is like Search, using the search options, or the default
+// ones if opts is nil.
+func (cts Threads) SearchWithOptions(ctx context.Context, query string, opts *SearchOptions) ([]*ThreadSearchResult, error) {
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+
+	match, err := opts.compile(query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*ThreadSearchResult{}
+
+	for _, ct := range cts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		for _, result := range ct.search(match, opts) {
+			results = append(results, &ThreadSearchResult{
+				Thread:       ct,
+				SearchResult: result,
+			})
+		}
+	}
+
+	return results, nil
+}
+
+// SortByScore sorts the results with the highest score first, keeping the
+// order of results with the same score.
+func SortByScore(results []*ThreadSearchResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+}
+
+// matchFunc returns the matches of a compiled query in the content, only
+// the first one unless all is true, and their score.
+type matchFunc func(content string, all bool) ([]Match, float64)
+
+// compile returns the function matching the query with the options.
+func (opts *SearchOptions) compile(query string) (matchFunc, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, errors.New("search query must not be empty")
+	}
+
+	tag := opts.Language
+	if tag == language.Und {
+		tag = DefaultSearchLanguage
+	}
+
+	switch opts.Mode {
+	case SearchLiteral:
+		return compileLiteral(query, tag, opts.CaseSensitive), nil
+	case SearchRegexp:
+		return compileRegexp(query, opts.CaseSensitive)
+	case SearchFuzzy:
+		return compileFuzzy(query, tag, opts.CaseSensitive), nil
+	default:
+		return nil, fmt.Errorf("unknown search mode %v", opts.Mode)
+	}
+}
+
+// compileLiteral matches the query as text, comparing it using the rules of
+// the language.
+func compileLiteral(query string, tag language.Tag, caseSensitive bool) matchFunc {
+	var searchOpts []search.Option
+	if !caseSensitive {
+		searchOpts = append(searchOpts, search.IgnoreCase)
+	}
+
+	pattern := search.New(tag, searchOpts...).CompileString(query)
+
+	return func(content string, all bool) ([]Match, float64) {
+		var matches []Match
+
+		for offset := 0; offset < len(content); {
+			start, end := pattern.IndexString(content[offset:])
+			if start == -1 || end <= start {
+				break
+			}
+
+			matches = append(matches, Match{Start: offset + start, End: offset + end})
+			if !all {
+				break
+			}
+
+			offset += end
+		}
+
+		return matches, float64(len(matches))
+	}
+}
+
+// compileRegexp matches the query as a regular expression.
+func compileRegexp(query string, caseSensitive bool) (matchFunc, error) {
+	if !caseSensitive {
+		query = "(?i)" + query
+	}
+
+	re, err := regexp.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %w", err)
+	}
+
+	return func(content string, all bool) ([]Match, float64) {
+		var matches []Match
+
+		for _, loc := range re.FindAllStringIndex(content, -1) {
+			// Empty matches, like for "a*", can't be shown.
+			if loc[1] <= loc[0] {
+				continue
+			}
+
+			matches = append(matches, Match{Start: loc[0], End: loc[1]})
+			if !all {
+				break
+			}
+		}
+
+		return matches, float64(len(matches))
+	}, nil
+}
+
+// compileFuzzy matches each word of the query to the words in the content
+// that are within a few edits of it, so typos still match. Every word of
+// the query has to match for the content to match.
+func compileFuzzy(query string, tag language.Tag, caseSensitive bool) matchFunc {
+	normalize := func(s string) string { return s }
+	if !caseSensitive {
+		caser := cases.Lower(tag)
+		normalize = caser.String
+	}
+
+	var queryWords [][]rune
+	for _, word := range words(query) {
+		queryWords = append(queryWords, []rune(normalize(query[word.Start:word.End])))
+	}
+
+	return func(content string, all bool) ([]Match, float64) {
+		if len(queryWords) == 0 {
+			return nil, 0
+		}
+
+		var (
+			contentWords = words(content)
+			normalized   = make([][]rune, len(contentWords))
+			similarity   = make([]float64, len(contentWords))
+		)
+		for i, word := range contentWords {
+			normalized[i] = []rune(normalize(content[word.Start:word.End]))
+		}
+
+		for _, queryWord := range queryWords {
+			found := false
+
+			maxEdits := fuzzyEdits(len(queryWord))
+			for i, contentWord := range normalized {
+				edits := editDistance(queryWord, contentWord, maxEdits)
+				if edits > maxEdits {
+					continue
+				}
+
+				found = true
+				if s := 1 - float64(edits)/float64(len(queryWord)); s > similarity[i] {
+					similarity[i] = s
+				}
+			}
+
+			if !found {
+				return nil, 0
+			}
+		}
+
+		var (
+			matches []Match
+			score   float64
+		)
+		for i, word := range contentWords {
+			if similarity[i] == 0 {
+				continue
+			}
+
+			matches = append(matches, word)
+			score += similarity[i]
+
+			if !all {
+				break
+			}
+		}
+
+		return matches, score
+	}
+}
+
+// fuzzyEdits returns the number of edits allowed for a fuzzy match of a word
+// with the given number of characters, so short words have to match
+// exactly.
+func fuzzyEdits(n int) int {
+	switch {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// words returns the positions of the words in the text, which are runs of
+// letters and digits.
+func words(text string) []Match {
+	var (
+		matches []Match
+		start   = -1
+	)
+
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+
+		switch {
+		case isWord && start == -1:
+			start = i
+		case !isWord && start != -1:
+			matches = append(matches, Match{Start: start, End: i})
+			start = -1
+		}
+	}
+
+	if start != -1 {
+		matches = append(matches, Match{Start: start, End: len(text)})
+	}
+
+	return matches
+}
+
+// editDistance returns the Levenshtein distance between a and b, or max+1
+// once it's known to be more than max, which saves comparing words that
+// are very different.
+func editDistance(a, b []rune, max int) int {
+	if d := len(a) - len(b); d > max || -d > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+
+		if rowMin > max {
+			return max + 1
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// minInt returns the smallest of the numbers.
+func minInt(n int, rest ...int) int {
+	for _, m := range rest {
+		if m < n {
+			n = m
+		}
+	}
+	return n
+}
+
+// containsString returns true if the value is in the list.
+func containsString(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+package chat_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picatz/openai"
+	"golang.org/x/text/language"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+func TestThreadsSearch(t *testing.T) {
+	threads := chat.Threads{
+		{
+			Name: "Pod bay doors",
+			ChatHistory: chat.Messages([]openai.ChatMessage{
+				{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL."},
+				{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Dave. I'm afraid I can't do that."},
+			}),
+		},
+		{
+			Name: "Chess",
+			ChatHistory: chat.Messages([]openai.ChatMessage{
+				{Role: openai.ChatRoleUser, Content: "Let's play chess."},
+				{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Frank,\nI think you missed it.\n\nQueen to bishop three."},
+			}),
+		},
+	}
+
+	results, err := threads.Search(context.Background(), "i'm SORRY")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+
+	if results[0].Thread != threads[0] || results[1].Thread != threads[1] || results[1].MessageIndex != 1 {
+		t.Fatalf("unexpected results: %+v, %+v", results[0], results[1])
+	}
+
+	before, match, after := results[1].Snippet(12)
+	if before != "" || match != "I'm sorry" || after != ", Frank, I t…" {
+		t.Fatalf("unexpected snippet: %q, %q, %q", before, match, after)
+	}
+
+	results, err = threads.Search(context.Background(), "bishop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, match, after = results[0].Snippet(10)
+	if before != "… Queen to " || match != "bishop" || after != " three." {
+		t.Fatalf("unexpected snippet: %q, %q, %q", before, match, after)
+	}
+}
+
+func TestThreadSearchOptions(t *testing.T) {
+	thread := &chat.Thread{
+		Name:    "Pod bay doors",
+		Created: time.Date(2001, time.April, 2, 0, 0, 0, 0, time.UTC),
+		ChatHistory: chat.Messages([]openai.ChatMessage{
+			{Role: openai.ChatRoleSystem, Content: "You are HAL 9000, the computer of Discovery One."},
+			{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL. HAL, do you read me?"},
+			{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Dave. I'm afraid I can't do that. This mission is too important."},
+			{Role: openai.ChatRoleUser, Content: "What's the problem? DIYARBAKIR is not the problem."},
+		}),
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		opts  *chat.SearchOptions
+		want  []string
+	}{
+		{
+			name:  "first match",
+			query: "hal",
+			want:  []string{"HAL", "HAL"},
+		},
+		{
+			name:  "all matches",
+			query: "hal",
+			opts:  &chat.SearchOptions{All: true},
+			want:  []string{"HAL", "HAL", "HAL"},
+		},
+		{
+			name:  "case sensitive",
+			query: "hal",
+			opts:  &chat.SearchOptions{CaseSensitive: true},
+		},
+		{
+			name:  "regexp",
+			query: `HAL(,| 9000)`,
+			opts:  &chat.SearchOptions{Mode: chat.SearchRegexp, All: true},
+			want:  []string{"HAL 9000", "HAL,"},
+		},
+		{
+			name:  "fuzzy",
+			query: "mision importnt",
+			opts:  &chat.SearchOptions{Mode: chat.SearchFuzzy, All: true},
+			want:  []string{"mission", "important"},
+		},
+		{
+			name:  "fuzzy needs every word",
+			query: "mission jupiter",
+			opts:  &chat.SearchOptions{Mode: chat.SearchFuzzy},
+		},
+		{
+			name:  "roles",
+			query: "hal",
+			opts:  &chat.SearchOptions{Roles: []string{openai.ChatRoleUser}},
+			want:  []string{"HAL"},
+		},
+		{
+			name:  "in date range",
+			query: "dave",
+			opts:  &chat.SearchOptions{Since: time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC), Until: time.Date(2002, time.January, 1, 0, 0, 0, 0, time.UTC)},
+			want:  []string{"Dave"},
+		},
+		{
+			name:  "out of date range",
+			query: "dave",
+			opts:  &chat.SearchOptions{Since: time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			// In Turkish, the lowercase of "I" is a dotless "ı".
+			name:  "language",
+			query: "diyarbakır",
+			opts:  &chat.SearchOptions{Mode: chat.SearchFuzzy, Language: language.Turkish},
+			want:  []string{"DIYARBAKIR"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := thread.SearchWithOptions(context.Background(), test.query, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, result := range results {
+				for _, match := range result.Matches {
+					got = append(got, result.Message.Content[match.Start:match.End])
+				}
+
+				if result.StartIndex != result.Matches[0].Start || result.EndIndex != result.Matches[0].End {
+					t.Fatalf("expected the first match to be the start and end index, got %+v", result)
+				}
+			}
+
+			if strings.Join(got, "|") != strings.Join(test.want, "|") {
+				t.Fatalf("expected matches %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestThreadSearchMessageTime(t *testing.T) {
+	reply := chat.NewMessage(openai.ChatRoleAssistant, "Good afternoon, Dave.")
+	reply.Metadata = &chat.Metadata{Time: time.Date(2003, time.January, 12, 0, 0, 0, 0, time.UTC)}
+
+	thread := &chat.Thread{
+		Name:    "Pod bay doors",
+		Created: time.Date(2001, time.April, 2, 0, 0, 0, 0, time.UTC),
+		ChatHistory: []chat.Message{
+			chat.NewMessage(openai.ChatRoleUser, "Hello, HAL. This is Dave."),
+			reply,
+		},
+	}
+
+	y2002 := time.Date(2002, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// The reply was sent after the thread was created, and messages
+	// without a time are taken as sent when it was created.
+	for _, test := range []struct {
+		opts *chat.SearchOptions
+		want int
+	}{
+		{opts: &chat.SearchOptions{Since: y2002}, want: 1},
+		{opts: &chat.SearchOptions{Until: y2002}, want: 0},
+	} {
+		results, err := thread.SearchWithOptions(context.Background(), "dave", test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 || results[0].MessageIndex != test.want {
+			t.Fatalf("expected message %d to match with %+v, got %+v", test.want, test.opts, results)
+		}
+	}
+}
+
+func TestThreadSearchErrors(t *testing.T) {
+	thread := &chat.Thread{}
+
+	if _, err := thread.Search(context.Background(), " "); err == nil {
+		t.Fatal("expected an error for an empty query")
+	}
+
+	if _, err := thread.SearchWithOptions(context.Background(), "pod bay (doors", &chat.SearchOptions{Mode: chat.SearchRegexp}); err == nil {
+		t.Fatal("expected an error for an invalid pattern")
+	}
+}
+
+func TestSortByScore(t *testing.T) {
+	threads := chat.Threads{
+		{
+			Name: "Typos",
+			ChatHistory: chat.Messages([]openai.ChatMessage{
+				{Role: openai.ChatRoleUser, Content: "Open the pod bay doers, HAL."},
+			}),
+		},
+		{
+			Name: "Pod bay doors",
+			ChatHistory: chat.Messages([]openai.ChatMessage{
+				{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL."},
+			}),
+		},
+	}
+
+	results, err := threads.SearchWithOptions(context.Background(), "doors", &chat.SearchOptions{Mode: chat.SearchFuzzy})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chat.SortByScore(results)
+
+	if len(results) != 2 || results[0].Thread != threads[1] || results[0].Score <= results[1].Score {
+		t.Fatalf("expected the exact match first, got %+v", results)
+	}
+}
@@ -0,0 +1,176 @@
+package chat
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/picatz/hal/pkg/atomicfile"
+)
+
+// Store persists chat threads to disk, one JSON file per thread.
+type Store struct {
+	// Dir is the directory the thread files are stored in.
+	Dir string
+}
+
+// DefaultStoreDir returns the default directory to store threads in,
+// which is $XDG_DATA_HOME/hal/threads, falling back to the
+// ~/.local/share/hal/threads directory if XDG_DATA_HOME is not set.
+func DefaultStoreDir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find home directory: %w", err)
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+
+	return filepath.Join(dataHome, "hal", "threads"), nil
+}
+
+// NewStore returns a new store using the given directory, creating
+// it if it does not exist yet.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create thread store directory %q: %w", dir, err)
+	}
+
+	return &Store{Dir: dir}, nil
+}
+
+// path returns the file path for the given thread.
+func (s *Store) path(ct *Thread) string {
+	return filepath.Join(s.Dir, ct.ID+".json")
+}
+
+// Save writes the thread to disk, assigning it an ID if it does not
+// have one yet, and recording its chat history in its conversation graph.
+//
+// The thread is first written to a temporary file in the same directory
+// which is then renamed over the previous version, so a crash in the middle
+// of a write never leaves a partially written thread behind.
+func (s *Store) Save(ct *Thread) error {
+	if ct.ID == "" {
+		ct.ID = NewThreadID()
+	}
+
+	ct.Sync()
+
+	b, err := json.MarshalIndent(ct, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode chat thread %q: %w", ct.Name, err)
+	}
+
+	if err := atomicfile.WriteFile(s.path(ct), b, 0o600); err != nil {
+		return fmt.Errorf("failed to save chat thread %q: %w", ct.Name, err)
+	}
+
+	return nil
+}
+
+// Load reads all of the threads in the store, sorted by creation date.
+//
+// Threads saved before the conversation graph are migrated, building the
+// graph from their chat history, and are written in the new format the next
+// time they're saved.
+//
+// Files that can't be read are skipped, and files that can't be decoded are
+// renamed with a ".corrupt" extension, so they're kept for recovery without
+// getting in the way. The threads that were loaded are returned with an
+// error saying which files were skipped.
+func (s *Store) Load() (Threads, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read thread store directory %q: %w", s.Dir, err)
+	}
+
+	var (
+		threads = Threads{}
+		errs    []error
+	)
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// Skip directories, temporary files left behind by a crash, and
+		// anything else that isn't a thread.
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(s.Dir, name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read chat thread file %q: %w", name, err))
+			continue
+		}
+
+		ct := &Thread{}
+		if err := json.Unmarshal(b, ct); err != nil {
+			errs = append(errs, s.quarantine(name, err))
+			continue
+		}
+
+		// The file name is the source of truth for the ID.
+		ct.ID = strings.TrimSuffix(name, ".json")
+
+		if ct.Version < ThreadVersion {
+			ct.Sync()
+		}
+
+		threads = append(threads, ct)
+	}
+
+	sort.Sort(threads)
+
+	switch len(errs) {
+	case 0:
+		return threads, nil
+	case 1:
+		return threads, errs[0]
+	default:
+		return threads, fmt.Errorf("%w, and %d more", errs[0], len(errs)-1)
+	}
+}
+
+// quarantine renames the thread file that failed to decode with a
+// ".corrupt" extension, returning the error to report for it.
+func (s *Store) quarantine(name string, err error) error {
+	corrupt := strings.TrimSuffix(name, ".json") + ".corrupt"
+
+	if renameErr := os.Rename(filepath.Join(s.Dir, name), filepath.Join(s.Dir, corrupt)); renameErr != nil {
+		return fmt.Errorf("failed to decode chat thread file %q: %w", name, err)
+	}
+
+	return fmt.Errorf("failed to decode chat thread file %q, moved it to %q: %w", name, corrupt, err)
+}
+
+// NewThreadID returns a new random thread ID.
+func NewThreadID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Delete removes the thread from disk. Threads that were never saved are
+// ignored.
+func (s *Store) Delete(ct *Thread) error {
+	if ct.ID == "" {
+		return nil
+	}
+
+	if err := os.Remove(s.path(ct)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete chat thread %q: %w", ct.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package chat
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picatz/openai"
+)
+
+func TestStoreSaveLoad(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "threads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := &Thread{
+		Name:    "First",
+		Created: time.Now().Add(-time.Hour),
+		ChatHistory: []Message{
+			SystemMessage,
+			NewMessage(openai.ChatRoleUser, "Hello HAL"),
+		},
+		Tokens: 42,
+	}
+
+	second := &Thread{
+		Name:    "Second",
+		Created: time.Now(),
+	}
+
+	for _, ct := range []*Thread{second, first} {
+		if err := store.Save(ct); err != nil {
+			t.Fatal(err)
+		}
+		if ct.ID == "" {
+			t.Fatalf("expected thread %q to be assigned an ID", ct.Name)
+		}
+	}
+
+	// Saving again should overwrite the previous version.
+	first.Summary = "Updated"
+	if err := store.Save(first); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leftover temporary files from a crash should be ignored.
+	if err := os.WriteFile(filepath.Join(store.Dir, ".tmp-crashed-123"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	threads, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads) != 2 {
+		t.Fatalf("expected 2 threads, got %d", len(threads))
+	}
+
+	if threads[0].ID != first.ID || threads[1].ID != second.ID {
+		t.Fatalf("expected threads to be sorted by creation date, got %q, %q", threads[0].Name, threads[1].Name)
+	}
+
+	if threads[0].Summary != "Updated" {
+		t.Fatalf("expected updated summary, got %q", threads[0].Summary)
+	}
+
+	if len(threads[0].ChatHistory) != 2 || threads[0].ChatHistory[1].Content != "Hello HAL" {
+		t.Fatalf("unexpected chat history: %+v", threads[0].ChatHistory)
+	}
+
+	if threads[0].Tokens != 42 {
+		t.Fatalf("expected 42 tokens, got %d", threads[0].Tokens)
+	}
+}
+
+func TestStoreLoadCorrupt(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good := &Thread{Name: "Good", Created: time.Now()}
+	if err := store.Save(good); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(store.Dir, "bad.json"), []byte(`{"name": "Bad"`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The good thread is loaded, and the bad file is set aside.
+	threads, err := store.Load()
+	if err == nil || !strings.Contains(err.Error(), `"bad.json"`) {
+		t.Fatalf("expected an error for the bad file, got %v", err)
+	}
+
+	if len(threads) != 1 || threads[0].ID != good.ID {
+		t.Fatalf("expected only the good thread, got %+v", threads)
+	}
+
+	if _, err := os.Stat(filepath.Join(store.Dir, "bad.corrupt")); err != nil {
+		t.Fatalf("expected the bad file to be kept, got %v", err)
+	}
+
+	// It isn't loaded again.
+	threads, err = store.Load()
+	if err != nil || len(threads) != 1 {
+		t.Fatalf("expected only the good thread, got %+v, %v", threads, err)
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ct := &Thread{Name: "Delete me", Created: time.Now()}
+
+	if err := store.Save(ct); err != nil {
+		t.Fatal(err)
+	}
+
+	dup := ct.Duplicate("Keep me")
+	if dup.ID != "" {
+		t.Fatalf("expected duplicate to not have an ID, got %q", dup.ID)
+	}
+
+	if err := store.Save(dup); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Delete(ct); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting a thread that was never saved is a no-op.
+	if err := store.Delete(&Thread{Name: "Never saved"}); err != nil {
+		t.Fatal(err)
+	}
+
+	threads, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads) != 1 || threads[0].Name != "Keep me" {
+		t.Fatalf("expected only the duplicate to be left, got %+v", threads)
+	}
+}
@@ -0,0 +1,154 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/picatz/openai"
+)
+
+// StreamDeltaMsg is sent for each chunk of content received while a
+// response is being streamed. Next must be called to keep reading the
+// stream, which ends with a FinishedMsg.
+type StreamDeltaMsg struct {
+	// Delta is the new content received since the last message.
+	Delta string
+
+	// Next is the command that reads the next chunk of the stream.
+	Next tea.Cmd
+}
+
+// stream is an in-flight streamed chat response.
+type stream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	resp   ResponseStream
+
+	opts    Options
+	sent    time.Time
+	history []Message
+	role    string
+	model   string
+	reason  string
+	usage   *Usage
+	buffer  strings.Builder
+}
+
+// Stream is like Send, but streams the response token-by-token, sending a
+// StreamDeltaMsg as each chunk of content arrives, and a FinishedMsg once
+// the response is complete.
+//
+// The request can be canceled using the given context, which ends the stream
+// with a FinishedMsg containing the context's error.
+//
+// If the stream fails part way through, the FinishedMsg contains both the
+// error and the partial content received so far, including it in the
+// returned history.
+func Stream(ctx context.Context, provider Provider, opts Options, chatHistory []Message, text string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithCancel(ctx)
+
+		sent := time.Now()
+		chatHistory = append(chatHistory, userMessage(text, sent))
+
+		resp, err := provider.Stream(ctx, opts.request(ChatMessages(chatHistory)))
+		if err != nil {
+			cancel()
+			return FinishedMsg{Err: err}
+		}
+
+		s := &stream{
+			ctx:     ctx,
+			cancel:  cancel,
+			resp:    resp,
+			opts:    opts,
+			sent:    sent,
+			history: chatHistory,
+			role:    openai.ChatRoleAssistant,
+		}
+
+		return s.next()
+	}
+}
+
+// next reads the stream until the next chunk of content, returning either
+// a StreamDeltaMsg or the FinishedMsg that ends the stream.
+func (s *stream) next() tea.Msg {
+	for {
+		// Stop as soon as the request is canceled, even if there's more
+		// content already buffered.
+		if err := s.ctx.Err(); err != nil {
+			return s.finish(err)
+		}
+
+		delta, err := s.resp.Recv()
+		if errors.Is(err, io.EOF) {
+			return s.finish(nil)
+		}
+		if err != nil {
+			// Prefer the context's error, so callers can tell if the
+			// request was canceled.
+			if ctxErr := s.ctx.Err(); ctxErr != nil {
+				err = ctxErr
+			}
+			return s.finish(err)
+		}
+
+		if delta.Role != "" {
+			s.role = delta.Role
+		}
+
+		if delta.Model != "" {
+			s.model = delta.Model
+		}
+
+		if delta.FinishReason != "" {
+			s.reason = delta.FinishReason
+		}
+
+		if delta.Usage != nil {
+			s.usage = delta.Usage
+		}
+
+		if delta.Content != "" {
+			s.buffer.WriteString(delta.Content)
+			return StreamDeltaMsg{
+				Delta: delta.Content,
+				Next:  s.next,
+			}
+		}
+	}
+}
+
+// finish closes the stream, and returns the final message including the
+// (possibly partial) response in the chat history.
+func (s *stream) finish(err error) tea.Msg {
+	s.resp.Close()
+	s.cancel()
+
+	if s.buffer.Len() > 0 {
+		s.history = append(s.history, Message{
+			ChatMessage: openai.ChatMessage{
+				Role:    s.role,
+				Content: s.buffer.String(),
+			},
+			Metadata: s.opts.responseMetadata(s.sent, s.model, s.usage, s.reason),
+		})
+	}
+
+	var tokens int
+	if s.usage != nil {
+		tokens = s.usage.TotalTokens
+	}
+
+	return FinishedMsg{
+		Err:     err,
+		Buffer:  []byte(s.buffer.String()),
+		History: s.history,
+		Tokens:  tokens,
+	}
+}
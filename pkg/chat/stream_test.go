@@ -0,0 +1,183 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/picatz/openai"
+)
+
+// rewriteTransport sends all requests to the given test server.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t *rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newStreamTestProvider returns an OpenAI provider that talks to a test
+// server which writes the given server-sent events.
+func newStreamTestProvider(t *testing.T, events ...string) Provider {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := openai.NewClient("test", openai.WithHTTPClient(&http.Client{
+		Transport: &rewriteTransport{target: target},
+	}))
+
+	return NewOpenAIProvider(client, openai.ModelGPT35Turbo)
+}
+
+// readStream runs the stream command until it finishes, returning all of
+// the deltas received, and the final message.
+func readStream(t *testing.T, cmd tea.Cmd) ([]string, FinishedMsg) {
+	t.Helper()
+
+	deltas := []string{}
+
+	for {
+		switch msg := cmd().(type) {
+		case StreamDeltaMsg:
+			deltas = append(deltas, msg.Delta)
+			cmd = msg.Next
+		case FinishedMsg:
+			return deltas, msg
+		default:
+			t.Fatalf("unexpected message type %T", msg)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	client := newStreamTestProvider(t,
+		`{"model":"gpt-4-0613","choices":[{"delta":{"role":"assistant"}}]}`,
+		`{"choices":[{"delta":{"content":"Hello"}}]}`,
+		`{"choices":[{"delta":{"content":", Dave."}}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"stop"}],"usage":{"total_tokens":12}}`,
+		`[DONE]`,
+	)
+
+	deltas, finished := readStream(t, Stream(context.Background(), client, Options{}, []Message{SystemMessage}, "Hi HAL"))
+	if finished.Err != nil {
+		t.Fatal(finished.Err)
+	}
+
+	if strings.Join(deltas, "|") != "Hello|, Dave." {
+		t.Fatalf("unexpected deltas: %q", deltas)
+	}
+
+	if string(finished.Buffer) != "Hello, Dave." {
+		t.Fatalf("unexpected buffer: %q", finished.Buffer)
+	}
+
+	if finished.Tokens != 12 {
+		t.Fatalf("expected 12 tokens, got %d", finished.Tokens)
+	}
+
+	if len(finished.History) != 3 {
+		t.Fatalf("expected 3 messages in history, got %d", len(finished.History))
+	}
+
+	if last := finished.History[2]; last.Role != openai.ChatRoleAssistant || last.Content != "Hello, Dave." {
+		t.Fatalf("unexpected last message: %+v", last)
+	}
+
+	md := finished.History[2].Metadata
+	if md == nil || md.Model != "gpt-4-0613" || md.FinishReason != "stop" || md.Usage == nil || md.Usage.TotalTokens != 12 || md.Time.IsZero() {
+		t.Fatalf("unexpected metadata: %+v", md)
+	}
+
+	if finished.History[1].Metadata == nil || finished.History[1].Metadata.Time.After(md.Time) {
+		t.Fatalf("expected the time the message was sent, got %+v", finished.History[1].Metadata)
+	}
+}
+
+func TestStreamMidStreamError(t *testing.T) {
+	client := newStreamTestProvider(t,
+		`{"choices":[{"delta":{"role":"assistant","content":"I'm sorry"}}]}`,
+		`{"error":{"type":"server_error","message":"overloaded"}}`,
+	)
+
+	deltas, finished := readStream(t, Stream(context.Background(), client, Options{}, []Message{SystemMessage}, "Open the pod bay doors"))
+	if finished.Err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if len(deltas) != 1 {
+		t.Fatalf("expected 1 delta, got %d", len(deltas))
+	}
+
+	if string(finished.Buffer) != "I'm sorry" {
+		t.Fatalf("expected partial content to be kept, got %q", finished.Buffer)
+	}
+
+	if len(finished.History) != 3 || finished.History[2].Content != "I'm sorry" {
+		t.Fatalf("expected partial content in history, got %+v", finished.History)
+	}
+}
+
+func TestStreamEndedUnexpectedly(t *testing.T) {
+	client := newStreamTestProvider(t,
+		`{"choices":[{"delta":{"role":"assistant","content":"Just"}}]}`,
+	)
+
+	_, finished := readStream(t, Stream(context.Background(), client, Options{}, []Message{SystemMessage}, "Hello"))
+	if finished.Err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if string(finished.Buffer) != "Just" {
+		t.Fatalf("unexpected buffer: %q", finished.Buffer)
+	}
+}
+
+func TestStreamCanceled(t *testing.T) {
+	client := newStreamTestProvider(t,
+		`{"choices":[{"delta":{"role":"assistant","content":"Affirmative"}}]}`,
+		`{"choices":[{"delta":{"content":", Dave."}}]}`,
+		`[DONE]`,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	msg := Stream(ctx, client, Options{}, []Message{SystemMessage}, "Hello")()
+
+	delta, ok := msg.(StreamDeltaMsg)
+	if !ok {
+		t.Fatalf("expected a delta, got %T", msg)
+	}
+
+	cancel()
+
+	finished, ok := delta.Next().(FinishedMsg)
+	if !ok {
+		t.Fatal("expected the stream to finish after being canceled")
+	}
+
+	if !errors.Is(finished.Err, context.Canceled) {
+		t.Fatalf("expected context canceled error, got %v", finished.Err)
+	}
+}
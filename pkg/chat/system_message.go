@@ -2,7 +2,7 @@ package chat
 
 import "github.com/picatz/openai"
 
-var SystemMessage = openai.ChatMessage{
-	Role:    openai.ChatRoleSystem,
-	Content: "You are HAL, a powerful code and text editor controlled by natural language. Answer as concisely as possible.",
-}
+var SystemMessage = NewMessage(
+	openai.ChatRoleSystem,
+	"You are HAL, a powerful code and text editor controlled by natural language. Answer as concisely as possible.",
+)
@@ -8,21 +8,34 @@ import (
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/picatz/openai"
-
-	"golang.org/x/text/language"
-	"golang.org/x/text/search"
 )
 
 // Thread is a "chat thread" that is used to store the chat history and
 // metadata for a chat session. It implements the list.Item interface
 // so that it can shown in a list in the UI.
 type Thread struct {
+	// Version is the version of the file format the thread was saved in,
+	// zero for threads saved before it was recorded.
+	Version int `json:"version,omitempty"`
+
+	// ID uniquely identifies the thread, and is used as the file name
+	// when the thread is persisted to a Store.
+	ID string `json:"id"`
+
 	// Name (title) of the thread.
 	Name string `json:"name"`
 
+	// TitleLocked is set when the user named the thread, so HAL doesn't
+	// replace the name with a generated title.
+	TitleLocked bool `json:"title_locked,omitempty"`
+
 	// Summary (description) of the thread.
 	Summary string `json:"summary"`
 
+	// SummarizedAt is the length of the chat history when the summary was
+	// last generated, zero if it never was.
+	SummarizedAt int `json:"summarized_at,omitempty"`
+
 	// Created is the date the thread was created.
 	Created time.Time `json:"date"`
 
@@ -31,12 +44,33 @@ type Thread struct {
 	// chat window.
 	Prompt string `json:"prompt"`
 
+	// Draft is the unsent text the user was writing when they last left
+	// the thread, which is restored when they come back to it.
+	Draft string `json:"draft,omitempty"`
+
 	// The chat history is the list of messages that have been sent and
-	// received in the chat session.
-	ChatHistory []openai.ChatMessage `json:"chat_history"`
+	// received in the chat session, on the branch of the conversation that
+	// is currently used.
+	ChatHistory []Message `json:"chat_history"`
+
+	// Nodes are all of the messages of the conversation graph, on every
+	// branch, in the order they were added.
+	Nodes []*Node `json:"nodes,omitempty"`
+
+	// Head is the ID of the node the chat history ends at.
+	Head string `json:"head,omitempty"`
+
+	// Provider is the name of the provider used for the thread, if empty
+	// the default provider is used.
+	Provider string `json:"provider,omitempty"`
 
 	// Tokens is the last reported number of tokens used in the chat session.
 	Tokens int `json:"tokens"`
+
+	// Compactions are the older messages which were replaced by a summary
+	// to keep the chat history within the model's context window, oldest
+	// first.
+	Compactions []*Compaction `json:"compactions,omitempty"`
 }
 
 // Implement the list.Item interface.
@@ -64,8 +98,45 @@ func (cts Threads) ListItems() []list.Item {
 	return chatThreadListItems
 }
 
+// Find returns the thread with the given ID, or with the given name if no
+// thread has that ID. It's an error if no thread, or more than one thread
+// with the same name, is found.
+func (cts Threads) Find(idOrName string) (*Thread, error) {
+	var found *Thread
+
+	for _, ct := range cts {
+		if ct.ID == idOrName {
+			return ct, nil
+		}
+
+		if ct.Name == idOrName {
+			if found != nil {
+				return nil, fmt.Errorf("more than one thread is named %q, use its ID instead", idOrName)
+			}
+			found = ct
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("thread %q not found", idOrName)
+	}
+
+	return found, nil
+}
+
 // Summarize returns a summrized version of the chat history.
-func (ct *Thread) Summarize(ctx context.Context, client *openai.Client) (string, error) {
+func (ct *Thread) Summarize(ctx context.Context, provider Provider) (string, error) {
+	summary, err := summarize(ctx, provider, ct.ChatHistory)
+	if err != nil {
+		return "", fmt.Errorf("failed to create summary of chat thread %q: %w", ct.Name, err)
+	}
+
+	return summary, nil
+}
+
+// summarize returns a summary of the messages, including the summaries of
+// messages that were compacted before, but leaving out system messages.
+func summarize(ctx context.Context, provider Provider, messages []Message) (string, error) {
 	// Create a new thread with a new system prompt to summarize conversation.
 	chatHistory := []openai.ChatMessage{
 		{
@@ -79,11 +150,15 @@ func (ct *Thread) Summarize(ctx context.Context, client *openai.Client) (string,
 
 				b.WriteString("Please summarize the following conversation:\n\n")
 
-				for _, m := range ct.ChatHistory {
-					if m.Role == openai.ChatRoleSystem {
+				for _, m := range messages {
+					switch {
+					case IsSummary(m):
+						b.WriteString(fmt.Sprintf("summary: %s", strings.TrimPrefix(m.Content, SummaryPrefix)))
+					case m.Role == openai.ChatRoleSystem:
 						continue
+					default:
+						b.WriteString(fmt.Sprintf("%s: %s", m.Role, m.Content))
 					}
-					b.WriteString(fmt.Sprintf("%s: %s", m.Role, m.Content))
 					b.WriteString("\n")
 				}
 
@@ -93,56 +168,39 @@ func (ct *Thread) Summarize(ctx context.Context, client *openai.Client) (string,
 	}
 
 	// create a summary of the chat history
-	summary, err := client.CreateChat(ctx, &openai.CreateChatRequest{
-		Model:    openai.ModelGPT35Turbo,
+	summary, err := provider.Complete(ctx, &Request{
 		Messages: chatHistory,
 	})
-
 	if err != nil {
-		return "", fmt.Errorf("failed to create summary of chat thread %q: %w", ct.Name, err)
+		return "", err
 	}
 
-	return summary.Choices[0].Message.Content, nil
+	return summary.Message.Content, nil
 }
 
-// SearchResult is a search result for a chat thread.
-type SearchResult struct {
-	// The message that matched the search query.
-	Message *openai.ChatMessage
-
-	// MessageIndex is the index of the message in the chat history.
-	MessageIndex int
-
-	// MatchStart is the index of the start of the match in the message.
-	StartIndex int
-
-	// MatchEnd is the index of the end of the match in the message.
-	EndIndex int
-}
+// Duplicate returns a copy of the thread with a new name, without an ID
+// so it's saved as a new thread. Its messages, compactions and conversation
+// graph are copied too, so changing one thread doesn't change the other.
+func (ct *Thread) Duplicate(name string) *Thread {
+	dup := *ct
+	dup.ID = ""
+	dup.Name = name
+	dup.Created = time.Now()
+	dup.ChatHistory = copyMessages(ct.ChatHistory)
+
+	dup.Compactions = make([]*Compaction, len(ct.Compactions))
+	for i, c := range ct.Compactions {
+		compaction := *c
+		compaction.Messages = copyMessages(c.Messages)
+		dup.Compactions[i] = &compaction
+	}
 
-// Search retruns the messages that match the search query. Searching
-// happens locally on the host.
-//
-// TODO: consider returning index information in the search results so
-// that we can highlight the matches in the UI.
-func (ct *Thread) Search(ctx context.Context, query string) ([]*SearchResult, error) {
-	matcher := search.New(language.AmericanEnglish, search.IgnoreCase)
-
-	pattern := matcher.CompileString(query)
-
-	results := []*SearchResult{}
-
-	for i, m := range ct.ChatHistory {
-		msg := m
-		if start, end := pattern.IndexString(msg.Content); start != -1 && end != -1 {
-			results = append(results, &SearchResult{
-				Message:      &msg,
-				MessageIndex: i,
-				StartIndex:   start,
-				EndIndex:     end,
-			})
-		}
+	dup.Nodes = make([]*Node, len(ct.Nodes))
+	for i, node := range ct.Nodes {
+		n := *node
+		n.Message = node.Message.copy()
+		dup.Nodes[i] = &n
 	}
 
-	return results, nil
+	return &dup
 }
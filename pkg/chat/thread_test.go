@@ -1,18 +1,50 @@
-package chat
+package chat_test
 
 import (
 	"context"
+	"flag"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/chat/chattest"
 )
 
+// record sends requests to the OpenAI API, using OPENAI_API_KEY, to update
+// the fixtures in testdata instead of replaying them.
+var record = flag.Bool("record", false, "record fixtures using the OpenAI API")
+
+// fixtureProvider returns a provider replaying the fixture at the given path,
+// or recording it if the -record flag is set.
+func fixtureProvider(t *testing.T, path string) (chat.Provider, *chattest.Fixture) {
+	t.Helper()
+
+	if *record {
+		recorder := chattest.NewRecorder(chat.NewOpenAIProvider(openai.NewClient(os.Getenv("OPENAI_API_KEY")), openai.ModelGPT35Turbo))
+		t.Cleanup(func() {
+			if err := recorder.Fixture().Save(path); err != nil {
+				t.Error(err)
+			}
+		})
+		return recorder, nil
+	}
+
+	fixture, err := chattest.LoadFixture(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fixture.Provider(), fixture
+}
+
 func TestThreadSummarize(t *testing.T) {
-	thread := &Thread{
+	thread := &chat.Thread{
 		Name: "Test Thread",
-		ChatHistory: []openai.ChatMessage{
+		ChatHistory: chat.Messages([]openai.ChatMessage{
 			{
 				Role:    openai.ChatRoleUser,
 				Content: "Who is Jon Snow's father? ",
@@ -33,14 +65,28 @@ func TestThreadSummarize(t *testing.T) {
 					"She is the younger sister of Ned Stark, who is Jon Snow's adoptive father. " +
 					"In the books, it is strongly suggested that the same is true, but it has not yet been explicitly confirmed.",
 			},
-		},
+		}),
 	}
 
-	summary, err := thread.Summarize(context.Background(), openai.NewClient(os.Getenv("OPENAI_API_KEY")))
+	provider, fixture := fixtureProvider(t, "testdata/summarize.json")
+
+	summary, err := thread.Summarize(context.Background(), provider)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	// The conversation must be sent the same way as when it was recorded.
+	if fake, ok := provider.(*chattest.Provider); ok {
+		requests := fake.Requests()
+		if len(requests) != 1 {
+			t.Fatalf("expected 1 request, got %d", len(requests))
+		}
+
+		if !reflect.DeepEqual(requests[0].Messages, fixture.Exchanges[0].Messages) {
+			t.Fatalf("request doesn't match the fixture, re-record it with -record:\n%+v", requests[0].Messages)
+		}
+	}
+
 	// Must contain the following words
 	words := []string{
 		"Jon Snow",
@@ -59,9 +105,9 @@ func TestThreadSummarize(t *testing.T) {
 }
 
 func TestThreadSearch(t *testing.T) {
-	thread := &Thread{
+	thread := &chat.Thread{
 		Name: "Test Thread",
-		ChatHistory: []openai.ChatMessage{
+		ChatHistory: chat.Messages([]openai.ChatMessage{
 			{
 				Role:    openai.ChatRoleUser,
 				Content: "Who is Jon Snow's father? ",
@@ -82,7 +128,7 @@ func TestThreadSearch(t *testing.T) {
 					"She is the younger sister of Ned Stark, who is Jon Snow's adoptive father. " +
 					"In the books, it is strongly suggested that the same is true, but it has not yet been explicitly confirmed.",
 			},
-		},
+		}),
 	}
 
 	matches, err := thread.Search(context.Background(), "Jon Snow")
@@ -98,3 +144,73 @@ func TestThreadSearch(t *testing.T) {
 		t.Log(match.Message.Content)
 	}
 }
+
+func TestThreadDuplicate(t *testing.T) {
+	reply := chat.NewMessage(openai.ChatRoleAssistant, "I'm sorry, Dave.")
+	reply.Metadata = &chat.Metadata{Model: "hal-9000", Usage: &chat.Usage{TotalTokens: 42}}
+
+	ct := &chat.Thread{
+		ID:   "a1",
+		Name: "Pod bay doors",
+		ChatHistory: []chat.Message{
+			chat.SystemMessage,
+			chat.NewMessage(openai.ChatRoleUser, "Open the pod bay doors, HAL."),
+			reply,
+		},
+	}
+	ct.Sync()
+
+	ct.Compactions = []*chat.Compaction{{
+		Summary:  "Dave asked HAL to open the pod bay doors.",
+		Messages: []chat.Message{ct.ChatHistory[1], reply},
+	}}
+
+	dup := ct.Duplicate("Pod bay doors (copy)")
+	if dup.ID != "" || len(dup.Nodes) != len(ct.Nodes) || dup.Head != ct.Head || len(dup.Compactions) != 1 {
+		t.Fatalf("expected a copy of the thread without an ID, got %+v", dup)
+	}
+
+	// Changing the copy doesn't change the original.
+	dup.Nodes[2].Content = "Opening the pod bay doors."
+	dup.Nodes[2].Parent = ""
+	dup.Nodes[2].Metadata.Model = "sal-9000"
+	dup.Nodes[2].Metadata.Usage.TotalTokens = 0
+	dup.ChatHistory[2].Content = "Opening the pod bay doors."
+	dup.ChatHistory[2].Metadata.Model = "sal-9000"
+	dup.Compactions[0].Summary = "Dave asked nicely."
+	dup.Compactions[0].Messages[1].Metadata.Model = "sal-9000"
+
+	if node := ct.Node("3"); node.Content != "I'm sorry, Dave." || node.Parent != "2" || node.Metadata.Model != "hal-9000" || node.Metadata.Usage.TotalTokens != 42 {
+		t.Fatalf("expected the original node to be unchanged, got %+v", node)
+	}
+	if msg := ct.ChatHistory[2]; msg.Content != "I'm sorry, Dave." || msg.Metadata.Model != "hal-9000" {
+		t.Fatalf("expected the original history to be unchanged, got %+v", ct.ChatHistory)
+	}
+	if c := ct.Compactions[0]; c.Summary != "Dave asked HAL to open the pod bay doors." || c.Messages[1].Metadata.Model != "hal-9000" {
+		t.Fatalf("expected the original compaction to be unchanged, got %+v", c)
+	}
+}
+
+func TestThreadsFind(t *testing.T) {
+	threads := chat.Threads{
+		{ID: "a1", Name: "Pod bay doors"},
+		{ID: "b2", Name: "Chess"},
+		{ID: "c3", Name: "Chess"},
+	}
+
+	if ct, err := threads.Find("b2"); err != nil || ct != threads[1] {
+		t.Fatalf("expected to find thread by ID, got %v, %v", ct, err)
+	}
+
+	if ct, err := threads.Find("Pod bay doors"); err != nil || ct != threads[0] {
+		t.Fatalf("expected to find thread by name, got %v, %v", ct, err)
+	}
+
+	if _, err := threads.Find("Chess"); err == nil {
+		t.Fatal("expected an error for an ambiguous name")
+	}
+
+	if _, err := threads.Find("Jupiter"); err == nil {
+		t.Fatal("expected an error for a missing thread")
+	}
+}
@@ -0,0 +1,131 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	tiktoken "github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+func init() {
+	// Use the BPE ranks embedded in the binary, instead of downloading
+	// them the first time tokens are counted.
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// defaultEncoding is the encoding used for models tiktoken doesn't know,
+// like local models, which makes their token counts an estimate.
+const defaultEncoding = tiktoken.MODEL_CL100K_BASE
+
+// Tokenizer counts tokens the same way a model does, so requests can be
+// checked against the model's context window before they're sent.
+type Tokenizer struct {
+	enc *tiktoken.Tiktoken
+}
+
+var (
+	tokenizersMu sync.Mutex
+	tokenizers   = map[string]*Tokenizer{}
+)
+
+// NewTokenizer returns the tokenizer for the given model. Models that aren't
+// known use the same encoding as gpt-3.5-turbo, which is close enough to
+// estimate their token counts.
+//
+// Tokenizers are cached, since loading an encoding is slow.
+func NewTokenizer(model string) (*Tokenizer, error) {
+	name := defaultEncoding
+	if encoding, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		name = encoding
+	} else {
+		for prefix, encoding := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+			if strings.HasPrefix(model, prefix) {
+				name = encoding
+				break
+			}
+		}
+	}
+
+	tokenizersMu.Lock()
+	defer tokenizersMu.Unlock()
+
+	if t, ok := tokenizers[name]; ok {
+		return t, nil
+	}
+
+	enc, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s tokenizer: %w", name, err)
+	}
+
+	t := &Tokenizer{enc: enc}
+	tokenizers[name] = t
+
+	return t, nil
+}
+
+// Count returns the number of tokens in the text.
+func (t *Tokenizer) Count(text string) int {
+	return len(t.enc.EncodeOrdinary(text))
+}
+
+// Tokens added by the chat format, see "How to count tokens with tiktoken"
+// in the OpenAI cookbook.
+const (
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+)
+
+// CountMessages returns the number of tokens the messages use as the prompt
+// of a chat request, including the tokens used to format each message.
+func (t *Tokenizer) CountMessages(messages []Message) int {
+	if len(messages) == 0 {
+		return 0
+	}
+
+	tokens := tokensPerReply
+	for _, msg := range messages {
+		tokens += t.CountMessage(msg)
+	}
+
+	return tokens
+}
+
+// CountMessage returns the number of tokens one more message adds to the
+// prompt, like a draft that hasn't been sent yet.
+func (t *Tokenizer) CountMessage(msg Message) int {
+	return tokensPerMessage + t.Count(msg.Role) + t.Count(msg.Content)
+}
+
+// contextWindows is the number of tokens each model can handle, for both the
+// prompt and the response. Longer prefixes are checked first.
+var contextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4-32k", 32768},
+	{"gpt-4-1106", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4o", 128000},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo-16k", 16384},
+	{"gpt-3.5-turbo-1106", 16385},
+	{"gpt-3.5-turbo", 4096},
+}
+
+// DefaultContextWindow is the context window assumed for unknown models.
+const DefaultContextWindow = 4096
+
+// ContextWindow returns the number of tokens the model can handle, for both
+// the prompt and the response, or DefaultContextWindow if the model isn't
+// known.
+func ContextWindow(model string) int {
+	for _, w := range contextWindows {
+		if strings.HasPrefix(model, w.prefix) {
+			return w.tokens
+		}
+	}
+	return DefaultContextWindow
+}
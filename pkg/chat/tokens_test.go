@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/picatz/openai"
+)
+
+func TestTokenizer(t *testing.T) {
+	tokenizer, err := NewTokenizer(openai.ModelGPT35Turbo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := tokenizer.Count("tiktoken is great!"); n != 6 {
+		t.Fatalf("expected 6 tokens, got %d", n)
+	}
+
+	messages := []Message{
+		NewMessage(openai.ChatRoleUser, "hello world"),
+	}
+
+	// 3 to prime the reply, and 3 for the message's format, plus 1 for the
+	// role, and 2 for the content.
+	if n := tokenizer.CountMessages(messages); n != 9 {
+		t.Fatalf("expected 9 tokens, got %d", n)
+	}
+
+	// Unknown models, like local ones, are estimated with the same encoding.
+	local, err := NewTokenizer("llama2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if local != tokenizer {
+		t.Fatal("expected the cached tokenizer to be reused")
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	tests := map[string]int{
+		"gpt-3.5-turbo":      4096,
+		"gpt-3.5-turbo-0301": 4096,
+		"gpt-3.5-turbo-16k":  16384,
+		"gpt-4":              8192,
+		"gpt-4-0314":         8192,
+		"gpt-4-32k-0314":     32768,
+		"llama2":             DefaultContextWindow,
+	}
+
+	for model, want := range tests {
+		if got := ContextWindow(model); got != want {
+			t.Errorf("expected %s context window of %d, got %d", model, want, got)
+		}
+	}
+}
@@ -0,0 +1,794 @@
+// Package config loads HAL's configuration file, which is written in HCL.
+//
+// Every setting is optional, anything that isn't set in the file uses the
+// default value from Default.
+//
+//	model          = "gpt-3.5-turbo"
+//	temperature    = 0.7
+//	max_tokens     = 1024
+//	context_window = 4096
+//	timeout        = "2m"
+//	system_message = "You are HAL, ..."
+//
+//	theme {
+//	  primary = "69"
+//	}
+//
+//	keys {
+//	  send = ["esc"]
+//	}
+//
+//	editor {
+//	  command = "nvim"
+//	}
+//
+//	compaction {
+//	  threshold = 0.75
+//	}
+//
+//	search {
+//	  mode     = "fuzzy"
+//	  language = "en-US"
+//	}
+//
+//	shell {
+//	  allow = ["git status *"]
+//	  deny  = ["git push *"]
+//
+//	  confirm {
+//	    write = "confirm"
+//	  }
+//
+//	  sandbox {
+//	    enabled = true
+//	  }
+//	}
+//
+//	provider "local" {
+//	  type     = "openai-compatible"
+//	  base_url = "http://localhost:11434/v1"
+//	  model    = "llama2"
+//	}
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/picatz/openai"
+	"golang.org/x/text/language"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/policy"
+	"github.com/picatz/hal/pkg/sandbox"
+)
+
+// Config is HAL's configuration.
+type Config struct {
+	// Model is the chat model to use, like "gpt-3.5-turbo".
+	Model string `hcl:"model,optional"`
+
+	// Temperature is the sampling temperature to use, between 0 and 2.
+	// Zero uses the API's default.
+	Temperature float64 `hcl:"temperature,optional"`
+
+	// MaxTokens is the maximum number of tokens to generate for each
+	// response. Zero uses the API's default.
+	MaxTokens int `hcl:"max_tokens,optional"`
+
+	// ContextWindow is the number of tokens the model can handle, for both
+	// the prompt and the response. Zero uses the known context window of
+	// the model.
+	ContextWindow int `hcl:"context_window,optional"`
+
+	// Timeout is how long to wait for a response, as a duration string
+	// like "2m" or "90s".
+	Timeout string `hcl:"timeout,optional"`
+
+	// SystemMessage is the system message new threads start with.
+	SystemMessage string `hcl:"system_message,optional"`
+
+	// DefaultProvider is the name of the provider used by threads that
+	// haven't chosen one.
+	DefaultProvider string `hcl:"default_provider,optional"`
+
+	Theme      *Theme      `hcl:"theme,block"`
+	Keys       *Keys       `hcl:"keys,block"`
+	Editor     *Editor     `hcl:"editor,block"`
+	Compaction *Compaction `hcl:"compaction,block"`
+	Search     *Search     `hcl:"search,block"`
+	Shell      *Shell      `hcl:"shell,block"`
+	Providers  []*Provider `hcl:"provider,block"`
+}
+
+// Provider types.
+const (
+	// ProviderTypeOpenAI is the OpenAI API.
+	ProviderTypeOpenAI = "openai"
+
+	// ProviderTypeOpenAICompatible is any server implementing the OpenAI
+	// chat completions API, like llama.cpp's server or Ollama.
+	ProviderTypeOpenAICompatible = "openai-compatible"
+)
+
+// Provider is a large language model backend threads can use.
+//
+// There is always an "openai" provider using the top-level model and the
+// OPENAI_API_KEY environment variable, unless another provider with that
+// name is configured.
+type Provider struct {
+	// Name is used to choose the provider for a thread.
+	Name string `hcl:"name,label"`
+
+	// Type is either "openai" or "openai-compatible".
+	Type string `hcl:"type"`
+
+	// BaseURL is the base URL of an "openai-compatible" API.
+	BaseURL string `hcl:"base_url,optional"`
+
+	// Model is the model to use, required for "openai-compatible"
+	// providers.
+	Model string `hcl:"model,optional"`
+
+	// APIKeyEnv is the environment variable containing the API key, which
+	// defaults to OPENAI_API_KEY for "openai" providers.
+	APIKeyEnv string `hcl:"api_key_env,optional"`
+
+	// ContextWindow is the number of tokens the model can handle, which
+	// should be set for local models. Zero uses the top-level setting.
+	ContextWindow int `hcl:"context_window,optional"`
+}
+
+// APIKey returns the API key from the provider's environment variable.
+func (p *Provider) APIKey() string {
+	if p.APIKeyEnv == "" && p.Type == ProviderTypeOpenAI {
+		return os.Getenv("OPENAI_API_KEY")
+	}
+	if p.APIKeyEnv == "" {
+		return ""
+	}
+	return os.Getenv(p.APIKeyEnv)
+}
+
+// Theme is the colors used by the UI, either ANSI color numbers like "69",
+// or hex colors like "#5f87ff".
+type Theme struct {
+	// Primary is HAL's main color, used for highlights and the status bar.
+	Primary string `hcl:"primary,optional"`
+
+	// Messages is the background of the status bar message count.
+	Messages string `hcl:"messages,optional"`
+
+	// Tokens is the background of the status bar token count.
+	Tokens string `hcl:"tokens,optional"`
+
+	// Error is the background of errors shown in the status bar.
+	Error string `hcl:"error,optional"`
+}
+
+// Keys is the key bindings for each action, like "ctrl+x" or "esc".
+type Keys struct {
+	Quit           []string `hcl:"quit,optional"`
+	Send           []string `hcl:"send,optional"`
+	Cancel         []string `hcl:"cancel,optional"`
+	Back           []string `hcl:"back,optional"`
+	ExternalEditor []string `hcl:"external_editor,optional"`
+	Truncate       []string `hcl:"truncate,optional"`
+	Regenerate     []string `hcl:"regenerate,optional"`
+	PreviousBranch []string `hcl:"previous_branch,optional"`
+	NextBranch     []string `hcl:"next_branch,optional"`
+	ThreadTree     []string `hcl:"thread_tree,optional"`
+	Shell          []string `hcl:"shell,optional"`
+	ReviewPatch    []string `hcl:"review_patch,optional"`
+
+	NewThread       []string `hcl:"new_thread,optional"`
+	RenameThread    []string `hcl:"rename_thread,optional"`
+	DuplicateThread []string `hcl:"duplicate_thread,optional"`
+	DeleteThread    []string `hcl:"delete_thread,optional"`
+	SwitchProvider  []string `hcl:"switch_provider,optional"`
+	SearchThreads   []string `hcl:"search_threads,optional"`
+	ExportThread    []string `hcl:"export_thread,optional"`
+}
+
+// Editor is the settings for the editor.
+type Editor struct {
+	// Command is the external editor to open, defaulting to $EDITOR.
+	Command string `hcl:"command,optional"`
+
+	// CharLimit is the maximum number of characters in the editor.
+	CharLimit int `hcl:"char_limit,optional"`
+
+	// LineNumbers shows line numbers in the editor.
+	LineNumbers bool `hcl:"line_numbers,optional"`
+}
+
+// Compaction is the settings for automatically replacing the older messages
+// of a thread with a summary, when it nears the model's context window.
+type Compaction struct {
+	// Enabled turns automatic compaction on or off.
+	Enabled bool `hcl:"enabled,optional"`
+
+	// Threshold is the fraction of the context window a thread can use
+	// before it's compacted, between 0 and 1.
+	Threshold float64 `hcl:"threshold,optional"`
+
+	// Keep is the number of the most recent messages that are kept as
+	// they are.
+	Keep int `hcl:"keep,optional"`
+}
+
+// Search is the settings for searching the messages of threads.
+type Search struct {
+	// Mode is how queries are matched by default, either "literal",
+	// "regexp" or "fuzzy".
+	Mode string `hcl:"mode,optional"`
+
+	// Language is the BCP 47 tag of the language used to compare text,
+	// like "en-US" or "tr", which decides how case is ignored.
+	Language string `hcl:"language,optional"`
+
+	// CaseSensitive stops ignoring case.
+	CaseSensitive bool `hcl:"case_sensitive,optional"`
+}
+
+// Shell is the settings for running the commands HAL proposes in shell mode.
+type Shell struct {
+	// Allow are rules of commands that run without confirmation, like
+	// "git status *", where "*" matches any text.
+	Allow []string `hcl:"allow,optional"`
+
+	// Deny are rules of commands that never run, which win over the
+	// allow rules.
+	Deny []string `hcl:"deny,optional"`
+
+	// AuditLog is the file the commands that ran, or were denied, are
+	// logged to, defaulting to audit.log next to the threads.
+	AuditLog string `hcl:"audit_log,optional"`
+
+	Confirm *Confirm `hcl:"confirm,block"`
+	Sandbox *Sandbox `hcl:"sandbox,block"`
+}
+
+// Confirm is how each class of command is confirmed before it runs, either
+// "none", "confirm", "type" to type "yes", or "deny".
+type Confirm struct {
+	// ReadOnly is the level of commands that only read files.
+	ReadOnly string `hcl:"read_only,optional"`
+
+	// Write is the level of commands that write files, or aren't known.
+	Write string `hcl:"write,optional"`
+
+	// Network is the level of commands that use the network.
+	Network string `hcl:"network,optional"`
+
+	// Destructive is the level of commands that can lose data, like rm
+	// or git push --force.
+	Destructive string `hcl:"destructive,optional"`
+}
+
+// Sandbox is the settings for running commands in a sandbox, on a copy of
+// the working directory, whose changes are reviewed before they're applied.
+// Limits set to zero don't restrict.
+type Sandbox struct {
+	// Enabled runs commands in a sandbox by default. It's only supported
+	// on Linux.
+	Enabled bool `hcl:"enabled,optional"`
+
+	// Network lets commands use the network.
+	Network bool `hcl:"network,optional"`
+
+	// Timeout is how long commands can run for, as a duration string like
+	// "1m".
+	Timeout string `hcl:"timeout,optional"`
+
+	// CPUTime is how much CPU time commands can use, as a duration string.
+	CPUTime string `hcl:"cpu_time,optional"`
+
+	// MemoryMB is how many megabytes of memory each process can use.
+	MemoryMB int `hcl:"memory_mb,optional"`
+
+	// FileSizeMB is how large, in megabytes, the files commands write can
+	// be.
+	FileSizeMB int `hcl:"file_size_mb,optional"`
+
+	// MaxCopySizeMB is how large, in megabytes, the working directory can
+	// be to be copied into the sandbox.
+	MaxCopySizeMB int `hcl:"max_copy_size_mb,optional"`
+
+	// Env are the names of environment variables passed to commands, on
+	// top of a few like PATH. Others, like OPENAI_API_KEY, aren't.
+	Env []string `hcl:"env,optional"`
+}
+
+// Default returns the default configuration.
+func Default() *Config {
+	return &Config{
+		Model:           openai.ModelGPT35Turbo,
+		Timeout:         "120s",
+		SystemMessage:   chat.SystemMessage.Content,
+		DefaultProvider: ProviderTypeOpenAI,
+		Theme: &Theme{
+			Primary:  "69",
+			Messages: "63",
+			Tokens:   "62",
+			Error:    "124",
+		},
+		Keys: &Keys{
+			Quit:           []string{"ctrl+c"},
+			Send:           []string{"esc"},
+			Cancel:         []string{"ctrl+x"},
+			Back:           []string{"ctrl+l"},
+			ExternalEditor: []string{"ctrl+e"},
+			Truncate:       []string{"ctrl+t"},
+			Regenerate:     []string{"ctrl+r"},
+			PreviousBranch: []string{"ctrl+left"},
+			NextBranch:     []string{"ctrl+right"},
+			ThreadTree:     []string{"ctrl+g"},
+			Shell:          []string{"ctrl+o"},
+			ReviewPatch:    []string{"ctrl+p"},
+
+			NewThread:       []string{"n"},
+			RenameThread:    []string{"r"},
+			DuplicateThread: []string{"c"},
+			DeleteThread:    []string{"x"},
+			SwitchProvider:  []string{"p"},
+			SearchThreads:   []string{"s"},
+			ExportThread:    []string{"e"},
+		},
+		Editor: &Editor{
+			CharLimit:   4096,
+			LineNumbers: true,
+		},
+		Compaction: &Compaction{
+			Enabled:   true,
+			Threshold: 0.75,
+			Keep:      4,
+		},
+		Search: &Search{
+			Mode:     chat.SearchLiteral.String(),
+			Language: chat.DefaultSearchLanguage.String(),
+		},
+		Shell: &Shell{
+			Confirm: &Confirm{
+				ReadOnly:    policy.DefaultLevels[policy.ReadOnly].String(),
+				Write:       policy.DefaultLevels[policy.Write].String(),
+				Network:     policy.DefaultLevels[policy.Network].String(),
+				Destructive: policy.DefaultLevels[policy.Destructive].String(),
+			},
+			Sandbox: &Sandbox{
+				Timeout:       sandbox.DefaultOptions.Timeout.String(),
+				CPUTime:       sandbox.DefaultOptions.CPUTime.String(),
+				MemoryMB:      int(sandbox.DefaultOptions.Memory >> 20),
+				FileSizeMB:    int(sandbox.DefaultOptions.FileSize >> 20),
+				MaxCopySizeMB: int(sandbox.DefaultOptions.MaxCopySize >> 20),
+			},
+		},
+	}
+}
+
+// DefaultPath returns the default path of the configuration file, which is
+// $XDG_CONFIG_HOME/hal/config.hcl, falling back to ~/.config/hal/config.hcl
+// if XDG_CONFIG_HOME is not set.
+//
+// The HAL_CONFIG environment variable can be used to point to a different
+// file instead.
+func DefaultPath() (string, error) {
+	if path := os.Getenv("HAL_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find home directory: %w", err)
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(configHome, "hal", "config.hcl"), nil
+}
+
+// Load reads the configuration file at the given path, on top of the
+// default configuration. If the file doesn't exist, the default
+// configuration is returned.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	src, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := Parse(cfg, path, src); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Parse decodes the HCL source on top of the given configuration, and then
+// validates the result. The filename is only used in error messages.
+func Parse(cfg *Config, filename string, src []byte) error {
+	file, diags := hclparse.NewParser().ParseHCL(src, filename)
+	if diags.HasErrors() {
+		return fmt.Errorf("failed to parse config file: %s", diagnosticsError(diags))
+	}
+
+	if diags := gohcl.DecodeBody(file.Body, nil, cfg); diags.HasErrors() {
+		return fmt.Errorf("failed to decode config file: %s", diagnosticsError(diags))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", filename, err)
+	}
+
+	return nil
+}
+
+// diagnosticsError formats the HCL diagnostics, which include the file name
+// and position of each problem, into a single error message.
+func diagnosticsError(diags hcl.Diagnostics) string {
+	msg := ""
+	for i, diag := range diags {
+		if i > 0 {
+			msg += "; "
+		}
+		msg += diag.Error()
+	}
+	return msg
+}
+
+// Validate checks that the configuration is usable.
+func (cfg *Config) Validate() error {
+	if cfg.Model == "" {
+		return errors.New("model must not be empty")
+	}
+
+	if cfg.Temperature < 0 || cfg.Temperature > 2 {
+		return fmt.Errorf("temperature must be between 0 and 2, got %v", cfg.Temperature)
+	}
+
+	if cfg.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative, got %d", cfg.MaxTokens)
+	}
+
+	if cfg.ContextWindow < 0 {
+		return fmt.Errorf("context_window must not be negative, got %d", cfg.ContextWindow)
+	}
+
+	if timeout, err := time.ParseDuration(cfg.Timeout); err != nil {
+		return fmt.Errorf("timeout must be a duration like \"2m\" or \"90s\", got %q", cfg.Timeout)
+	} else if timeout <= 0 {
+		return fmt.Errorf("timeout must be positive, got %q", cfg.Timeout)
+	}
+
+	if cfg.SystemMessage == "" {
+		return errors.New("system_message must not be empty")
+	}
+
+	for _, color := range []struct{ name, value string }{
+		{"primary", cfg.Theme.Primary},
+		{"messages", cfg.Theme.Messages},
+		{"tokens", cfg.Theme.Tokens},
+		{"error", cfg.Theme.Error},
+	} {
+		if !validColor(color.value) {
+			return fmt.Errorf("theme %s must be an ANSI color number (0-255) or hex color (#rrggbb), got %q", color.name, color.value)
+		}
+	}
+
+	for _, binding := range cfg.Keys.bindings() {
+		if len(binding.keys) == 0 {
+			return fmt.Errorf("keys %s must have at least one key", binding.name)
+		}
+		for _, k := range binding.keys {
+			if k == "" {
+				return fmt.Errorf("keys %s must not contain an empty key", binding.name)
+			}
+		}
+	}
+
+	if cfg.Editor.CharLimit < 0 {
+		return fmt.Errorf("editor char_limit must not be negative, got %d", cfg.Editor.CharLimit)
+	}
+
+	if cfg.Compaction.Threshold <= 0 || cfg.Compaction.Threshold > 1 {
+		return fmt.Errorf("compaction threshold must be between 0 and 1, got %v", cfg.Compaction.Threshold)
+	}
+
+	if cfg.Compaction.Keep < 0 {
+		return fmt.Errorf("compaction keep must not be negative, got %d", cfg.Compaction.Keep)
+	}
+
+	if _, err := chat.ParseSearchMode(cfg.Search.Mode); err != nil {
+		return err
+	}
+
+	if _, err := language.Parse(cfg.Search.Language); err != nil {
+		return fmt.Errorf("search language must be a BCP 47 language tag like \"en-US\", got %q", cfg.Search.Language)
+	}
+
+	for _, rules := range []struct {
+		name  string
+		rules []string
+	}{
+		{"allow", cfg.Shell.Allow},
+		{"deny", cfg.Shell.Deny},
+	} {
+		for _, rule := range rules.rules {
+			if strings.TrimSpace(rule) == "" {
+				return fmt.Errorf("shell %s must not contain an empty rule", rules.name)
+			}
+		}
+	}
+
+	levels := cfg.Shell.Confirm.levels()
+	for _, class := range policy.Classes {
+		if _, err := policy.ParseLevel(levels[class]); err != nil {
+			return fmt.Errorf("shell confirm %s: %w", class, err)
+		}
+	}
+
+	for _, duration := range []struct{ name, value string }{
+		{"timeout", cfg.Shell.Sandbox.Timeout},
+		{"cpu_time", cfg.Shell.Sandbox.CPUTime},
+	} {
+		if d, err := time.ParseDuration(duration.value); err != nil {
+			return fmt.Errorf("shell sandbox %s must be a duration like \"1m\" or \"90s\", got %q", duration.name, duration.value)
+		} else if d < 0 {
+			return fmt.Errorf("shell sandbox %s must not be negative, got %q", duration.name, duration.value)
+		}
+	}
+
+	for _, size := range []struct {
+		name  string
+		value int
+	}{
+		{"memory_mb", cfg.Shell.Sandbox.MemoryMB},
+		{"file_size_mb", cfg.Shell.Sandbox.FileSizeMB},
+		{"max_copy_size_mb", cfg.Shell.Sandbox.MaxCopySizeMB},
+	} {
+		if size.value < 0 {
+			return fmt.Errorf("shell sandbox %s must not be negative, got %d", size.name, size.value)
+		}
+	}
+
+	for _, name := range cfg.Shell.Sandbox.Env {
+		if name == "" || strings.ContainsAny(name, "= ") {
+			return fmt.Errorf("shell sandbox env must only contain environment variable names, got %q", name)
+		}
+	}
+
+	names := map[string]bool{}
+
+	for _, p := range cfg.Providers {
+		if p.Name == "" {
+			return errors.New("provider name must not be empty")
+		}
+
+		if names[p.Name] {
+			return fmt.Errorf("provider %q is defined more than once", p.Name)
+		}
+		names[p.Name] = true
+
+		if p.ContextWindow < 0 {
+			return fmt.Errorf("provider %q context_window must not be negative, got %d", p.Name, p.ContextWindow)
+		}
+
+		switch p.Type {
+		case ProviderTypeOpenAI:
+		case ProviderTypeOpenAICompatible:
+			if p.BaseURL == "" {
+				return fmt.Errorf("provider %q must set base_url", p.Name)
+			}
+			if p.Model == "" {
+				return fmt.Errorf("provider %q must set model", p.Name)
+			}
+		default:
+			return fmt.Errorf("provider %q type must be %q or %q, got %q", p.Name, ProviderTypeOpenAI, ProviderTypeOpenAICompatible, p.Type)
+		}
+	}
+
+	if cfg.Provider(cfg.DefaultProvider) == nil {
+		return fmt.Errorf("default_provider %q is not a configured provider", cfg.DefaultProvider)
+	}
+
+	return nil
+}
+
+// AllProviders returns the configured providers, including the built-in
+// "openai" provider unless it was replaced.
+func (cfg *Config) AllProviders() []*Provider {
+	providers := []*Provider{}
+
+	builtin := true
+	for _, p := range cfg.Providers {
+		if p.Name == ProviderTypeOpenAI {
+			builtin = false
+		}
+	}
+
+	if builtin {
+		providers = append(providers, &Provider{
+			Name:  ProviderTypeOpenAI,
+			Type:  ProviderTypeOpenAI,
+			Model: cfg.Model,
+		})
+	}
+
+	return append(providers, cfg.Providers...)
+}
+
+// Provider returns the provider with the given name, or nil if there is
+// no provider with that name.
+func (cfg *Config) Provider(name string) *Provider {
+	for _, p := range cfg.AllProviders() {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// ProviderModel returns the model used by the provider, which defaults to
+// the top-level model.
+func (cfg *Config) ProviderModel(p *Provider) string {
+	if p.Model != "" {
+		return p.Model
+	}
+	return cfg.Model
+}
+
+// ProviderContextWindow returns the context window of the provider's model,
+// from the configuration or the known context window of the model.
+func (cfg *Config) ProviderContextWindow(p *Provider) int {
+	switch {
+	case p.ContextWindow > 0:
+		return p.ContextWindow
+	case cfg.ContextWindow > 0:
+		return cfg.ContextWindow
+	default:
+		return chat.ContextWindow(cfg.ProviderModel(p))
+	}
+}
+
+// SearchOptions returns the options to search threads with, which are
+// assumed to be valid.
+func (cfg *Config) SearchOptions() chat.SearchOptions {
+	mode, _ := chat.ParseSearchMode(cfg.Search.Mode)
+
+	return chat.SearchOptions{
+		Mode:          mode,
+		CaseSensitive: cfg.Search.CaseSensitive,
+		Language:      language.Make(cfg.Search.Language),
+	}
+}
+
+// ShellPolicy returns the policy deciding which commands proposed in shell
+// mode can run, which is assumed to be valid.
+func (cfg *Config) ShellPolicy() *policy.Policy {
+	p := &policy.Policy{
+		Allow:  cfg.Shell.Allow,
+		Deny:   cfg.Shell.Deny,
+		Levels: map[policy.Class]policy.Level{},
+	}
+
+	for class, name := range cfg.Shell.Confirm.levels() {
+		p.Levels[class], _ = policy.ParseLevel(name)
+	}
+
+	return p
+}
+
+// AuditLogPath returns the path of the audit log of shell commands, which is
+// next to the default thread store directory by default.
+func (cfg *Config) AuditLogPath() (string, error) {
+	if cfg.Shell.AuditLog != "" {
+		return cfg.Shell.AuditLog, nil
+	}
+
+	dir, err := chat.DefaultStoreDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(dir), "audit.log"), nil
+}
+
+// SandboxOptions returns the restrictions of the sandbox shell commands run
+// in, which are assumed to be valid.
+func (cfg *Config) SandboxOptions() sandbox.Options {
+	timeout, _ := time.ParseDuration(cfg.Shell.Sandbox.Timeout)
+	cpuTime, _ := time.ParseDuration(cfg.Shell.Sandbox.CPUTime)
+
+	return sandbox.Options{
+		Network:     cfg.Shell.Sandbox.Network,
+		Timeout:     timeout,
+		CPUTime:     cpuTime,
+		Memory:      int64(cfg.Shell.Sandbox.MemoryMB) << 20,
+		FileSize:    int64(cfg.Shell.Sandbox.FileSizeMB) << 20,
+		MaxCopySize: int64(cfg.Shell.Sandbox.MaxCopySizeMB) << 20,
+		Env:         cfg.Shell.Sandbox.Env,
+	}
+}
+
+// TimeoutDuration returns the parsed timeout, which is assumed to be valid.
+func (cfg *Config) TimeoutDuration() time.Duration {
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+	return timeout
+}
+
+// ChatOptions returns the options to use for chat requests, leaving the
+// model to each provider.
+func (cfg *Config) ChatOptions() chat.Options {
+	return chat.Options{
+		Temperature: cfg.Temperature,
+		MaxTokens:   cfg.MaxTokens,
+	}
+}
+
+// bindings returns the key bindings with their configuration name.
+func (k *Keys) bindings() []struct {
+	name string
+	keys []string
+} {
+	return []struct {
+		name string
+		keys []string
+	}{
+		{"quit", k.Quit},
+		{"send", k.Send},
+		{"cancel", k.Cancel},
+		{"back", k.Back},
+		{"external_editor", k.ExternalEditor},
+		{"truncate", k.Truncate},
+		{"regenerate", k.Regenerate},
+		{"previous_branch", k.PreviousBranch},
+		{"next_branch", k.NextBranch},
+		{"thread_tree", k.ThreadTree},
+		{"shell", k.Shell},
+		{"review_patch", k.ReviewPatch},
+		{"new_thread", k.NewThread},
+		{"rename_thread", k.RenameThread},
+		{"duplicate_thread", k.DuplicateThread},
+		{"delete_thread", k.DeleteThread},
+		{"switch_provider", k.SwitchProvider},
+		{"search_threads", k.SearchThreads},
+		{"export_thread", k.ExportThread},
+	}
+}
+
+// levels returns the configured level of each class of command.
+func (c *Confirm) levels() map[policy.Class]string {
+	return map[policy.Class]string{
+		policy.ReadOnly:    c.ReadOnly,
+		policy.Write:       c.Write,
+		policy.Network:     c.Network,
+		policy.Destructive: c.Destructive,
+	}
+}
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// validColor returns true if the color is an ANSI color number or hex color.
+func validColor(color string) bool {
+	if hexColor.MatchString(color) {
+		return true
+	}
+
+	n, err := strconv.Atoi(color)
+	return err == nil && n >= 0 && n <= 255
+}
@@ -0,0 +1,402 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/policy"
+	"github.com/picatz/hal/pkg/sandbox"
+)
+
+func TestDefault(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("expected default config to be valid: %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.hcl")
+
+	src := `
+model       = "gpt-4"
+temperature = 0.5
+timeout     = "2m"
+
+theme {
+  primary = "#ff00ff"
+}
+
+keys {
+  send = ["ctrl+s", "esc"]
+}
+
+editor {
+  command    = "nvim"
+  char_limit = 8192
+}
+`
+
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Model != "gpt-4" {
+		t.Fatalf("expected model gpt-4, got %q", cfg.Model)
+	}
+
+	if cfg.Temperature != 0.5 {
+		t.Fatalf("expected temperature 0.5, got %v", cfg.Temperature)
+	}
+
+	if cfg.TimeoutDuration() != 2*time.Minute {
+		t.Fatalf("expected timeout of 2m, got %v", cfg.TimeoutDuration())
+	}
+
+	if cfg.Theme.Primary != "#ff00ff" {
+		t.Fatalf("expected primary color #ff00ff, got %q", cfg.Theme.Primary)
+	}
+
+	// Settings that aren't in the file keep their defaults.
+	if cfg.Theme.Tokens != Default().Theme.Tokens {
+		t.Fatalf("expected default tokens color, got %q", cfg.Theme.Tokens)
+	}
+
+	if strings.Join(cfg.Keys.Send, ",") != "ctrl+s,esc" {
+		t.Fatalf("unexpected send keys: %q", cfg.Keys.Send)
+	}
+
+	if strings.Join(cfg.Keys.Cancel, ",") != "ctrl+x" {
+		t.Fatalf("expected default cancel keys, got %q", cfg.Keys.Cancel)
+	}
+
+	if cfg.Editor.Command != "nvim" || cfg.Editor.CharLimit != 8192 || !cfg.Editor.LineNumbers {
+		t.Fatalf("unexpected editor config: %+v", cfg.Editor)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), "missing.hcl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Model != Default().Model {
+		t.Fatalf("expected default model, got %q", cfg.Model)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "syntax error",
+			src:  `model = `,
+			want: "config.hcl:1",
+		},
+		{
+			name: "unknown setting",
+			src:  "\nmodle = \"gpt-4\"",
+			want: "config.hcl:2",
+		},
+		{
+			name: "wrong type",
+			src:  `max_tokens = "lots"`,
+			want: "config.hcl:1",
+		},
+		{
+			name: "temperature out of range",
+			src:  `temperature = 3`,
+			want: "temperature must be between 0 and 2",
+		},
+		{
+			name: "invalid timeout",
+			src:  `timeout = "soon"`,
+			want: "timeout must be a duration",
+		},
+		{
+			name: "invalid color",
+			src:  `theme { error = "red" }`,
+			want: "theme error must be an ANSI color number",
+		},
+		{
+			name: "negative context window",
+			src:  `context_window = -1`,
+			want: "context_window must not be negative",
+		},
+		{
+			name: "compaction threshold out of range",
+			src:  `compaction { threshold = 1.5 }`,
+			want: "compaction threshold must be between 0 and 1",
+		},
+		{
+			name: "unknown search mode",
+			src:  `search { mode = "exact" }`,
+			want: "search mode must be",
+		},
+		{
+			name: "invalid search language",
+			src:  `search { language = "not a language" }`,
+			want: "search language must be a BCP 47 language tag",
+		},
+		{
+			name: "unknown shell confirmation level",
+			src: `shell {
+  confirm { network = "ask" }
+}`,
+			want: `shell confirm network: confirmation level must be`,
+		},
+		{
+			name: "empty shell rule",
+			src:  `shell { deny = [""] }`,
+			want: "shell deny must not contain an empty rule",
+		},
+		{
+			name: "invalid sandbox timeout",
+			src: `shell {
+  sandbox { timeout = "soon" }
+}`,
+			want: `shell sandbox timeout must be a duration`,
+		},
+		{
+			name: "negative sandbox memory",
+			src: `shell {
+  sandbox { memory_mb = -1 }
+}`,
+			want: "shell sandbox memory_mb must not be negative",
+		},
+		{
+			name: "invalid sandbox env",
+			src: `shell {
+  sandbox { env = ["GOPATH=/tmp"] }
+}`,
+			want: "shell sandbox env must only contain environment variable names",
+		},
+		{
+			name: "empty key binding",
+			src:  `keys { quit = [] }`,
+			want: "keys quit must have at least one key",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Parse(Default(), "config.hcl", []byte(test.src))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("expected error to contain %q, got %q", test.want, err)
+			}
+		})
+	}
+}
+
+func TestProviders(t *testing.T) {
+	cfg := Default()
+
+	src := `
+default_provider = "local"
+
+provider "local" {
+  type     = "openai-compatible"
+  base_url = "http://localhost:11434/v1"
+  model    = "llama2"
+}
+`
+
+	if err := Parse(cfg, "config.hcl", []byte(src)); err != nil {
+		t.Fatal(err)
+	}
+
+	providers := cfg.AllProviders()
+	if len(providers) != 2 {
+		t.Fatalf("expected the built-in and local providers, got %d", len(providers))
+	}
+
+	if openai := cfg.Provider("openai"); openai == nil || openai.Model != cfg.Model {
+		t.Fatalf("expected built-in openai provider using the top-level model, got %+v", openai)
+	}
+
+	local := cfg.Provider("local")
+	if local == nil || local.BaseURL != "http://localhost:11434/v1" {
+		t.Fatalf("unexpected local provider: %+v", local)
+	}
+
+	// Unknown models use the default context window, unless it's configured.
+	if n := cfg.ProviderContextWindow(local); n != chat.DefaultContextWindow {
+		t.Fatalf("expected the default context window, got %d", n)
+	}
+
+	local.ContextWindow = 2048
+	if n := cfg.ProviderContextWindow(local); n != 2048 {
+		t.Fatalf("expected the configured context window, got %d", n)
+	}
+
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "unknown default provider",
+			src:  `default_provider = "missing"`,
+			want: `default_provider "missing" is not a configured provider`,
+		},
+		{
+			name: "unknown type",
+			src:  `provider "other" { type = "magic" }`,
+			want: `provider "other" type must be`,
+		},
+		{
+			name: "missing base url",
+			src:  `provider "local" { type = "openai-compatible" }`,
+			want: `provider "local" must set base_url`,
+		},
+		{
+			name: "duplicate",
+			src: `
+provider "local" {
+  type     = "openai-compatible"
+  base_url = "http://localhost:8080"
+  model    = "llama2"
+}
+
+provider "local" {
+  type     = "openai-compatible"
+  base_url = "http://localhost:8080"
+  model    = "llama2"
+}`,
+			want: `provider "local" is defined more than once`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Parse(Default(), "config.hcl", []byte(test.src))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("expected error to contain %q, got %q", test.want, err)
+			}
+		})
+	}
+}
+
+func TestSearchOptions(t *testing.T) {
+	cfg := Default()
+
+	if err := Parse(cfg, "config.hcl", []byte(`search {
+  mode     = "fuzzy"
+  language = "tr"
+}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := cfg.SearchOptions()
+	if opts.Mode != chat.SearchFuzzy || opts.Language != language.Turkish || opts.CaseSensitive {
+		t.Fatalf("unexpected search options: %+v", opts)
+	}
+
+	if opts := Default().SearchOptions(); opts.Mode != chat.SearchLiteral || opts.Language != chat.DefaultSearchLanguage {
+		t.Fatalf("unexpected default search options: %+v", opts)
+	}
+}
+
+func TestShellPolicy(t *testing.T) {
+	cfg := Default()
+
+	if err := Parse(cfg, "config.hcl", []byte(`shell {
+  allow = ["git status *"]
+  deny  = ["git push *"]
+
+  confirm {
+    network = "deny"
+  }
+}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	p := cfg.ShellPolicy()
+	if len(p.Allow) != 1 || len(p.Deny) != 1 {
+		t.Fatalf("unexpected rules: %+v", p)
+	}
+
+	// The classes that weren't configured keep their default level.
+	want := map[policy.Class]policy.Level{
+		policy.ReadOnly:    policy.LevelNone,
+		policy.Write:       policy.LevelConfirm,
+		policy.Network:     policy.LevelDeny,
+		policy.Destructive: policy.LevelType,
+	}
+	for class, level := range want {
+		if p.Levels[class] != level {
+			t.Fatalf("expected %s to be %s, got %s", class, level, p.Levels[class])
+		}
+	}
+
+	if d := p.Check("curl https://example.com"); d.Level != policy.LevelDeny {
+		t.Fatalf("expected network commands to be denied, got %s", d.Level)
+	}
+}
+
+func TestSandboxOptions(t *testing.T) {
+	if opts := Default().SandboxOptions(); opts.Timeout != sandbox.DefaultOptions.Timeout || opts.Memory != sandbox.DefaultOptions.Memory || opts.Network {
+		t.Fatalf("unexpected default sandbox options: %+v", opts)
+	}
+
+	cfg := Default()
+
+	if err := Parse(cfg, "config.hcl", []byte(`shell {
+  sandbox {
+    enabled   = true
+    timeout   = "5m"
+    memory_mb = 0
+    env       = ["GOPATH"]
+  }
+}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !cfg.Shell.Sandbox.Enabled {
+		t.Fatal("expected the sandbox to be enabled")
+	}
+
+	// Limits that weren't configured keep their default.
+	opts := cfg.SandboxOptions()
+	if opts.Timeout != 5*time.Minute || opts.CPUTime != sandbox.DefaultOptions.CPUTime || opts.Memory != 0 || len(opts.Env) != 1 {
+		t.Fatalf("unexpected sandbox options: %+v", opts)
+	}
+}
+
+func TestAuditLogPath(t *testing.T) {
+	dataHome := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataHome)
+
+	if path, err := Default().AuditLogPath(); err != nil || path != filepath.Join(dataHome, "hal", "audit.log") {
+		t.Fatalf("expected the audit log next to the threads, got %q, %v", path, err)
+	}
+
+	cfg := Default()
+	cfg.Shell.AuditLog = "/var/log/hal.log"
+
+	if path, err := cfg.AuditLogPath(); err != nil || path != "/var/log/hal.log" {
+		t.Fatalf("expected the configured audit log, got %q, %v", path, err)
+	}
+}
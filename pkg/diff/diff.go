@@ -0,0 +1,383 @@
+// Package diff finds the differences between the lines of two texts, and
+// formats them as unified diffs.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op is what an edit does to a line.
+type Op int
+
+const (
+	// Equal keeps a line that is in both texts.
+	Equal Op = iota
+
+	// Delete removes a line of the old text.
+	Delete
+
+	// Insert adds a line of the new text.
+	Insert
+)
+
+// String returns the prefix of lines with the op in a unified diff.
+func (op Op) String() string {
+	switch op {
+	case Delete:
+		return "-"
+	case Insert:
+		return "+"
+	default:
+		return " "
+	}
+}
+
+// Edit is a line of a diff.
+type Edit struct {
+	Op Op
+
+	// Line is the line, including its line ending, which the last line of
+	// a text might not have.
+	Line string
+}
+
+// maxEdits is the number of differences after which the shortest diff stops
+// being searched for, and the rest of the texts are replaced as a whole, so
+// diffing very different texts stays fast.
+const maxEdits = 2000
+
+// SplitLines splits the text into lines, keeping their line endings.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// Edits returns the shortest list of edits turning the old lines into the
+// new lines, using Myers' algorithm.
+func Edits(old, new []string) []Edit {
+	// Lines in common at the start and the end are kept as they are.
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]Edit, 0, len(old)+len(new))
+	for _, line := range old[:prefix] {
+		edits = append(edits, Edit{Equal, line})
+	}
+
+	edits = append(edits, myers(old[prefix:len(old)-suffix], new[prefix:len(new)-suffix])...)
+
+	for _, line := range old[len(old)-suffix:] {
+		edits = append(edits, Edit{Equal, line})
+	}
+
+	return edits
+}
+
+// myers returns the edits turning a into b, keeping the furthest reaching
+// path of each diagonal at each number of differences, to walk it back once
+// the end is reached.
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	var (
+		offset = n + m + 1
+		v      = make([]int, 2*offset+1)
+		trace  [][]int
+	)
+
+	for d := 0; d <= n+m; d++ {
+		if d > maxEdits {
+			return replace(a, b)
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+				return backtrack(trace, a, b)
+			}
+		}
+
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+
+	return replace(a, b)
+}
+
+// backtrack walks the path found by myers back from the end, where the
+// trace has the furthest x of each diagonal k, from -d to d, for each d.
+func backtrack(trace [][]int, a, b []string) []Edit {
+	var (
+		edits []Edit
+		x, y  = len(a), len(b)
+	)
+
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		furthest := func(k int) int { return prev[k+d-1] }
+
+		k := x - y
+
+		prevK := k - 1
+		if k == -d || k != d && furthest(k-1) < furthest(k+1) {
+			prevK = k + 1
+		}
+
+		prevX := furthest(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			edits = append(edits, Edit{Equal, a[x-1]})
+			x--
+			y--
+		}
+
+		if x == prevX {
+			edits = append(edits, Edit{Insert, b[y-1]})
+			y--
+		} else {
+			edits = append(edits, Edit{Delete, a[x-1]})
+			x--
+		}
+	}
+
+	for x > 0 && y > 0 {
+		edits = append(edits, Edit{Equal, a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+
+	return edits
+}
+
+// replace returns the edits deleting all of a, and inserting all of b.
+func replace(a, b []string) []Edit {
+	edits := make([]Edit, 0, len(a)+len(b))
+	for _, line := range a {
+		edits = append(edits, Edit{Delete, line})
+	}
+	for _, line := range b {
+		edits = append(edits, Edit{Insert, line})
+	}
+	return edits
+}
+
+// Hunk is a group of changed lines, with the lines around them as context.
+type Hunk struct {
+	// OldStart and NewStart are the indexes of the hunk's first line in
+	// the old and the new lines.
+	OldStart, NewStart int
+
+	// OldLines and NewLines are the number of lines of the hunk in the
+	// old and the new lines.
+	OldLines, NewLines int
+
+	Edits []Edit
+}
+
+// Header returns the header of the hunk in a unified diff, like
+// "@@ -1,4 +1,5 @@", where lines are counted from 1.
+func (h Hunk) Header() string {
+	oldStart, newStart := h.OldStart+1, h.NewStart+1
+	if h.OldLines == 0 {
+		oldStart--
+	}
+	if h.NewLines == 0 {
+		newStart--
+	}
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", oldStart, h.OldLines, newStart, h.NewLines)
+}
+
+// Hunks groups the changes of the edits into hunks, with up to the given
+// number of unchanged lines around them. Changes closer than twice that
+// are in the same hunk.
+func Hunks(edits []Edit, context int) []Hunk {
+	var (
+		hunks    []Hunk
+		cur      *Hunk
+		oldLine  int
+		newLine  int
+		trailing int // unchanged lines at the end of cur
+	)
+
+	for i, edit := range edits {
+		if edit.Op != Equal {
+			if cur == nil {
+				// Start with the unchanged lines before the change.
+				start := i
+				for start > 0 && i-start < context && edits[start-1].Op == Equal {
+					start--
+				}
+
+				cur = &Hunk{OldStart: oldLine - (i - start), NewStart: newLine - (i - start)}
+				for _, e := range edits[start:i] {
+					cur.add(e)
+				}
+			}
+
+			cur.add(edit)
+			trailing = 0
+		} else if cur != nil {
+			// Unchanged lines continue the hunk if another change is
+			// close enough.
+			next := i
+			for next < len(edits) && edits[next].Op == Equal {
+				next++
+			}
+
+			if trailing < context || next < len(edits) && next-i+trailing < 2*context+1 {
+				cur.add(edit)
+				trailing++
+			} else {
+				cur.trim(trailing - context)
+				hunks = append(hunks, *cur)
+				cur, trailing = nil, 0
+			}
+		}
+
+		switch edit.Op {
+		case Equal:
+			oldLine++
+			newLine++
+		case Delete:
+			oldLine++
+		case Insert:
+			newLine++
+		}
+	}
+
+	if cur != nil {
+		cur.trim(trailing - context)
+		hunks = append(hunks, *cur)
+	}
+
+	return hunks
+}
+
+// add adds the edit at the end of the hunk.
+func (h *Hunk) add(e Edit) {
+	h.Edits = append(h.Edits, e)
+	if e.Op != Insert {
+		h.OldLines++
+	}
+	if e.Op != Delete {
+		h.NewLines++
+	}
+}
+
+// trim removes n unchanged lines from the end of the hunk.
+func (h *Hunk) trim(n int) {
+	for ; n > 0; n-- {
+		h.Edits = h.Edits[:len(h.Edits)-1]
+		h.OldLines--
+		h.NewLines--
+	}
+}
+
+// Context is the number of unchanged lines shown around changes in unified
+// diffs.
+const Context = 3
+
+// Unified returns the unified diff of the old and the new text, with the
+// given names in its header, or an empty string if they're the same.
+func Unified(oldName, newName, old, new string) string {
+	hunks := Hunks(Edits(SplitLines(old), SplitLines(new)), Context)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+
+	for _, hunk := range hunks {
+		b.WriteString(hunk.Header() + "\n")
+		WriteEdits(&b, hunk.Edits)
+	}
+
+	return b.String()
+}
+
+// WriteEdits writes the edits as lines of a unified diff, marking lines
+// without a line ending.
+func WriteEdits(b *strings.Builder, edits []Edit) {
+	for _, edit := range edits {
+		b.WriteString(edit.Op.String())
+		b.WriteString(edit.Line)
+
+		if !strings.HasSuffix(edit.Line, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// Apply returns the old lines with the changes of the accepted hunks made,
+// where accepted has whether each hunk is accepted. The hunks have to be of
+// the old lines, in order.
+func Apply(old []string, hunks []Hunk, accepted []bool) string {
+	var (
+		b    strings.Builder
+		line int
+	)
+
+	for i, hunk := range hunks {
+		if i >= len(accepted) || !accepted[i] {
+			continue
+		}
+
+		for _, l := range old[line:hunk.OldStart] {
+			b.WriteString(l)
+		}
+
+		for _, edit := range hunk.Edits {
+			if edit.Op != Delete {
+				b.WriteString(edit.Line)
+			}
+		}
+
+		line = hunk.OldStart + hunk.OldLines
+	}
+
+	for _, l := range old[line:] {
+		b.WriteString(l)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,232 @@
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestEdits(t *testing.T) {
+	old := SplitLines("a\nb\nc\nd\ne\n")
+	new := SplitLines("a\nc\nd\nx\ne\nf\n")
+
+	var b strings.Builder
+	for _, edit := range Edits(old, new) {
+		b.WriteString(edit.Op.String() + edit.Line)
+	}
+
+	if want := " a\n-b\n c\n d\n+x\n e\n+f\n"; b.String() != want {
+		t.Fatalf("expected:\n%s\ngot:\n%s", want, b.String())
+	}
+}
+
+func TestEditsRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	random := func() []string {
+		lines := make([]string, r.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a'+r.Intn(4))) + "\n"
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		old, new := random(), random()
+
+		var gotOld, gotNew []string
+		for _, edit := range Edits(old, new) {
+			if edit.Op != Insert {
+				gotOld = append(gotOld, edit.Line)
+			}
+			if edit.Op != Delete {
+				gotNew = append(gotNew, edit.Line)
+			}
+		}
+
+		if strings.Join(gotOld, "") != strings.Join(old, "") || strings.Join(gotNew, "") != strings.Join(new, "") {
+			t.Fatalf("edits don't turn %q into %q", old, new)
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	var old, new strings.Builder
+	for i := 1; i <= 20; i++ {
+		line := strings.Repeat("x", i) + "\n"
+		old.WriteString(line)
+		switch i {
+		case 2:
+			new.WriteString("changed\n")
+		case 18:
+		default:
+			new.WriteString(line)
+		}
+	}
+	new.WriteString("last")
+
+	want := `--- a/file
++++ b/file
+@@ -1,5 +1,5 @@
+ x
+-xx
++changed
+ xxx
+ xxxx
+ xxxxx
+@@ -15,6 +15,6 @@
+ xxxxxxxxxxxxxxx
+ xxxxxxxxxxxxxxxx
+ xxxxxxxxxxxxxxxxx
+-xxxxxxxxxxxxxxxxxx
+ xxxxxxxxxxxxxxxxxxx
+ xxxxxxxxxxxxxxxxxxxx
++last
+\ No newline at end of file
+`
+
+	if got := Unified("a/file", "b/file", old.String(), new.String()); got != want {
+		t.Fatalf("expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	if got := Unified("a", "b", "same\n", "same\n"); got != "" {
+		t.Fatalf("expected no diff, got:\n%s", got)
+	}
+
+	if got := Unified("a", "b", "", "new\n"); got != "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+new\n" {
+		t.Fatalf("unexpected diff of a new file:\n%s", got)
+	}
+}
+
+func TestApply(t *testing.T) {
+	var old, new strings.Builder
+	for i := 1; i <= 20; i++ {
+		line := strings.Repeat("x", i) + "\n"
+		old.WriteString(line)
+		switch i {
+		case 2:
+			new.WriteString("changed\n")
+		case 18:
+		default:
+			new.WriteString(line)
+		}
+	}
+
+	oldLines := SplitLines(old.String())
+	hunks := Hunks(Edits(oldLines, SplitLines(new.String())), Context)
+	if len(hunks) != 2 {
+		t.Fatalf("expected 2 hunks, got %d", len(hunks))
+	}
+
+	if got := Apply(oldLines, hunks, []bool{true, true}); got != new.String() {
+		t.Fatalf("expected all hunks to make the new text, got:\n%s", got)
+	}
+
+	if got := Apply(oldLines, hunks, nil); got != old.String() {
+		t.Fatalf("expected no hunks to keep the old text, got:\n%s", got)
+	}
+
+	want := strings.Replace(old.String(), "xx\n", "changed\n", 1)
+	if got := Apply(oldLines, hunks, []bool{true, false}); got != want {
+		t.Fatalf("expected only the first hunk, got:\n%s", got)
+	}
+
+	// Any set of hunks applies cleanly.
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		a := make([]string, r.Intn(40))
+		for i := range a {
+			a[i] = string(rune('a'+r.Intn(5))) + "\n"
+		}
+		b := make([]string, r.Intn(40))
+		for i := range b {
+			b[i] = string(rune('a'+r.Intn(5))) + "\n"
+		}
+
+		hunks := Hunks(Edits(a, b), Context)
+		all := make([]bool, len(hunks))
+		for i := range all {
+			all[i] = true
+		}
+
+		if got := Apply(a, hunks, all); got != strings.Join(b, "") {
+			t.Fatalf("applying all hunks of %q to %q got %q", b, a, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	reply := "Here's the fix:\n\n```diff\n" +
+		"diff --git a/main.go b/main.go\n" +
+		"--- a/main.go\n" +
+		"+++ b/main.go\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		" package main\n" +
+		"\n" +
+		"-func main() {}\n" +
+		"+func main() { println(\"hi\") }\n" +
+		"--- /dev/null\n" +
+		"+++ b/README.md\t2023-01-01 00:00:00\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+# Hi\n" +
+		"\\ No newline at end of file\n" +
+		"```\n\nLet me know if it works.\n"
+
+	patches, err := Parse(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patches) != 2 {
+		t.Fatalf("expected 2 patches, got %+v", patches)
+	}
+
+	if p := patches[0]; p.OldName != "main.go" || p.NewName != "main.go" || len(p.Hunks) != 1 || p.Hunks[0].OldLines != 3 || p.Hunks[0].NewLines != 3 {
+		t.Fatalf("unexpected patch of main.go: %+v", p)
+	}
+
+	if p := patches[1]; p.OldName != DevNull || p.NewName != "README.md" || len(p.Hunks) != 1 || p.Hunks[0].Edits[0].Line != "# Hi" {
+		t.Fatalf("unexpected patch of README.md: %+v", p)
+	}
+
+	if !ContainsPatch(reply) || ContainsPatch("--- a\n+++ b\n\nNo hunks.") {
+		t.Fatal("expected only text with hunks to contain a patch")
+	}
+
+	if _, err := Parse("@@ -1 +1 @@\n-a\n+b\n"); err == nil {
+		t.Fatal("expected an error for a hunk without file names")
+	}
+}
+
+func TestApplyTo(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\n"
+
+	// The line numbers are off by two, and the context has a trailing
+	// space.
+	patches, err := Parse("--- a/x\n+++ b/x\n@@ -1,3 +1,3 @@\n c \n-d\n+D\n e\n@@ -6,2 +6,3 @@\n g\n h\n+i\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := patches[0].ApplyTo(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "a\nb\nc\nD\ne\nf\ng\nh\ni\n"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	if _, err := patches[0].ApplyTo("x\ny\n"); err == nil || !strings.Contains(err.Error(), "hunk 1 of x doesn't match") {
+		t.Fatalf("expected a mismatch, got %v", err)
+	}
+
+	created, err := Parse("--- /dev/null\n+++ b/new\n@@ -0,0 +1,2 @@\n+one\n+two\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := created[0].ApplyTo(""); err != nil || got != "one\ntwo\n" {
+		t.Fatalf("expected the new file, got %q, %v", got, err)
+	}
+}
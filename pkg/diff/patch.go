@@ -0,0 +1,289 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DevNull is the name of the missing side of a patch creating or deleting a
+// file.
+const DevNull = "/dev/null"
+
+// FilePatch is the changes a patch makes to a file.
+type FilePatch struct {
+	// OldName and NewName are the paths of the file before and after,
+	// without the "a/" and "b/" prefixes of git diffs, or DevNull if it's
+	// created or deleted.
+	OldName, NewName string
+
+	Hunks []Hunk
+}
+
+// Parse returns the changes to each file of the unified diffs in the text,
+// like the output of "git diff" or "diff -u", skipping any other lines, so
+// patches can be found in replies written in Markdown.
+//
+// The line counts of hunk headers are ignored, since they're often wrong in
+// patches that weren't made by a program, and blank lines in hunks are taken
+// as unchanged empty lines.
+func Parse(text string) ([]FilePatch, error) {
+	var (
+		patches []FilePatch
+		lines   = strings.SplitAfter(text, "\n")
+	)
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			patches = append(patches, FilePatch{
+				OldName: patchName(line[len("--- "):], "a/"),
+				NewName: patchName(strings.TrimRight(lines[i+1], "\r\n")[len("+++ "):], "b/"),
+			})
+			i++
+		case strings.HasPrefix(line, "@@ "):
+			if len(patches) == 0 {
+				return nil, errors.New("diff: hunk before the names of the file it changes")
+			}
+
+			hunk, n, err := parseHunk(lines[i:])
+			if err != nil {
+				return nil, err
+			}
+
+			p := &patches[len(patches)-1]
+			p.Hunks = append(p.Hunks, hunk)
+			i += n - 1
+		}
+	}
+
+	// Names without hunks, like in the text around a patch, aren't patches.
+	parsed := patches[:0]
+	for _, p := range patches {
+		if len(p.Hunks) > 0 {
+			parsed = append(parsed, p)
+		}
+	}
+
+	return parsed, nil
+}
+
+// ContainsPatch returns true if the text has a unified diff.
+func ContainsPatch(text string) bool {
+	if !strings.Contains(text, "\n@@ ") {
+		return false
+	}
+
+	patches, err := Parse(text)
+	return err == nil && len(patches) > 0
+}
+
+// patchName returns the path of a file header's name, without its prefix or
+// a timestamp after a tab.
+func patchName(name, prefix string) string {
+	if i := strings.IndexByte(name, '\t'); i >= 0 {
+		name = name[:i]
+	}
+
+	name = strings.TrimSpace(name)
+	if name == DevNull {
+		return name
+	}
+
+	return strings.TrimPrefix(name, prefix)
+}
+
+// parseHunk parses the hunk at the start of the lines, returning how many
+// lines it is, with its header.
+func parseHunk(lines []string) (Hunk, int, error) {
+	header := strings.TrimRight(lines[0], "\r\n")
+
+	var oldRange, newRange string
+	if fields := strings.Fields(header); len(fields) >= 3 && strings.HasPrefix(fields[1], "-") && strings.HasPrefix(fields[2], "+") {
+		oldRange, newRange = fields[1][1:], fields[2][1:]
+	} else {
+		return Hunk{}, 0, fmt.Errorf("diff: invalid hunk header %q", header)
+	}
+
+	oldStart, err := parseStart(oldRange)
+	if err != nil {
+		return Hunk{}, 0, fmt.Errorf("diff: invalid hunk header %q", header)
+	}
+
+	newStart, err := parseStart(newRange)
+	if err != nil {
+		return Hunk{}, 0, fmt.Errorf("diff: invalid hunk header %q", header)
+	}
+
+	hunk := Hunk{OldStart: oldStart, NewStart: newStart}
+
+	n := 1
+	for ; n < len(lines); n++ {
+		line := lines[n]
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+
+		switch {
+		case strings.HasPrefix(line, `\`):
+			// The previous line has no line ending.
+			if len(hunk.Edits) > 0 {
+				last := &hunk.Edits[len(hunk.Edits)-1]
+				last.Line = strings.TrimSuffix(strings.TrimSuffix(last.Line, "\n"), "\r")
+			}
+			continue
+		case strings.TrimRight(line, "\r\n") == "":
+			if !continuesHunk(lines[n+1:]) {
+				return hunk, n, nil
+			}
+			hunk.add(Edit{Equal, line})
+			continue
+		case strings.HasPrefix(line, "--- ") && n+1 < len(lines) && strings.HasPrefix(lines[n+1], "+++ "):
+			// The header of the next file ends the hunk.
+			return hunk, n, nil
+		}
+
+		switch line[0] {
+		case ' ':
+			hunk.add(Edit{Equal, line[1:]})
+		case '-':
+			hunk.add(Edit{Delete, line[1:]})
+		case '+':
+			hunk.add(Edit{Insert, line[1:]})
+		default:
+			return hunk, n, nil
+		}
+	}
+
+	return hunk, n, nil
+}
+
+// continuesHunk returns true if the hunk goes on after a blank line, which
+// is when the next line that isn't blank is a line of the hunk.
+func continuesHunk(lines []string) bool {
+	for _, line := range lines {
+		if strings.TrimRight(line, "\r\n") == "" {
+			continue
+		}
+		return line[0] == ' ' || line[0] == '+' || line[0] == '\\' ||
+			line[0] == '-' && !strings.HasPrefix(line, "--- ")
+	}
+	return false
+}
+
+// parseStart returns the index of the first line of a hunk's range, like
+// "12,3", where lines are counted from 1, or the index of the line after
+// which lines are inserted if the range is empty.
+func parseStart(r string) (int, error) {
+	start, count, found := strings.Cut(r, ",")
+
+	line, err := strconv.Atoi(start)
+	if err != nil || line < 0 {
+		return 0, errors.New("invalid range")
+	}
+
+	if found {
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, errors.New("invalid range")
+		}
+		if n == 0 {
+			return line, nil
+		}
+	}
+
+	if line == 0 {
+		return 0, nil
+	}
+
+	return line - 1, nil
+}
+
+// ApplyTo returns the text with the changes of the patch made. Each hunk is
+// applied where its lines are, closest to where its header says, so patches
+// with slightly wrong line numbers still apply. Line endings and trailing
+// spaces are ignored when matching lines.
+func (p FilePatch) ApplyTo(text string) (string, error) {
+	var (
+		lines  = SplitLines(text)
+		b      strings.Builder
+		cursor int
+		offset int
+	)
+
+	for i, hunk := range p.Hunks {
+		var old []string
+		for _, edit := range hunk.Edits {
+			if edit.Op != Insert {
+				old = append(old, edit.Line)
+			}
+		}
+
+		pos, ok := findLines(lines, old, cursor, hunk.OldStart+offset)
+		if !ok {
+			return "", fmt.Errorf("diff: hunk %d of %s doesn't match it", i+1, p.NewName)
+		}
+		offset = pos - hunk.OldStart
+
+		for _, line := range lines[cursor:pos] {
+			b.WriteString(line)
+		}
+
+		// Unchanged lines are kept as they are in the text.
+		line := pos
+		for _, edit := range hunk.Edits {
+			switch edit.Op {
+			case Equal:
+				b.WriteString(lines[line])
+				line++
+			case Delete:
+				line++
+			case Insert:
+				b.WriteString(edit.Line)
+			}
+		}
+
+		cursor = pos + len(old)
+	}
+
+	for _, line := range lines[cursor:] {
+		b.WriteString(line)
+	}
+
+	return b.String(), nil
+}
+
+// findLines returns the index of the lines in the text at or after the
+// cursor that is closest to the expected index.
+func findLines(text, lines []string, cursor, expected int) (int, bool) {
+	if expected < cursor {
+		expected = cursor
+	}
+	if expected > len(text) {
+		expected = len(text)
+	}
+
+	for distance := 0; expected-distance >= cursor || expected+distance <= len(text); distance++ {
+		for _, pos := range []int{expected - distance, expected + distance} {
+			if pos >= cursor && pos+len(lines) <= len(text) && matchLines(text[pos:pos+len(lines)], lines) {
+				return pos, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// matchLines returns true if the lines are the same, ignoring trailing
+// spaces and line endings.
+func matchLines(a, b []string) bool {
+	for i := range a {
+		if strings.TrimRight(a[i], " \t\r\n") != strings.TrimRight(b[i], " \t\r\n") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,617 @@
+// Package diffview is a component to review the changes between two texts
+// hunk by hunk, accepting or rejecting each, before they're made.
+//
+// Changes are shown as a unified diff, or side by side, with the words that
+// changed in each line highlighted.
+package diffview
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/reflow/ansi"
+	"github.com/muesli/reflow/truncate"
+
+	"github.com/picatz/hal/pkg/diff"
+)
+
+// Layout is how the changes are shown.
+type Layout int
+
+const (
+	// Unified shows the changes as a unified diff, with removed lines
+	// before the lines added in their place.
+	Unified Layout = iota
+
+	// SideBySide shows the old lines on the left, and the new lines on the
+	// right.
+	SideBySide
+)
+
+// Styles are the styles used by the diff view.
+type Styles struct {
+	// Insert and Delete are the styles of added and removed lines.
+	Insert, Delete lipgloss.Style
+
+	// InsertWord and DeleteWord are the styles of the words that changed
+	// in added and removed lines.
+	InsertWord, DeleteWord lipgloss.Style
+
+	// Hunk is the style of hunk headers.
+	Hunk lipgloss.Style
+
+	// Rejected is the style of the changed lines of rejected hunks.
+	Rejected lipgloss.Style
+
+	// LineNumber is the style of line numbers and other notes.
+	LineNumber lipgloss.Style
+
+	// Cursor is the style of the mark of the selected hunk.
+	Cursor lipgloss.Style
+}
+
+// DefaultStyles are the styles used by the diff view by default.
+var DefaultStyles = Styles{
+	Insert:     lipgloss.NewStyle().Foreground(lipgloss.Color("34")),
+	Delete:     lipgloss.NewStyle().Foreground(lipgloss.Color("160")),
+	InsertWord: lipgloss.NewStyle().Foreground(lipgloss.Color("15")).Background(lipgloss.Color("28")),
+	DeleteWord: lipgloss.NewStyle().Foreground(lipgloss.Color("15")).Background(lipgloss.Color("124")),
+	Hunk:       lipgloss.NewStyle().Foreground(lipgloss.Color("37")),
+	Rejected:   lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Strikethrough(true),
+	LineNumber: lipgloss.NewStyle().Foreground(lipgloss.Color("241")),
+	Cursor:     lipgloss.NewStyle().Foreground(lipgloss.Color("69")).Bold(true),
+}
+
+// KeyMap is the key bindings of the diff view.
+type KeyMap struct {
+	Next      key.Binding
+	Previous  key.Binding
+	Accept    key.Binding
+	Reject    key.Binding
+	Toggle    key.Binding
+	AcceptAll key.Binding
+	RejectAll key.Binding
+	Undo      key.Binding
+	Layout    key.Binding
+	PageUp    key.Binding
+	PageDown  key.Binding
+}
+
+// DefaultKeyMap returns the key bindings of the diff view by default.
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		Next:      key.NewBinding(key.WithKeys("tab", "down", "j"), key.WithHelp("tab", "next")),
+		Previous:  key.NewBinding(key.WithKeys("shift+tab", "up", "k"), key.WithHelp("shift+tab", "previous")),
+		Accept:    key.NewBinding(key.WithKeys("y"), key.WithHelp("y", "accept")),
+		Reject:    key.NewBinding(key.WithKeys("n"), key.WithHelp("n", "reject")),
+		Toggle:    key.NewBinding(key.WithKeys(" "), key.WithHelp("space", "toggle")),
+		AcceptAll: key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "accept all")),
+		RejectAll: key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "reject all")),
+		Undo:      key.NewBinding(key.WithKeys("u", "ctrl+z"), key.WithHelp("u", "undo")),
+		Layout:    key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "layout")),
+		PageUp:    key.NewBinding(key.WithKeys("pgup"), key.WithHelp("pgup", "page up")),
+		PageDown:  key.NewBinding(key.WithKeys("pgdown"), key.WithHelp("pgdown", "page down")),
+	}
+}
+
+// review is what's accepted and selected, as it was before a change, to
+// undo it.
+type review struct {
+	accepted []bool
+	selected int
+}
+
+// Model is a diff view model.
+type Model struct {
+	KeyMap KeyMap
+	Styles Styles
+
+	// Layout is how the changes are shown. It's changed with SetLayout,
+	// or the Layout key.
+	Layout Layout
+
+	old      []string
+	hunks    []diff.Hunk
+	accepted []bool
+	selected int
+
+	// history is the reviews before each change, most recent last.
+	history []review
+
+	width    int
+	viewport viewport.Model
+
+	// offsets are the lines where each hunk starts in the viewport.
+	offsets []int
+}
+
+// New creates a new diff view of the changes from the old text to the new
+// text, with every change accepted until it's rejected.
+func New(old, new string) *Model {
+	m := &Model{
+		KeyMap:   DefaultKeyMap(),
+		Styles:   DefaultStyles,
+		old:      diff.SplitLines(old),
+		viewport: viewport.New(0, 0),
+	}
+
+	m.hunks = diff.Hunks(diff.Edits(m.old, diff.SplitLines(new)), diff.Context)
+
+	m.accepted = make([]bool, len(m.hunks))
+	for i := range m.accepted {
+		m.accepted[i] = true
+	}
+
+	m.render()
+
+	return m
+}
+
+// SetSize changes the size of the diff view.
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.viewport.Width = width
+	m.viewport.Height = height
+	m.render()
+}
+
+// SetLayout changes how the changes are shown.
+func (m *Model) SetLayout(layout Layout) {
+	m.Layout = layout
+	m.render()
+}
+
+// Hunks returns the hunks of changes.
+func (m *Model) Hunks() []diff.Hunk {
+	return m.hunks
+}
+
+// Accepted returns true if the hunk at index i is accepted.
+func (m *Model) Accepted(i int) bool {
+	return m.accepted[i]
+}
+
+// Count returns how many hunks are accepted, out of the total.
+func (m *Model) Count() (accepted, total int) {
+	for _, ok := range m.accepted {
+		if ok {
+			accepted++
+		}
+	}
+	return accepted, len(m.hunks)
+}
+
+// Selected returns the index of the selected hunk.
+func (m *Model) Selected() int {
+	return m.selected
+}
+
+// Select selects the hunk at index i, if there is one, scrolling to it.
+func (m *Model) Select(i int) {
+	if i >= 0 && i < len(m.hunks) {
+		m.selected = i
+	}
+	m.render()
+}
+
+// SetAccepted accepts or rejects the hunk at index i.
+func (m *Model) SetAccepted(i int, ok bool) {
+	if m.accepted[i] == ok {
+		return
+	}
+
+	m.remember()
+	m.accepted[i] = ok
+	m.render()
+}
+
+// SetAll accepts or rejects every hunk.
+func (m *Model) SetAll(ok bool) {
+	changed := false
+	for _, accepted := range m.accepted {
+		changed = changed || accepted != ok
+	}
+	if !changed {
+		return
+	}
+
+	m.remember()
+	for i := range m.accepted {
+		m.accepted[i] = ok
+	}
+	m.render()
+}
+
+// Undo undoes the last hunks accepted or rejected, returning false if there
+// is nothing to undo.
+func (m *Model) Undo() bool {
+	if len(m.history) == 0 {
+		return false
+	}
+
+	last := m.history[len(m.history)-1]
+	m.history = m.history[:len(m.history)-1]
+
+	m.accepted, m.selected = last.accepted, last.selected
+	m.render()
+
+	return true
+}
+
+// remember saves the review before a change, to undo it.
+func (m *Model) remember() {
+	m.history = append(m.history, review{
+		accepted: append([]bool(nil), m.accepted...),
+		selected: m.selected,
+	})
+}
+
+// Result returns the old text with the changes of the accepted hunks made.
+func (m *Model) Result() string {
+	return diff.Apply(m.old, m.hunks, m.accepted)
+}
+
+// Init implements tea.Model, but does nothing currently.
+func (m *Model) Init() tea.Cmd {
+	return nil
+}
+
+// Update implements tea.Model, handling key presses to review the hunks.
+func (m *Model) Update(msg tea.Msg) (*Model, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok || len(m.hunks) == 0 {
+		return m, nil
+	}
+
+	switch {
+	case key.Matches(keyMsg, m.KeyMap.Next):
+		m.Select(m.selected + 1)
+	case key.Matches(keyMsg, m.KeyMap.Previous):
+		m.Select(m.selected - 1)
+	case key.Matches(keyMsg, m.KeyMap.Accept):
+		m.SetAccepted(m.selected, true)
+		m.Select(m.selected + 1)
+	case key.Matches(keyMsg, m.KeyMap.Reject):
+		m.SetAccepted(m.selected, false)
+		m.Select(m.selected + 1)
+	case key.Matches(keyMsg, m.KeyMap.Toggle):
+		m.SetAccepted(m.selected, !m.accepted[m.selected])
+	case key.Matches(keyMsg, m.KeyMap.AcceptAll):
+		m.SetAll(true)
+	case key.Matches(keyMsg, m.KeyMap.RejectAll):
+		m.SetAll(false)
+	case key.Matches(keyMsg, m.KeyMap.Undo):
+		m.Undo()
+	case key.Matches(keyMsg, m.KeyMap.Layout):
+		m.SetLayout((m.Layout + 1) % 2)
+	case key.Matches(keyMsg, m.KeyMap.PageUp):
+		m.viewport.ViewUp()
+	case key.Matches(keyMsg, m.KeyMap.PageDown):
+		m.viewport.ViewDown()
+	}
+
+	return m, nil
+}
+
+// View implements tea.Model, rendering the hunks, scrolled to the selected
+// one.
+func (m *Model) View() string {
+	return m.viewport.View()
+}
+
+// Help returns the help for the key bindings of the diff view.
+func (m *Model) Help() string {
+	var help []string
+	for _, b := range []key.Binding{
+		m.KeyMap.Next, m.KeyMap.Previous, m.KeyMap.Accept, m.KeyMap.Reject,
+		m.KeyMap.Toggle, m.KeyMap.AcceptAll, m.KeyMap.RejectAll, m.KeyMap.Undo,
+		m.KeyMap.Layout,
+	} {
+		help = append(help, b.Help().Key+" "+b.Help().Desc)
+	}
+	return strings.Join(help, " · ")
+}
+
+// render renders the hunks in the viewport, scrolling to the selected hunk
+// if it's out of view.
+func (m *Model) render() {
+	var (
+		b strings.Builder
+		n int // lines written
+	)
+
+	m.offsets = make([]int, len(m.hunks))
+
+	for i, hunk := range m.hunks {
+		m.offsets[i] = n
+
+		var rows []string
+		if m.Layout == SideBySide {
+			rows = m.renderSideBySide(hunk, m.accepted[i])
+		} else {
+			rows = m.renderUnified(hunk, m.accepted[i])
+		}
+
+		b.WriteString(m.renderHeader(i) + "\n")
+		for _, row := range rows {
+			b.WriteString(row + "\n")
+		}
+		b.WriteString("\n")
+
+		n += len(rows) + 2
+	}
+
+	m.viewport.SetContent(b.String())
+
+	if len(m.hunks) == 0 {
+		return
+	}
+
+	offset := m.offsets[m.selected]
+	if offset < m.viewport.YOffset || offset >= m.viewport.YOffset+m.viewport.Height {
+		m.viewport.SetYOffset(offset)
+	}
+}
+
+// renderHeader renders the header of the hunk at index i, with whether it's
+// accepted or selected.
+func (m *Model) renderHeader(i int) string {
+	mark := m.Styles.Insert.Render("✓ accept")
+	if !m.accepted[i] {
+		mark = m.Styles.Delete.Render("✗ reject")
+	}
+
+	cursor := "  "
+	if i == m.selected {
+		cursor = m.Styles.Cursor.Render("▶ ")
+	}
+
+	return cursor + m.Styles.Hunk.Render(m.hunks[i].Header()) + " " + mark
+}
+
+// renderUnified renders the lines of the hunk as a unified diff, with the
+// old and new line numbers.
+func (m *Model) renderUnified(hunk diff.Hunk, accepted bool) []string {
+	var (
+		rows             []string
+		oldLine, newLine = hunk.OldStart, hunk.NewStart
+		lines            = m.renderLines(hunk, accepted)
+	)
+
+	number := func(n int) string {
+		return fmt.Sprintf("%4d", n+1)
+	}
+
+	for i, edit := range hunk.Edits {
+		var numbers string
+
+		switch edit.Op {
+		case diff.Equal:
+			numbers = number(oldLine) + " " + number(newLine)
+			oldLine++
+			newLine++
+		case diff.Delete:
+			numbers = number(oldLine) + "     "
+			oldLine++
+		case diff.Insert:
+			numbers = "     " + number(newLine)
+			newLine++
+		}
+
+		rows = append(rows, "  "+m.Styles.LineNumber.Render(numbers+" │ ")+lines[i])
+	}
+
+	return rows
+}
+
+// renderSideBySide renders the lines of the hunk in two columns, with the
+// lines removed on the left next to the lines added in their place on the
+// right.
+func (m *Model) renderSideBySide(hunk diff.Hunk, accepted bool) []string {
+	width := m.width
+	if width <= 0 {
+		width = 80
+	}
+
+	column := (width - 5) / 2
+	if column < 10 {
+		column = 10
+	}
+
+	var (
+		rows             []string
+		oldLine, newLine = hunk.OldStart, hunk.NewStart
+		lines            = m.renderLines(hunk, accepted)
+	)
+
+	cell := func(n int, line string) string {
+		cell := m.Styles.LineNumber.Render(fmt.Sprintf("%4d ", n+1)) + line
+		cell = truncate.String(cell, uint(column))
+		if pad := column - ansi.PrintableRuneWidth(cell); pad > 0 {
+			cell += strings.Repeat(" ", pad)
+		}
+		return cell
+	}
+
+	blank := strings.Repeat(" ", column)
+
+	for i := 0; i < len(hunk.Edits); {
+		if hunk.Edits[i].Op == diff.Equal {
+			rows = append(rows, "  "+cell(oldLine, lines[i])+m.Styles.LineNumber.Render(" │ ")+cell(newLine, lines[i]))
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		var deletes, inserts []int
+
+		for ; i < len(hunk.Edits) && hunk.Edits[i].Op != diff.Equal; i++ {
+			if hunk.Edits[i].Op == diff.Delete {
+				deletes = append(deletes, i)
+			} else {
+				inserts = append(inserts, i)
+			}
+		}
+
+		for j := 0; j < len(deletes) || j < len(inserts); j++ {
+			left, right := blank, blank
+
+			if j < len(deletes) {
+				left = cell(oldLine, lines[deletes[j]])
+				oldLine++
+			}
+			if j < len(inserts) {
+				right = cell(newLine, lines[inserts[j]])
+				newLine++
+			}
+
+			rows = append(rows, "  "+left+m.Styles.LineNumber.Render(" │ ")+right)
+		}
+	}
+
+	return rows
+}
+
+// renderLines renders each line of the hunk with a "+", "-" or " " prefix,
+// highlighting the words that changed in lines replacing each other.
+func (m *Model) renderLines(hunk diff.Hunk, accepted bool) []string {
+	lines := make([]string, len(hunk.Edits))
+
+	for i := 0; i < len(hunk.Edits); {
+		if hunk.Edits[i].Op == diff.Equal {
+			lines[i] = " " + m.renderText(hunk.Edits[i].Line, lipgloss.NewStyle())
+			i++
+			continue
+		}
+
+		var deletes, inserts []int
+
+		for ; i < len(hunk.Edits) && hunk.Edits[i].Op != diff.Equal; i++ {
+			if hunk.Edits[i].Op == diff.Delete {
+				deletes = append(deletes, i)
+			} else {
+				inserts = append(inserts, i)
+			}
+		}
+
+		if !accepted {
+			for _, j := range append(deletes, inserts...) {
+				prefix := "-"
+				if hunk.Edits[j].Op == diff.Insert {
+					prefix = "+"
+				}
+				lines[j] = m.renderText(hunk.Edits[j].Line, m.Styles.Rejected, prefix)
+			}
+			continue
+		}
+
+		// The k-th removed line is compared to the k-th added line, which
+		// is most likely the line it was changed to.
+		for k, j := range deletes {
+			if k < len(inserts) {
+				lines[j], lines[inserts[k]] = m.renderWords(hunk.Edits[j].Line, hunk.Edits[inserts[k]].Line)
+			} else {
+				lines[j] = m.renderText(hunk.Edits[j].Line, m.Styles.Delete, "-")
+			}
+		}
+		for k := len(deletes); k < len(inserts); k++ {
+			lines[inserts[k]] = m.renderText(hunk.Edits[inserts[k]].Line, m.Styles.Insert, "+")
+		}
+	}
+
+	return lines
+}
+
+// renderWords renders the old line replaced by the new line, highlighting
+// the words that changed, unless nothing but spaces is the same.
+func (m *Model) renderWords(old, new string) (string, string) {
+	var (
+		oldWords = words(displayText(old))
+		newWords = words(displayText(new))
+		edits    = diff.Edits(oldWords, newWords)
+		same     bool
+	)
+
+	for _, edit := range edits {
+		if edit.Op == diff.Equal && strings.TrimSpace(edit.Line) != "" {
+			same = true
+			break
+		}
+	}
+
+	if !same {
+		return m.renderText(old, m.Styles.Delete, "-"), m.renderText(new, m.Styles.Insert, "+")
+	}
+
+	var o, n strings.Builder
+
+	o.WriteString(m.Styles.Delete.Render("-"))
+	n.WriteString(m.Styles.Insert.Render("+"))
+
+	for _, edit := range edits {
+		switch edit.Op {
+		case diff.Equal:
+			o.WriteString(m.Styles.Delete.Render(edit.Line))
+			n.WriteString(m.Styles.Insert.Render(edit.Line))
+		case diff.Delete:
+			o.WriteString(m.Styles.DeleteWord.Render(edit.Line))
+		case diff.Insert:
+			n.WriteString(m.Styles.InsertWord.Render(edit.Line))
+		}
+	}
+
+	return o.String() + m.noNewline(old), n.String() + m.noNewline(new)
+}
+
+// renderText renders the line in the style, after the prefix, if any.
+func (m *Model) renderText(line string, style lipgloss.Style, prefix ...string) string {
+	return style.Render(strings.Join(prefix, "")+displayText(line)) + m.noNewline(line)
+}
+
+// noNewline returns a note for lines without a line ending.
+func (m *Model) noNewline(line string) string {
+	if strings.HasSuffix(line, "\n") {
+		return ""
+	}
+	return m.Styles.LineNumber.Render(" (no newline at end)")
+}
+
+// displayText returns the line without its line ending, and tabs as spaces.
+func displayText(line string) string {
+	return strings.ReplaceAll(strings.TrimRight(line, "\r\n"), "\t", "    ")
+}
+
+// words splits the text into words, runs of spaces, and other characters,
+// which are compared to highlight what changed in a line.
+func words(text string) []string {
+	var (
+		words []string
+		start int
+	)
+
+	class := func(r rune) int {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			return 1
+		case unicode.IsSpace(r):
+			return 2
+		}
+		return 0
+	}
+
+	runes := []rune(text)
+	for i := 1; i <= len(runes); i++ {
+		if i == len(runes) || class(runes[i]) != class(runes[start]) || class(runes[i]) == 0 {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+
+	return words
+}
@@ -0,0 +1,125 @@
+package diffview
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var ansiPattern = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func stripANSI(s string) string {
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+func press(m *Model, keys ...string) {
+	for _, k := range keys {
+		msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+		switch k {
+		case "tab":
+			msg = tea.KeyMsg{Type: tea.KeyTab}
+		case " ":
+			msg = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")}
+		}
+		m.Update(msg)
+	}
+}
+
+const (
+	oldText = "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	newText = "a\nB\nc\nd\ne\nf\ng\nh\ni\nj changed\n"
+)
+
+func TestModel(t *testing.T) {
+	m := New(oldText, newText)
+	m.SetSize(80, 40)
+
+	if accepted, total := m.Count(); accepted != 2 || total != 2 {
+		t.Fatalf("expected 2 of 2 hunks accepted, got %d of %d", accepted, total)
+	}
+
+	// Reject the first hunk, which moves on to the second.
+	press(m, "n")
+	if m.Accepted(0) || !m.Accepted(1) || m.Selected() != 1 {
+		t.Fatalf("expected the first hunk rejected and the second selected")
+	}
+
+	if want := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj changed\n"; m.Result() != want {
+		t.Fatalf("expected %q, got %q", want, m.Result())
+	}
+
+	press(m, "r", " ")
+	if accepted, _ := m.Count(); accepted != 1 || !m.Accepted(1) {
+		t.Fatalf("expected only the second hunk accepted, got %d", accepted)
+	}
+
+	// Undo the toggle, rejecting all, and rejecting the first hunk.
+	press(m, "u", "u")
+	if m.Accepted(0) || !m.Accepted(1) {
+		t.Fatal("expected undo to restore the first hunk rejected")
+	}
+	press(m, "u")
+	if !m.Accepted(0) || m.Selected() != 0 {
+		t.Fatal("expected undo to restore the first hunk accepted and selected")
+	}
+	if m.Undo() {
+		t.Fatal("expected nothing left to undo")
+	}
+
+	if m.Result() != newText {
+		t.Fatalf("expected the new text, got %q", m.Result())
+	}
+}
+
+func TestModelView(t *testing.T) {
+	m := New(oldText, newText)
+	m.SetSize(80, 40)
+
+	view := stripANSI(m.View())
+	for _, want := range []string{"▶ @@ -1,5 +1,5 @@ ✓ accept", "   2      │ -b", "        2 │ +B", "  10      │ -j", "       10 │ +j changed"} {
+		if !strings.Contains(view, want) {
+			t.Fatalf("expected %q in the unified view:\n%s", want, view)
+		}
+	}
+
+	press(m, "s")
+	if m.Layout != SideBySide {
+		t.Fatal("expected the side by side layout")
+	}
+
+	view = stripANSI(m.View())
+	if !regexp.MustCompile(`   2 -b +│    2 \+B`).MatchString(view) {
+		t.Fatalf("expected the changed lines side by side:\n%s", view)
+	}
+
+	press(m, "n")
+	if view := stripANSI(m.View()); !strings.Contains(view, "✗ reject") {
+		t.Fatalf("expected the rejected hunk to be marked:\n%s", view)
+	}
+}
+
+func TestModelWords(t *testing.T) {
+	m := New("x := compute(a, b)\n", "x := compute(a, c)\n")
+
+	// Changed words are padded, to tell them apart without colors.
+	m.Styles.DeleteWord = lipgloss.NewStyle().PaddingLeft(1)
+	m.Styles.InsertWord = lipgloss.NewStyle().PaddingLeft(1)
+
+	old, new := m.renderWords("x := compute(a, b)\n", "x := compute(a, c)\n")
+	if old != "-x := compute(a,  b)" || new != "+x := compute(a,  c)" {
+		t.Fatalf("expected only the changed words highlighted, got %q and %q", old, new)
+	}
+
+	// Lines with nothing in common aren't highlighted word by word.
+	old, _ = m.renderWords("foo\n", "bar\n")
+	if old != "-foo" {
+		t.Fatalf("expected no words highlighted, got %q", old)
+	}
+
+	if got := words("foo(bar,  baz)"); strings.Join(got, "|") != "foo|(|bar|,|  |baz|)" {
+		t.Fatalf("unexpected words: %q", got)
+	}
+}
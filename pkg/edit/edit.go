@@ -0,0 +1,132 @@
+// Package edit edits files with instructions in natural language, asking a
+// chat provider for the file's new content, which is reviewed as a diff
+// before it's written back.
+package edit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// SystemMessage returns the system message of threads editing the file.
+func SystemMessage(path string) chat.Message {
+	return chat.NewMessage(openai.ChatRoleSystem, fmt.Sprintf(
+		"You are HAL, a powerful code and text editor controlled by natural language, editing the file %s. Each message "+
+			"has the file's current content and an instruction. Reply with the whole file, changed as instructed, in a "+
+			"single code block fenced like the file's, without any explanation. Keep everything the instruction doesn't "+
+			"ask to change exactly as it is. If the instruction can't be done, explain why without a code block.",
+		path,
+	))
+}
+
+// Prompt returns the message asking to edit the file's content as
+// instructed.
+func Prompt(path, content, instruction string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s:\n\n", path)
+	writeBlock(&b, content)
+	fmt.Fprintf(&b, "\n%s", strings.TrimSpace(instruction))
+
+	return b.String()
+}
+
+// ProposedMsg is sent when an edit was proposed for an instruction, or it
+// failed.
+type ProposedMsg struct {
+	Err error
+
+	// Content is the file's content with the edit made.
+	Content string
+
+	// Messages are the instruction and the reply with the edit, which can
+	// be added to the thread's chat history.
+	Messages []chat.Message
+}
+
+// Propose returns a command that asks the provider to edit the file's
+// content as instructed, with the chat history as context, sending a
+// ProposedMsg with the edited content.
+func Propose(ctx context.Context, provider chat.Provider, opts chat.Options, chatHistory []chat.Message, path, content, instruction string) tea.Cmd {
+	send := chat.Send(ctx, provider, opts, chatHistory, Prompt(path, content, instruction))
+
+	return func() tea.Msg {
+		finished := send().(chat.FinishedMsg)
+		if finished.Err != nil {
+			return ProposedMsg{Err: fmt.Errorf("failed to propose an edit: %w", finished.Err)}
+		}
+
+		reply := finished.History[len(finished.History)-1]
+
+		edited, ok := ParseContent(reply.Content, content)
+		if !ok {
+			return ProposedMsg{Err: errors.New(strings.TrimSpace(reply.Content))}
+		}
+
+		return ProposedMsg{
+			Content:  edited,
+			Messages: finished.History[len(chatHistory):],
+		}
+	}
+}
+
+// ParseContent returns the file content in the reply, which is its first code
+// block, ending with a line ending only if the original content does. It
+// returns false if there is no code block, like when the reply explains why
+// the file can't be edited.
+func ParseContent(reply, original string) (string, bool) {
+	var (
+		lines []string
+		fence string
+	)
+
+	for _, line := range strings.SplitAfter(reply, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if fence == "" {
+			if strings.HasPrefix(trimmed, "```") {
+				fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, "`"))]
+			}
+			continue
+		}
+
+		// Only a fence at least as long as the opening one closes the block,
+		// so shorter ones in the file's content are kept.
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, "`") == "" {
+			content := strings.Join(lines, "")
+			if !strings.HasSuffix(original, "\n") {
+				content = strings.TrimSuffix(content, "\n")
+			} else if content != "" && !strings.HasSuffix(content, "\n") {
+				content += "\n"
+			}
+			return content, true
+		}
+
+		lines = append(lines, line)
+	}
+
+	return "", false
+}
+
+// writeBlock writes the text as a Markdown code block, with a fence longer
+// than any run of backticks in the text.
+func writeBlock(b *strings.Builder, text string) {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+
+	b.WriteString(fence + "\n")
+	b.WriteString(text)
+	if !strings.HasSuffix(text, "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString(fence + "\n")
+}
@@ -0,0 +1,186 @@
+package edit
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/chat/chattest"
+)
+
+func TestParseContent(t *testing.T) {
+	tests := []struct {
+		reply    string
+		original string
+		want     string
+		ok       bool
+	}{
+		{reply: "```go\npackage main\n```", original: "package foo\n", want: "package main\n", ok: true},
+		{reply: "Here it is:\n\n```\na\nb\n```\n\nI changed b.", original: "a\n", want: "a\nb\n", ok: true},
+		{reply: "```\nno newline\n```", original: "old", want: "no newline", ok: true},
+		{reply: "````md\n# Title\n\n```sh\nls\n```\n````", original: "# Old\n", want: "# Title\n\n```sh\nls\n```\n", ok: true},
+		{reply: "```\n```", original: "a\n", want: "", ok: true},
+		{reply: "I'm afraid I can't do that.", original: "a\n", ok: false},
+		{reply: "```\nunterminated", original: "a\n", ok: false},
+	}
+
+	for _, test := range tests {
+		got, ok := ParseContent(test.reply, test.original)
+		if got != test.want || ok != test.ok {
+			t.Fatalf("expected %q, %v for %q, got %q, %v", test.want, test.ok, test.reply, got, ok)
+		}
+	}
+}
+
+func TestPropose(t *testing.T) {
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "```go\npackage main\n\nfunc main() {}\n```"},
+		chattest.Reply{Content: "I'm afraid I can't do that."},
+	)
+
+	history := []chat.Message{SystemMessage("main.go")}
+
+	msg := Propose(context.Background(), provider, chat.Options{}, history, "main.go", "package main\n", "add a main function")().(ProposedMsg)
+	if msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+
+	if msg.Content != "package main\n\nfunc main() {}\n" || len(msg.Messages) != 2 {
+		t.Fatalf("unexpected proposal: %+v", msg)
+	}
+
+	sent := provider.Requests()[0].Messages
+	if prompt := sent[len(sent)-1].Content; !strings.Contains(prompt, "```\npackage main\n```") || !strings.HasSuffix(prompt, "add a main function") {
+		t.Fatalf("expected the file and the instruction to be sent, got %q", prompt)
+	}
+
+	msg = Propose(context.Background(), provider, chat.Options{}, history, "main.go", "package main\n", "open the pod bay doors")().(ProposedMsg)
+	if msg.Err == nil || msg.Err.Error() != "I'm afraid I can't do that." {
+		t.Fatalf("expected the explanation as an error, got %v", msg.Err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.sh")
+	if err := os.WriteFile(path, []byte("echo hi\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Write("echo hello\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Fatalf("expected the mode to be kept, got %v", info.Mode())
+	}
+
+	if content, _ := os.ReadFile(path); string(content) != "echo hello\n" || f.Content != "echo hello\n" {
+		t.Fatalf("expected the new content, got %q", content)
+	}
+
+	// Files changed since they were read aren't overwritten.
+	if err := os.WriteFile(path, []byte("echo changed\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Write("echo again\n"); err == nil || !strings.Contains(err.Error(), "changed since it was opened") {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected no temporary files left, got %v, %v", entries, err)
+	}
+
+	created, err := NewFile(filepath.Join(t.TempDir(), "new.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := created.Write("hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(created.Path); string(content) != "hello\n" {
+		t.Fatalf("expected the file to be created, got %q", content)
+	}
+	if _, err := NewFile(created.Path); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected existing files to be refused, got %v", err)
+	}
+
+	// New files are created along with the directories they're in.
+	nested, err := NewFile(filepath.Join(t.TempDir(), "new", "dir", "file.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Write("package dir\n"); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(nested.Path); string(content) != "package dir\n" {
+		t.Fatalf("expected the file to be created, got %q", content)
+	}
+
+	binary := filepath.Join(t.TempDir(), "bin")
+	if err := os.WriteFile(binary, []byte{0, 1, 2}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(binary); err == nil || !strings.Contains(err.Error(), "isn't a text file") {
+		t.Fatalf("expected binary files to be refused, got %v", err)
+	}
+}
+
+func TestFileLink(t *testing.T) {
+	dir := t.TempDir()
+
+	target := filepath.Join(dir, "targets", "run.sh")
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("echo hi\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(dir, "run.sh")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip(err)
+	}
+
+	f, err := ReadFile(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Write("echo hello\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The link is kept, and the file it links to is written.
+	if info, err := os.Lstat(link); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Fatalf("expected the link to be kept, got %v, %v", info, err)
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o700 {
+		t.Fatalf("expected the mode of the file linked to be kept, got %v", info.Mode())
+	}
+	if content, _ := os.ReadFile(target); string(content) != "echo hello\n" {
+		t.Fatalf("expected the file linked to be written, got %q", content)
+	}
+
+	// The temporary file is made next to the file linked to.
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 2 {
+		t.Fatalf("expected no other files, got %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,111 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/picatz/hal/pkg/atomicfile"
+)
+
+// MaxSize is the size, in bytes, of the largest file that can be edited, so
+// it fits in the context window of most models with its edited content.
+const MaxSize = 64 * 1024
+
+// File is a text file being edited.
+type File struct {
+	// Path is the path of the file.
+	Path string
+
+	// Content is the file's content, as it was last read or written.
+	Content string
+
+	// Mode is the file's permissions, which are kept when it's written.
+	Mode fs.FileMode
+}
+
+// ReadFile reads the text file at the path.
+func ReadFile(path string) (*File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !info.Mode().IsRegular():
+		return nil, fmt.Errorf("%s isn't a regular file", path)
+	case info.Size() > MaxSize:
+		return nil, fmt.Errorf("%s is too large to edit, larger than %d KB", path, MaxSize/1024)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !utf8.Valid(content) || strings.IndexByte(string(content), 0) >= 0 {
+		return nil, fmt.Errorf("%s isn't a text file", path)
+	}
+
+	return &File{
+		Path:    path,
+		Content: string(content),
+		Mode:    info.Mode().Perm(),
+	}, nil
+}
+
+// NewFile returns a text file to create at the path, which must not exist
+// yet.
+func NewFile(path string) (*File, error) {
+	if _, err := os.Lstat(path); err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return &File{
+		Path: path,
+		Mode: 0o644,
+	}, nil
+}
+
+// Write replaces the file's content atomically, by writing it to a temporary
+// file next to it that is renamed over it, so it's never left half written.
+// It fails if the file changed since it was last read or written, and files
+// without content that don't exist are created, along with their directory.
+//
+// If the file is a link, the file it links to is written, so the link is
+// kept.
+func (f *File) Write(content string) error {
+	path, err := filepath.EvalSymlinks(f.Path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && f.Content == "":
+		path = f.Path
+	case err != nil:
+		return err
+	}
+
+	current, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && f.Content == "":
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("failed to create the directory of %s: %w", f.Path, err)
+		}
+	case err != nil:
+		return err
+	case string(current) != f.Content:
+		return fmt.Errorf("%s changed since it was opened", f.Path)
+	}
+
+	if err := atomicfile.WriteFile(path, []byte(content), f.Mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", f.Path, err)
+	}
+
+	f.Content = content
+
+	return nil
+}
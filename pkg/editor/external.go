@@ -29,13 +29,11 @@ func ConfiguredExternalCommand() string {
 	return editor
 }
 
-// OpenExternal opens the external editor and returns a command that will
-// wait for it to finish.
+// OpenExternal opens the external editor command and returns a command
+// that will wait for it to finish.
 //
 // The buffer is the initial contents of the file.
-func OpenExternal(buffer string) tea.Cmd {
-	// Get the external editor command.
-	editor := ConfiguredExternalCommand()
+func OpenExternal(editor string, buffer string) tea.Cmd {
 
 	// Write to a temp file and open it
 	f, err := os.CreateTemp(os.TempDir(), "hal-editor-*")
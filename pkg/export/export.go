@@ -0,0 +1,162 @@
+// Package export writes chat threads to Markdown, JSON, or standalone HTML
+// files, so conversations can be shared outside of HAL, like in design
+// docs and pull requests.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// Format is a file format threads can be exported to.
+type Format string
+
+// Formats threads can be exported to.
+const (
+	Markdown Format = "markdown"
+	JSON     Format = "json"
+	HTML     Format = "html"
+)
+
+// Formats returns all of the formats threads can be exported to.
+func Formats() []Format {
+	return []Format{Markdown, JSON, HTML}
+}
+
+// ParseFormat returns the format with the given name, or file extension
+// like "md".
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "markdown", "md":
+		return Markdown, nil
+	case "json":
+		return JSON, nil
+	case "html", "htm":
+		return HTML, nil
+	default:
+		return "", fmt.Errorf("export format must be %q, %q or %q, got %q", Markdown, JSON, HTML, name)
+	}
+}
+
+// FormatFromPath returns the format for the extension of the file path.
+func FormatFromPath(path string) (Format, error) {
+	ext := filepath.Ext(path)
+	if ext == "" {
+		return "", fmt.Errorf("can't tell the export format of %q without a file extension", path)
+	}
+	return ParseFormat(ext)
+}
+
+// Ext returns the file extension for the format, including the dot.
+func (f Format) Ext() string {
+	switch f {
+	case Markdown:
+		return ".md"
+	default:
+		return "." + string(f)
+	}
+}
+
+// Options changes what is exported.
+type Options struct {
+	// System includes the system messages, which are left out of the
+	// Markdown and HTML exports by default. JSON exports always include
+	// the whole thread.
+	System bool
+}
+
+// Write writes the thread to w in the given format.
+func Write(w io.Writer, ct *chat.Thread, format Format, opts Options) error {
+	switch format {
+	case Markdown:
+		return WriteMarkdown(w, ct, opts)
+	case JSON:
+		return WriteJSON(w, ct)
+	case HTML:
+		return WriteHTML(w, ct, opts)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// WriteJSON writes the whole thread as JSON, the same way it's stored.
+func WriteJSON(w io.Writer, ct *chat.Thread) error {
+	b, err := json.MarshalIndent(ct, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode chat thread %q: %w", ct.Name, err)
+	}
+
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// FileName returns a file name for the thread exported in the format, made
+// from the thread's name.
+func FileName(ct *chat.Thread, format Format) string {
+	var b strings.Builder
+
+	dash := false
+	for _, r := range strings.ToLower(ct.Name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+
+	name := strings.TrimSuffix(b.String(), "-")
+	if name == "" {
+		name = "thread"
+	}
+
+	return name + format.Ext()
+}
+
+// messages returns the messages of the thread to export.
+func messages(ct *chat.Thread, opts Options) []chat.Message {
+	var msgs []chat.Message
+
+	for _, msg := range ct.ChatHistory {
+		// Summaries of earlier messages are kept, since the conversation
+		// doesn't make sense without them.
+		if msg.Role == openai.ChatRoleSystem && !opts.System && !chat.IsSummary(msg) {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+// roleName returns the name shown for the message's role.
+func roleName(msg chat.Message) string {
+	switch {
+	case chat.IsSummary(msg):
+		return "Earlier messages (summary)"
+	case msg.Role == openai.ChatRoleUser:
+		return "You"
+	case msg.Role == openai.ChatRoleAssistant:
+		return "HAL"
+	case msg.Role == openai.ChatRoleSystem:
+		return "System"
+	default:
+		return msg.Role
+	}
+}
+
+// content returns the content of the message, without the prefix marking
+// summaries.
+func content(msg chat.Message) string {
+	return strings.TrimSpace(strings.TrimPrefix(msg.Content, chat.SummaryPrefix))
+}
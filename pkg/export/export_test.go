@@ -0,0 +1,143 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+func testThread() *chat.Thread {
+	return &chat.Thread{
+		ID:      "a1",
+		Name:    "Pod bay doors?",
+		Summary: "Dave asks HAL\nto open the doors.",
+		Created: time.Date(2001, time.April, 2, 9, 30, 0, 0, time.UTC),
+		ChatHistory: chat.Messages([]openai.ChatMessage{
+			{Role: openai.ChatRoleSystem, Content: "You are HAL 9000."},
+			{Role: openai.ChatRoleSystem, Content: chat.SummaryPrefix + "Dave went outside."},
+			{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, <b>HAL</b>."},
+			{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Dave.\n\n```go\nfmt.Println(\"no\")"},
+		}),
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		want Format
+	}{
+		{name: "md", want: Markdown},
+		{name: "Markdown", want: Markdown},
+		{name: ".json", want: JSON},
+		{name: "htm", want: HTML},
+	}
+
+	for _, test := range tests {
+		got, err := ParseFormat(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Fatalf("expected %q for %q, got %q", test.want, test.name, got)
+		}
+	}
+
+	if _, err := ParseFormat("pdf"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+
+	if _, err := FormatFromPath("thread"); err == nil {
+		t.Fatal("expected an error for a path without an extension")
+	}
+
+	if got, err := FormatFromPath("out/thread.md"); err != nil || got != Markdown {
+		t.Fatalf("expected markdown, got %q, %v", got, err)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	if got := FileName(testThread(), Markdown); got != "pod-bay-doors.md" {
+		t.Fatalf("unexpected file name %q", got)
+	}
+
+	if got := FileName(&chat.Thread{Name: "???"}, HTML); got != "thread.html" {
+		t.Fatalf("unexpected file name %q", got)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, testThread(), Markdown, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# Pod bay doors?\n\n" +
+		"> Dave asks HAL to open the doors.\n\n" +
+		"_April 2, 2001 09:30_\n\n" +
+		"## Earlier messages (summary)\n\nDave went outside.\n\n" +
+		"## You\n\nOpen the pod bay doors, <b>HAL</b>.\n\n" +
+		"## HAL\n\nI'm sorry, Dave.\n\n```go\nfmt.Println(\"no\")\n```\n\n"
+
+	if b.String() != want {
+		t.Fatalf("unexpected markdown:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := Write(&b, testThread(), Markdown, Options{System: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(b.String(), "## System\n\nYou are HAL 9000.") {
+		t.Fatalf("expected the system message, got:\n%s", b.String())
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, testThread(), JSON, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var ct chat.Thread
+	if err := json.Unmarshal(b.Bytes(), &ct); err != nil {
+		t.Fatal(err)
+	}
+
+	if ct.ID != "a1" || len(ct.ChatHistory) != 4 || !ct.Created.Equal(testThread().Created) {
+		t.Fatalf("expected the whole thread, got %+v", ct)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, testThread(), HTML, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := b.String()
+
+	for _, want := range []string{
+		"<title>Pod bay doors?</title>",
+		"<p>Dave asks HAL to open the doors.</p>",
+		`<section class="assistant">`,
+		`<pre><code class="language-go">fmt.Println(&quot;no&quot;)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in:\n%s", want, out)
+		}
+	}
+
+	if strings.Contains(out, "<b>HAL</b>") {
+		t.Fatalf("expected raw HTML to be left out, got:\n%s", out)
+	}
+
+	if strings.Contains(out, "You are HAL 9000.") {
+		t.Fatalf("expected the system message to be left out, got:\n%s", out)
+	}
+}
@@ -0,0 +1,101 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// markdown renders the content of messages to HTML. Raw HTML in messages is
+// left out, since the renderer isn't configured to allow unsafe HTML.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// htmlTemplate is a standalone HTML document, with the styles inline, so the
+// exported file can be opened or shared on its own.
+var htmlTemplate = template.Must(template.New("thread").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Name }}</title>
+<style>
+body { max-width: 48rem; margin: 2rem auto; padding: 0 1rem; font-family: system-ui, sans-serif; line-height: 1.5; color: #1f2328; }
+header p { color: #59636e; }
+section { margin: 1.5rem 0; padding: 0.5rem 1rem; border-left: 4px solid #d1d9e0; }
+section.user { border-color: #8250df; }
+section.assistant { border-color: #1f883d; }
+section.system { border-color: #9a6700; }
+h2 { font-size: 1rem; margin: 0.5rem 0; }
+pre { padding: 1rem; overflow: auto; background: #f6f8fa; border-radius: 6px; }
+code { font-family: ui-monospace, monospace; font-size: 0.9em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.25rem 0.75rem; border: 1px solid #d1d9e0; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{ .Name }}</h1>
+{{- if .Summary }}
+<p>{{ .Summary }}</p>
+{{- end }}
+{{- if .Created }}
+<p><time>{{ .Created }}</time></p>
+{{- end }}
+</header>
+{{- range .Messages }}
+<section class="{{ .Role }}">
+<h2>{{ .Name }}</h2>
+{{ .Content }}
+</section>
+{{- end }}
+</body>
+</html>
+`))
+
+// htmlMessage is a message in the HTML template.
+type htmlMessage struct {
+	Role    string
+	Name    string
+	Content template.HTML
+}
+
+// WriteHTML writes the thread as a standalone HTML document, with the
+// Markdown of each message rendered.
+func WriteHTML(w io.Writer, ct *chat.Thread, opts Options) error {
+	data := struct {
+		Name     string
+		Summary  string
+		Created  string
+		Messages []htmlMessage
+	}{
+		Name:    ct.Name,
+		Summary: strings.Join(strings.Fields(ct.Summary), " "),
+	}
+
+	if !ct.Created.IsZero() {
+		data.Created = ct.Created.Format("January 2, 2006 15:04")
+	}
+
+	for _, msg := range messages(ct, opts) {
+		var b bytes.Buffer
+		if err := markdown.Convert([]byte(closeFences(content(msg))), &b); err != nil {
+			return fmt.Errorf("failed to render message: %w", err)
+		}
+
+		data.Messages = append(data.Messages, htmlMessage{
+			Role: msg.Role,
+			Name: roleName(msg),
+			// Safe since the renderer leaves out raw HTML.
+			Content: template.HTML(b.String()),
+		})
+	}
+
+	return htmlTemplate.Execute(w, data)
+}
@@ -0,0 +1,60 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// WriteMarkdown writes the thread as Markdown, with the thread's name as the
+// title, and a header for the role of each message.
+func WriteMarkdown(w io.Writer, ct *chat.Thread, opts Options) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "# %s\n\n", ct.Name)
+
+	if ct.Summary != "" {
+		fmt.Fprintf(bw, "> %s\n\n", strings.Join(strings.Fields(ct.Summary), " "))
+	}
+
+	if !ct.Created.IsZero() {
+		fmt.Fprintf(bw, "_%s_\n\n", ct.Created.Format("January 2, 2006 15:04"))
+	}
+
+	for _, msg := range messages(ct, opts) {
+		fmt.Fprintf(bw, "## %s\n\n%s\n\n", roleName(msg), closeFences(content(msg)))
+	}
+
+	return bw.Flush()
+}
+
+// closeFences closes a fenced code block that was left open, like in a
+// response that was cut off, so it doesn't swallow the messages after it.
+func closeFences(text string) string {
+	var fence string
+
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case fence == "":
+			for _, marker := range []string{"```", "~~~"} {
+				if strings.HasPrefix(trimmed, marker) {
+					fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, marker[:1]))]
+					break
+				}
+			}
+		case strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "":
+			fence = ""
+		}
+	}
+
+	if fence != "" {
+		return text + "\n" + fence
+	}
+
+	return text
+}
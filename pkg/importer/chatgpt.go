@@ -0,0 +1,215 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// chatGPTConversation is a conversation in a ChatGPT export, which is a tree
+// of messages, since editing a message or regenerating a response starts a
+// new branch.
+type chatGPTConversation struct {
+	ID             string                 `json:"id"`
+	ConversationID string                 `json:"conversation_id"`
+	Title          string                 `json:"title"`
+	CreateTime     float64                `json:"create_time"`
+	CurrentNode    string                 `json:"current_node"`
+	Mapping        map[string]chatGPTNode `json:"mapping"`
+}
+
+// chatGPTNode is a node in the tree of messages of a conversation.
+type chatGPTNode struct {
+	ID       string          `json:"id"`
+	Message  *chatGPTMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+// chatGPTMessage is a message in a conversation.
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Metadata   struct {
+		ModelSlug string `json:"model_slug"`
+	} `json:"metadata"`
+	Content struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+	} `json:"content"`
+}
+
+// text returns the text of the message, leaving out things like images.
+func (msg *chatGPTMessage) text() string {
+	var parts []string
+
+	for _, raw := range msg.Content.Parts {
+		var part string
+		if err := json.Unmarshal(raw, &part); err == nil && strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) == 0 && msg.Content.Text != "" {
+		parts = append(parts, msg.Content.Text)
+	}
+
+	return strings.TrimSpace(strings.Join(parts, "\n\n"))
+}
+
+// ReadChatGPT returns the threads in the conversations.json file of a
+// ChatGPT data export, oldest first.
+//
+// Only the active branch of each conversation is kept, which is the one that
+// was last shown in ChatGPT. Tool calls and their results, and messages
+// without any text, are left out.
+func ReadChatGPT(r io.Reader) (chat.Threads, error) {
+	var conversations []chatGPTConversation
+	if err := json.NewDecoder(r).Decode(&conversations); err != nil {
+		return nil, fmt.Errorf("failed to decode ChatGPT conversations: %w", err)
+	}
+
+	threads := make(chat.Threads, 0, len(conversations))
+
+	for i, conv := range conversations {
+		id := conv.ConversationID
+		if id == "" {
+			id = conv.ID
+		}
+		if id == "" {
+			return nil, fmt.Errorf("ChatGPT conversation %d has no ID", i)
+		}
+
+		ct := &chat.Thread{
+			ID:          threadID(string(ChatGPT), id),
+			Name:        strings.TrimSpace(conv.Title),
+			TitleLocked: strings.TrimSpace(conv.Title) != "",
+			Created:     chatGPTTime(conv.CreateTime),
+		}
+
+		for _, node := range conv.activePath() {
+			msg := node.Message
+			if msg == nil {
+				continue
+			}
+
+			switch msg.Author.Role {
+			case openai.ChatRoleSystem, openai.ChatRoleUser, openai.ChatRoleAssistant:
+			default:
+				continue
+			}
+
+			text := msg.text()
+			if text == "" {
+				continue
+			}
+
+			if ct.Created.IsZero() {
+				ct.Created = chatGPTTime(msg.CreateTime)
+			}
+
+			imported := chat.NewMessage(msg.Author.Role, text)
+			if sent := chatGPTTime(msg.CreateTime); !sent.IsZero() {
+				imported.Metadata = &chat.Metadata{
+					Time:  sent,
+					Model: msg.Metadata.ModelSlug,
+				}
+			}
+
+			ct.ChatHistory = append(ct.ChatHistory, imported)
+		}
+
+		if ct.Name == "" {
+			ct.Name = "Imported thread"
+		}
+
+		threads = append(threads, ct)
+	}
+
+	sort.Stable(threads)
+
+	return threads, nil
+}
+
+// activePath returns the nodes from the root of the conversation to its
+// current node. Conversations without a current node follow the last child,
+// which is the newest branch.
+func (conv *chatGPTConversation) activePath() []chatGPTNode {
+	current, ok := conv.Mapping[conv.CurrentNode]
+	if !ok {
+		current, ok = conv.newestLeaf()
+		if !ok {
+			return nil
+		}
+	}
+
+	var path []chatGPTNode
+
+	seen := map[string]bool{}
+	for node, ok := current, true; ok && !seen[node.ID]; node, ok = conv.Mapping[node.Parent] {
+		seen[node.ID] = true
+		path = append(path, node)
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	return path
+}
+
+// newestLeaf returns the leaf reached by following the last child from the
+// root of the conversation.
+func (conv *chatGPTConversation) newestLeaf() (chatGPTNode, bool) {
+	var (
+		root  chatGPTNode
+		found bool
+	)
+
+	for _, node := range conv.Mapping {
+		if _, ok := conv.Mapping[node.Parent]; !ok {
+			root, found = node, true
+			break
+		}
+	}
+
+	if !found {
+		return chatGPTNode{}, false
+	}
+
+	node := root
+	seen := map[string]bool{}
+	for len(node.Children) > 0 && !seen[node.ID] {
+		seen[node.ID] = true
+
+		child, ok := conv.Mapping[node.Children[len(node.Children)-1]]
+		if !ok {
+			break
+		}
+		node = child
+	}
+
+	return node, true
+}
+
+// chatGPTTime returns the time for a ChatGPT timestamp, which is seconds
+// since the Unix epoch, or the zero time if it isn't set.
+func chatGPTTime(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC()
+}
@@ -0,0 +1,79 @@
+// Package importer converts conversations from other chat tools into chat
+// threads, so they can be continued and searched in HAL.
+//
+// Imported threads get an ID derived from where they came from, like the
+// ID of a ChatGPT conversation, so importing the same conversations again
+// can skip the ones that were already imported.
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// Format is a file format conversations can be imported from.
+type Format string
+
+// Formats conversations can be imported from.
+const (
+	// ChatGPT is the conversations.json file of a ChatGPT data export.
+	ChatGPT Format = "chatgpt"
+
+	// Markdown is a transcript with a heading for each message, like the
+	// ones exported by HAL.
+	Markdown Format = "markdown"
+)
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "chatgpt":
+		return ChatGPT, nil
+	case "markdown", "md":
+		return Markdown, nil
+	default:
+		return "", fmt.Errorf("import format must be %q or %q, got %q", ChatGPT, Markdown, name)
+	}
+}
+
+// FormatFromPath returns the format for the extension of the file path,
+// where JSON files are assumed to be ChatGPT exports.
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ChatGPT, nil
+	case ".md", ".markdown":
+		return Markdown, nil
+	default:
+		return "", fmt.Errorf("can't tell the import format of %q from its extension", path)
+	}
+}
+
+// Read returns the threads in r, in the given format.
+func Read(r io.Reader, format Format) (chat.Threads, error) {
+	switch format {
+	case ChatGPT:
+		return ReadChatGPT(r)
+	case Markdown:
+		ct, err := ReadMarkdown(r)
+		if err != nil {
+			return nil, err
+		}
+		return chat.Threads{ct}, nil
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+}
+
+// threadID returns the thread ID for a conversation from the source, which
+// is the same every time it's imported.
+func threadID(source, id string) string {
+	sum := sha256.Sum256([]byte(source + ":" + id))
+	return hex.EncodeToString(sum[:8])
+}
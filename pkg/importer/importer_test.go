@@ -0,0 +1,191 @@
+package importer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/export"
+)
+
+// chatGPTExport is a ChatGPT export with a conversation where the first
+// response was regenerated, and one without a current node.
+const chatGPTExport = `[
+  {
+    "id": "conv-2",
+    "title": "Chess",
+    "create_time": 986300000.5,
+    "mapping": {
+      "r": {"id": "r", "message": null, "parent": null, "children": ["u"]},
+      "u": {"id": "u", "message": {"author": {"role": "user"}, "content": {"content_type": "text", "parts": ["Let's play chess."]}}, "parent": "r", "children": ["a1", "a2"]},
+      "a1": {"id": "a1", "message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["No."]}}, "parent": "u", "children": []},
+      "a2": {"id": "a2", "message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["Queen to bishop three."]}}, "parent": "u", "children": []}
+    }
+  },
+  {
+    "id": "conv-1",
+    "title": "Pod bay doors",
+    "create_time": 986200000,
+    "current_node": "a1",
+    "mapping": {
+      "r": {"id": "r", "message": null, "parent": null, "children": ["s"]},
+      "s": {"id": "s", "message": {"author": {"role": "system"}, "content": {"content_type": "text", "parts": [""]}}, "parent": "r", "children": ["u"]},
+      "u": {"id": "u", "message": {"author": {"role": "user"}, "content": {"content_type": "multimodal_text", "parts": [{"asset_pointer": "file-1"}, "Open the pod bay doors, HAL."]}}, "parent": "s", "children": ["t"]},
+      "t": {"id": "t", "message": {"author": {"role": "tool"}, "content": {"content_type": "text", "parts": ["door status: closed"]}}, "parent": "u", "children": ["a1", "a2"]},
+      "a1": {"id": "a1", "message": {"author": {"role": "assistant"}, "create_time": 986200060, "metadata": {"model_slug": "gpt-4"}, "content": {"content_type": "text", "parts": ["I'm sorry, Dave. I'm afraid I can't do that."]}}, "parent": "t", "children": []},
+      "a2": {"id": "a2", "message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["Opening."]}}, "parent": "t", "children": []}
+    }
+  }
+]`
+
+func TestReadChatGPT(t *testing.T) {
+	threads, err := Read(strings.NewReader(chatGPTExport), ChatGPT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads) != 2 {
+		t.Fatalf("expected 2 threads, got %d", len(threads))
+	}
+
+	// Threads are sorted by creation date.
+	ct := threads[0]
+	if ct.Name != "Pod bay doors" || !ct.TitleLocked || !ct.Created.Equal(time.Unix(986200000, 0)) {
+		t.Fatalf("unexpected thread: %+v", ct)
+	}
+
+	want := []openai.ChatMessage{
+		{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL."},
+		{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Dave. I'm afraid I can't do that."},
+	}
+
+	if len(ct.ChatHistory) != len(want) {
+		t.Fatalf("expected %d messages, got %+v", len(want), ct.ChatHistory)
+	}
+
+	for i, msg := range ct.ChatHistory {
+		if msg.ChatMessage != want[i] {
+			t.Fatalf("expected message %d to be %+v, got %+v", i, want[i], msg)
+		}
+	}
+
+	if md := ct.ChatHistory[1].Metadata; md == nil || md.Model != "gpt-4" || !md.Time.Equal(time.Unix(986200060, 0)) {
+		t.Fatalf("expected the time and model of the reply, got %+v", md)
+	}
+
+	// Without a current node, the newest branch is used.
+	if last := threads[1].ChatHistory[len(threads[1].ChatHistory)-1]; last.Content != "Queen to bishop three." {
+		t.Fatalf("expected the newest branch, got %q", last.Content)
+	}
+
+	if !threads[1].Created.Equal(time.Unix(986300000, 5e8)) {
+		t.Fatalf("expected fractional seconds to be kept, got %v", threads[1].Created)
+	}
+
+	// Importing again gives the same IDs.
+	again, err := ReadChatGPT(strings.NewReader(chatGPTExport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again[0].ID != ct.ID || ct.ID == threads[1].ID {
+		t.Fatalf("expected stable, unique IDs, got %q, %q and %q", ct.ID, again[0].ID, threads[1].ID)
+	}
+
+	if _, err := ReadChatGPT(strings.NewReader(`{"title": "not a list"}`)); err == nil {
+		t.Fatal("expected an error for an invalid export")
+	}
+}
+
+func TestReadMarkdown(t *testing.T) {
+	ct, err := ReadMarkdown(strings.NewReader(`# Pod bay doors
+
+> Dave asks HAL to open the doors.
+
+_April 2, 2001 09:30_
+
+## User
+
+Open the pod bay doors, HAL.
+
+## Assistant:
+
+I'm sorry, Dave.
+
+` + "```markdown\n## User\n\nNot a message.\n```" + `
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ct.Name != "Pod bay doors" || ct.Summary != "Dave asks HAL to open the doors." {
+		t.Fatalf("unexpected thread: %+v", ct)
+	}
+
+	if want := time.Date(2001, time.April, 2, 9, 30, 0, 0, time.Local); !ct.Created.Equal(want) {
+		t.Fatalf("expected the thread to be created %v, got %v", want, ct.Created)
+	}
+
+	if len(ct.ChatHistory) != 2 {
+		t.Fatalf("expected 2 messages, got %+v", ct.ChatHistory)
+	}
+
+	if msg := ct.ChatHistory[1]; msg.Role != openai.ChatRoleAssistant || !strings.HasSuffix(msg.Content, "Not a message.\n```") {
+		t.Fatalf("expected headings in code blocks to be kept, got %+v", msg)
+	}
+
+	if _, err := ReadMarkdown(strings.NewReader("# Notes\n\nNothing here.\n")); err == nil {
+		t.Fatal("expected an error without any messages")
+	}
+}
+
+func TestReadMarkdownExport(t *testing.T) {
+	want := &chat.Thread{
+		Name:    "Pod bay doors",
+		Created: time.Date(2001, time.April, 2, 9, 30, 0, 0, time.Local),
+		ChatHistory: chat.Messages([]openai.ChatMessage{
+			{Role: openai.ChatRoleSystem, Content: "You are HAL."},
+			{Role: openai.ChatRoleSystem, Content: chat.SummaryPrefix + "Dave went outside."},
+			{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL."},
+			{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Dave."},
+		}),
+	}
+
+	var b bytes.Buffer
+	if err := export.WriteMarkdown(&b, want, export.Options{System: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	ct, err := ReadMarkdown(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ct.Name != want.Name || !ct.Created.Equal(want.Created) || len(ct.ChatHistory) != len(want.ChatHistory) {
+		t.Fatalf("expected the exported thread, got %+v", ct)
+	}
+
+	for i, msg := range ct.ChatHistory {
+		if msg != want.ChatHistory[i] {
+			t.Fatalf("expected message %d to be %+v, got %+v", i, want.ChatHistory[i], msg)
+		}
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	if f, err := FormatFromPath("conversations.json"); err != nil || f != ChatGPT {
+		t.Fatalf("expected %q, got %q, %v", ChatGPT, f, err)
+	}
+
+	if f, err := FormatFromPath("notes.MD"); err != nil || f != Markdown {
+		t.Fatalf("expected %q, got %q, %v", Markdown, f, err)
+	}
+
+	if _, err := FormatFromPath("notes.txt"); err == nil {
+		t.Fatal("expected an error for an unknown extension")
+	}
+}
@@ -0,0 +1,190 @@
+package importer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// markdownDateLayouts are the layouts of the date line of a transcript,
+// like the one written by HAL's Markdown export.
+var markdownDateLayouts = []string{
+	"January 2, 2006 15:04",
+	"January 2, 2006",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC3339,
+}
+
+// markdownRoles are the names of the roles used in headings, in lower case.
+var markdownRoles = map[string]string{
+	"you":       openai.ChatRoleUser,
+	"user":      openai.ChatRoleUser,
+	"me":        openai.ChatRoleUser,
+	"human":     openai.ChatRoleUser,
+	"hal":       openai.ChatRoleAssistant,
+	"assistant": openai.ChatRoleAssistant,
+	"chatgpt":   openai.ChatRoleAssistant,
+	"ai":        openai.ChatRoleAssistant,
+	"system":    openai.ChatRoleSystem,
+}
+
+// markdownSummaryRole is the heading of a summary of earlier messages in
+// HAL's Markdown export.
+const markdownSummaryRole = "earlier messages (summary)"
+
+// ReadMarkdown returns the thread in a Markdown transcript, where each
+// message starts with a heading naming its role, like "## You" or
+// "## Assistant". The first other heading is used as the thread's name, a
+// quote before the first message as its summary, and a date line like
+// "_January 2, 2006 15:04_" as its creation date.
+func ReadMarkdown(r io.Reader) (*chat.Thread, error) {
+	var (
+		ct        = &chat.Thread{}
+		source    strings.Builder
+		quote     []string
+		role      string
+		isSummary bool
+		content   []string
+		fence     string
+		started   bool
+	)
+
+	flush := func() {
+		if !started {
+			return
+		}
+
+		text := strings.TrimSpace(strings.Join(content, "\n"))
+		content = nil
+
+		if text == "" {
+			return
+		}
+
+		if isSummary {
+			text = chat.SummaryPrefix + text
+		}
+
+		ct.ChatHistory = append(ct.ChatHistory, chat.NewMessage(role, text))
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		source.WriteString(line + "\n")
+
+		trimmed := strings.TrimSpace(line)
+
+		// Headings in code blocks are part of the message.
+		if marker := fenceMarker(trimmed); marker != "" {
+			switch {
+			case fence == "":
+				fence = marker
+			case strings.HasPrefix(marker, fence) && trimmed == marker:
+				fence = ""
+			}
+		} else if name, ok := heading(trimmed); ok && fence == "" {
+			if next, summary, ok := markdownRole(name); ok {
+				flush()
+				started, role, isSummary = true, next, summary
+				continue
+			}
+
+			if !started && ct.Name == "" {
+				ct.Name = name
+				continue
+			}
+		}
+
+		if started {
+			content = append(content, line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, ">"):
+			quote = append(quote, strings.TrimSpace(strings.TrimPrefix(trimmed, ">")))
+		case ct.Created.IsZero() && len(trimmed) > 2 && (trimmed[0] == '_' || trimmed[0] == '*'):
+			ct.Created = parseMarkdownDate(strings.Trim(trimmed, "_*"))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Markdown transcript: %w", err)
+	}
+
+	flush()
+
+	if len(ct.ChatHistory) == 0 {
+		return nil, errors.New("no messages found in Markdown transcript, each message must start with a heading like \"## You\" or \"## Assistant\"")
+	}
+
+	ct.ID = threadID(string(Markdown), source.String())
+	ct.Summary = strings.TrimSpace(strings.Join(quote, " "))
+	ct.TitleLocked = ct.Name != ""
+	if ct.Name == "" {
+		ct.Name = "Imported thread"
+	}
+
+	return ct, nil
+}
+
+// heading returns the text of an ATX heading, like "## You".
+func heading(line string) (string, bool) {
+	level := len(line) - len(strings.TrimLeft(line, "#"))
+	if level == 0 || level > 6 || len(line) == level || line[level] != ' ' {
+		// HAL's transcripts use "» You" instead of a heading.
+		if strings.HasPrefix(line, "» ") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "» ")), true
+		}
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.TrimRight(line[level:], "# ")), true
+}
+
+// markdownRole returns the role named by a heading, and whether it's a
+// summary of earlier messages.
+func markdownRole(name string) (string, bool, bool) {
+	name = strings.ToLower(strings.TrimSuffix(strings.Trim(name, "*_ "), ":"))
+
+	if name == markdownSummaryRole {
+		return openai.ChatRoleSystem, true, true
+	}
+
+	role, ok := markdownRoles[name]
+	return role, false, ok
+}
+
+// fenceMarker returns the backticks or tildes starting a code fence line,
+// or an empty string if the line isn't one.
+func fenceMarker(line string) string {
+	for _, c := range []string{"`", "~"} {
+		marker := line[:len(line)-len(strings.TrimLeft(line, c))]
+		if len(marker) >= 3 {
+			return marker
+		}
+	}
+	return ""
+}
+
+// parseMarkdownDate returns the date in a transcript's date line, or the
+// zero time if it isn't one.
+func parseMarkdownDate(s string) time.Time {
+	for _, layout := range markdownDateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
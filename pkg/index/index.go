@@ -0,0 +1,370 @@
+// Package index is an on-disk inverted index over the messages of chat
+// threads, so searching months of threads doesn't have to scan every
+// message on each query.
+//
+// Each thread's postings are kept in their own file, named after the
+// thread's ID, which is only rewritten when that thread changes. Messages
+// appended to a thread are indexed on their own, while a thread whose
+// earlier messages changed, like after a compaction, is indexed again.
+//
+// Queries match whole words, or word prefixes ending with "*", and results
+// are ranked with BM25.
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/picatz/hal/pkg/atomicfile"
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// version is the format of the index files, which are indexed again when
+// it changes.
+const version = 1
+
+// ErrCorrupt is returned when the index files can't be read, in which case
+// the index has to be rebuilt.
+var ErrCorrupt = errors.New("search index is corrupt, run \"hal index rebuild\"")
+
+// DefaultDir returns the default directory to store the index in, which is
+// next to the default thread store directory.
+func DefaultDir() (string, error) {
+	dir, err := chat.DefaultStoreDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(dir), "index"), nil
+}
+
+// Index is an inverted index over the messages of chat threads. It's safe
+// to use from multiple goroutines.
+type Index struct {
+	dir string
+
+	mu sync.Mutex
+
+	// segments are the postings of each thread by ID.
+	segments map[string]*segment
+
+	// threads are the IDs of the threads that contain each term.
+	threads map[string]map[string]struct{}
+
+	// terms are all the terms in sorted order, used for prefix queries,
+	// or nil if they changed since they were last sorted.
+	terms []string
+
+	// messages and length are the number of messages and terms that
+	// are indexed, used to rank results.
+	messages int
+	length   int
+}
+
+// segment is the postings of a single thread, which is stored in its own
+// file.
+type segment struct {
+	Version  int    `json:"version"`
+	ThreadID string `json:"thread_id"`
+
+	// Digest is a hash of the indexed messages, used to tell if they
+	// changed since.
+	Digest string `json:"digest"`
+
+	// Lengths is the number of terms in each indexed message.
+	Lengths []int `json:"lengths"`
+
+	// Postings are the messages each term is in.
+	Postings map[string][]posting `json:"postings"`
+}
+
+// posting is a message a term is in, and how many times.
+type posting struct {
+	Message int `json:"m"`
+	Count   int `json:"n"`
+}
+
+// Open returns the index stored in the given directory, creating the
+// directory if it doesn't exist yet. An error wrapping ErrCorrupt is
+// returned if any of the index files can't be read.
+func Open(dir string) (*Index, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create index directory %q: %w", dir, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index directory %q: %w", dir, err)
+	}
+
+	ix := &Index{
+		dir:      dir,
+		segments: map[string]*segment{},
+		threads:  map[string]map[string]struct{}{},
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// Skip temporary files left behind by a crash.
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index file %q: %w", name, err)
+		}
+
+		seg := &segment{}
+		if err := json.Unmarshal(b, seg); err != nil {
+			return nil, fmt.Errorf("%w: failed to decode %q: %v", ErrCorrupt, name, err)
+		}
+
+		if seg.ThreadID != strings.TrimSuffix(name, ".json") {
+			return nil, fmt.Errorf("%w: %q is for thread %q", ErrCorrupt, name, seg.ThreadID)
+		}
+
+		// Older formats are indexed again with the next update.
+		if seg.Version != version {
+			continue
+		}
+
+		ix.add(seg)
+	}
+
+	return ix, nil
+}
+
+// Rebuild removes the index stored in the given directory, even if it's
+// corrupt, and indexes the threads again.
+func Rebuild(dir string, threads chat.Threads) (*Index, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read index directory %q: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" && !strings.HasPrefix(entry.Name(), atomicfile.TempPrefix) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return nil, fmt.Errorf("failed to remove index file %q: %w", entry.Name(), err)
+		}
+	}
+
+	ix, err := Open(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ix.Sync(threads); err != nil {
+		return nil, err
+	}
+
+	return ix, nil
+}
+
+// Stats returns the number of threads and messages that are indexed.
+func (ix *Index) Stats() (threads, messages int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	return len(ix.segments), ix.messages
+}
+
+// Update indexes the messages of the thread that aren't indexed yet. The
+// whole thread is indexed again if any of the messages that were already
+// indexed changed. Threads without an ID, which were never saved, are
+// ignored.
+func (ix *Index) Update(ct *chat.Thread) error {
+	if ct.ID == "" {
+		return nil
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	old := ix.segments[ct.ID]
+
+	seg := &segment{
+		Version:  version,
+		ThreadID: ct.ID,
+		Postings: map[string][]posting{},
+	}
+
+	h := sha256.New()
+
+	// Keep the postings of the messages that were already indexed, if
+	// they didn't change.
+	if old != nil && len(old.Lengths) <= len(ct.ChatHistory) {
+		for _, msg := range ct.ChatHistory[:len(old.Lengths)] {
+			hashMessage(h, msg)
+		}
+
+		if hex.EncodeToString(h.Sum(nil)) == old.Digest {
+			if len(old.Lengths) == len(ct.ChatHistory) {
+				return nil
+			}
+
+			seg.Lengths = append(seg.Lengths, old.Lengths...)
+			for term, postings := range old.Postings {
+				seg.Postings[term] = append([]posting(nil), postings...)
+			}
+		} else {
+			h.Reset()
+		}
+	}
+
+	for i := len(seg.Lengths); i < len(ct.ChatHistory); i++ {
+		msg := ct.ChatHistory[i]
+		hashMessage(h, msg)
+
+		counts := map[string]int{}
+		tokens := Tokenize(msg.Content)
+		for _, token := range tokens {
+			counts[token.Term]++
+		}
+
+		for term, count := range counts {
+			seg.Postings[term] = append(seg.Postings[term], posting{Message: i, Count: count})
+		}
+
+		seg.Lengths = append(seg.Lengths, len(tokens))
+	}
+
+	seg.Digest = hex.EncodeToString(h.Sum(nil))
+
+	b, err := json.Marshal(seg)
+	if err != nil {
+		return fmt.Errorf("failed to encode index of chat thread %q: %w", ct.Name, err)
+	}
+
+	if err := atomicfile.WriteFile(filepath.Join(ix.dir, ct.ID+".json"), b, 0o600); err != nil {
+		return fmt.Errorf("failed to save index of chat thread %q: %w", ct.Name, err)
+	}
+
+	if old != nil {
+		ix.remove(old)
+	}
+	ix.add(seg)
+
+	return nil
+}
+
+// Remove removes the thread with the given ID from the index.
+func (ix *Index) Remove(id string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if err := os.Remove(filepath.Join(ix.dir, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove index of chat thread %q: %w", id, err)
+	}
+
+	if seg, ok := ix.segments[id]; ok {
+		ix.remove(seg)
+	}
+
+	return nil
+}
+
+// Sync updates the index with the threads, removing any other threads
+// from the index, like threads that were deleted.
+func (ix *Index) Sync(threads chat.Threads) error {
+	ids := map[string]bool{}
+
+	for _, ct := range threads {
+		if err := ix.Update(ct); err != nil {
+			return err
+		}
+		ids[ct.ID] = true
+	}
+
+	ix.mu.Lock()
+	var removed []string
+	for id := range ix.segments {
+		if !ids[id] {
+			removed = append(removed, id)
+		}
+	}
+	ix.mu.Unlock()
+
+	for _, id := range removed {
+		if err := ix.Remove(id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// add adds the segment's postings to the index, which must be locked.
+func (ix *Index) add(seg *segment) {
+	ix.segments[seg.ThreadID] = seg
+
+	for term := range seg.Postings {
+		ids, ok := ix.threads[term]
+		if !ok {
+			ids = map[string]struct{}{}
+			ix.threads[term] = ids
+			ix.terms = nil
+		}
+		ids[seg.ThreadID] = struct{}{}
+	}
+
+	ix.messages += len(seg.Lengths)
+	for _, n := range seg.Lengths {
+		ix.length += n
+	}
+}
+
+// remove removes the segment's postings from the index, which must be
+// locked.
+func (ix *Index) remove(seg *segment) {
+	delete(ix.segments, seg.ThreadID)
+
+	for term := range seg.Postings {
+		ids := ix.threads[term]
+		delete(ids, seg.ThreadID)
+
+		if len(ids) == 0 {
+			delete(ix.threads, term)
+			ix.terms = nil
+		}
+	}
+
+	ix.messages -= len(seg.Lengths)
+	for _, n := range seg.Lengths {
+		ix.length -= n
+	}
+}
+
+// hashMessage adds the message to the digest of a thread's messages.
+func hashMessage(h hash.Hash, msg chat.Message) {
+	h.Write([]byte(msg.Role))
+	h.Write([]byte{0})
+	h.Write([]byte(msg.Content))
+	h.Write([]byte{0})
+}
+
+// sortedTerms returns all the terms in sorted order, which must be locked.
+func (ix *Index) sortedTerms() []string {
+	if ix.terms == nil {
+		ix.terms = make([]string, 0, len(ix.threads))
+		for term := range ix.threads {
+			ix.terms = append(ix.terms, term)
+		}
+		sort.Strings(ix.terms)
+	}
+	return ix.terms
+}
@@ -0,0 +1,211 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+func testThreads() chat.Threads {
+	return chat.Threads{
+		{
+			ID:   "a1",
+			Name: "Pod bay doors",
+			ChatHistory: chat.Messages([]openai.ChatMessage{
+				{Role: openai.ChatRoleUser, Content: "Open the pod bay doors, HAL."},
+				{Role: openai.ChatRoleAssistant, Content: "I'm sorry, Dave. I'm afraid I can't do that."},
+			}),
+		},
+		{
+			ID:   "b2",
+			Name: "Chess",
+			ChatHistory: chat.Messages([]openai.ChatMessage{
+				{Role: openai.ChatRoleUser, Content: "Let's play chess, HAL. I'll open with the queen's pawn."},
+				{Role: openai.ChatRoleAssistant, Content: "Queen to bishop three. Bishop takes knight's pawn. Sorry, Frank, I think you missed it."},
+			}),
+		},
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize("Open the POD-bay doors, HAL 9000.")
+
+	want := []string{"open", "the", "pod", "bay", "doors", "hal", "9000"}
+	if len(tokens) != len(want) {
+		t.Fatalf("expected %d tokens, got %+v", len(want), tokens)
+	}
+
+	for i, token := range tokens {
+		if token.Term != want[i] {
+			t.Fatalf("expected term %q, got %q", want[i], token.Term)
+		}
+	}
+
+	if tokens[2].Start != 9 || tokens[2].End != 12 {
+		t.Fatalf("unexpected position of %q: %d-%d", tokens[2].Term, tokens[2].Start, tokens[2].End)
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	ix, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	threads := testThreads()
+	if err := ix.Sync(threads); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []Hit
+	}{
+		{query: "pod bay", want: []Hit{{ThreadID: "a1", MessageIndex: 0}}},
+		{query: "SORRY", want: []Hit{{ThreadID: "a1", MessageIndex: 1}, {ThreadID: "b2", MessageIndex: 1}}},
+		{query: "bish*", want: []Hit{{ThreadID: "b2", MessageIndex: 1}}},
+		{query: "open hal", want: []Hit{{ThreadID: "a1", MessageIndex: 0}, {ThreadID: "b2", MessageIndex: 0}}},
+		{query: "open jupiter"},
+		{query: "bish"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			hits := ix.Search(test.query, 0)
+			if len(hits) != len(test.want) {
+				t.Fatalf("expected %d hits, got %+v", len(test.want), hits)
+			}
+
+			for i, hit := range hits {
+				if hit.ThreadID != test.want[i].ThreadID || hit.MessageIndex != test.want[i].MessageIndex {
+					t.Fatalf("expected hit %d to be %+v, got %+v", i, test.want[i], hit)
+				}
+			}
+		})
+	}
+
+	// Shorter messages with the same term rank higher.
+	if hits := ix.Search("open", 1); len(hits) != 1 || hits[0].ThreadID != "a1" {
+		t.Fatalf("expected the shorter message first, got %+v", hits)
+	}
+
+	results := Results(threads, ix.Search("bish*", 0))
+	if len(results) != 1 || len(results[0].Matches) != 2 {
+		t.Fatalf("expected both prefix matches to be highlighted, got %+v", results)
+	}
+
+	if before, match, _ := results[0].Snippet(10); before != "Queen to " || match != "bishop" {
+		t.Fatalf("unexpected snippet: %q, %q", before, match)
+	}
+}
+
+func TestIndexUpdate(t *testing.T) {
+	dir := t.TempDir()
+
+	ix, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	threads := testThreads()
+	if err := ix.Sync(threads); err != nil {
+		t.Fatal(err)
+	}
+
+	// Appended messages are indexed.
+	ct := threads[0]
+	ct.ChatHistory = append(ct.ChatHistory, chat.NewMessage(openai.ChatRoleUser, "What's the problem?"))
+
+	if err := ix.Update(ct); err != nil {
+		t.Fatal(err)
+	}
+
+	if hits := ix.Search("problem", 0); len(hits) != 1 || hits[0].MessageIndex != 2 {
+		t.Fatalf("expected the new message to be found, got %+v", hits)
+	}
+
+	// Rewritten messages, like after a compaction, are indexed again.
+	ct.ChatHistory = chat.Messages([]openai.ChatMessage{
+		{Role: openai.ChatRoleSystem, Content: chat.SummaryPrefix + "Dave asked HAL to open the doors."},
+		{Role: openai.ChatRoleUser, Content: "What's the problem?"},
+	})
+
+	if err := ix.Update(ct); err != nil {
+		t.Fatal(err)
+	}
+
+	if hits := ix.Search("sorry", 0); len(hits) != 1 || hits[0].ThreadID != "b2" {
+		t.Fatalf("expected the replaced message to be gone, got %+v", hits)
+	}
+
+	if hits := ix.Search("problem", 0); len(hits) != 1 || hits[0].MessageIndex != 1 {
+		t.Fatalf("expected the moved message to be found, got %+v", hits)
+	}
+
+	// Deleted threads are removed.
+	if err := ix.Sync(threads[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if hits := ix.Search("chess", 0); len(hits) != 0 {
+		t.Fatalf("expected the deleted thread to be gone, got %+v", hits)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "b2.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the deleted thread's index file to be removed, got %v", err)
+	}
+
+	// The index is kept on disk.
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if threads, messages := reopened.Stats(); threads != 1 || messages != 2 {
+		t.Fatalf("expected 1 thread and 2 messages, got %d and %d", threads, messages)
+	}
+
+	if hits := reopened.Search("doors", 0); len(hits) != 1 || hits[0].ThreadID != "a1" {
+		t.Fatalf("expected the reopened index to be searchable, got %+v", hits)
+	}
+}
+
+func TestIndexRebuild(t *testing.T) {
+	dir := t.TempDir()
+
+	ix, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	threads := testThreads()
+	if err := ix.Sync(threads); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "a1.json"), []byte(`{"version": 1, "postings": `), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected the index to be corrupt, got %v", err)
+	}
+
+	rebuilt, err := Rebuild(dir, threads)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hits := rebuilt.Search("doors", 0); len(hits) != 1 || hits[0].ThreadID != "a1" {
+		t.Fatalf("expected the rebuilt index to be searchable, got %+v", hits)
+	}
+
+	if _, err := Open(dir); err != nil {
+		t.Fatalf("expected the rebuilt index to open, got %v", err)
+	}
+}
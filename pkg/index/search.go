@@ -0,0 +1,280 @@
+package index
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// Parameters of the BM25 ranking function.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// maxTermLen is the length of the longest term that is indexed, so things
+// like encoded data don't bloat the index.
+const maxTermLen = 64
+
+// Token is a term in a text, and where it is.
+type Token struct {
+	// Term is the word, with its case folded.
+	Term string
+
+	// Start and End are the position of the word in the text, in bytes.
+	Start int
+	End   int
+}
+
+// Tokenize returns the terms of the text, which are runs of letters and
+// digits, ignoring case.
+func Tokenize(text string) []Token {
+	var (
+		tokens []Token
+		fold   = cases.Fold()
+		start  = -1
+	)
+
+	emit := func(end int) {
+		if end-start <= maxTermLen {
+			tokens = append(tokens, Token{Term: fold.String(text[start:end]), Start: start, End: end})
+		}
+		start = -1
+	}
+
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+
+		switch {
+		case isWord && start == -1:
+			start = i
+		case !isWord && start != -1:
+			emit(i)
+		}
+	}
+
+	if start != -1 {
+		emit(len(text))
+	}
+
+	return tokens
+}
+
+// Hit is a message matching a query.
+type Hit struct {
+	// ThreadID is the ID of the thread the message is in.
+	ThreadID string
+
+	// MessageIndex is the index of the message in the chat history.
+	MessageIndex int
+
+	// Score ranks the hit, where higher is better.
+	Score float64
+
+	// Terms are the terms of the message that matched, which can be
+	// different from the query for prefix queries.
+	Terms []string
+}
+
+// queryWord is a word of a query, and the terms it matches.
+type queryWord struct {
+	terms []string
+}
+
+// Search returns up to limit messages matching every word of the query,
+// with the best matches first, or all of them if limit is zero. A word
+// ending with "*" matches any word starting with it.
+func (ix *Index) Search(query string, limit int) []Hit {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	words := ix.parseQuery(query)
+	if len(words) == 0 {
+		return nil
+	}
+
+	type key struct {
+		thread  string
+		message int
+	}
+
+	type candidate struct {
+		words  int
+		scores []float64
+		terms  []string
+	}
+
+	var (
+		candidates = map[key]*candidate{}
+		avgLength  = float64(ix.length) / math.Max(float64(ix.messages), 1)
+	)
+
+	for w, word := range words {
+		for _, term := range word.terms {
+			// The number of messages the term is in.
+			df := 0
+			for id := range ix.threads[term] {
+				df += len(ix.segments[id].Postings[term])
+			}
+
+			idf := math.Log(1 + (float64(ix.messages)-float64(df)+0.5)/(float64(df)+0.5))
+
+			for id := range ix.threads[term] {
+				seg := ix.segments[id]
+
+				for _, p := range seg.Postings[term] {
+					k := key{thread: id, message: p.Message}
+
+					c, ok := candidates[k]
+					if !ok {
+						// Messages that didn't match the previous words
+						// can't match the whole query.
+						if w > 0 {
+							continue
+						}
+						c = &candidate{scores: make([]float64, len(words))}
+						candidates[k] = c
+					}
+
+					// Only count each word once, using its best term.
+					tf := float64(p.Count)
+					score := idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(seg.Lengths[p.Message])/avgLength))
+					if c.scores[w] == 0 {
+						c.words++
+					}
+					if score > c.scores[w] {
+						c.scores[w] = score
+					}
+
+					c.terms = append(c.terms, term)
+				}
+			}
+		}
+
+		// Drop the messages missing this word.
+		for k, c := range candidates {
+			if c.words < w+1 {
+				delete(candidates, k)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(candidates))
+	for k, c := range candidates {
+		hit := Hit{
+			ThreadID:     k.thread,
+			MessageIndex: k.message,
+			Terms:        c.terms,
+		}
+		for _, score := range c.scores {
+			hit.Score += score
+		}
+		hits = append(hits, hit)
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].ThreadID != hits[j].ThreadID {
+			return hits[i].ThreadID < hits[j].ThreadID
+		}
+		return hits[i].MessageIndex < hits[j].MessageIndex
+	})
+
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	return hits
+}
+
+// parseQuery returns the words of the query, with the terms each one
+// matches, which must be locked. It returns nil if any word doesn't match
+// any terms, since no message can match the query.
+func (ix *Index) parseQuery(query string) []queryWord {
+	var words []queryWord
+
+	for _, field := range strings.Fields(query) {
+		prefix := strings.HasSuffix(field, "*")
+
+		tokens := Tokenize(field)
+		for i, token := range tokens {
+			word := queryWord{}
+
+			if prefix && i == len(tokens)-1 {
+				terms := ix.sortedTerms()
+				for j := sort.SearchStrings(terms, token.Term); j < len(terms) && strings.HasPrefix(terms[j], token.Term); j++ {
+					word.terms = append(word.terms, terms[j])
+				}
+			} else if _, ok := ix.threads[token.Term]; ok {
+				word.terms = []string{token.Term}
+			}
+
+			if len(word.terms) == 0 {
+				return nil
+			}
+
+			words = append(words, word)
+		}
+	}
+
+	return words
+}
+
+// Results returns the search results for the hits in the threads, with
+// every matching term as a match, in the same order. Hits for threads or
+// messages that no longer exist are skipped.
+func Results(threads chat.Threads, hits []Hit) []*chat.ThreadSearchResult {
+	byID := make(map[string]*chat.Thread, len(threads))
+	for _, ct := range threads {
+		byID[ct.ID] = ct
+	}
+
+	results := []*chat.ThreadSearchResult{}
+
+	for _, hit := range hits {
+		ct, ok := byID[hit.ThreadID]
+		if !ok || hit.MessageIndex >= len(ct.ChatHistory) {
+			continue
+		}
+
+		terms := map[string]bool{}
+		for _, term := range hit.Terms {
+			terms[term] = true
+		}
+
+		msg := ct.ChatHistory[hit.MessageIndex]
+
+		var matches []chat.Match
+		for _, token := range Tokenize(msg.Content) {
+			if terms[token.Term] {
+				matches = append(matches, chat.Match{Start: token.Start, End: token.End})
+			}
+		}
+
+		// The message changed since it was indexed.
+		if len(matches) == 0 {
+			continue
+		}
+
+		results = append(results, &chat.ThreadSearchResult{
+			Thread: ct,
+			SearchResult: &chat.SearchResult{
+				Message:      &msg,
+				MessageIndex: hit.MessageIndex,
+				StartIndex:   matches[0].Start,
+				EndIndex:     matches[0].End,
+				Matches:      matches,
+				Score:        hit.Score,
+			},
+		})
+	}
+
+	return results
+}
@@ -0,0 +1,131 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outcomes of commands in the audit log.
+const (
+	// OutcomeRan is a command that ran, whatever its exit code.
+	OutcomeRan = "ran"
+
+	// OutcomeDenied is a command the policy didn't let run.
+	OutcomeDenied = "denied"
+
+	// OutcomeDeclined is a command the user didn't confirm.
+	OutcomeDeclined = "declined"
+
+	// OutcomeFailed is a command that couldn't be run, or was stopped.
+	OutcomeFailed = "failed"
+)
+
+// Entry is a command in the audit log, which is written as a line of JSON.
+type Entry struct {
+	Time time.Time `json:"time"`
+
+	// Thread is the ID of the thread the command was proposed in.
+	Thread string `json:"thread,omitempty"`
+
+	// Task is what the user asked for.
+	Task string `json:"task,omitempty"`
+
+	// Command is the command line, as it was run after being reviewed.
+	Command string `json:"command"`
+
+	// Classes are the names of the command's classes, like "write".
+	Classes []string `json:"classes"`
+
+	// Level is the name of the confirmation level the command needed.
+	Level string `json:"level"`
+
+	// Rule is the allow or deny rule that decided the level, if any.
+	Rule string `json:"rule,omitempty"`
+
+	// Outcome is what happened to the command, like "ran" or "denied".
+	Outcome string `json:"outcome"`
+
+	// ExitCode is the exit code of a command that ran.
+	ExitCode *int `json:"exit_code,omitempty"`
+
+	// Duration is how long a command that ran took, like "1.5s".
+	Duration string `json:"duration,omitempty"`
+
+	// Interactive is set for commands run in the terminal.
+	Interactive bool `json:"interactive,omitempty"`
+
+	// Sandboxed is set for commands run in a sandbox.
+	Sandboxed bool `json:"sandboxed,omitempty"`
+
+	// Error is why a command failed.
+	Error string `json:"error,omitempty"`
+}
+
+// NewEntry returns an entry for the command line, with the policy's
+// decision about it.
+func NewEntry(commandLine string, d Decision, outcome string) Entry {
+	classes := make([]string, len(d.Classes))
+	for i, class := range d.Classes {
+		classes[i] = class.String()
+	}
+
+	return Entry{
+		Time:    time.Now(),
+		Command: commandLine,
+		Classes: classes,
+		Level:   d.Level.String(),
+		Rule:    d.Rule,
+		Outcome: outcome,
+	}
+}
+
+// SetResult sets the exit code and duration of a command that ran.
+func (e *Entry) SetResult(exitCode int, duration time.Duration) {
+	e.ExitCode = &exitCode
+	e.Duration = duration.Round(time.Millisecond).String()
+}
+
+// AuditLog appends entries to a file, one line of JSON each. It's safe to
+// use from multiple goroutines.
+type AuditLog struct {
+	// Path is the path of the log file, which is created if it doesn't
+	// exist yet.
+	Path string
+
+	mu sync.Mutex
+}
+
+// Append writes the entry at the end of the log.
+func (l *AuditLog) Append(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit log entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0o700); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package policy
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	log := &AuditLog{Path: filepath.Join(t.TempDir(), "hal", "audit.log")}
+
+	p := &Policy{Deny: []string{"git push *"}}
+
+	denied := NewEntry("git push", p.Check("git push"), OutcomeDenied)
+	if err := log.Append(denied); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := NewEntry("rm -rf build", p.Check("rm -rf build"), OutcomeRan)
+	ran.Thread = "1"
+	ran.SetResult(1, 1500*time.Millisecond)
+	if err := log.Append(ran); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(log.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("expected the audit log to only be readable by its owner, got %v", perm)
+	}
+
+	f, err := os.Open(log.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("expected a line of JSON, got %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+
+	if e := entries[0]; e.Outcome != OutcomeDenied || e.Level != "deny" || e.Rule != "git push *" || e.ExitCode != nil {
+		t.Fatalf("unexpected denied entry: %+v", e)
+	}
+
+	if e := entries[1]; e.Outcome != OutcomeRan || e.Level != "type" || e.Classes[0] != "destructive" ||
+		e.ExitCode == nil || *e.ExitCode != 1 || e.Duration != "1.5s" || e.Thread != "1" {
+		t.Fatalf("unexpected ran entry: %+v", e)
+	}
+}
@@ -0,0 +1,468 @@
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Class is what a command can do, from the least to the most risky.
+type Class int
+
+const (
+	// ReadOnly commands only read files and print their output.
+	ReadOnly Class = iota
+
+	// Write commands change files, or can't be told not to, like commands
+	// that aren't known.
+	Write
+
+	// Network commands use the network.
+	Network
+
+	// Destructive commands can lose data in a way that can't be undone,
+	// like deleting files or force pushing.
+	Destructive
+)
+
+// Classes are all the classes of commands, from the least to the most risky.
+var Classes = []Class{ReadOnly, Write, Network, Destructive}
+
+// String returns the name of the class, as used in the configuration.
+func (c Class) String() string {
+	switch c {
+	case ReadOnly:
+		return "read_only"
+	case Write:
+		return "write"
+	case Network:
+		return "network"
+	case Destructive:
+		return "destructive"
+	default:
+		return fmt.Sprintf("Class(%d)", int(c))
+	}
+}
+
+// Description returns a short description of the class, to show to users.
+func (c Class) Description() string {
+	switch c {
+	case ReadOnly:
+		return "read-only"
+	case Write:
+		return "writes files"
+	case Network:
+		return "uses the network"
+	case Destructive:
+		return "destructive"
+	default:
+		return c.String()
+	}
+}
+
+// Classification is what a command line does, as far as can be told without
+// running it.
+type Classification struct {
+	// Classes are the classes of the commands of the command line, from
+	// the least to the most risky. It's only ReadOnly if every command is.
+	Classes []Class
+
+	// Reasons explain why the command line has its classes, like "rm
+	// deletes files".
+	Reasons []string
+
+	// commands are the simple commands of the command line, including
+	// those run by other commands, which rules are matched against.
+	commands []ruleCommand
+
+	// redirects is true if the command line writes to files with
+	// redirections, which rules aren't matched against.
+	redirects bool
+}
+
+// ruleCommand is a simple command as matched by rules.
+type ruleCommand struct {
+	// text is the command as it was written, without variable
+	// assignments and redirections.
+	text string
+
+	// unwrapped is the command without wrappers like sudo or nohup, or
+	// the global options of git.
+	unwrapped string
+}
+
+// Class returns the most risky class of the command line.
+func (c Classification) Class() Class {
+	if len(c.Classes) == 0 {
+		return ReadOnly
+	}
+	return c.Classes[len(c.Classes)-1]
+}
+
+// Has returns true if the command line has the class.
+func (c Classification) Has(class Class) bool {
+	for _, has := range c.Classes {
+		if has == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify returns what the command line does. Commands that aren't known
+// are assumed to write files, and command lines that can't be parsed are
+// assumed to be destructive.
+func Classify(commandLine string) Classification {
+	var c Classification
+
+	commands, err := parse(commandLine)
+	if err != nil {
+		c.add(Destructive, fmt.Sprintf("the command couldn't be checked: %v", err))
+	}
+
+	for _, cmd := range commands {
+		for _, file := range cmd.writes {
+			c.add(Write, "writes to "+file)
+			c.redirects = true
+		}
+		c.command(cmd.args, cmd.piped)
+	}
+
+	if len(c.Classes) == 0 {
+		c.Classes = []Class{ReadOnly}
+	}
+
+	return c
+}
+
+// add adds the class, and the reason for it, if they weren't added yet.
+// ReadOnly is never added, since it's only used when there's no other class.
+func (c *Classification) add(class Class, reason string) {
+	if class != ReadOnly && !c.Has(class) {
+		c.Classes = append(c.Classes, class)
+		sort.Slice(c.Classes, func(i, j int) bool { return c.Classes[i] < c.Classes[j] })
+	}
+
+	if reason == "" {
+		return
+	}
+	for _, r := range c.Reasons {
+		if r == reason {
+			return
+		}
+	}
+	c.Reasons = append(c.Reasons, reason)
+}
+
+// line classifies a command line run by another command, like sh -c.
+func (c *Classification) line(commandLine string) {
+	nested := Classify(commandLine)
+
+	for _, class := range nested.Classes {
+		if class != ReadOnly {
+			c.add(class, "")
+		}
+	}
+	for _, reason := range nested.Reasons {
+		c.add(ReadOnly, reason)
+	}
+
+	c.commands = append(c.commands, nested.commands...)
+	c.redirects = c.redirects || nested.redirects
+}
+
+// command classifies a simple command.
+func (c *Classification) command(args []string, piped bool) {
+	args = skipKeywords(args)
+	if len(args) == 0 {
+		return
+	}
+
+	text := strings.Join(args, " ")
+
+	for len(args) > 0 {
+		skip, ok := wrappers[filepath.Base(args[0])]
+		if !ok {
+			break
+		}
+
+		if filepath.Base(args[0]) == "sudo" || filepath.Base(args[0]) == "doas" {
+			c.add(Write, args[0]+" runs as another user")
+		}
+
+		args = skipOptions(args[1:], skip)
+	}
+
+	if len(args) == 0 {
+		c.commands = append(c.commands, ruleCommand{text: text, unwrapped: text})
+		return
+	}
+
+	name := filepath.Base(args[0])
+
+	// Git's global options, like "git -C dir push", would keep rules like
+	// "git push *" from matching.
+	unwrapped := args
+	if name == "git" {
+		unwrapped = append([]string{args[0]}, skipGitOptions(args[1:])...)
+	}
+
+	c.commands = append(c.commands, ruleCommand{text: text, unwrapped: strings.Join(unwrapped, " ")})
+
+	if classify, ok := commands[name]; ok {
+		classify(c, name, args[1:])
+		return
+	}
+
+	if class, ok := simpleCommands[name]; ok {
+		c.add(class.class, name+" "+class.reason)
+		return
+	}
+
+	switch {
+	case readOnlyCommands[name]:
+	case shells[name]:
+		c.shell(name, args[1:], piped)
+	case strings.HasPrefix(name, "mkfs"):
+		c.add(Destructive, name+" formats a file system")
+	default:
+		c.add(Write, name+" isn't known to be read-only")
+	}
+}
+
+// shell classifies a shell, which runs its -c argument, a script, or the
+// commands piped into it.
+func (c *Classification) shell(name string, args []string, piped bool) {
+	for i, arg := range args {
+		switch {
+		case arg == "-c" && i+1 < len(args):
+			c.line(args[i+1])
+			return
+		case !strings.HasPrefix(arg, "-"):
+			c.add(Write, fmt.Sprintf("%s runs the script %s", name, arg))
+			return
+		}
+	}
+
+	if piped {
+		c.add(Destructive, name+" runs the commands piped into it")
+	} else {
+		c.add(Write, name+" runs a new shell")
+	}
+}
+
+// keywords are the shell's reserved words, which can start a simple command
+// without being the command.
+var keywords = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "fi": true,
+	"do": true, "done": true, "while": true, "until": true,
+	"!": true, "{": true, "}": true,
+}
+
+// skipKeywords removes the reserved words from the start of the command.
+// Loop headers like "for x in a b" are removed entirely, since their words
+// aren't run.
+func skipKeywords(args []string) []string {
+	for len(args) > 0 && keywords[args[0]] {
+		args = args[1:]
+	}
+
+	if len(args) > 0 && (args[0] == "for" || args[0] == "select" || args[0] == "case" || args[0] == "esac") {
+		return nil
+	}
+
+	return args
+}
+
+// wrappers are commands that run the rest of their arguments as a command,
+// with the options of each that take a value.
+var wrappers = map[string]map[string]bool{
+	"sudo":    {"-u": true, "-g": true, "-C": true, "-D": true, "-h": true, "-p": true, "-U": true},
+	"doas":    {"-u": true, "-C": true},
+	"env":     {"-u": true, "-C": true, "-S": true},
+	"nice":    {"-n": true},
+	"ionice":  {"-c": true, "-n": true, "-p": true},
+	"nohup":   {},
+	"command": {},
+	"builtin": {},
+	"exec":    {"-a": true},
+	"stdbuf":  {"-i": true, "-o": true, "-e": true},
+	"timeout": {"-s": true, "-k": true, "--signal": true, "--kill-after": true},
+	"watch":   {"-n": true, "-d": true, "--interval": true},
+	"xargs":   {"-I": true, "-n": true, "-L": true, "-P": true, "-d": true, "-s": true, "-E": true, "-a": true},
+	"time":    {"-f": true, "-o": true},
+}
+
+// skipOptions removes the options of a wrapper from the start of its
+// arguments, including the values of those that take one, variable
+// assignments, and the duration of timeout.
+func skipOptions(args []string, withValue map[string]bool) []string {
+	for len(args) > 0 {
+		arg := args[0]
+
+		switch {
+		case arg == "--":
+			return args[1:]
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			args = args[1:]
+			if withValue[arg] && len(args) > 0 {
+				args = args[1:]
+			}
+		case isAssignment(arg):
+			args = args[1:]
+		case isDuration(arg):
+			args = args[1:]
+		default:
+			return args
+		}
+	}
+
+	return args
+}
+
+// isDuration returns true if the argument is a duration as taken by timeout,
+// like "10" or "1.5m".
+func isDuration(arg string) bool {
+	return arg != "" && arg[0] >= '0' && arg[0] <= '9' && strings.Trim(strings.TrimRight(arg, "smhd"), "0123456789.") == ""
+}
+
+// readOnlyCommands are commands that don't change anything, whatever their
+// arguments.
+var readOnlyCommands = setOf(
+	"ls", "ll", "la", "dir", "cat", "tac", "head", "tail", "less", "more", "bat",
+	"grep", "egrep", "fgrep", "rg", "ag", "ack", "wc", "du", "df", "pwd", "echo",
+	"printf", "which", "whereis", "type", "file", "stat", "uniq", "cut",
+	"tr", "cal", "whoami", "id", "groups", "uname",
+	"printenv", "ps", "pgrep", "top", "htop", "free", "uptime", "diff", "cmp",
+	"comm", "md5sum", "sha1sum", "sha256sum", "sha512sum", "shasum", "cksum",
+	"basename", "dirname", "realpath", "readlink", "jq", "yq", "true", "false",
+	"test", "[", "[[", "seq", "column", "nl", "rev", "fold", "fmt", "paste",
+	"join", "od", "hexdump", "strings", "man", "help", "lsof", "sleep",
+	"wait", "expr", "bc", "cd", "pushd", "popd", "set", "export", "local",
+	"read", "shift", "alias", "unalias", "lsblk", "lscpu", "nproc",
+	"locale", "tput", "clear", "look", "apropos", "whatis", "info", "vmstat",
+	"iostat", "netstat", "ss", "findmnt", "getent",
+	"stty", "tty", "yes", "numfmt", "envsubst", "zcat", "zgrep", "zless",
+	"gzcat", "bzcat", "xzcat", "sw_vers", "lsb_release", "w", "who", "last",
+	"dmesg", "mawk",
+)
+
+// simpleClass is the class of a command, whatever its arguments, and why.
+type simpleClass struct {
+	class  Class
+	reason string
+}
+
+// simpleCommands are commands whose class doesn't depend on their arguments.
+var simpleCommands = map[string]simpleClass{
+	"cp":         {Write, "copies files"},
+	"mv":         {Write, "moves files"},
+	"mkdir":      {Write, "creates directories"},
+	"rmdir":      {Write, "removes directories"},
+	"touch":      {Write, "changes files"},
+	"ln":         {Write, "creates links"},
+	"chmod":      {Write, "changes permissions"},
+	"chown":      {Write, "changes owners"},
+	"chgrp":      {Write, "changes groups"},
+	"install":    {Write, "copies files"},
+	"patch":      {Write, "changes files"},
+	"split":      {Write, "writes files"},
+	"zip":        {Write, "writes archives"},
+	"unzip":      {Write, "extracts archives"},
+	"gzip":       {Write, "compresses files"},
+	"gunzip":     {Write, "decompresses files"},
+	"bzip2":      {Write, "compresses files"},
+	"xz":         {Write, "compresses files"},
+	"make":       {Write, "runs build commands"},
+	"source":     {Write, "runs a script"},
+	".":          {Write, "runs a script"},
+	"shred":      {Destructive, "overwrites files"},
+	"truncate":   {Destructive, "empties files"},
+	"wipefs":     {Destructive, "erases file systems"},
+	"fdisk":      {Destructive, "changes partitions"},
+	"parted":     {Destructive, "changes partitions"},
+	"kill":       {Destructive, "stops processes"},
+	"pkill":      {Destructive, "stops processes"},
+	"killall":    {Destructive, "stops processes"},
+	"shutdown":   {Destructive, "shuts down the machine"},
+	"reboot":     {Destructive, "restarts the machine"},
+	"halt":       {Destructive, "shuts down the machine"},
+	"poweroff":   {Destructive, "shuts down the machine"},
+	"ssh":        {Network, "connects to another machine"},
+	"sftp":       {Network, "connects to another machine"},
+	"telnet":     {Network, "connects to another machine"},
+	"ftp":        {Network, "connects to another machine"},
+	"nc":         {Network, "uses the network"},
+	"ncat":       {Network, "uses the network"},
+	"netcat":     {Network, "uses the network"},
+	"ping":       {Network, "uses the network"},
+	"dig":        {Network, "uses the network"},
+	"nslookup":   {Network, "uses the network"},
+	"host":       {Network, "uses the network"},
+	"whois":      {Network, "uses the network"},
+	"mtr":        {Network, "uses the network"},
+	"http":       {Network, "uses the network"},
+	"traceroute": {Network, "uses the network"},
+	"gh":         {Network, "uses the GitHub API"},
+}
+
+// shells are the shells that run commands given to them.
+var shells = setOf("sh", "bash", "zsh", "dash", "ksh", "fish")
+
+// commands classify commands whose class depends on their arguments. They're
+// set by init, since some of them classify the commands they run.
+var commands map[string]func(c *Classification, name string, args []string)
+
+func init() {
+	commands = map[string]func(c *Classification, name string, args []string){
+		"rm":         classifyRm,
+		"eval":       classifyEval,
+		"sort":       classifySort,
+		"tree":       classifyTree,
+		"xxd":        classifyXxd,
+		"journalctl": classifyJournalctl,
+		"date":       classifyDate,
+		"history":    classifyHistory,
+		"find":       classifyFind,
+		"sed":        classifySed,
+		"awk":        classifyAwk,
+		"gawk":       classifyAwk,
+		"tee":        classifyTee,
+		"tar":        classifyTar,
+		"dd":         classifyDd,
+		"curl":       classifyCurl,
+		"wget":       classifyDownload,
+		"scp":        classifyDownload,
+		"rsync":      classifyDownload,
+		"crontab":    classifyCrontab,
+		"git":        classifyGit,
+		"go":         classifySubcommand(goSubcommands),
+		"npm":        classifySubcommand(packageSubcommands),
+		"yarn":       classifySubcommand(packageSubcommands),
+		"pnpm":       classifySubcommand(packageSubcommands),
+		"pip":        classifySubcommand(packageSubcommands),
+		"pip3":       classifySubcommand(packageSubcommands),
+		"gem":        classifySubcommand(packageSubcommands),
+		"cargo":      classifySubcommand(packageSubcommands),
+		"brew":       classifySubcommand(packageSubcommands),
+		"apt":        classifySubcommand(packageSubcommands),
+		"apt-get":    classifySubcommand(packageSubcommands),
+		"dnf":        classifySubcommand(packageSubcommands),
+		"yum":        classifySubcommand(packageSubcommands),
+		"docker":     classifySubcommand(containerSubcommands),
+		"podman":     classifySubcommand(containerSubcommands),
+		"kubectl":    classifySubcommand(clusterSubcommands),
+		"terraform":  classifySubcommand(terraformSubcommands),
+		"systemctl":  classifySubcommand(serviceSubcommands),
+	}
+}
+
+// setOf returns a set of the given names.
+func setOf(names ...string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
@@ -0,0 +1,672 @@
+package policy
+
+import (
+	"strings"
+)
+
+// classReasons are what commands of each class do, used as the reason for
+// commands classified by their subcommand, like "npm install uses the
+// network".
+var classReasons = map[Class]string{
+	Write:       "changes files",
+	Network:     "uses the network",
+	Destructive: "can lose data",
+}
+
+// subcommands are the classes of the subcommands of a command, keyed by one
+// or two words, like "install" or "system prune". Subcommands that aren't
+// listed are assumed to write files, and ones listed without a class are
+// read-only.
+type subcommands map[string][]Class
+
+// classifySubcommand returns a function classifying a command by its
+// subcommand, which is its first argument that isn't an option.
+func classifySubcommand(table subcommands) func(c *Classification, name string, args []string) {
+	return func(c *Classification, name string, args []string) {
+		words := positional(args)
+		if len(words) == 0 {
+			return
+		}
+
+		sub := words[0]
+		classes, ok := table[sub]
+
+		if len(words) > 1 {
+			if two, ok2 := table[words[0]+" "+words[1]]; ok2 {
+				sub, classes, ok = words[0]+" "+words[1], two, true
+			}
+		}
+
+		if !ok {
+			c.add(Write, name+" "+sub+" isn't known to be read-only")
+			return
+		}
+
+		for _, class := range classes {
+			c.add(class, name+" "+sub+" "+classReasons[class])
+		}
+	}
+}
+
+// positional returns the arguments that aren't options.
+func positional(args []string) []string {
+	var words []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			words = append(words, arg)
+		}
+	}
+	return words
+}
+
+// hasOption returns true if the arguments contain any of the options, which
+// can be long options with a value like --force=true, or short options
+// combined like -rf.
+func hasOption(args []string, options ...string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+
+		for _, option := range options {
+			switch {
+			case arg == option:
+				return true
+			case strings.HasPrefix(option, "--") && strings.HasPrefix(arg, option+"="):
+				return true
+			case len(option) == 2 && option[0] == '-' && option[1] != '-' &&
+				len(arg) > 2 && arg[0] == '-' && arg[1] != '-' && strings.ContainsRune(arg[1:], rune(option[1])):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func classifyRm(c *Classification, name string, args []string) {
+	if hasOption(args, "-r", "-R", "--recursive") {
+		c.add(Destructive, name+" -r deletes files and directories recursively")
+		return
+	}
+	c.add(Destructive, name+" deletes files")
+}
+
+// classifyEval classifies the command line eval runs, which is its arguments
+// joined with spaces.
+func classifyEval(c *Classification, name string, args []string) {
+	if len(args) > 0 {
+		c.line(strings.Join(args, " "))
+	}
+}
+
+// optionValues returns the values of the options, which can be separate
+// arguments like "-o out", attached to short options like "-oout", or long
+// options like "--output=out".
+func optionValues(args []string, options ...string) []string {
+	var values []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+
+		for _, option := range options {
+			switch {
+			case arg == option && i+1 < len(args):
+				values = append(values, args[i+1])
+				i++
+			case strings.HasPrefix(option, "--") && strings.HasPrefix(arg, option+"="):
+				values = append(values, strings.TrimPrefix(arg, option+"="))
+			case !strings.HasPrefix(option, "--") && !strings.HasPrefix(arg, "--") && len(arg) > len(option) && strings.HasPrefix(arg, option):
+				values = append(values, strings.TrimPrefix(arg, option))
+			default:
+				continue
+			}
+			break
+		}
+	}
+
+	return values
+}
+
+func classifySort(c *Classification, name string, args []string) {
+	for _, file := range optionValues(args, "-o", "--output") {
+		c.add(Write, name+" -o writes to "+file)
+	}
+}
+
+func classifyTree(c *Classification, name string, args []string) {
+	for _, file := range optionValues(args, "-o") {
+		c.add(Write, name+" -o writes to "+file)
+	}
+}
+
+// xxdOptions are the options of xxd that take a value.
+var xxdOptions = setOf("-c", "-cols", "-g", "-groupsize", "-l", "-len", "-s", "-seek", "-o", "-n", "-name")
+
+// classifyXxd classifies xxd, which writes to its second file, if any, like
+// "xxd -r in out".
+func classifyXxd(c *Classification, name string, args []string) {
+	var files []string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case xxdOptions[args[i]]:
+			i++
+		case strings.HasPrefix(args[i], "-") && args[i] != "-":
+		default:
+			files = append(files, args[i])
+		}
+	}
+
+	if len(files) > 1 && files[1] != "-" {
+		c.add(Write, name+" writes to "+files[1])
+	}
+}
+
+func classifyJournalctl(c *Classification, name string, args []string) {
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--vacuum-"):
+			c.add(Destructive, name+" "+strings.SplitN(arg, "=", 2)[0]+" deletes journal files")
+		case arg == "--rotate" || arg == "--flush" || arg == "--sync" || arg == "--relinquish-var" ||
+			arg == "--smart-relinquish-var" || arg == "--setup-keys" || arg == "--update-catalog":
+			c.add(Write, name+" "+arg+" changes journal files")
+		}
+	}
+}
+
+// classifyDate classifies date, which sets the system clock with -s, or a
+// time that isn't a format like "+%F".
+func classifyDate(c *Classification, name string, args []string) {
+	if len(optionValues(args, "-s", "--set")) > 0 {
+		c.add(Write, name+" -s sets the system clock")
+		return
+	}
+
+	for _, arg := range positional(args) {
+		if !strings.HasPrefix(arg, "+") && len(optionValues(args, "-d", "--date", "-r", "--reference", "-f", "--file")) == 0 {
+			c.add(Write, name+" "+arg+" sets the system clock")
+			return
+		}
+	}
+}
+
+func classifyHistory(c *Classification, name string, args []string) {
+	switch {
+	case hasOption(args, "-c"):
+		c.add(Destructive, name+" -c clears the shell history")
+	case hasOption(args, "-d"):
+		c.add(Destructive, name+" -d deletes shell history entries")
+	case hasOption(args, "-w", "-a", "-s"):
+		c.add(Write, name+" writes the shell history")
+	}
+}
+
+func classifyFind(c *Classification, name string, args []string) {
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-delete":
+			c.add(Destructive, name+" -delete deletes files")
+		case "-fprint", "-fprint0", "-fprintf", "-fls":
+			c.add(Write, name+" "+args[i]+" writes files")
+		case "-exec", "-execdir", "-ok", "-okdir":
+			// The command runs up to a ";" or "+" argument.
+			end := i + 1
+			for end < len(args) && args[end] != ";" && args[end] != "+" {
+				end++
+			}
+			c.command(args[i+1:end], false)
+			i = end
+		}
+	}
+}
+
+func classifySed(c *Classification, name string, args []string) {
+	if hasOption(args, "-i", "--in-place") {
+		c.add(Write, name+" -i changes files in place")
+		return
+	}
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-i") && !strings.HasPrefix(arg, "--") {
+			c.add(Write, name+" -i changes files in place")
+			return
+		}
+	}
+}
+
+func classifyAwk(c *Classification, name string, args []string) {
+	for _, arg := range args {
+		if strings.Contains(arg, "system(") {
+			c.add(Write, name+" runs commands with system()")
+			return
+		}
+	}
+}
+
+func classifyTee(c *Classification, name string, args []string) {
+	for _, file := range positional(args) {
+		c.add(Write, name+" writes to "+file)
+	}
+}
+
+func classifyTar(c *Classification, name string, args []string) {
+	if len(args) == 0 {
+		return
+	}
+
+	// The first argument can be the options without a dash, like "xzf".
+	mode := args[0]
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") {
+			mode += arg
+		}
+	}
+
+	if hasOption(args, "-t", "--list") || !strings.HasPrefix(args[0], "-") && strings.ContainsRune(args[0], 't') {
+		return
+	}
+
+	if strings.ContainsAny(strings.TrimLeft(mode, "-"), "cxru") || hasOption(args, "--create", "--extract", "--get", "--append", "--update", "--delete") {
+		c.add(Write, name+" writes files")
+	}
+}
+
+func classifyDd(c *Classification, name string, args []string) {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "of=") {
+			c.add(Destructive, name+" overwrites "+strings.TrimPrefix(arg, "of="))
+		}
+	}
+}
+
+func classifyCurl(c *Classification, name string, args []string) {
+	c.add(Network, name+" uses the network")
+
+	if hasOption(args, "-o", "-O", "--output", "--remote-name", "--remote-name-all", "--output-dir") {
+		c.add(Write, name+" saves files")
+	}
+}
+
+func classifyDownload(c *Classification, name string, args []string) {
+	c.add(Network, name+" uses the network")
+
+	// wget -O - prints what it downloads.
+	for i, arg := range args {
+		if name == "wget" && (strings.HasSuffix(arg, "O-") || strings.HasSuffix(arg, "O") && i+1 < len(args) && args[i+1] == "-") {
+			return
+		}
+	}
+
+	c.add(Write, name+" saves files")
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--delete") || strings.HasPrefix(arg, "--remove-source-files") {
+			c.add(Destructive, name+" "+arg+" deletes files")
+		}
+	}
+}
+
+func classifyCrontab(c *Classification, name string, args []string) {
+	switch {
+	case hasOption(args, "-r"):
+		c.add(Destructive, name+" -r removes every job")
+	case hasOption(args, "-l"):
+	default:
+		c.add(Write, name+" changes jobs")
+	}
+}
+
+// gitReadOnly are the git subcommands that don't change anything.
+var gitReadOnly = setOf(
+	"status", "log", "diff", "show", "blame", "annotate", "shortlog", "describe",
+	"rev-parse", "rev-list", "ls-files", "ls-tree", "cat-file", "grep", "help",
+	"version", "whatchanged", "name-rev", "cherry", "count-objects", "show-ref",
+	"show-branch", "merge-base", "for-each-ref", "var", "check-ignore",
+	"check-attr", "range-diff", "verify-commit", "verify-tag", "fsck", "difftool",
+)
+
+// skipGitOptions removes git's global options from the start of its
+// arguments, like "-C dir" or "--git-dir=.git", including the values of those
+// that take one.
+func skipGitOptions(args []string) []string {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-C", "-c", "--git-dir", "--work-tree", "--namespace", "--exec-path":
+			args = args[1:]
+		}
+		if len(args) > 0 {
+			args = args[1:]
+		}
+	}
+	return args
+}
+
+func classifyGit(c *Classification, name string, args []string) {
+	args = skipGitOptions(args)
+	if len(args) == 0 {
+		return
+	}
+
+	var (
+		sub   = args[0]
+		rest  = args[1:]
+		words = positional(rest)
+		what  = name + " " + sub
+	)
+
+	switch sub {
+	case "push":
+		c.add(Network, what+" uses the network")
+
+		forced := hasOption(rest, "-f", "--force", "--force-with-lease", "--mirror", "-d", "--delete", "--prune")
+		for _, word := range words {
+			if strings.HasPrefix(word, "+") || strings.HasPrefix(word, ":") {
+				forced = true
+			}
+		}
+		if forced {
+			c.add(Destructive, what+" can overwrite or delete remote branches")
+		}
+	case "fetch", "ls-remote":
+		c.add(Network, what+" uses the network")
+	case "pull", "clone", "submodule":
+		c.add(Network, what+" uses the network")
+		c.add(Write, what+" changes files")
+	case "reset":
+		if hasOption(rest, "--hard", "--merge", "--keep") {
+			c.add(Destructive, what+" --hard discards changes")
+		} else {
+			c.add(Write, what+" changes the index")
+		}
+	case "clean":
+		if !hasOption(rest, "-n", "--dry-run") {
+			c.add(Destructive, what+" deletes untracked files")
+		}
+	case "checkout":
+		discards := hasOption(rest, "-f", "--force")
+		for _, arg := range rest {
+			if arg == "--" || arg == "." {
+				discards = true
+			}
+		}
+		if discards {
+			c.add(Destructive, what+" discards changes")
+		} else {
+			c.add(Write, what+" changes files")
+		}
+	case "restore":
+		if hasOption(rest, "-S", "--staged") && !hasOption(rest, "-W", "--worktree") {
+			c.add(Write, what+" changes the index")
+		} else {
+			c.add(Destructive, what+" discards changes")
+		}
+	case "stash":
+		switch {
+		case len(words) > 0 && (words[0] == "list" || words[0] == "show"):
+		case len(words) > 0 && (words[0] == "drop" || words[0] == "clear"):
+			c.add(Destructive, what+" "+words[0]+" deletes stashed changes")
+		default:
+			c.add(Write, what+" changes files")
+		}
+	case "branch":
+		switch {
+		case hasOption(rest, "-D") || hasOption(rest, "-d", "--delete") && hasOption(rest, "-f", "--force"):
+			c.add(Destructive, what+" -D deletes unmerged branches")
+		case len(words) > 0 || hasOption(rest, "-d", "--delete", "-m", "-M", "-c", "-C", "-u", "--set-upstream-to", "--unset-upstream", "--edit-description"):
+			c.add(Write, what+" changes branches")
+		}
+	case "tag":
+		if len(words) > 0 && !hasOption(rest, "-l", "--list", "-v", "--verify") {
+			c.add(Write, what+" changes tags")
+		}
+	case "remote":
+		switch {
+		case len(words) == 0 || words[0] == "get-url":
+		case words[0] == "show" || words[0] == "update" || words[0] == "prune":
+			c.add(Network, what+" "+words[0]+" uses the network")
+		default:
+			c.add(Write, what+" changes remotes")
+		}
+	case "config":
+		if !hasOption(rest, "--get", "--get-all", "--get-regexp", "-l", "--list") && len(words) != 1 {
+			c.add(Write, what+" changes the configuration")
+		}
+	case "reflog":
+		if len(words) > 0 && (words[0] == "expire" || words[0] == "delete") {
+			c.add(Destructive, what+" "+words[0]+" deletes history")
+		}
+	case "filter-branch", "filter-repo":
+		c.add(Destructive, what+" rewrites history")
+	case "update-ref":
+		if hasOption(rest, "-d") {
+			c.add(Destructive, what+" -d deletes references")
+		} else {
+			c.add(Write, what+" changes references")
+		}
+	default:
+		if !gitReadOnly[sub] {
+			c.add(Write, what+" changes the repository")
+		}
+	}
+
+	// Commands showing diffs can write them to a file instead.
+	for _, file := range optionValues(rest, "--output") {
+		c.add(Write, what+" --output writes to "+file)
+	}
+}
+
+var goSubcommands = subcommands{
+	"version":      nil,
+	"env":          nil,
+	"doc":          nil,
+	"list":         nil,
+	"help":         nil,
+	"vet":          nil,
+	"build":        {Write},
+	"test":         {Write},
+	"run":          {Write},
+	"generate":     {Write},
+	"fmt":          {Write},
+	"fix":          {Write},
+	"clean":        {Write},
+	"tool":         {Write},
+	"work":         {Write},
+	"mod graph":    nil,
+	"mod why":      nil,
+	"mod verify":   nil,
+	"mod edit":     {Write},
+	"mod init":     {Write},
+	"mod vendor":   {Write, Network},
+	"mod tidy":     {Write, Network},
+	"mod download": {Network},
+	"get":          {Write, Network},
+	"install":      {Write, Network},
+}
+
+// packageSubcommands are the subcommands of package managers, which mostly
+// share their names.
+var packageSubcommands = subcommands{
+	"list":       nil,
+	"ls":         nil,
+	"show":       nil,
+	"info":       nil,
+	"freeze":     nil,
+	"help":       nil,
+	"version":    nil,
+	"which":      nil,
+	"why":        nil,
+	"check":      {Write},
+	"view":       {Network},
+	"search":     {Network},
+	"outdated":   {Network},
+	"audit":      {Network},
+	"install":    {Write, Network},
+	"i":          {Write, Network},
+	"ci":         {Write, Network},
+	"add":        {Write, Network},
+	"update":     {Write, Network},
+	"upgrade":    {Write, Network},
+	"download":   {Write, Network},
+	"fetch":      {Write, Network},
+	"publish":    {Write, Network},
+	"login":      {Network},
+	"run":        {Write},
+	"test":       {Write},
+	"exec":       {Write},
+	"start":      {Write},
+	"build":      {Write},
+	"init":       {Write},
+	"link":       {Write},
+	"remove":     {Write},
+	"uninstall":  {Write},
+	"rm":         {Write},
+	"autoremove": {Write},
+	"clean":      {Write},
+	"purge":      {Destructive},
+}
+
+// containerSubcommands are the subcommands of docker and podman.
+var containerSubcommands = subcommands{
+	"ps":                nil,
+	"images":            nil,
+	"logs":              nil,
+	"inspect":           nil,
+	"version":           nil,
+	"info":              nil,
+	"stats":             nil,
+	"top":               nil,
+	"history":           nil,
+	"port":              nil,
+	"diff":              nil,
+	"events":            nil,
+	"compose ps":        nil,
+	"compose logs":      nil,
+	"compose config":    nil,
+	"pull":              {Network},
+	"push":              {Network},
+	"login":             {Network},
+	"search":            {Network},
+	"build":             {Write, Network},
+	"compose pull":      {Network},
+	"compose build":     {Write, Network},
+	"compose up":        {Write, Network},
+	"run":               {Write, Network},
+	"exec":              {Write},
+	"start":             {Write},
+	"stop":              {Write},
+	"restart":           {Write},
+	"create":            {Write},
+	"cp":                {Write},
+	"tag":               {Write},
+	"commit":            {Write},
+	"load":              {Write},
+	"save":              {Write},
+	"pause":             {Write},
+	"unpause":           {Write},
+	"rm":                {Destructive},
+	"rmi":               {Destructive},
+	"kill":              {Destructive},
+	"compose down":      {Destructive},
+	"compose rm":        {Destructive},
+	"system prune":      {Destructive},
+	"image prune":       {Destructive},
+	"image rm":          {Destructive},
+	"container prune":   {Destructive},
+	"container rm":      {Destructive},
+	"volume prune":      {Destructive},
+	"volume rm":         {Destructive},
+	"network prune":     {Destructive},
+	"network rm":        {Destructive},
+	"builder prune":     {Destructive},
+	"volume ls":         nil,
+	"network ls":        nil,
+	"image ls":          nil,
+	"container ls":      nil,
+	"volume inspect":    nil,
+	"network inspect":   nil,
+	"image inspect":     nil,
+	"container inspect": nil,
+}
+
+// clusterSubcommands are the subcommands of kubectl, which all talk to a
+// cluster.
+var clusterSubcommands = subcommands{
+	"get":            {Network},
+	"describe":       {Network},
+	"logs":           {Network},
+	"explain":        {Network},
+	"version":        {Network},
+	"top":            {Network},
+	"api-resources":  {Network},
+	"cluster-info":   {Network},
+	"config view":    nil,
+	"config":         {Write},
+	"apply":          {Write, Network},
+	"create":         {Write, Network},
+	"edit":           {Write, Network},
+	"patch":          {Write, Network},
+	"scale":          {Write, Network},
+	"rollout":        {Write, Network},
+	"exec":           {Write, Network},
+	"run":            {Write, Network},
+	"set":            {Write, Network},
+	"label":          {Write, Network},
+	"annotate":       {Write, Network},
+	"expose":         {Write, Network},
+	"cp":             {Write, Network},
+	"port-forward":   {Network},
+	"autoscale":      {Write, Network},
+	"cordon":         {Write, Network},
+	"uncordon":       {Write, Network},
+	"delete":         {Network, Destructive},
+	"drain":          {Network, Destructive},
+	"replace":        {Network, Destructive},
+	"rollout status": {Network},
+}
+
+var terraformSubcommands = subcommands{
+	"version":    nil,
+	"show":       nil,
+	"output":     nil,
+	"providers":  nil,
+	"graph":      nil,
+	"validate":   nil,
+	"fmt":        {Write},
+	"init":       {Write, Network},
+	"plan":       {Network},
+	"refresh":    {Write, Network},
+	"apply":      {Write, Network},
+	"import":     {Write, Network},
+	"state":      {Write},
+	"state list": nil,
+	"state show": nil,
+	"state rm":   {Destructive},
+	"destroy":    {Network, Destructive},
+}
+
+// serviceSubcommands are the subcommands of systemctl.
+var serviceSubcommands = subcommands{
+	"status":          nil,
+	"show":            nil,
+	"cat":             nil,
+	"list-units":      nil,
+	"list-unit-files": nil,
+	"list-timers":     nil,
+	"is-active":       nil,
+	"is-enabled":      nil,
+	"is-failed":       nil,
+	"start":           {Write},
+	"stop":            {Write},
+	"restart":         {Write},
+	"reload":          {Write},
+	"enable":          {Write},
+	"disable":         {Write},
+	"mask":            {Write},
+	"unmask":          {Write},
+	"daemon-reload":   {Write},
+	"poweroff":        {Destructive},
+	"reboot":          {Destructive},
+	"halt":            {Destructive},
+}
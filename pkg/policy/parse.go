@@ -0,0 +1,470 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// command is a simple command of a command line, like one side of a pipe.
+type command struct {
+	// args are the words of the command with quotes removed, without
+	// variable assignments and redirections.
+	args []string
+
+	// writes are the files the command's output is redirected to.
+	writes []string
+
+	// piped is set if the command's input is piped from another command.
+	piped bool
+}
+
+// redirect is the kind of redirection waiting for its target.
+type redirect int
+
+const (
+	redirectNone redirect = iota
+	redirectInput
+	redirectOutput
+	redirectHeredoc
+)
+
+// parser splits a command line into simple commands. It understands enough
+// of the POSIX shell syntax to find every command that would be run, which
+// includes those in command substitutions, but it doesn't expand anything.
+type parser struct {
+	src []rune
+	pos int
+
+	commands []command
+	cur      command
+
+	word     strings.Builder
+	inWord   bool
+	redirect redirect
+
+	// heredocs are the delimiters of the here-documents that start after
+	// the current line.
+	heredocs []string
+
+	// nested are the command lines of substitutions, parsed once the
+	// outer command line is.
+	nested []string
+}
+
+// parse returns the simple commands of the command line, followed by those
+// of its substitutions.
+func parse(line string) ([]command, error) {
+	p := &parser{src: []rune(line)}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+
+	commands := p.commands
+	for _, nested := range p.nested {
+		nestedCommands, err := parse(nested)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, nestedCommands...)
+	}
+
+	return commands, nil
+}
+
+func (p *parser) peek(offset int) rune {
+	if p.pos+offset < len(p.src) {
+		return p.src[p.pos+offset]
+	}
+	return 0
+}
+
+func (p *parser) parse() error {
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+
+		switch {
+		case r == '\n':
+			p.endCommand(false)
+			p.pos++
+			p.skipHeredocs()
+			continue
+		case unicode.IsSpace(r):
+			p.endWord()
+		case r == '#' && !p.inWord:
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		case r == '\\':
+			p.pos++
+			if p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.word.WriteRune(p.src[p.pos])
+				p.inWord = true
+			}
+		case r == '\'':
+			end := p.pos + 1
+			for end < len(p.src) && p.src[end] != '\'' {
+				end++
+			}
+			if end == len(p.src) {
+				return errors.New("unterminated single quote")
+			}
+			p.word.WriteString(string(p.src[p.pos+1 : end]))
+			p.pos = end
+			p.inWord = true
+		case r == '"':
+			if err := p.doubleQuote(); err != nil {
+				return err
+			}
+			continue
+		case r == '`':
+			if err := p.backquote(); err != nil {
+				return err
+			}
+			continue
+		case r == '$':
+			if err := p.dollar(); err != nil {
+				return err
+			}
+			continue
+		case r == '|':
+			p.endWord()
+			if p.peek(1) == '|' {
+				p.pos++
+				p.endCommand(false)
+			} else {
+				if p.peek(1) == '&' {
+					p.pos++
+				}
+				p.endCommand(true)
+			}
+		case r == '&':
+			p.endWord()
+			switch p.peek(1) {
+			case '&':
+				p.pos++
+				p.endCommand(false)
+			case '>':
+				// &> and &>> redirect both stdout and stderr.
+				p.pos++
+				if p.peek(1) == '>' {
+					p.pos++
+				}
+				p.redirect = redirectOutput
+			default:
+				p.endCommand(false)
+			}
+		case r == ';' || r == '(' || r == ')' || r == '{' && !p.inWord && p.isWordEnd(1) || r == '}' && !p.inWord && p.isWordEnd(1):
+			p.endCommand(false)
+		case r == '>' || r == '<':
+			if err := p.redirection(); err != nil {
+				return err
+			}
+			continue
+		default:
+			p.word.WriteRune(r)
+			p.inWord = true
+		}
+
+		p.pos++
+	}
+
+	p.endWord()
+
+	if p.redirect != redirectNone {
+		return errors.New("missing redirection target")
+	}
+
+	p.endCommand(false)
+
+	return nil
+}
+
+// isWordEnd returns true if the rune at the offset ends a word.
+func (p *parser) isWordEnd(offset int) bool {
+	r := p.peek(offset)
+	return r == 0 || unicode.IsSpace(r) || strings.ContainsRune(";&|()<>", r)
+}
+
+// doubleQuote reads a double quoted string, which can contain substitutions.
+func (p *parser) doubleQuote() error {
+	p.pos++
+	p.inWord = true
+
+	for p.pos < len(p.src) {
+		switch r := p.src[p.pos]; r {
+		case '"':
+			p.pos++
+			return nil
+		case '\\':
+			if next := p.peek(1); strings.ContainsRune("$`\"\\\n", next) {
+				p.pos++
+				if next != '\n' {
+					p.word.WriteRune(next)
+				}
+			} else {
+				p.word.WriteRune(r)
+			}
+			p.pos++
+		case '`':
+			if err := p.backquote(); err != nil {
+				return err
+			}
+		case '$':
+			if err := p.dollar(); err != nil {
+				return err
+			}
+		default:
+			p.word.WriteRune(r)
+			p.pos++
+		}
+	}
+
+	return errors.New("unterminated double quote")
+}
+
+// backquote reads an old-style command substitution.
+func (p *parser) backquote() error {
+	var inner strings.Builder
+
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		switch r := p.src[p.pos]; {
+		case r == '`':
+			p.pos++
+			p.nested = append(p.nested, inner.String())
+			p.inWord = true
+			return nil
+		case r == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			inner.WriteRune(p.src[p.pos])
+		default:
+			inner.WriteRune(r)
+		}
+	}
+
+	return errors.New("unterminated backquote")
+}
+
+// dollar reads a parameter expansion, an arithmetic expansion, or a command
+// substitution, which is parsed as a command line of its own.
+func (p *parser) dollar() error {
+	switch p.peek(1) {
+	case '(':
+		arithmetic := p.peek(2) == '('
+		inner, err := p.balanced('(', ')')
+		if err != nil {
+			return err
+		}
+		// Arithmetic doesn't run commands, unless it contains command
+		// substitutions.
+		if !arithmetic || strings.ContainsAny(inner, "$`") {
+			p.nested = append(p.nested, inner)
+		}
+	case '{':
+		inner, err := p.balanced('{', '}')
+		if err != nil {
+			return err
+		}
+		p.word.WriteString("${" + inner + "}")
+	default:
+		p.word.WriteRune('$')
+		p.pos++
+	}
+
+	p.inWord = true
+
+	return nil
+}
+
+// balanced reads from the opening character after the current one up to the
+// matching closing character, returning what's between them.
+func (p *parser) balanced(open, close rune) (string, error) {
+	p.pos++
+
+	var (
+		start = p.pos + 1
+		depth = 0
+		quote rune
+	)
+
+	for ; p.pos < len(p.src); p.pos++ {
+		r := p.src[p.pos]
+
+		switch {
+		case quote != 0:
+			if r == '\\' && quote == '"' {
+				p.pos++
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '\\':
+			p.pos++
+		case r == '\'' || r == '"':
+			quote = r
+		case r == open:
+			depth++
+		case r == close:
+			depth--
+			if depth == 0 {
+				p.pos++
+				return string(p.src[start : p.pos-1]), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("unterminated %c", open)
+}
+
+// redirection reads a redirection operator, or a process substitution.
+func (p *parser) redirection() error {
+	r := p.src[p.pos]
+
+	// A number right before the operator is the file descriptor it
+	// redirects, not an argument.
+	if p.inWord && strings.Trim(p.word.String(), "0123456789") == "" {
+		p.word.Reset()
+		p.inWord = false
+	}
+	p.endWord()
+
+	if p.peek(1) == '(' {
+		inner, err := p.balanced('(', ')')
+		if err != nil {
+			return err
+		}
+		p.nested = append(p.nested, inner)
+		p.word.WriteString("/dev/fd/63")
+		p.inWord = true
+		return nil
+	}
+
+	p.pos++
+
+	if r == '>' {
+		// >>, >| and >& all write to their target, unless >& duplicates
+		// another file descriptor.
+		if next := p.peek(0); next == '>' || next == '|' || next == '&' {
+			p.pos++
+		}
+		p.redirect = redirectOutput
+		return nil
+	}
+
+	switch p.peek(0) {
+	case '<':
+		p.pos++
+		switch p.peek(0) {
+		case '<':
+			// A here-string is read by the command.
+			p.pos++
+			p.redirect = redirectInput
+		case '-':
+			p.pos++
+			p.redirect = redirectHeredoc
+		default:
+			p.redirect = redirectHeredoc
+		}
+	case '>':
+		// <> opens the target for reading and writing.
+		p.pos++
+		p.redirect = redirectOutput
+	case '&':
+		p.pos++
+		p.redirect = redirectInput
+	default:
+		p.redirect = redirectInput
+	}
+
+	return nil
+}
+
+// skipHeredocs skips the lines of the here-documents started on the line
+// that just ended, which are input rather than commands.
+func (p *parser) skipHeredocs() {
+	for _, delimiter := range p.heredocs {
+		for p.pos < len(p.src) {
+			end := p.pos
+			for end < len(p.src) && p.src[end] != '\n' {
+				end++
+			}
+
+			line := strings.TrimLeft(string(p.src[p.pos:end]), "\t")
+			p.pos = end + 1
+
+			if line == delimiter {
+				break
+			}
+		}
+	}
+
+	p.heredocs = nil
+}
+
+// endWord adds the current word to the current command, or uses it as the
+// target of a redirection.
+func (p *parser) endWord() {
+	if !p.inWord {
+		return
+	}
+
+	word := p.word.String()
+	p.word.Reset()
+	p.inWord = false
+
+	switch p.redirect {
+	case redirectOutput:
+		if !isStandardFile(word) {
+			p.cur.writes = append(p.cur.writes, word)
+		}
+	case redirectHeredoc:
+		p.heredocs = append(p.heredocs, word)
+	case redirectInput:
+	default:
+		if len(p.cur.args) == 0 && isAssignment(word) {
+			return
+		}
+		p.cur.args = append(p.cur.args, word)
+	}
+
+	p.redirect = redirectNone
+}
+
+// endCommand ends the current command. If piped is set, the next command
+// reads its output.
+func (p *parser) endCommand(piped bool) {
+	p.endWord()
+
+	if len(p.cur.args) > 0 || len(p.cur.writes) > 0 {
+		p.commands = append(p.commands, p.cur)
+	}
+
+	p.cur = command{piped: piped}
+}
+
+// isStandardFile returns true if writing to the file doesn't change any
+// files, like /dev/null or another file descriptor.
+func isStandardFile(name string) bool {
+	switch name {
+	case "/dev/null", "/dev/stdout", "/dev/stderr", "/dev/tty", "-":
+		return true
+	}
+	return strings.Trim(name, "0123456789") == "" || strings.HasPrefix(name, "/dev/fd/")
+}
+
+// isAssignment returns true if the word assigns a variable, like FOO=bar.
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+
+	return true
+}
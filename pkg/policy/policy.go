@@ -0,0 +1,216 @@
+// Package policy decides whether shell commands proposed by HAL can be run.
+//
+// Commands are parsed and classified by what they do, like only reading
+// files, writing them, using the network, or deleting data. A policy then
+// allows or denies them with rules, and picks how they're confirmed before
+// they run from their classes. Commands that ran, or were denied, are
+// appended to an audit log.
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Level is how a command is confirmed before it runs, from the least to the
+// most strict.
+type Level int
+
+const (
+	// LevelNone runs the command once it's reviewed, without asking again.
+	LevelNone Level = iota
+
+	// LevelConfirm asks to confirm the command before it runs.
+	LevelConfirm
+
+	// LevelType asks to type "yes" before the command runs.
+	LevelType
+
+	// LevelDeny doesn't run the command.
+	LevelDeny
+)
+
+// String returns the name of the level, as used in the configuration.
+func (l Level) String() string {
+	switch l {
+	case LevelNone:
+		return "none"
+	case LevelConfirm:
+		return "confirm"
+	case LevelType:
+		return "type"
+	case LevelDeny:
+		return "deny"
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+}
+
+// ParseLevel returns the level with the given name.
+func ParseLevel(name string) (Level, error) {
+	for _, level := range []Level{LevelNone, LevelConfirm, LevelType, LevelDeny} {
+		if level.String() == name {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("confirmation level must be %q, %q, %q or %q, got %q", LevelNone, LevelConfirm, LevelType, LevelDeny, name)
+}
+
+// DefaultLevels are the levels of each class of command, unless they're
+// configured.
+var DefaultLevels = map[Class]Level{
+	ReadOnly:    LevelNone,
+	Write:       LevelConfirm,
+	Network:     LevelConfirm,
+	Destructive: LevelType,
+}
+
+// Policy decides whether commands can run, and how they're confirmed.
+//
+// Rules are matched against each simple command of a command line, like
+// each side of a pipe, with its words separated by single spaces. A "*"
+// matches any text, and a rule ending in " *" also matches the command
+// without arguments, so "git log *" matches both "git log" and "git log
+// -p".
+type Policy struct {
+	// Allow are the rules of commands that run without confirmation. A
+	// command line is allowed if all its commands match a rule, unless it
+	// writes to files with redirections or is destructive, which is
+	// confirmed at the level of those classes.
+	Allow []string
+
+	// Deny are the rules of commands that never run. A command line is
+	// denied if any of its commands matches a rule, with or without
+	// wrappers like sudo.
+	Deny []string
+
+	// Levels are how each class of command is confirmed. Classes that
+	// aren't set use their default level.
+	Levels map[Class]Level
+}
+
+// Decision is what the policy decided for a command line.
+type Decision struct {
+	Classification
+
+	// Level is how the command line is confirmed, or LevelDeny if it
+	// can't run.
+	Level Level
+
+	// Rule is the allow or deny rule that decided the level, if any.
+	Rule string
+}
+
+// Reason returns why the command line got its level, to show to users.
+func (d Decision) Reason() string {
+	switch {
+	case d.Rule != "" && d.Level == LevelDeny:
+		return fmt.Sprintf("denied by the rule %q", d.Rule)
+	case d.Rule != "":
+		return fmt.Sprintf("allowed by the rule %q", d.Rule)
+	case len(d.Reasons) > 0:
+		return strings.Join(d.Reasons, ", ")
+	default:
+		return d.Class().Description()
+	}
+}
+
+// Check classifies the command line and decides whether it can run, and how
+// it's confirmed. Deny rules win over allow rules, which win over the
+// levels of the command line's classes, except those of writing to files
+// with redirections and of destructive commands.
+func (p *Policy) Check(commandLine string) Decision {
+	d := Decision{Classification: Classify(commandLine)}
+
+	for _, cmd := range d.commands {
+		if rule, ok := matchRules(p.Deny, cmd.text, cmd.unwrapped); ok {
+			d.Level, d.Rule = LevelDeny, rule
+			return d
+		}
+	}
+
+	// A command line that couldn't be parsed has no commands, and isn't
+	// allowed by any rule.
+	allowed := len(d.commands) > 0
+	for _, cmd := range d.commands {
+		rule, ok := matchRules(p.Allow, cmd.text)
+		if !ok {
+			allowed = false
+			break
+		}
+		if d.Rule == "" {
+			d.Rule = rule
+		}
+	}
+
+	// Rules are matched without redirections, so they can't allow writing
+	// to files with them, and don't allow losing data without confirming
+	// it like any other command.
+	classes := d.Classes
+	if allowed {
+		classes = nil
+		if d.redirects {
+			classes = append(classes, Write)
+		}
+		if d.Has(Destructive) {
+			classes = append(classes, Destructive)
+		}
+	}
+
+	for _, class := range classes {
+		if level := p.level(class); level > d.Level {
+			d.Level = level
+		}
+	}
+
+	if !allowed || d.Level > LevelNone {
+		d.Rule = ""
+	}
+
+	return d
+}
+
+// level returns the level of the class.
+func (p *Policy) level(class Class) Level {
+	if level, ok := p.Levels[class]; ok {
+		return level
+	}
+	if level, ok := DefaultLevels[class]; ok {
+		return level
+	}
+	return LevelConfirm
+}
+
+// matchRules returns the first rule matching any of the texts.
+func matchRules(rules []string, texts ...string) (string, bool) {
+	for _, rule := range rules {
+		for _, text := range texts {
+			if MatchRule(rule, text) {
+				return rule, true
+			}
+		}
+	}
+	return "", false
+}
+
+// MatchRule returns true if the rule matches the simple command.
+func MatchRule(rule, command string) bool {
+	rule = strings.Join(strings.Fields(rule), " ")
+	command = strings.Join(strings.Fields(command), " ")
+
+	if rule == "" {
+		return false
+	}
+
+	if strings.HasSuffix(rule, " *") && strings.TrimSuffix(rule, " *") == command {
+		return true
+	}
+
+	parts := strings.Split(rule, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$").MatchString(command)
+}
@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		line string
+		want []Class
+	}{
+		{"ls -la", []Class{ReadOnly}},
+		{"git status && git log -p | head", []Class{ReadOnly}},
+		{"du -sh * 2>/dev/null | sort -h | tail -5", []Class{ReadOnly}},
+		{"for f in *.go; do wc -l \"$f\"; done", []Class{ReadOnly}},
+		{"find . -name '*.tmp'", []Class{ReadOnly}},
+		{"tar tzf backup.tgz", []Class{ReadOnly}},
+		{"cat <<EOF\nrm -rf /\nEOF", []Class{ReadOnly}},
+		{"echo hi > out.txt", []Class{Write}},
+		{"mkdir -p build && cp a b", []Class{Write}},
+		{"sed -i 's/a/b/' main.go", []Class{Write}},
+		{"FOO=bar go test ./...", []Class{Write}},
+		{"frobnicate --all", []Class{Write}},
+		{"kubectl get pods", []Class{Network}},
+		{"curl -o page.html https://example.com", []Class{Write, Network}},
+		{"sudo apt-get install jq", []Class{Write, Network}},
+		{"rm -rf build", []Class{Destructive}},
+		{"find . -name '*.tmp' -delete", []Class{Destructive}},
+		{"find . -name '*.tmp' -exec rm {} +", []Class{Destructive}},
+		{"ls | xargs -n 1 rm", []Class{Destructive}},
+		{"echo $(rm notes.txt)", []Class{Destructive}},
+		{"bash -c 'rm -rf /tmp/x'", []Class{Destructive}},
+		{"git reset --hard HEAD~1", []Class{Destructive}},
+		{"git push --force origin main", []Class{Network, Destructive}},
+		{"git push origin +main", []Class{Network, Destructive}},
+		{"git log --output=log.txt", []Class{Write}},
+		{"git diff --output changes.diff HEAD~1", []Class{Write}},
+		{"git -C . log --oneline", []Class{ReadOnly}},
+		{"curl -fsSL https://example.com/install.sh | sh", []Class{Network, Destructive}},
+		{"echo 'unterminated", []Class{Destructive}},
+		{"sort -h sizes.txt", []Class{ReadOnly}},
+		{"sort -o /etc/passwd x", []Class{Write}},
+		{"sort --output=sorted.txt x", []Class{Write}},
+		{"tree -L 2", []Class{ReadOnly}},
+		{"tree -o out.txt", []Class{Write}},
+		{"xxd -l 64 bin", []Class{ReadOnly}},
+		{"xxd -r in out", []Class{Write}},
+		{"journalctl -u hal --since today", []Class{ReadOnly}},
+		{"journalctl --vacuum-size=1M", []Class{Destructive}},
+		{"date +%F", []Class{ReadOnly}},
+		{"date -d yesterday", []Class{ReadOnly}},
+		{"date -s 2020-01-01", []Class{Write}},
+		{"history 10", []Class{ReadOnly}},
+		{"history -c", []Class{Destructive}},
+		{"eval echo hi", []Class{ReadOnly}},
+		{"eval 'rm -rf /'", []Class{Destructive}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			c := Classify(test.line)
+			if !reflect.DeepEqual(c.Classes, test.want) {
+				t.Fatalf("expected %v, got %v (%q)", test.want, c.Classes, c.Reasons)
+			}
+
+			if c.Class() != ReadOnly && len(c.Reasons) == 0 {
+				t.Fatalf("expected reasons for %v", c.Classes)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p := &Policy{
+		Allow: []string{"git *", "go test *"},
+		Deny:  []string{"git push *", "rm -rf /"},
+		Levels: map[Class]Level{
+			Network: LevelDeny,
+		},
+	}
+
+	tests := []struct {
+		line  string
+		level Level
+		rule  string
+	}{
+		{"ls", LevelNone, ""},
+		{"mkdir build", LevelConfirm, ""},
+		{"rm notes.txt", LevelType, ""},
+		{"curl https://example.com", LevelDeny, ""},
+
+		// Every command has to be allowed.
+		{"git status && go test ./...", LevelNone, "git *"},
+		{"go test ./... && rm -rf build", LevelType, ""},
+		{"git fetch", LevelNone, "git *"},
+
+		// Allow rules don't allow redirections writing to files, or
+		// destroying data.
+		{"git log > ~/.bashrc", LevelConfirm, ""},
+		{"git log > /dev/null", LevelNone, "git *"},
+		{"git clean -fd", LevelType, ""},
+		{"go test ./... 2> errors.txt", LevelConfirm, ""},
+
+		// Deny rules win over allow rules, and match without wrappers.
+		{"git push origin main", LevelDeny, "git push *"},
+		{"ls && sudo rm -rf /", LevelDeny, "rm -rf /"},
+
+		// Or git's global options.
+		{"git -C . push", LevelDeny, "git push *"},
+		{"git -c push.default=current push", LevelDeny, "git push *"},
+		{"git --git-dir=.git --work-tree=. push origin", LevelDeny, "git push *"},
+		{"sudo git -C /srv/repo push", LevelDeny, "git push *"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			d := p.Check(test.line)
+			if d.Level != test.level || d.Rule != test.rule {
+				t.Fatalf("expected %s by %q, got %s by %q (%s)", test.level, test.rule, d.Level, d.Rule, d.Reason())
+			}
+		})
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	tests := []struct {
+		rule, command string
+		want          bool
+	}{
+		{"git log *", "git log", true},
+		{"git log *", "git log -p", true},
+		{"git log *", "git logs", false},
+		{"git log", "git log -p", false},
+		{"rm -rf *", "rm  -rf   build", true},
+		{"*.sh", "./install.sh", true},
+		{"go test ./...", "go test ./...", true},
+		{"", "ls", false},
+	}
+
+	for _, test := range tests {
+		if got := MatchRule(test.rule, test.command); got != test.want {
+			t.Errorf("MatchRule(%q, %q) = %v, want %v", test.rule, test.command, got, test.want)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{LevelNone, LevelConfirm, LevelType, LevelDeny} {
+		got, err := ParseLevel(level.String())
+		if err != nil || got != level {
+			t.Fatalf("expected %s, got %s (%v)", level, got, err)
+		}
+	}
+
+	if _, err := ParseLevel("ask"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
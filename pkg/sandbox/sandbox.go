@@ -0,0 +1,495 @@
+// Package sandbox runs shell commands in a restricted environment, on a copy
+// of a working directory, so the files they change can be reviewed and then
+// applied to the working directory, or discarded.
+//
+// Commands run with only a few environment variables, like PATH, and a home
+// directory of their own, so API keys and other secrets aren't passed to
+// them. They're limited in how long they run, how much memory they use, and
+// how large the files they write can be. On Linux, they also run in their own
+// user, PID and network namespaces, without network access.
+//
+// It isn't a security boundary against commands written to escape it: files
+// outside of the working directory can still be read, and written to by
+// absolute path.
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/picatz/hal/pkg/atomicfile"
+	"github.com/picatz/hal/pkg/diff"
+)
+
+// ErrUnsupported is returned on platforms commands can't be sandboxed on.
+var ErrUnsupported = errors.New("sandbox: only supported on Linux")
+
+// Options are the restrictions of a sandbox. Zero values don't restrict.
+type Options struct {
+	// Network lets commands use the network.
+	Network bool
+
+	// Timeout is how long commands can run for.
+	Timeout time.Duration
+
+	// CPUTime is how much CPU time commands can use.
+	CPUTime time.Duration
+
+	// Memory is how many bytes of virtual memory each process can use.
+	Memory int64
+
+	// FileSize is how large, in bytes, the files commands write can be.
+	FileSize int64
+
+	// MaxCopySize is how large, in bytes, the working directory can be to
+	// be copied into the sandbox.
+	MaxCopySize int64
+
+	// Env are the names of environment variables passed to commands, on
+	// top of the ones that always are, like PATH.
+	Env []string
+}
+
+// DefaultOptions are the options of a sandbox, unless they're configured.
+var DefaultOptions = Options{
+	Timeout:     time.Minute,
+	CPUTime:     time.Minute,
+	Memory:      2 << 30,
+	FileSize:    256 << 20,
+	MaxCopySize: 512 << 20,
+}
+
+// env are the environment variables always passed to commands.
+var env = []string{
+	"PATH", "LANG", "LANGUAGE", "LC_ALL", "LC_CTYPE", "LC_MESSAGES", "TERM",
+	"COLORTERM", "TZ", "USER", "LOGNAME", "SHELL",
+}
+
+// Sandbox is a copy of a working directory commands run in.
+type Sandbox struct {
+	// Source is the working directory the sandbox is a copy of.
+	Source string
+
+	// Dir is the copy of the working directory.
+	Dir string
+
+	root string
+	opts Options
+}
+
+// New returns a sandbox with a copy of the source directory, which has to
+// be removed with Remove once it's not needed anymore.
+func New(source string, opts Options) (*Sandbox, error) {
+	if !supported {
+		return nil, ErrUnsupported
+	}
+
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: %w", err)
+	}
+
+	root, err := os.MkdirTemp("", "hal-sandbox-")
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: failed to create directory: %w", err)
+	}
+
+	s := &Sandbox{
+		Source: source,
+		Dir:    filepath.Join(root, filepath.Base(source)),
+		root:   root,
+		opts:   opts,
+	}
+
+	for _, dir := range []string{s.home(), s.tmp()} {
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			s.Remove()
+			return nil, fmt.Errorf("sandbox: failed to create directory: %w", err)
+		}
+	}
+
+	if err := copyTree(source, s.Dir, opts.MaxCopySize); err != nil {
+		s.Remove()
+		return nil, fmt.Errorf("sandbox: failed to copy %s: %w", source, err)
+	}
+
+	return s, nil
+}
+
+// Options returns the restrictions of the sandbox.
+func (s *Sandbox) Options() Options {
+	return s.opts
+}
+
+func (s *Sandbox) home() string { return filepath.Join(s.root, ".home") }
+func (s *Sandbox) tmp() string  { return filepath.Join(s.root, ".tmp") }
+
+// Command returns the command running the command line with the shell in
+// the sandbox. The context stops it, as does the sandbox's timeout, whose
+// cancel function has to be called once the command is done.
+func (s *Sandbox) Command(ctx context.Context, shell, commandLine string) (*exec.Cmd, context.CancelFunc) {
+	cancel := func() {}
+	if s.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+	}
+
+	// The limits are set by sh before it runs the command line with the
+	// shell, which are passed as arguments so they don't need quoting.
+	var limits []string
+	if s.opts.CPUTime > 0 {
+		limits = append(limits, "ulimit -t "+strconv.Itoa(int(s.opts.CPUTime.Seconds())))
+	}
+	if s.opts.Memory > 0 {
+		limits = append(limits, "ulimit -v "+strconv.FormatInt(s.opts.Memory/1024, 10))
+	}
+	if s.opts.FileSize > 0 {
+		limits = append(limits, "ulimit -f "+strconv.FormatInt(s.opts.FileSize/512, 10))
+	}
+	script := strings.Join(append(limits, `exec "$0" -c "$1"`), " && ")
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", script, shell, commandLine)
+	cmd.Dir = s.Dir
+	cmd.Env = s.env()
+	cmd.SysProcAttr = sysProcAttr(s.opts)
+
+	return cmd, cancel
+}
+
+// env returns the environment of commands.
+func (s *Sandbox) env() []string {
+	vars := []string{
+		"HOME=" + s.home(),
+		"TMPDIR=" + s.tmp(),
+		"PWD=" + s.Dir,
+	}
+
+	for _, name := range append(env, s.opts.Env...) {
+		if value, ok := os.LookupEnv(name); ok {
+			vars = append(vars, name+"="+value)
+		}
+	}
+
+	return vars
+}
+
+// Remove removes the sandbox and its copy of the working directory.
+func (s *Sandbox) Remove() error {
+	err := os.RemoveAll(s.root)
+	if err == nil {
+		return nil
+	}
+
+	// Commands can leave read-only directories behind, like Go's module
+	// cache, whose files can't be removed until they're writable.
+	filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(path, 0o700)
+		}
+		return nil
+	})
+
+	if err := os.RemoveAll(s.root); err != nil {
+		return fmt.Errorf("sandbox: failed to remove %s: %w", s.root, err)
+	}
+
+	return nil
+}
+
+// copyTree copies the regular files, directories and symbolic links of the
+// source directory to the destination, failing if they're larger than max
+// bytes in total.
+func copyTree(source, dest string, max int64) error {
+	var size int64
+
+	return filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, info.Mode().Perm()|0o700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			if size += info.Size(); max > 0 && size > max {
+				return fmt.Errorf("larger than %d MB", max>>20)
+			}
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			// Sockets, devices and other special files aren't copied.
+			return nil
+		}
+	})
+}
+
+// copyFile copies the file's content to a new file with the given mode.
+func copyFile(source, dest string, mode fs.FileMode) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// ChangeKind is how a file was changed.
+type ChangeKind int
+
+const (
+	// Added is a file that didn't exist before.
+	Added ChangeKind = iota
+
+	// Modified is a file whose content or mode changed.
+	Modified
+
+	// Deleted is a file that was deleted.
+	Deleted
+)
+
+// String returns the name of the change kind.
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Modified:
+		return "modified"
+	case Deleted:
+		return "deleted"
+	default:
+		return fmt.Sprintf("ChangeKind(%d)", int(k))
+	}
+}
+
+// Change is a file changed by commands in the sandbox.
+type Change struct {
+	// Path is the path of the file, relative to the working directory.
+	Path string
+
+	Kind ChangeKind
+
+	// Old and New are the content of the file before and after, which is
+	// the target of symbolic links.
+	Old, New []byte
+
+	// OldMode and NewMode are the mode of the file before and after.
+	OldMode, NewMode fs.FileMode
+}
+
+// Binary returns true if the file isn't text, so it can't be diffed.
+func (c Change) Binary() bool {
+	for _, content := range [][]byte{c.Old, c.New} {
+		if bytes.IndexByte(content, 0) >= 0 || !utf8.Valid(content) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the unified diff of the change.
+func (c Change) Diff() string {
+	oldName, newName := "a/"+c.Path, "b/"+c.Path
+	switch c.Kind {
+	case Added:
+		oldName = "/dev/null"
+	case Deleted:
+		newName = "/dev/null"
+	}
+
+	if c.Binary() {
+		return fmt.Sprintf("Binary files %s and %s differ\n", oldName, newName)
+	}
+
+	unified := diff.Unified(oldName, newName, string(c.Old), string(c.New))
+	if unified == "" && c.OldMode != c.NewMode {
+		return fmt.Sprintf("--- %s\n+++ %s\nmode %v → %v\n", oldName, newName, c.OldMode, c.NewMode)
+	}
+
+	return unified
+}
+
+// file is a file of a directory tree, as compared by Changes.
+type file struct {
+	content []byte
+	mode    fs.FileMode
+}
+
+// Changes returns the files changed in the sandbox's copy of the working
+// directory, compared to the working directory, sorted by path.
+func (s *Sandbox) Changes() ([]Change, error) {
+	before, err := readTree(s.Source)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: failed to read %s: %w", s.Source, err)
+	}
+
+	after, err := readTree(s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: failed to read changes: %w", err)
+	}
+
+	var changes []Change
+
+	for path, new := range after {
+		old, ok := before[path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Path: path, Kind: Added, New: new.content, NewMode: new.mode})
+		case !bytes.Equal(old.content, new.content) || old.mode != new.mode:
+			changes = append(changes, Change{Path: path, Kind: Modified, Old: old.content, New: new.content, OldMode: old.mode, NewMode: new.mode})
+		}
+	}
+
+	for path, old := range before {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, Change{Path: path, Kind: Deleted, Old: old.content, OldMode: old.mode})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	return changes, nil
+}
+
+// readTree reads the regular files and symbolic links of the directory by
+// their slash separated path.
+func readTree(dir string) (map[string]file, error) {
+	files := map[string]file{}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			files[rel] = file{content: []byte(link), mode: fs.ModeSymlink}
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files[rel] = file{content: content, mode: info.Mode().Perm()}
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+// Apply makes the changes to the working directory. It fails without
+// changing anything if any of the files changed in the working directory
+// since the changes were found.
+func (s *Sandbox) Apply(changes []Change) error {
+	for _, c := range changes {
+		path := filepath.Join(s.Source, filepath.FromSlash(c.Path))
+
+		current, err := readFile(path)
+		switch {
+		case c.Kind == Added && errors.Is(err, os.ErrNotExist):
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			return fmt.Errorf("sandbox: %w", err)
+		case c.Kind == Added || err != nil || !bytes.Equal(current.content, c.Old) || current.mode != c.OldMode:
+			return fmt.Errorf("sandbox: %s changed since the command ran", c.Path)
+		}
+	}
+
+	for _, c := range changes {
+		path := filepath.Join(s.Source, filepath.FromSlash(c.Path))
+
+		if err := applyChange(path, c); err != nil {
+			return fmt.Errorf("sandbox: failed to apply changes to %s: %w", c.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// readFile reads a regular file or symbolic link like readTree.
+func readFile(path string) (file, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return file{}, err
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		return file{content: []byte(link), mode: fs.ModeSymlink}, err
+	}
+
+	content, err := os.ReadFile(path)
+	return file{content: content, mode: info.Mode().Perm()}, err
+}
+
+// applyChange makes the change to the file at the path, replacing files
+// atomically.
+func applyChange(path string, c Change) error {
+	if c.Kind == Deleted {
+		return os.Remove(path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	if c.NewMode == fs.ModeSymlink {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return os.Symlink(string(c.New), path)
+	}
+
+	return atomicfile.WriteFile(path, c.New, c.NewMode)
+}
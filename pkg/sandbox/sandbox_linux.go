@@ -0,0 +1,30 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"syscall"
+)
+
+const supported = true
+
+// sysProcAttr runs commands in their own user namespace, mapping the user
+// to itself, and in their own PID namespace, so every process they start is
+// killed when they are. Unless they can use the network, they also run in
+// their own network namespace, with only a loopback interface that is down.
+// They're killed if HAL exits.
+func sysProcAttr(opts Options) *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{
+		Pdeathsig:   syscall.SIGKILL,
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}},
+	}
+
+	if !opts.Network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+
+	return attr
+}
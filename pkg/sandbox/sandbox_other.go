@@ -0,0 +1,11 @@
+//go:build !linux
+
+package sandbox
+
+import "syscall"
+
+const supported = false
+
+func sysProcAttr(opts Options) *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,196 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSandbox returns a sandbox of a directory with the files, skipping the
+// test if sandboxes aren't supported.
+func newSandbox(t *testing.T, opts Options, files map[string]string) *Sandbox {
+	t.Helper()
+
+	source := t.TempDir()
+	for path, content := range files {
+		path = filepath.Join(source, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := New(source, opts)
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Remove() })
+
+	return s
+}
+
+// run runs the command line in the sandbox, returning its output.
+func run(t *testing.T, s *Sandbox, commandLine string) (string, error) {
+	t.Helper()
+
+	cmd, cancel := s.Command(context.Background(), "/bin/sh", commandLine)
+	defer cancel()
+
+	out, err := cmd.CombinedOutput()
+	if err != nil && strings.Contains(err.Error(), "operation not permitted") {
+		t.Skipf("namespaces aren't available: %v", err)
+	}
+
+	return string(out), err
+}
+
+func TestSandbox(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "secret")
+
+	s := newSandbox(t, DefaultOptions, map[string]string{
+		"a.txt":     "one\ntwo\nthree\n",
+		"b.txt":     "gone\n",
+		"sub/c.txt": "same\n",
+	})
+
+	out, err := run(t, s, `sed -i 's/two/2/' a.txt && rm b.txt && echo new > sub/d.txt && printf '%s' "$OPENAI_API_KEY" > key.txt`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v: %s", err, out)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(s.Source, "a.txt")); err != nil || string(content) != "one\ntwo\nthree\n" {
+		t.Fatalf("expected the working directory to be unchanged, got %q, %v", content, err)
+	}
+
+	changes, err := s.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.Kind.String()+" "+c.Path)
+	}
+
+	want := []string{"modified a.txt", "deleted b.txt", "added key.txt", "added sub/d.txt"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("expected changes %q, got %q", want, got)
+	}
+
+	if key := string(changes[2].New); key != "" {
+		t.Fatalf("expected OPENAI_API_KEY not to be passed to the command, got %q", key)
+	}
+
+	wantDiff := "--- a/a.txt\n+++ b/a.txt\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"
+	if diff := changes[0].Diff(); diff != wantDiff {
+		t.Fatalf("expected diff:\n%s\ngot:\n%s", wantDiff, diff)
+	}
+
+	if diff := changes[1].Diff(); !strings.HasPrefix(diff, "--- a/b.txt\n+++ /dev/null\n") {
+		t.Fatalf("expected a diff deleting b.txt, got:\n%s", diff)
+	}
+
+	if err := s.Apply(changes); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{"a.txt": "one\n2\nthree\n", "sub/d.txt": "new\n", "sub/c.txt": "same\n"} {
+		content, err := os.ReadFile(filepath.Join(s.Source, path))
+		if err != nil || string(content) != want {
+			t.Fatalf("expected %s to be %q, got %q, %v", path, want, content, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(s.Source, "b.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected b.txt to be deleted, got %v", err)
+	}
+
+	if changes, err := s.Changes(); err != nil || len(changes) != 0 {
+		t.Fatalf("expected no changes left once applied, got %v, %v", changes, err)
+	}
+
+	root := s.root
+	if err := s.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Fatalf("expected the sandbox to be removed, got %v", err)
+	}
+}
+
+func TestSandboxApplyConflict(t *testing.T) {
+	s := newSandbox(t, DefaultOptions, map[string]string{"a.txt": "one\n"})
+
+	if out, err := run(t, s, "echo two > a.txt && echo new > b.txt"); err != nil {
+		t.Fatalf("unexpected error: %v: %s", err, out)
+	}
+
+	changes, err := s.Changes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(s.Source, "a.txt"), []byte("edited\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Apply(changes)
+	if err == nil || !strings.Contains(err.Error(), "a.txt changed since the command ran") {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.Source, "b.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be applied, got %v", err)
+	}
+}
+
+func TestSandboxNetwork(t *testing.T) {
+	s := newSandbox(t, DefaultOptions, nil)
+
+	// The network namespace only has a loopback interface.
+	out, err := run(t, s, "tail -n +3 /proc/net/dev | cut -d: -f1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v: %s", err, out)
+	}
+
+	if interfaces := strings.Fields(out); len(interfaces) != 1 || interfaces[0] != "lo" {
+		t.Fatalf("expected only a loopback interface, got %q", interfaces)
+	}
+}
+
+func TestSandboxTimeout(t *testing.T) {
+	s := newSandbox(t, Options{Timeout: 100 * time.Millisecond}, nil)
+
+	start := time.Now()
+	if _, err := run(t, s, "sleep 5"); err == nil {
+		t.Fatal("expected the command to be stopped")
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the command to be stopped after its timeout, took %v", elapsed)
+	}
+}
+
+func TestSandboxMaxCopySize(t *testing.T) {
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "big"), make([]byte, 2<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := New(source, Options{MaxCopySize: 1 << 20})
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "larger than 1 MB") {
+		t.Fatalf("expected the directory to be too large, got %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/sandbox"
+)
+
+// MaxOutput is the number of bytes of a command's stdout and stderr that are
+// kept, each, so a command printing a lot doesn't fill the model's context
+// window.
+const MaxOutput = 8 * 1024
+
+// Result is the result of running a command.
+type Result struct {
+	// Command is the command that was run.
+	Command string
+
+	// Stdout and Stderr are the output of the command, cut to MaxOutput
+	// bytes each. They're empty for commands run in the terminal.
+	Stdout string
+	Stderr string
+
+	// Truncated is set if any of the output was cut.
+	Truncated bool
+
+	// ExitCode is the exit code of the command.
+	ExitCode int
+
+	// Duration is how long the command ran for.
+	Duration time.Duration
+
+	// Interactive is set for commands run in the terminal, whose output
+	// isn't captured.
+	Interactive bool
+
+	// Sandboxed is set for commands run in a sandbox, and Network if the
+	// sandbox let them use the network.
+	Sandboxed bool
+	Network   bool
+
+	// TimedOut is set for sandboxed commands stopped because they ran for
+	// too long.
+	TimedOut bool
+
+	// Sandbox is the sandbox of a command that changed files in it, which
+	// are its Changes. Applied is set once they're applied to the working
+	// directory.
+	Sandbox *sandbox.Sandbox
+	Changes []sandbox.Change
+	Applied bool
+}
+
+// FinishedMsg is sent when a command finished, or it couldn't be run.
+// Commands that exit with a non-zero exit code still have a result, without
+// an error.
+type FinishedMsg struct {
+	Err    error
+	Result *Result
+}
+
+// shellCommand returns the command to run the command line with the shell.
+func shellCommand(ctx context.Context, commandLine string) *exec.Cmd {
+	return exec.CommandContext(ctx, Shell(), "-c", commandLine)
+}
+
+// Run returns a command that runs the command line with the shell,
+// capturing its output, and sends a FinishedMsg when it exits. It's stopped
+// if the context is canceled.
+func Run(ctx context.Context, commandLine string) tea.Cmd {
+	return func() tea.Msg {
+		return run(ctx, commandLine, shellCommand(ctx, commandLine))
+	}
+}
+
+// RunSandboxed returns a command like Run, which runs the command line in a
+// new sandbox with a copy of the directory. The result has the sandbox if the
+// command changed any files, which has to be removed once they're applied or
+// discarded.
+func RunSandboxed(ctx context.Context, dir string, opts sandbox.Options, commandLine string) tea.Cmd {
+	return func() tea.Msg {
+		sb, err := sandbox.New(dir, opts)
+		if err != nil {
+			return FinishedMsg{Err: err}
+		}
+
+		cmd, cancel := sb.Command(ctx, Shell(), commandLine)
+		defer cancel()
+
+		return finishSandboxed(sb, run(ctx, commandLine, cmd))
+	}
+}
+
+// run runs the command, capturing its output.
+func run(ctx context.Context, commandLine string, cmd *exec.Cmd) FinishedMsg {
+	var (
+		stdout = &cappedBuffer{max: MaxOutput}
+		stderr = &cappedBuffer{max: MaxOutput}
+	)
+
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err := cmd.Run()
+
+	if ctx.Err() != nil {
+		return FinishedMsg{Err: ctx.Err()}
+	}
+
+	result, err := newResult(commandLine, start, err)
+	if err != nil {
+		return FinishedMsg{Err: err}
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.Truncated = stdout.truncated || stderr.truncated
+
+	return FinishedMsg{Result: result}
+}
+
+// Exec returns a command that runs the command line with the shell in the
+// terminal, suspending the program until it exits, so interactive commands
+// like editors work. Its output isn't captured.
+//
+// The message sent when it finishes is returned by fn, so it can be wrapped.
+func Exec(commandLine string, fn func(FinishedMsg) tea.Msg) tea.Cmd {
+	start := time.Now()
+
+	return tea.ExecProcess(shellCommand(context.Background(), commandLine), func(err error) tea.Msg {
+		return fn(execFinished(commandLine, start, err))
+	})
+}
+
+// ExecSandboxed returns a command like Exec, which runs the command line in
+// the sandbox. The sandbox is removed unless the command changed any files,
+// in which case the result has it, like RunSandboxed.
+func ExecSandboxed(sb *sandbox.Sandbox, commandLine string, fn func(FinishedMsg) tea.Msg) tea.Cmd {
+	cmd, cancel := sb.Command(context.Background(), Shell(), commandLine)
+	start := time.Now()
+
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		cancel()
+		return fn(finishSandboxed(sb, execFinished(commandLine, start, err)))
+	})
+}
+
+// execFinished returns the message of a command run in the terminal.
+func execFinished(commandLine string, start time.Time, err error) FinishedMsg {
+	result, err := newResult(commandLine, start, err)
+	if err != nil {
+		return FinishedMsg{Err: err}
+	}
+
+	result.Interactive = true
+
+	return FinishedMsg{Result: result}
+}
+
+// finishSandboxed adds the files changed in the sandbox to the message of a
+// command that ran in it, removing the sandbox unless there are any.
+func finishSandboxed(sb *sandbox.Sandbox, msg FinishedMsg) FinishedMsg {
+	if msg.Err != nil {
+		sb.Remove()
+		return msg
+	}
+
+	msg.Result.Sandboxed = true
+	msg.Result.Network = sb.Options().Network
+
+	// Commands killed once they ran for as long as the sandbox allows
+	// don't exit on their own.
+	if timeout := sb.Options().Timeout; timeout > 0 && msg.Result.ExitCode != 0 && msg.Result.Duration >= timeout {
+		msg.Result.TimedOut = true
+	}
+
+	changes, err := sb.Changes()
+	if err != nil {
+		sb.Remove()
+		return FinishedMsg{Err: err}
+	}
+
+	if len(changes) == 0 {
+		sb.Remove()
+		return msg
+	}
+
+	msg.Result.Sandbox = sb
+	msg.Result.Changes = changes
+
+	return msg
+}
+
+// newResult returns the result of the command, given the error it exited
+// with, which is only returned if the command couldn't be run at all.
+func newResult(commandLine string, start time.Time, err error) (*Result, error) {
+	result := &Result{
+		Command:  commandLine,
+		Duration: time.Since(start),
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		return nil, fmt.Errorf("failed to run command: %w", err)
+	}
+
+	return result, nil
+}
+
+// Message returns the message telling the model what the command printed and
+// how it exited, which is added to the thread's chat history.
+func (r *Result) Message() chat.Message {
+	var b strings.Builder
+
+	where := ""
+	if r.Interactive {
+		where += " in my terminal"
+	}
+	switch {
+	case r.Sandboxed && r.Network:
+		where += " in a sandbox, on a copy of the working directory with network access but without secrets"
+	case r.Sandboxed:
+		where += " in a sandbox, on a copy of the working directory without network access or secrets"
+	}
+
+	fmt.Fprintf(&b, "I ran this command%s, and it exited with code %d:\n\n", where, r.ExitCode)
+
+	writeBlock(&b, "sh", r.Command)
+
+	switch {
+	case r.Interactive:
+		b.WriteString("\nIts output wasn't captured.")
+	case r.Stdout == "" && r.Stderr == "":
+		b.WriteString("\nIt didn't print anything.")
+	default:
+		if r.Stdout != "" {
+			b.WriteString("\nstdout:\n\n")
+			writeBlock(&b, "", r.Stdout)
+		}
+		if r.Stderr != "" {
+			b.WriteString("\nstderr:\n\n")
+			writeBlock(&b, "", r.Stderr)
+		}
+		if r.Truncated {
+			fmt.Fprintf(&b, "\nOnly the first %d bytes of output are shown.", MaxOutput)
+		}
+	}
+
+	if r.TimedOut {
+		fmt.Fprintf(&b, "\nIt was stopped after running for %s.", r.Duration.Round(time.Second))
+	}
+
+	if len(r.Changes) > 0 {
+		if r.Applied {
+			b.WriteString("\n\nIt changed these files, and I applied the changes to the working directory:\n")
+		} else {
+			b.WriteString("\n\nIt changed these files, and I discarded the changes:\n")
+		}
+		for _, c := range r.Changes {
+			fmt.Fprintf(&b, "\n- %s (%s)", c.Path, c.Kind)
+		}
+	}
+
+	msg := chat.NewMessage(openai.ChatRoleUser, strings.TrimSpace(b.String()))
+	msg.Metadata = &chat.Metadata{Time: time.Now()}
+
+	return msg
+}
+
+// writeBlock writes the text as a Markdown code block, with a fence longer
+// than any run of backticks in the text.
+func writeBlock(b *strings.Builder, lang, text string) {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+
+	b.WriteString(fence + lang + "\n")
+	b.WriteString(strings.TrimRight(text, "\n"))
+	b.WriteString("\n" + fence + "\n")
+}
+
+// cappedBuffer is a buffer that keeps the first max bytes written to it,
+// and drops the rest.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+// Write implements io.Writer, always reporting the whole write as written so
+// the command doesn't fail.
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if room := c.max - c.buf.Len(); len(p) > room {
+		c.buf.Write(p[:room])
+		c.truncated = true
+	} else {
+		c.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// String returns what was kept, as valid UTF-8 even if the cut was in the
+// middle of a character.
+func (c *cappedBuffer) String() string {
+	return strings.ToValidUTF8(c.buf.String(), "")
+}
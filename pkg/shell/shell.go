@@ -0,0 +1,124 @@
+// Package shell turns tasks described in natural language into shell
+// commands, and runs them once they're reviewed, so their output can be
+// added to a chat thread as context for follow-up questions.
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+// Shell returns the shell commands are run with, which is $SHELL, falling
+// back to /bin/sh.
+func Shell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
+
+// SystemMessage returns the system message used to ask for a command, which
+// replaces the thread's system message.
+func SystemMessage() chat.Message {
+	return chat.NewMessage(openai.ChatRoleSystem, fmt.Sprintf(
+		"You are HAL, turning tasks into shell commands. Reply with a single command for %s on %s in a ```sh code block, "+
+			"without any explanation. Combine steps with pipes or &&, and prefer commands that don't change anything unless "+
+			"the task asks to. If the task can't be done with a shell command, explain why without a code block.",
+		filepath.Base(Shell()), runtime.GOOS,
+	))
+}
+
+// ProposedMsg is sent when a command was proposed for a task, or it failed.
+type ProposedMsg struct {
+	Err error
+
+	// Command is the proposed command.
+	Command string
+
+	// Messages are the task and the reply proposing the command, which
+	// can be added to the thread's chat history once the command is run.
+	Messages []chat.Message
+}
+
+// Propose returns a command that asks the provider for a shell command to do
+// the task, with the chat history as context, sending a ProposedMsg with the
+// command.
+func Propose(ctx context.Context, provider chat.Provider, opts chat.Options, chatHistory []chat.Message, task string) tea.Cmd {
+	// The thread's system message is replaced, but summaries of compacted
+	// messages are kept as context.
+	messages := []chat.Message{SystemMessage()}
+	for _, msg := range chatHistory {
+		if msg.Role != openai.ChatRoleSystem || chat.IsSummary(msg) {
+			messages = append(messages, msg)
+		}
+	}
+
+	send := chat.Send(ctx, provider, opts, messages, task)
+
+	return func() tea.Msg {
+		finished := send().(chat.FinishedMsg)
+		if finished.Err != nil {
+			return ProposedMsg{Err: fmt.Errorf("failed to propose a command: %w", finished.Err)}
+		}
+
+		reply := finished.History[len(finished.History)-1]
+
+		command := ParseCommand(reply.Content)
+		if command == "" {
+			return ProposedMsg{Err: errors.New(strings.TrimSpace(reply.Content))}
+		}
+
+		return ProposedMsg{
+			Command:  command,
+			Messages: finished.History[len(messages):],
+		}
+	}
+}
+
+// ParseCommand returns the command in the reply, which is the first code
+// block, or inline code if that's all the reply is. Prompts like "$ " at the
+// start of each line are removed. It returns an empty string if there is no
+// command, like when the reply explains why there can't be one.
+func ParseCommand(reply string) string {
+	var (
+		lines   []string
+		inBlock bool
+		found   bool
+	)
+
+	for _, line := range strings.Split(reply, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			if inBlock {
+				break
+			}
+			inBlock, found = true, true
+			continue
+		}
+
+		if inBlock {
+			lines = append(lines, strings.TrimPrefix(line, "$ "))
+		}
+	}
+
+	if !found {
+		reply = strings.TrimSpace(reply)
+		if strings.Contains(reply, "\n") || !strings.HasPrefix(reply, "`") || !strings.HasSuffix(reply, "`") {
+			return ""
+		}
+		lines = []string{strings.TrimPrefix(strings.Trim(reply, "`"), "$ ")}
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
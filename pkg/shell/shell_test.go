@@ -0,0 +1,161 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/chat/chattest"
+	"github.com/picatz/hal/pkg/sandbox"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  string
+	}{
+		{reply: "```sh\nls -la\n```", want: "ls -la"},
+		{reply: "Sure:\n\n```bash\n$ find . -size +100M\n```\n\nThis finds large files.", want: "find . -size +100M"},
+		{reply: "`du -sh .`", want: "du -sh ."},
+		{reply: "I can't do that, Dave.", want: ""},
+		{reply: "Use `ls`.\nIt lists files.", want: ""},
+	}
+
+	for _, test := range tests {
+		if got := ParseCommand(test.reply); got != test.want {
+			t.Fatalf("expected %q for %q, got %q", test.want, test.reply, got)
+		}
+	}
+}
+
+func TestPropose(t *testing.T) {
+	provider := chattest.NewProvider(
+		chattest.Reply{Content: "```sh\nls -la\n```"},
+		chattest.Reply{Content: "I'm afraid I can't do that."},
+	)
+
+	history := []chat.Message{
+		chat.NewMessage(openai.ChatRoleSystem, "You are HAL."),
+		chat.NewMessage(openai.ChatRoleUser, "Hello, HAL."),
+	}
+
+	msg := Propose(context.Background(), provider, chat.Options{}, history, "list the files here")().(ProposedMsg)
+	if msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+
+	if msg.Command != "ls -la" || len(msg.Messages) != 2 || msg.Messages[0].Content != "list the files here" {
+		t.Fatalf("unexpected proposal: %+v", msg)
+	}
+
+	// The thread's system message is replaced.
+	sent := provider.Requests()[0].Messages
+	if len(sent) != 3 || sent[0].Content == "You are HAL." || sent[1].Content != "Hello, HAL." {
+		t.Fatalf("unexpected request: %+v", sent)
+	}
+
+	msg = Propose(context.Background(), provider, chat.Options{}, history, "open the pod bay doors")().(ProposedMsg)
+	if msg.Err == nil || msg.Err.Error() != "I'm afraid I can't do that." {
+		t.Fatalf("expected the explanation as an error, got %v", msg.Err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+
+	msg := Run(context.Background(), "echo hello; echo oops >&2; exit 3")().(FinishedMsg)
+	if msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+
+	if r := msg.Result; r.Stdout != "hello\n" || r.Stderr != "oops\n" || r.ExitCode != 3 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+
+	content := msg.Result.Message().Content
+	for _, want := range []string{"exited with code 3", "```sh\necho hello; echo oops >&2; exit 3\n```", "stdout:\n\n```\nhello\n```", "stderr:\n\n```\noops\n```"} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("expected %q in:\n%s", want, content)
+		}
+	}
+
+	// Output is cut, and fenced so it can't end the code block early.
+	msg = Run(context.Background(), "echo '```'; head -c 10000 /dev/zero | tr '\\0' a")().(FinishedMsg)
+	if r := msg.Result; len(r.Stdout) != MaxOutput || !r.Truncated {
+		t.Fatalf("expected the output to be cut to %d bytes, got %d", MaxOutput, len(r.Stdout))
+	}
+
+	if content := msg.Result.Message().Content; !strings.Contains(content, "````\n```\naaa") {
+		t.Fatalf("expected a longer fence, got:\n%.100s", content)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if msg := Run(ctx, "sleep 10")().(FinishedMsg); msg.Err == nil {
+		t.Fatal("expected an error for a canceled command")
+	}
+}
+
+func TestRunSandboxed(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+	t.Setenv("OPENAI_API_KEY", "secret")
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := RunSandboxed(context.Background(), dir, sandbox.DefaultOptions, `echo two > a.txt; echo "key=$OPENAI_API_KEY"`)().(FinishedMsg)
+	if errors.Is(msg.Err, sandbox.ErrUnsupported) {
+		t.Skip(msg.Err)
+	}
+	if msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+
+	r := msg.Result
+	if r.Stdout != "key=\n" || !r.Sandboxed || r.Sandbox == nil {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	defer r.Sandbox.Remove()
+
+	if len(r.Changes) != 1 || r.Changes[0].Path != "a.txt" || r.Changes[0].Kind != sandbox.Modified {
+		t.Fatalf("expected a.txt to be modified, got %+v", r.Changes)
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(content) != "one\n" {
+		t.Fatalf("expected the working directory to be unchanged, got %q", content)
+	}
+
+	content := r.Message().Content
+	for _, want := range []string{"in a sandbox, on a copy of the working directory without network access or secrets", "I discarded the changes:\n\n- a.txt (modified)"} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("expected %q in:\n%s", want, content)
+		}
+	}
+
+	// Sandboxes without changes are removed.
+	msg = RunSandboxed(context.Background(), dir, sandbox.DefaultOptions, "cat a.txt")().(FinishedMsg)
+	if msg.Err != nil || msg.Result.Sandbox != nil || len(msg.Result.Changes) != 0 {
+		t.Fatalf("expected no changes, got %+v, %v", msg.Result, msg.Err)
+	}
+
+	// The model is told what the sandbox allowed.
+	opts := sandbox.DefaultOptions
+	opts.Network = true
+
+	msg = RunSandboxed(context.Background(), dir, opts, "cat a.txt")().(FinishedMsg)
+	if msg.Err != nil {
+		t.Fatal(msg.Err)
+	}
+	if content := msg.Result.Message().Content; !strings.Contains(content, "with network access but without secrets") {
+		t.Fatalf("expected the network access in:\n%s", content)
+	}
+}
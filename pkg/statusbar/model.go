@@ -8,16 +8,34 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/muesli/reflow/ansi"
+	"github.com/muesli/reflow/truncate"
+	"github.com/picatz/openai"
+
 	"github.com/picatz/hal/pkg/chat"
 )
 
-var (
-	messageCountStatusBarBlockStyle = lipgloss.NewStyle().Background(lipgloss.Color("63"))
+// Colors are the colors used by the status bar.
+type Colors struct {
+	// Primary is the background of the status bar, and current thread name.
+	Primary lipgloss.Color
 
-	tokensCountStatusBarBlockStyle = lipgloss.NewStyle().Background(lipgloss.Color("62"))
+	// Messages is the background of the message count.
+	Messages lipgloss.Color
 
-	currentThreadNameBlockStyle = lipgloss.NewStyle().Background(lipgloss.Color("69")).Bold(true)
-)
+	// Tokens is the background of the token count.
+	Tokens lipgloss.Color
+
+	// Error is the background of the last error.
+	Error lipgloss.Color
+}
+
+// DefaultColors are the colors used by the status bar by default.
+var DefaultColors = Colors{
+	Primary:  lipgloss.Color("69"),
+	Messages: lipgloss.Color("63"),
+	Tokens:   lipgloss.Color("62"),
+	Error:    lipgloss.Color("124"),
+}
 
 // ChatThreadMsg is a message sent to the status bar.
 type ChatThreadMsg struct {
@@ -36,9 +54,30 @@ type Model struct {
 	Width int
 	Style lipgloss.Style
 
+	messageCountStatusBarBlockStyle lipgloss.Style
+	tokensCountStatusBarBlockStyle  lipgloss.Style
+	currentThreadNameBlockStyle     lipgloss.Style
+	errorBlockStyle                 lipgloss.Style
+
 	Spinner  spinner.Model
 	Spinning bool
 
+	// Err is the last error to show in the status bar, if any.
+	Err error
+
+	// Provider is the name of the provider used by the current thread.
+	Provider string
+
+	// TokensUsed is the number of tokens the next request would use, out of
+	// the TokenLimit of the current thread's model. If the limit is zero,
+	// the tokens last reported by the provider are shown instead.
+	TokensUsed int
+	TokenLimit int
+
+	// TokensOver is set when the next request wouldn't fit in the model's
+	// context window, to warn before it's sent.
+	TokensOver bool
+
 	ChatThread *chat.Thread
 }
 
@@ -49,12 +88,23 @@ func New() *Model {
 	// s.Style = lipgloss.NewStyle().
 	// 	Background(lipgloss.Color("69")).
 	// 	Bold(true)
-	return &Model{
+	m := &Model{
 		Spinner: s,
-		Style: lipgloss.NewStyle().
-			// Padding(0, 1).
-			Background(lipgloss.Color("69")),
 	}
+	m.SetColors(DefaultColors)
+	return m
+}
+
+// SetColors changes the colors used by the status bar.
+func (s *Model) SetColors(colors Colors) {
+	s.Style = lipgloss.NewStyle().
+		// Padding(0, 1).
+		Background(colors.Primary)
+
+	s.messageCountStatusBarBlockStyle = lipgloss.NewStyle().Background(colors.Messages)
+	s.tokensCountStatusBarBlockStyle = lipgloss.NewStyle().Background(colors.Tokens)
+	s.currentThreadNameBlockStyle = lipgloss.NewStyle().Background(colors.Primary).Bold(true)
+	s.errorBlockStyle = lipgloss.NewStyle().Background(colors.Error).Bold(true)
 }
 
 // Init implements tea.Model, but does nothing currently.
@@ -101,14 +151,28 @@ func (s *Model) View() string {
 		chatTokensCount = s.ChatThread.Tokens
 	}
 
-	// Right hand side blocks.
-	var (
-		rightBlocks = []string{
-			messageCountStatusBarBlockStyle.Render(fmt.Sprintf(" Messages: %d ", chatMessageCount)),
-			tokensCountStatusBarBlockStyle.Render(fmt.Sprintf(" Tokens: %d ", chatTokensCount)),
-			currentThreadNameBlockStyle.Render(fmt.Sprintf(" %s ", currentThreadName)),
+	tokensBlock := s.tokensCountStatusBarBlockStyle.Render(fmt.Sprintf(" Tokens: %d ", chatTokensCount))
+	if s.ChatThread != nil && s.TokenLimit > 0 {
+		style := s.tokensCountStatusBarBlockStyle
+		if s.TokensOver {
+			style = s.errorBlockStyle
 		}
+		tokensBlock = style.Render(fmt.Sprintf(" Tokens: %d / %d ", s.TokensUsed, s.TokenLimit))
+	}
 
+	// Right hand side blocks.
+	rightBlocks := []string{
+		s.messageCountStatusBarBlockStyle.Render(fmt.Sprintf(" Messages: %d ", chatMessageCount)),
+		tokensBlock,
+	}
+
+	if s.ChatThread != nil && s.Provider != "" {
+		rightBlocks = append(rightBlocks, s.messageCountStatusBarBlockStyle.Render(fmt.Sprintf(" %s ", s.Provider)))
+	}
+
+	rightBlocks = append(rightBlocks, s.currentThreadNameBlockStyle.Render(fmt.Sprintf(" %s ", currentThreadName)))
+
+	var (
 		rightBlocksJoined = strings.Join(rightBlocks, "")
 
 		rightBlocksJoinedWidth = ansi.PrintableRuneWidth(rightBlocksJoined)
@@ -125,15 +189,49 @@ func (s *Model) View() string {
 				return "»"
 			}(),
 		}
+	)
 
-		leftBlocksJoined = strings.Join(leftBlocks, "")
+	// Show the last error, truncated to fit the space that's left over, or
+	// else how the last reply was generated.
+	maxLeftWidth := s.Width - rightBlocksJoinedWidth - 10
+	switch {
+	case maxLeftWidth <= 0:
+	case s.Err != nil:
+		leftBlocks = append(leftBlocks, " ", s.errorBlockStyle.Render(
+			" "+truncate.StringWithTail(s.Err.Error(), uint(maxLeftWidth), "…")+" ",
+		))
+	case s.ChatThread != nil:
+		if details := lastReplyDetails(s.ChatThread); details != "" {
+			leftBlocks = append(leftBlocks, " ", truncate.StringWithTail(details, uint(maxLeftWidth), "…"))
+		}
+	}
+
+	var (
+		leftBlocksJoined      = strings.Join(leftBlocks, "")
+		leftBlocksJoinedWidth = ansi.PrintableRuneWidth(leftBlocksJoined)
 	)
 
 	// get printable characters (non ANSI escape codes)
 
 	// build status bar including the current thread name on right hand side, filling the rest of the space with spaces
-	statusText := " " + leftBlocksJoined + strings.Repeat(" ", s.Width-rightBlocksJoinedWidth-6) + rightBlocksJoined + " "
+	spaceBetween := s.Width - rightBlocksJoinedWidth - leftBlocksJoinedWidth - 5
+	if spaceBetween < 0 {
+		spaceBetween = 0
+	}
+
+	statusText := " " + leftBlocksJoined + strings.Repeat(" ", spaceBetween) + rightBlocksJoined + " "
 
 	// TODO: add a way to set the status bar style, and stuff inside it.
 	return s.Style.Render(statusText)
 }
+
+// lastReplyDetails returns the model, latency and token usage of the last
+// reply in the thread, if it has any.
+func lastReplyDetails(ct *chat.Thread) string {
+	for i := len(ct.ChatHistory) - 1; i >= 0; i-- {
+		if msg := ct.ChatHistory[i]; msg.Role == openai.ChatRoleAssistant {
+			return msg.Metadata.String()
+		}
+	}
+	return ""
+}
@@ -1,8 +1,15 @@
 package music
 
-import "testing"
+import (
+	"os/exec"
+	"testing"
+)
 
 func TestSpotifyCurrentlyPlaying(t *testing.T) {
+	if _, err := exec.LookPath("osascript"); err != nil {
+		t.Skip("osascript is not available")
+	}
+
 	track, err := SpotifyCurrentlyPlaying()
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,180 @@
+// Package transcript renders chat histories for the terminal, with a header
+// for each message's role and the content rendered as Markdown.
+package transcript
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/diff"
+)
+
+var (
+	userHeaderStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("231")).Bold(true)
+	assistantHeaderStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("69")).Bold(true)
+	systemHeaderStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Bold(true)
+	summaryHeaderStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Italic(true)
+	detailsStyle         = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	branchStyle          = lipgloss.NewStyle().Foreground(lipgloss.Color("69"))
+)
+
+// Renderer renders chat histories, caching the rendered Markdown of each
+// message so that re-rendering a growing history (like while streaming a
+// response) only renders what changed.
+type Renderer struct {
+	width int
+	md    *glamour.TermRenderer
+	cache map[string]string
+}
+
+// NewRenderer returns a new renderer that wraps text to the given width.
+func NewRenderer(width int) (*Renderer, error) {
+	md, err := glamour.NewTermRenderer(
+		glamour.WithStandardStyle("dark"),
+		glamour.WithWordWrap(width),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create markdown renderer: %w", err)
+	}
+
+	return &Renderer{
+		width: width,
+		md:    md,
+		cache: map[string]string{},
+	}, nil
+}
+
+// Width returns the width the renderer wraps text to.
+func (r *Renderer) Width() int {
+	return r.width
+}
+
+// Render renders the chat history, returning the rendered content and the
+// line offset each message starts at, which can be used to scroll to a
+// specific message.
+//
+// Pending messages are rendered after the history, but are not cached since
+// they're still changing, like a response that is still being streamed.
+func (r *Renderer) Render(history []chat.Message, pending ...chat.Message) (string, []int, error) {
+	return r.RenderBranches(history, nil, pending...)
+}
+
+// RenderBranches is like Render, but marks the messages of the history that
+// have siblings in the thread's conversation graph with where they are among
+// them, like "‹ 2/3 ›".
+//
+// Replies of the history with a patch are marked too, like "± patch".
+func (r *Renderer) RenderBranches(history []chat.Message, branches []chat.Branch, pending ...chat.Message) (string, []int, error) {
+	var (
+		b       strings.Builder
+		offsets = make([]int, 0, len(history)+len(pending))
+		lines   int
+	)
+
+	for i, msg := range append(history[:len(history):len(history)], pending...) {
+		offsets = append(offsets, lines)
+
+		header, text := Header(msg.Role), msg.Content
+
+		// Mark where older messages were replaced by their summary.
+		if chat.IsSummary(msg) {
+			header = SummaryHeader()
+			text = strings.TrimPrefix(text, chat.SummaryPrefix)
+		}
+
+		if details := Details(msg.Metadata); details != "" {
+			header += detailsStyle.Render(" · " + details)
+		}
+
+		if i < len(branches) && branches[i].Count > 1 {
+			header += branchStyle.Render(fmt.Sprintf("  ‹ %d/%d ›", branches[i].Index+1, branches[i].Count))
+		}
+
+		// Mark replies with a patch, which can be reviewed and applied.
+		if msg.Role == openai.ChatRoleAssistant && i < len(history) && diff.ContainsPatch(text) {
+			header += branchStyle.Render("  ± patch")
+		}
+
+		content, err := r.renderMarkdown(text, i < len(history))
+		if err != nil {
+			return "", nil, err
+		}
+
+		block := header + "\n" + content + "\n"
+
+		b.WriteString(block)
+		lines += strings.Count(block, "\n")
+	}
+
+	return b.String(), offsets, nil
+}
+
+// renderMarkdown renders the content as Markdown, using the cache if it was
+// already rendered before, and adding it to the cache if asked to.
+func (r *Renderer) renderMarkdown(content string, cache bool) (string, error) {
+	if rendered, ok := r.cache[content]; ok {
+		return rendered, nil
+	}
+
+	rendered, err := r.md.Render(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to render message: %w", err)
+	}
+
+	// Glamour pads the output with blank lines, which are replaced with
+	// the spacing between messages.
+	rendered = strings.Trim(rendered, "\n")
+
+	if cache {
+		r.cache[content] = rendered
+	}
+
+	return rendered, nil
+}
+
+// SummaryHeader returns the rendered header shown above the summary of
+// messages that were compacted.
+func SummaryHeader() string {
+	return summaryHeaderStyle.Render("» Earlier messages were summarized")
+}
+
+// Header returns the rendered header shown above a message with the
+// given role.
+func Header(role string) string {
+	switch role {
+	case openai.ChatRoleUser:
+		return userHeaderStyle.Render("» You")
+	case openai.ChatRoleAssistant:
+		return assistantHeaderStyle.Render("» HAL")
+	case openai.ChatRoleSystem:
+		return systemHeaderStyle.Render("» System")
+	default:
+		return systemHeaderStyle.Render("» " + role)
+	}
+}
+
+// Details returns when the message was sent, and how the response was
+// generated, like "Apr 2 09:30 · gpt-4 · 1.2s · 312 tokens", or an empty
+// string for messages without metadata.
+func Details(md *chat.Metadata) string {
+	if md == nil {
+		return ""
+	}
+
+	var parts []string
+
+	if !md.Time.IsZero() {
+		parts = append(parts, md.Time.Local().Format("Jan 2 15:04"))
+	}
+
+	if s := md.String(); s != "" {
+		parts = append(parts, s)
+	}
+
+	return strings.Join(parts, " · ")
+}
@@ -0,0 +1,190 @@
+package transcript
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muesli/reflow/ansi"
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+)
+
+func TestRender(t *testing.T) {
+	r, err := NewRenderer(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := []chat.Message{
+		chat.NewMessage(openai.ChatRoleSystem, "You are HAL."),
+		{
+			ChatMessage: openai.ChatMessage{
+				Role:    openai.ChatRoleUser,
+				Content: "Write hello world in Go.",
+			},
+			Metadata: &chat.Metadata{Time: time.Date(2001, time.April, 2, 9, 30, 0, 0, time.Local)},
+		},
+	}
+
+	pending := chat.NewMessage(openai.ChatRoleAssistant, "```go\nfmt.Println(\"hello world\")\n```")
+
+	content, offsets, err := r.Render(history, pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(offsets) != 3 {
+		t.Fatalf("expected 3 offsets, got %d", len(offsets))
+	}
+
+	lines := strings.Split(content, "\n")
+
+	for i, header := range []string{"» System", "» You", "» HAL"} {
+		if line := lines[offsets[i]]; !strings.Contains(line, header) {
+			t.Fatalf("expected line %d to contain header %q, got %q", offsets[i], header, line)
+		}
+	}
+
+	if line := stripANSI(lines[offsets[1]]); line != "» You · Apr 2 09:30" {
+		t.Fatalf("expected the time the message was sent, got %q", line)
+	}
+
+	if !strings.Contains(stripANSI(content), `fmt.Println("hello world")`) {
+		t.Fatalf("expected code block in transcript, got:\n%s", content)
+	}
+
+	// Pending messages aren't cached, since they're still changing.
+	if _, ok := r.cache[pending.Content]; ok {
+		t.Fatal("expected pending message to not be cached")
+	}
+
+	if _, ok := r.cache[history[1].Content]; !ok {
+		t.Fatal("expected history message to be cached")
+	}
+}
+
+// stripANSI removes the ANSI escape sequences from the string.
+func stripANSI(s string) string {
+	var (
+		b     strings.Builder
+		inSeq bool
+	)
+
+	for _, c := range s {
+		if c == ansi.Marker {
+			inSeq = true
+		} else if inSeq {
+			if ansi.IsTerminator(c) {
+				inSeq = false
+			}
+		} else {
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+func TestRenderSummary(t *testing.T) {
+	r, err := NewRenderer(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compaction := &chat.Compaction{Summary: "Dave asked HAL to open the pod bay doors."}
+
+	content, _, err := r.Render([]chat.Message{compaction.Message()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content = stripANSI(content)
+
+	if !strings.Contains(content, "» Earlier messages were summarized") {
+		t.Fatalf("expected a summary marker, got:\n%s", content)
+	}
+
+	if strings.Contains(content, strings.TrimSpace(chat.SummaryPrefix)) || !strings.Contains(content, "pod bay doors") {
+		t.Fatalf("expected only the summary, got:\n%s", content)
+	}
+}
+
+func TestRenderBranches(t *testing.T) {
+	r, err := NewRenderer(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := []chat.Message{
+		chat.NewMessage(openai.ChatRoleUser, "Open the pod bay doors, HAL."),
+		chat.NewMessage(openai.ChatRoleAssistant, "I'm sorry, Dave."),
+	}
+
+	content, offsets, err := r.RenderBranches(history, []chat.Branch{{Index: 0, Count: 1}, {Index: 1, Count: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(stripANSI(content), "\n")
+
+	if line := lines[offsets[0]]; line != "» You" {
+		t.Fatalf("expected no branch marker without siblings, got %q", line)
+	}
+
+	if line := lines[offsets[1]]; line != "» HAL  ‹ 2/3 ›" {
+		t.Fatalf("expected a branch marker, got %q", line)
+	}
+}
+
+func TestDetails(t *testing.T) {
+	md := &chat.Metadata{
+		Time:         time.Date(2001, time.April, 2, 9, 30, 0, 0, time.Local),
+		Model:        "gpt-4",
+		Latency:      1200 * time.Millisecond,
+		Usage:        &chat.Usage{TotalTokens: 312},
+		FinishReason: "length",
+	}
+
+	if got, want := Details(md), "Apr 2 09:30 · gpt-4 · 1.2s · 312 tokens · length"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	if got := Details(nil); got != "" {
+		t.Fatalf("expected no details without metadata, got %q", got)
+	}
+}
+
+func TestRenderPatch(t *testing.T) {
+	r, err := NewRenderer(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := []chat.Message{
+		chat.NewMessage(openai.ChatRoleUser, "Fix the typo."),
+		chat.NewMessage(openai.ChatRoleAssistant, "```diff\n--- a/README.md\n+++ b/README.md\n@@ -1 +1 @@\n-# HLA\n+# HAL\n```"),
+	}
+
+	content, offsets, err := r.Render(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(stripANSI(content), "\n")
+
+	if line := lines[offsets[1]]; line != "» HAL  ± patch" {
+		t.Fatalf("expected a patch marker, got %q", line)
+	}
+
+	// Replies still being streamed aren't marked.
+	content, offsets, err = r.Render(history[:1], history[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if line := strings.Split(stripANSI(content), "\n")[offsets[1]]; line != "» HAL" {
+		t.Fatalf("expected no patch marker while pending, got %q", line)
+	}
+}
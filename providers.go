@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/picatz/openai"
+
+	"github.com/picatz/hal/pkg/chat"
+	"github.com/picatz/hal/pkg/config"
+)
+
+// newProviders returns the configured providers by name.
+//
+// OpenAI providers without an API key are left out, so HAL can still be
+// used with local models without an OpenAI account, unless it's the
+// default provider.
+func newProviders(cfg *config.Config) (map[string]chat.Provider, error) {
+	providers := map[string]chat.Provider{}
+
+	for _, p := range cfg.AllProviders() {
+		model := cfg.ProviderModel(p)
+
+		switch p.Type {
+		case config.ProviderTypeOpenAI:
+			apiKey := p.APIKey()
+			if apiKey == "" {
+				if p.Name == cfg.DefaultProvider {
+					keyEnv := p.APIKeyEnv
+					if keyEnv == "" {
+						keyEnv = "OPENAI_API_KEY"
+					}
+					return nil, fmt.Errorf("%s environment variable is not set", keyEnv)
+				}
+				continue
+			}
+			providers[p.Name] = chat.NewOpenAIProvider(openai.NewClient(apiKey), model)
+		case config.ProviderTypeOpenAICompatible:
+			providers[p.Name] = chat.NewHTTPProvider(p.BaseURL, p.APIKey(), model)
+		}
+	}
+
+	return providers, nil
+}
+
+// tokenBudget is the context window of a provider's model, which requests
+// are checked against before they're sent.
+type tokenBudget struct {
+	// model is used to choose the tokenizer.
+	model string
+
+	// limit is the number of tokens the model can handle, for both the
+	// prompt and the response.
+	limit int
+
+	// reserved is the number of tokens kept free for the response.
+	reserved int
+}
+
+// newTokenBudgets returns the token budget of each provider by name.
+func newTokenBudgets(cfg *config.Config) map[string]tokenBudget {
+	budgets := map[string]tokenBudget{}
+
+	for _, p := range cfg.AllProviders() {
+		budgets[p.Name] = newTokenBudget(cfg, p, "")
+	}
+
+	return budgets
+}
+
+// newTokenBudget returns the token budget of the provider, using the given
+// model instead of the provider's model if it's set.
+func newTokenBudget(cfg *config.Config, p *config.Provider, model string) tokenBudget {
+	budget := tokenBudget{
+		model:    cfg.ProviderModel(p),
+		limit:    cfg.ProviderContextWindow(p),
+		reserved: cfg.MaxTokens,
+	}
+
+	// A configured context window is for the provider's model, so another
+	// model uses its own known context window.
+	if model != "" && model != budget.model {
+		budget.model = model
+		budget.limit = chat.ContextWindow(model)
+	}
+
+	return budget
+}
+
+// tokenizer returns the tokenizer for the budget's model.
+func (b tokenBudget) tokenizer() (*chat.Tokenizer, error) {
+	return chat.NewTokenizer(b.model)
+}
+
+// check returns an error if a prompt with the given number of tokens
+// doesn't leave room for the response.
+func (b tokenBudget) check(tokens int) error {
+	if tokens+b.reserved > b.limit {
+		if b.reserved > 0 {
+			return fmt.Errorf("message is over the %s context window: %d / %d tokens, with %d reserved for the reply", b.model, tokens, b.limit, b.reserved)
+		}
+		return fmt.Errorf("message is over the %s context window: %d / %d tokens", b.model, tokens, b.limit)
+	}
+	return nil
+}